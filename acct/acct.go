@@ -0,0 +1,288 @@
+// Package acct accounts for uplink traffic per LAN client.
+//
+// Each client found in a LAN's neighbor table gets a pair of target-less rules
+// per uplink in a dedicated chain that every forwarded packet passes through.
+// The rules' counters, summed across uplinks, are the client's usage.
+package acct
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/fw/rules"
+	"go.jonnrb.io/egress/log"
+)
+
+// The chain holding the per-client accounting rules.
+const Chain = "fw-acct"
+
+const (
+	defaultMaxClients  = 256
+	defaultInterval    = 10 * time.Second
+	defaultIdleTimeout = time.Hour
+)
+
+// Rules to add to the firewall to account for LAN clients. The accounting
+// chain is jumped to ahead of everything else in FORWARD so it sees packets
+// of established connections too.
+func Rules() rules.RuleSet {
+	return rules.RuleSet{
+		rules.Rule("-t filter -N " + Chain),
+		rules.Rule("-t filter -I FORWARD -j " + Chain),
+	}
+}
+
+// Uplink usage by a LAN client. Upload is traffic from the client out any
+// uplink; download is the reverse.
+type Client struct {
+	IP              net.IP    `json:"ip"`
+	MAC             string    `json:"mac,omitempty"`
+	UploadBytes     uint64    `json:"upload_bytes"`
+	UploadPackets   uint64    `json:"upload_packets"`
+	DownloadBytes   uint64    `json:"download_bytes"`
+	DownloadPackets uint64    `json:"download_packets"`
+	LastActive      time.Time `json:"last_active"`
+}
+
+func (c Client) TotalBytes() uint64 {
+	return c.UploadBytes + c.DownloadBytes
+}
+
+// Keeps accounting rules in place for the clients on LANs while running.
+type Accountant struct {
+	LANs    []fw.Link
+	Uplinks []fw.Link
+
+	// The most clients to account for at once. Clients beyond this aren't
+	// accounted for until others go idle.
+	MaxClients int
+
+	// How often to look for new clients and read counters.
+	Interval time.Duration
+
+	// How long a client that has left the neighbor table is remembered after
+	// it was last active.
+	IdleTimeout time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*Client
+
+	// Dependencies, overridden in tests.
+	neighbors func() ([]neighbor, error)
+	apply     func(rules.RuleSet) error
+	counters  func() ([]fw.RuleCounter, error)
+	now       func() time.Time
+}
+
+func New(lans, uplinks []fw.Link) *Accountant {
+	a := &Accountant{
+		LANs:        lans,
+		Uplinks:     uplinks,
+		MaxClients:  defaultMaxClients,
+		Interval:    defaultInterval,
+		IdleTimeout: defaultIdleTimeout,
+		clients:     make(map[string]*Client),
+		apply:       fw.ApplyRules,
+		counters: func() ([]fw.RuleCounter, error) {
+			return fw.ReadRuleCounters([]fw.Chain{{Table: "filter", Name: Chain}})
+		},
+		now: time.Now,
+	}
+	a.neighbors = func() ([]neighbor, error) {
+		var ns []neighbor
+		for _, l := range a.LANs {
+			n, err := listNeighbors(l)
+			if err != nil {
+				return nil, err
+			}
+			ns = append(ns, n...)
+		}
+		return ns, nil
+	}
+	return a
+}
+
+// Accounts for clients until ctx is done. The accounting chain is emptied
+// when starting and stopping, so usage is only kept for a single run.
+func (a *Accountant) Run(ctx context.Context) error {
+	if err := a.reset(); err != nil {
+		return err
+	}
+	defer func() {
+		if err := a.reset(); err != nil {
+			log.Warningf("acct: could not clean up: %v", err)
+		}
+	}()
+
+	t := time.NewTicker(a.Interval)
+	defer t.Stop()
+	for {
+		if err := a.update(); err != nil {
+			log.Errorf("acct: could not update client usage: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+func (a *Accountant) reset() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.clients = make(map[string]*Client)
+	err := a.apply(rules.RuleSet{rules.Rule("-t filter -F " + Chain)})
+	if err != nil {
+		return fmt.Errorf("acct: could not flush chain %q: %w", Chain, err)
+	}
+	return nil
+}
+
+func (a *Accountant) update() error {
+	ns, err := a.neighbors()
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	present := make(map[string]bool)
+	for _, n := range ns {
+		k := n.IP.String()
+		present[k] = true
+		if c, ok := a.clients[k]; ok {
+			c.MAC = n.MAC.String()
+			continue
+		}
+		if len(a.clients) >= a.MaxClients {
+			log.V(2).Infof("acct: not accounting for %v; at limit of %d clients", n.IP, a.MaxClients)
+			continue
+		}
+		if err := a.apply(a.clientRules("-A", n.IP)); err != nil {
+			return fmt.Errorf("acct: could not add rules for %v: %w", n.IP, err)
+		}
+		a.clients[k] = &Client{IP: n.IP, MAC: n.MAC.String(), LastActive: now}
+	}
+
+	cs, err := a.counters()
+	if err != nil {
+		return err
+	}
+	usage := make(map[string]*Client)
+	for _, rc := range cs {
+		ip, upload, ok := parseClientRule(rc.Rule)
+		if !ok {
+			continue
+		}
+		if _, ok := a.clients[ip]; !ok {
+			continue
+		}
+		u, ok := usage[ip]
+		if !ok {
+			u = &Client{}
+			usage[ip] = u
+		}
+		if upload {
+			u.UploadBytes += rc.Bytes
+			u.UploadPackets += rc.Packets
+		} else {
+			u.DownloadBytes += rc.Bytes
+			u.DownloadPackets += rc.Packets
+		}
+	}
+	for ip, u := range usage {
+		c := a.clients[ip]
+		if c.UploadBytes != u.UploadBytes || c.UploadPackets != u.UploadPackets ||
+			c.DownloadBytes != u.DownloadBytes || c.DownloadPackets != u.DownloadPackets {
+			c.UploadBytes, c.UploadPackets = u.UploadBytes, u.UploadPackets
+			c.DownloadBytes, c.DownloadPackets = u.DownloadBytes, u.DownloadPackets
+			c.LastActive = now
+		}
+	}
+
+	for k, c := range a.clients {
+		if present[k] || now.Sub(c.LastActive) < a.IdleTimeout {
+			continue
+		}
+		if err := a.apply(a.clientRules("-D", c.IP)); err != nil {
+			return fmt.Errorf("acct: could not remove rules for %v: %w", c.IP, err)
+		}
+		delete(a.clients, k)
+	}
+	return nil
+}
+
+// Rules that count traffic between ip and the uplinks. op is "-A" or "-D".
+func (a *Accountant) clientRules(op string, ip net.IP) (rs rules.RuleSet) {
+	for _, u := range a.Uplinks {
+		rs = append(rs,
+			rules.Rule(fmt.Sprintf("-t filter %s %s -s %v/32 -o %s", op, Chain, ip, u.Name())),
+			rules.Rule(fmt.Sprintf("-t filter %s %s -d %v/32 -i %s", op, Chain, ip, u.Name())))
+	}
+	return
+}
+
+// Parses a rule from clientRules() as printed by iptables.
+func parseClientRule(rule string) (ip string, upload, ok bool) {
+	args := strings.Fields(rule)
+	for i := 0; i < len(args)-1; i++ {
+		switch args[i] {
+		case "-s":
+			upload = true
+		case "-d":
+		default:
+			continue
+		}
+		addr := strings.TrimSuffix(args[i+1], "/32")
+		if net.ParseIP(addr) == nil {
+			return "", false, false
+		}
+		return addr, upload, true
+	}
+	return "", false, false
+}
+
+// Returns the clients being accounted for, heaviest users first.
+func (a *Accountant) Clients() []Client {
+	a.mu.Lock()
+	var cs []Client
+	for _, c := range a.clients {
+		cs = append(cs, *c)
+	}
+	a.mu.Unlock()
+
+	sort.Slice(cs, func(i, j int) bool {
+		if cs[i].TotalBytes() != cs[j].TotalBytes() {
+			return cs[i].TotalBytes() > cs[j].TotalBytes()
+		}
+		return cs[i].IP.String() < cs[j].IP.String()
+	})
+	return cs
+}
+
+// Serves Clients() as JSON.
+func (a *Accountant) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cs := a.Clients()
+	if cs == nil {
+		cs = []Client{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Clients []Client `json:"clients"`
+	}{cs}); err != nil {
+		log.V(2).Infof("acct: error writing clients: %v", err)
+	}
+}
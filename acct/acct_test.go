@@ -0,0 +1,195 @@
+package acct
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/fw/rules"
+)
+
+// Stands in for iptables and the neighbor table.
+type fakeKernel struct {
+	neighbors []neighbor
+	rules     map[rules.Rule]bool
+	counters  map[string]fw.RuleCounter
+	now       time.Time
+}
+
+func newTestAccountant() (*Accountant, *fakeKernel) {
+	k := &fakeKernel{
+		rules:    make(map[rules.Rule]bool),
+		counters: make(map[string]fw.RuleCounter),
+		now:      time.Unix(1600000000, 0),
+	}
+	a := New([]fw.Link{fw.LinkString("eth0")}, []fw.Link{fw.LinkString("eth1")})
+	a.neighbors = func() ([]neighbor, error) { return k.neighbors, nil }
+	a.apply = func(rs rules.RuleSet) error {
+		for _, r := range rs {
+			k.rules[r] = true
+		}
+		return nil
+	}
+	a.counters = func() ([]fw.RuleCounter, error) {
+		var cs []fw.RuleCounter
+		for _, c := range k.counters {
+			cs = append(cs, c)
+		}
+		return cs, nil
+	}
+	a.now = func() time.Time { return k.now }
+	return a, k
+}
+
+func (k *fakeKernel) count(rule string, packets, bytes uint64) {
+	k.counters[rule] = fw.RuleCounter{
+		Chain:   fw.Chain{Table: "filter", Name: Chain},
+		Rule:    rule,
+		Packets: packets,
+		Bytes:   bytes,
+	}
+}
+
+func testNeighbor(last byte) neighbor {
+	return neighbor{
+		IP:  net.IPv4(10, 0, 0, last).To4(),
+		MAC: net.HardwareAddr{2, 0, 0, 0, 0, last},
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	a, k := newTestAccountant()
+	k.neighbors = []neighbor{testNeighbor(2), testNeighbor(3)}
+
+	if err := a.update(); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []rules.Rule{
+		"-t filter -A fw-acct -s 10.0.0.2/32 -o eth1",
+		"-t filter -A fw-acct -d 10.0.0.2/32 -i eth1",
+		"-t filter -A fw-acct -s 10.0.0.3/32 -o eth1",
+		"-t filter -A fw-acct -d 10.0.0.3/32 -i eth1",
+	} {
+		if !k.rules[r] {
+			t.Errorf("expected rule %q to be applied", r)
+		}
+	}
+
+	k.count("-s 10.0.0.2/32 -o eth1", 10, 1000)
+	k.count("-d 10.0.0.2/32 -i eth1", 20, 30000)
+	k.count("-s 10.0.0.3/32 -o eth1", 1, 100)
+	if err := a.update(); err != nil {
+		t.Fatal(err)
+	}
+
+	cs := a.Clients()
+	if len(cs) != 2 {
+		t.Fatalf("expected 2 clients; got %+v", cs)
+	}
+	c := cs[0]
+	if !c.IP.Equal(net.IPv4(10, 0, 0, 2)) || c.MAC != "02:00:00:00:00:02" {
+		t.Errorf("expected heaviest client to be 10.0.0.2; got %+v", c)
+	}
+	if c.UploadBytes != 1000 || c.UploadPackets != 10 ||
+		c.DownloadBytes != 30000 || c.DownloadPackets != 20 {
+		t.Errorf("wrong usage for 10.0.0.2: %+v", c)
+	}
+}
+
+func TestUpdate_multipleUplinks(t *testing.T) {
+	a, k := newTestAccountant()
+	a.Uplinks = append(a.Uplinks, fw.LinkString("wg0"))
+	k.neighbors = []neighbor{testNeighbor(2)}
+
+	if err := a.update(); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []rules.Rule{
+		"-t filter -A fw-acct -s 10.0.0.2/32 -o eth1",
+		"-t filter -A fw-acct -s 10.0.0.2/32 -o wg0",
+		"-t filter -A fw-acct -d 10.0.0.2/32 -i wg0",
+	} {
+		if !k.rules[r] {
+			t.Errorf("expected rule %q to be applied", r)
+		}
+	}
+
+	k.count("-s 10.0.0.2/32 -o eth1", 10, 1000)
+	k.count("-s 10.0.0.2/32 -o wg0", 5, 500)
+	k.count("-d 10.0.0.2/32 -i wg0", 20, 30000)
+	if err := a.update(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := a.Clients()[0]
+	if c.UploadBytes != 1500 || c.UploadPackets != 15 ||
+		c.DownloadBytes != 30000 || c.DownloadPackets != 20 {
+		t.Errorf("expected usage summed across uplinks; got %+v", c)
+	}
+}
+
+func TestUpdate_maxClients(t *testing.T) {
+	a, k := newTestAccountant()
+	a.MaxClients = 1
+	k.neighbors = []neighbor{testNeighbor(2), testNeighbor(3)}
+
+	if err := a.update(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(a.Clients()); n != 1 {
+		t.Errorf("expected 1 client; got %d", n)
+	}
+}
+
+func TestUpdate_prunesIdleClients(t *testing.T) {
+	a, k := newTestAccountant()
+	k.neighbors = []neighbor{testNeighbor(2)}
+	if err := a.update(); err != nil {
+		t.Fatal(err)
+	}
+
+	k.neighbors = nil
+	k.now = k.now.Add(a.IdleTimeout / 2)
+	if err := a.update(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(a.Clients()); n != 1 {
+		t.Fatalf("expected recently active client to be kept; got %d clients", n)
+	}
+
+	k.now = k.now.Add(a.IdleTimeout)
+	if err := a.update(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(a.Clients()); n != 0 {
+		t.Errorf("expected idle client to be pruned; got %d clients", n)
+	}
+	if r := rules.Rule("-t filter -D fw-acct -s 10.0.0.2/32 -o eth1"); !k.rules[r] {
+		t.Errorf("expected rule %q to be applied", r)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	a, k := newTestAccountant()
+	k.neighbors = []neighbor{testNeighbor(2)}
+	if err := a.update(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, httptest.NewRequest("GET", "/clients", nil))
+
+	var resp struct {
+		Clients []Client `json:"clients"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Clients) != 1 || resp.Clients[0].MAC != "02:00:00:00:00:02" {
+		t.Errorf("unexpected response: %s", w.Body.String())
+	}
+}
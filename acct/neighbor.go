@@ -0,0 +1,46 @@
+package acct
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"go.jonnrb.io/egress/fw"
+)
+
+// Neighbor states in which a neighbor is considered present.
+const presentStates = netlink.NUD_REACHABLE |
+	netlink.NUD_STALE |
+	netlink.NUD_DELAY |
+	netlink.NUD_PROBE |
+	netlink.NUD_PERMANENT
+
+type neighbor struct {
+	IP  net.IP
+	MAC net.HardwareAddr
+}
+
+// Lists the IPv4 neighbors present on link.
+func listNeighbors(link fw.Link) ([]neighbor, error) {
+	l, err := netlink.LinkByName(link.Name())
+	if err != nil {
+		return nil, fmt.Errorf("acct: could not find link %q: %w", link.Name(), err)
+	}
+	ns, err := netlink.NeighList(l.Attrs().Index, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, fmt.Errorf("acct: could not list neighbors on %q: %w", link.Name(), err)
+	}
+
+	var out []neighbor
+	for _, n := range ns {
+		if n.State&presentStates == 0 || len(n.HardwareAddr) == 0 {
+			continue
+		}
+		ip := n.IP.To4()
+		if ip == nil {
+			continue
+		}
+		out = append(out, neighbor{IP: ip, MAC: n.HardwareAddr})
+	}
+	return out, nil
+}
@@ -4,18 +4,64 @@ import (
 	"sync"
 
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 var (
-	once sync.Once
-	c    *rest.Config
-	e    error
+	mu         sync.Mutex
+	loaded     bool
+	c          *rest.Config
+	e          error
+	kubeconfig string
 )
 
-// Creates a kubernetes client configured from the environment.
+// Makes Get() load the kubeconfig at path instead of using the pod's service
+// account.
+func UseKubeconfig(path string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	kubeconfig, loaded = path, false
+}
+
+// Makes Get() return cfg. Meant for tests (e.g. against a fake API server).
+func Set(cfg *rest.Config) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	loaded, c, e = true, cfg, nil
+}
+
+// Creates a kubernetes client configured from the environment (or the
+// kubeconfig passed to UseKubeconfig()).
 func Get() (*rest.Config, error) {
-	once.Do(func() {
-		c, e = rest.InClusterConfig()
-	})
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !loaded {
+		if kubeconfig != "" {
+			c, e = clientcmd.BuildConfigFromFlags("", kubeconfig)
+		} else {
+			c, e = rest.InClusterConfig()
+		}
+		loaded = true
+	}
 	return c, e
 }
+
+// Returns the namespace of the current context in the kubeconfig passed to
+// UseKubeconfig(), if any.
+func KubeconfigNamespace() (string, error) {
+	mu.Lock()
+	path := kubeconfig
+	mu.Unlock()
+
+	if path == "" {
+		return "", nil
+	}
+	ns, _, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: path},
+		&clientcmd.ConfigOverrides{},
+	).Namespace()
+	return ns, err
+}
@@ -0,0 +1,301 @@
+// Package cniroute implements a chained CNI plugin that routes pods attached to
+// an egress-managed network through the egress gateway.
+//
+// It is added after the plugin that attaches and addresses the interface in a
+// NetworkAttachmentDefinition's plugin list:
+//
+//	{
+//	  "cniVersion": "0.3.1",
+//	  "name": "lan",
+//	  "plugins": [
+//	    {"type": "macvlan", "ipam": {...}},
+//	    {
+//	      "type": "egress-cni",
+//	      "exclude": ["10.96.0.0/12", "10.244.0.0/16"],
+//	      "skipPods": ["egress/gw-*"]
+//	    }
+//	  ]
+//	}
+package cniroute
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/cni/pkg/version"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+)
+
+type NetConf struct {
+	types.NetConf
+
+	// The egress gateway. Defaults to the gateway the previous plugin's IPAM
+	// assigned to the interface.
+	Gateway string `json:"gateway"`
+
+	// Destinations to route through the gateway. Defaults to the default route
+	// of each IP family the gateway is known for.
+	Routes []string `json:"routes"`
+
+	// Destinations that keep going the way the default route went before it
+	// was replaced (e.g. the cluster's pod and service CIDRs).
+	Exclude []string `json:"exclude"`
+
+	// Pods ("namespace/name" globs) to leave alone. The egress routers
+	// themselves are attached to the network and must not route through the
+	// gateway they provide.
+	SkipPods []string `json:"skipPods"`
+}
+
+type k8sArgs struct {
+	types.CommonArgs
+	K8S_POD_NAMESPACE types.UnmarshallableString
+	K8S_POD_NAME      types.UnmarshallableString
+}
+
+func parseConf(b []byte) (*NetConf, error) {
+	conf := &NetConf{}
+	if err := json.Unmarshal(b, conf); err != nil {
+		return nil, fmt.Errorf("cniroute: could not parse config: %w", err)
+	}
+	if conf.RawPrevResult == nil {
+		return nil, fmt.Errorf("cniroute: must be chained after a plugin that addresses the interface")
+	}
+	if err := version.ParsePrevResult(&conf.NetConf); err != nil {
+		return nil, fmt.Errorf("cniroute: %w", err)
+	}
+	if conf.Gateway != "" && net.ParseIP(conf.Gateway) == nil {
+		return nil, fmt.Errorf("cniroute: invalid gateway %q", conf.Gateway)
+	}
+	for _, s := range append(append([]string(nil), conf.Routes...), conf.Exclude...) {
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			return nil, fmt.Errorf("cniroute: invalid route %q: %w", s, err)
+		}
+	}
+	for _, p := range conf.SkipPods {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("cniroute: invalid skipPods pattern %q: %w", p, err)
+		}
+	}
+	return conf, nil
+}
+
+// Whether the pod described by CNI_ARGS is to be left alone.
+func (conf *NetConf) skip(cniArgs string) (bool, error) {
+	if len(conf.SkipPods) == 0 {
+		return false, nil
+	}
+	var args k8sArgs
+	args.IgnoreUnknown = true
+	if err := types.LoadArgs(cniArgs, &args); err != nil {
+		return false, fmt.Errorf("cniroute: could not parse CNI_ARGS: %w", err)
+	}
+	pod := string(args.K8S_POD_NAMESPACE) + "/" + string(args.K8S_POD_NAME)
+	for _, p := range conf.SkipPods {
+		if ok, _ := path.Match(p, pod); ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Returns the gateway to use for each IP family ("4" or "6").
+func (conf *NetConf) gateways(result *current.Result, ifName string) map[string]net.IP {
+	gws := make(map[string]net.IP)
+	if conf.Gateway != "" {
+		gw := net.ParseIP(conf.Gateway)
+		gws[family(gw)] = gw
+		return gws
+	}
+	for _, ip := range result.IPs {
+		if ip.Gateway == nil || ip.Interface == nil ||
+			*ip.Interface < 0 || *ip.Interface >= len(result.Interfaces) {
+			continue
+		}
+		if iface := result.Interfaces[*ip.Interface]; iface.Name != ifName || iface.Sandbox == "" {
+			continue
+		}
+		if _, ok := gws[family(ip.Gateway)]; !ok {
+			gws[family(ip.Gateway)] = ip.Gateway
+		}
+	}
+	return gws
+}
+
+// Returns the routes to install through gws.
+func (conf *NetConf) routes(gws map[string]net.IP) ([]*types.Route, error) {
+	dsts := conf.Routes
+	if len(dsts) == 0 {
+		if _, ok := gws["4"]; ok {
+			dsts = append(dsts, "0.0.0.0/0")
+		}
+		if _, ok := gws["6"]; ok {
+			dsts = append(dsts, "::/0")
+		}
+	}
+	if len(dsts) == 0 {
+		return nil, fmt.Errorf("cniroute: no gateway configured or assigned by IPAM")
+	}
+
+	var routes []*types.Route
+	for _, s := range dsts {
+		_, dst, _ := net.ParseCIDR(s)
+		gw, ok := gws[family(dst.IP)]
+		if !ok {
+			return nil, fmt.Errorf("cniroute: no IPv%s gateway for route %v", family(dst.IP), dst)
+		}
+		routes = append(routes, &types.Route{Dst: *dst, GW: gw})
+	}
+	return routes, nil
+}
+
+func family(ip net.IP) string {
+	if ip.To4() != nil {
+		return "4"
+	}
+	return "6"
+}
+
+func Add(args *skel.CmdArgs) error {
+	conf, err := parseConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	result, err := current.NewResultFromResult(conf.PrevResult)
+	if err != nil {
+		return fmt.Errorf("cniroute: could not convert prevResult: %w", err)
+	}
+	if skip, err := conf.skip(args.Args); err != nil {
+		return err
+	} else if skip {
+		return types.PrintResult(result, conf.CNIVersion)
+	}
+
+	routes, err := conf.routes(conf.gateways(result, args.IfName))
+	if err != nil {
+		return err
+	}
+	err = ns.WithNetNSPath(args.Netns, func(ns.NetNS) error {
+		return install(args.IfName, routes, conf.Exclude)
+	})
+	if err != nil {
+		return err
+	}
+
+	result.Routes = append(result.Routes, routes...)
+	return types.PrintResult(result, conf.CNIVersion)
+}
+
+// Routes are torn down with the pod's network namespace.
+func Del(args *skel.CmdArgs) error {
+	return nil
+}
+
+func Check(args *skel.CmdArgs) error {
+	conf, err := parseConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	result, err := current.NewResultFromResult(conf.PrevResult)
+	if err != nil {
+		return fmt.Errorf("cniroute: could not convert prevResult: %w", err)
+	}
+	if skip, err := conf.skip(args.Args); err != nil || skip {
+		return err
+	}
+
+	routes, err := conf.routes(conf.gateways(result, args.IfName))
+	if err != nil {
+		return err
+	}
+	return ns.WithNetNSPath(args.Netns, func(ns.NetNS) error {
+		return check(args.IfName, routes)
+	})
+}
+
+func install(ifName string, routes []*types.Route, exclude []string) error {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("cniroute: could not find %q: %w", ifName, err)
+	}
+
+	// Pin the excluded destinations to the default route before it is
+	// replaced.
+	for _, s := range exclude {
+		_, dst, _ := net.ParseCIDR(s)
+		def, err := defaultRoute(dst.IP)
+		if err != nil {
+			return err
+		}
+		if def == nil {
+			continue
+		}
+		r := *def
+		r.Dst = dst
+		if err := netlink.RouteReplace(&r); err != nil {
+			return fmt.Errorf("cniroute: could not route %v: %w", dst, err)
+		}
+	}
+
+	for _, route := range routes {
+		dst := route.Dst
+		err := netlink.RouteReplace(&netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Dst:       &dst,
+			Gw:        route.GW,
+		})
+		if err != nil {
+			return fmt.Errorf("cniroute: could not route %v via %v: %w", &dst, route.GW, err)
+		}
+	}
+	return nil
+}
+
+func defaultRoute(ip net.IP) (*netlink.Route, error) {
+	fam := netlink.FAMILY_V4
+	if ip.To4() == nil {
+		fam = netlink.FAMILY_V6
+	}
+	routes, err := netlink.RouteList(nil, fam)
+	if err != nil {
+		return nil, fmt.Errorf("cniroute: could not list routes: %w", err)
+	}
+	for _, r := range routes {
+		if r.Dst == nil || r.Dst.IP.IsUnspecified() {
+			return &netlink.Route{LinkIndex: r.LinkIndex, Gw: r.Gw}, nil
+		}
+	}
+	return nil, nil
+}
+
+func check(ifName string, routes []*types.Route) error {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("cniroute: could not find %q: %w", ifName, err)
+	}
+	for _, route := range routes {
+		dst := &route.Dst
+		// Default routes are listed without a destination.
+		if ones, _ := dst.Mask.Size(); ones == 0 {
+			dst = nil
+		}
+		found, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Dst:       dst,
+			Gw:        route.GW,
+		}, netlink.RT_FILTER_OIF|netlink.RT_FILTER_DST|netlink.RT_FILTER_GW)
+		if err != nil {
+			return fmt.Errorf("cniroute: could not list routes: %w", err)
+		}
+		if len(found) == 0 {
+			return fmt.Errorf("cniroute: missing route to %v via %v", &route.Dst, route.GW)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package cniroute
+
+import (
+	"net"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types/current"
+)
+
+const testConf = `{
+	"cniVersion": "0.3.1",
+	"name": "lan",
+	"type": "egress-cni",
+	"exclude": ["10.96.0.0/12"],
+	"skipPods": ["egress/gw-*"],
+	"prevResult": {
+		"cniVersion": "0.3.1",
+		"interfaces": [
+			{"name": "eth0", "sandbox": "/var/run/netns/a"},
+			{"name": "net1", "sandbox": "/var/run/netns/a"},
+			{"name": "macvlan0"}
+		],
+		"ips": [
+			{"version": "4", "interface": 0, "address": "10.244.0.5/24", "gateway": "10.244.0.1"},
+			{"version": "4", "interface": 1, "address": "192.168.1.20/24", "gateway": "192.168.1.1"},
+			{"version": "6", "interface": 1, "address": "fd00::20/64", "gateway": "fd00::1"}
+		]
+	}
+}`
+
+func mustParseConf(t *testing.T, s string) (*NetConf, *current.Result) {
+	conf, err := parseConf([]byte(s))
+	if err != nil {
+		t.Fatalf("parseConf() = %v", err)
+	}
+	result, err := current.NewResultFromResult(conf.PrevResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf, result
+}
+
+func TestParseConf_requiresPrevResult(t *testing.T) {
+	_, err := parseConf([]byte(`{"cniVersion": "0.3.1", "type": "egress-cni"}`))
+	if err == nil {
+		t.Error("expected an error without a prevResult")
+	}
+}
+
+func TestRoutes_fromIPAM(t *testing.T) {
+	conf, result := mustParseConf(t, testConf)
+
+	routes, err := conf.routes(conf.gateways(result, "net1"))
+	if err != nil {
+		t.Fatalf("routes() = %v", err)
+	}
+	want := []string{"0.0.0.0/0 via 192.168.1.1", "::/0 via fd00::1"}
+	if len(routes) != len(want) {
+		t.Fatalf("got %d routes; want %d", len(routes), len(want))
+	}
+	for i, r := range routes {
+		if got := r.Dst.String() + " via " + r.GW.String(); got != want[i] {
+			t.Errorf("got route %q; want %q", got, want[i])
+		}
+	}
+}
+
+func TestRoutes_explicit(t *testing.T) {
+	conf, result := mustParseConf(t, testConf)
+	conf.Gateway = "192.168.1.254"
+	conf.Routes = []string{"0.0.0.0/1", "128.0.0.0/1"}
+
+	routes, err := conf.routes(conf.gateways(result, "net1"))
+	if err != nil {
+		t.Fatalf("routes() = %v", err)
+	}
+	for _, r := range routes {
+		if !r.GW.Equal(net.ParseIP("192.168.1.254")) {
+			t.Errorf("route %v goes via %v; want 192.168.1.254", &r.Dst, r.GW)
+		}
+	}
+
+	conf.Routes = []string{"::/0"}
+	if _, err := conf.routes(conf.gateways(result, "net1")); err == nil {
+		t.Error("expected an error routing IPv6 without an IPv6 gateway")
+	}
+}
+
+func TestRoutes_noGateway(t *testing.T) {
+	conf, result := mustParseConf(t, testConf)
+	if _, err := conf.routes(conf.gateways(result, "net2")); err == nil {
+		t.Error("expected an error for an interface without a gateway")
+	}
+}
+
+func TestSkip(t *testing.T) {
+	conf, _ := mustParseConf(t, testConf)
+	for args, want := range map[string]bool{
+		"IgnoreUnknown=1;K8S_POD_NAMESPACE=egress;K8S_POD_NAME=gw-7d9f-abcde":  true,
+		"IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=gw-7d9f-abcde": false,
+		"K8S_POD_NAMESPACE=egress;K8S_POD_NAME=client":                         false,
+	} {
+		got, err := conf.skip(args)
+		if err != nil {
+			t.Errorf("skip(%q) = %v", args, err)
+		} else if got != want {
+			t.Errorf("skip(%q) = %v; want %v", args, got, want)
+		}
+	}
+}
@@ -1,32 +1,50 @@
 package kubernetes
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/vishvananda/netlink"
 	"go.jonnrb.io/egress/backend/kubernetes/coordinator"
+	"go.jonnrb.io/egress/ctsync"
 	"go.jonnrb.io/egress/fw"
 	"go.jonnrb.io/egress/fw/rules"
 	"go.jonnrb.io/egress/ha"
+	"go.jonnrb.io/egress/log"
 	"go.jonnrb.io/egress/vaddr/dhcp"
+	"go.jonnrb.io/egress/vaddr/srcroute"
+	"go.jonnrb.io/egress/vaddr/wireguard"
 )
 
 type Params struct {
-	LANNetwork           string    `json:"lanNetwork"`
-	LANMACAddress        string    `json:"lanMACAddress"`
-	FlatNetworks         []string  `json:"flatNetworks"`
-	UplinkNetwork        string    `json:"uplinkNetwork"`
-	UplinkInterface      string    `json:"uplinkInterface"`
-	UplinkMACAddress     string    `json:"uplinkMACAddress"`
-	UplinkIPAddress      string    `json:"uplinkIPAddress"`
-	UplinkGWAddress      string    `json:"uplinkGWAddress"`
-	UplinkLeaseConfigMap string    `json:"uplinkLeaseConfigMap"`
-	HA                   *HAParams `json:"ha"`
+	LANNetwork           string                 `json:"lanNetwork"`
+	LANMACAddress        string                 `json:"lanMACAddress"`
+	LANGWAddress         string                 `json:"lanGWAddress"`
+	LANGWAddresses       map[string]string      `json:"lanGWAddresses"`
+	LANName              string                 `json:"lanName"`
+	LANs                 []LANParams            `json:"lans"`
+	LANPolicy            []LANPolicyParams      `json:"lanPolicy"`
+	FlatNetworks         []string               `json:"flatNetworks"`
+	UplinkNetwork        string                 `json:"uplinkNetwork"`
+	UplinkInterface      string                 `json:"uplinkInterface"`
+	UplinkMACAddress     string                 `json:"uplinkMACAddress"`
+	UplinkIPAddress      string                 `json:"uplinkIPAddress"`
+	UplinkGWAddress      string                 `json:"uplinkGWAddress"`
+	UplinkLeaseConfigMap string                 `json:"uplinkLeaseConfigMap"`
+	UplinkLeaseFile      string                 `json:"uplinkLeaseFile"`
+	UplinkResolvConf     string                 `json:"uplinkResolvConf"`
+	UplinkVLAN           int                    `json:"uplinkVLAN"`
+	UplinkDHCP           *DHCPParams            `json:"uplinkDHCP"`
+	UplinkWireGuard      *WireGuardParams       `json:"uplinkWireGuard"`
+	UplinkSelection      *UplinkSelectionParams `json:"uplinkSelection"`
+	HA                   *HAParams              `json:"ha"`
+	ConntrackSync        *ConntrackSyncParams   `json:"conntrackSync"`
 }
 
 type HAParams struct {
@@ -36,10 +54,34 @@ type HAParams struct {
 	RetryPeriod   string `json:"retryPeriod"`
 }
 
+type DHCPParams struct {
+	// Hex, optionally separated by colons (e.g. "01:aa:bb:cc:dd:ee:ff").
+	ClientID    string `json:"clientID"`
+	Hostname    string `json:"hostname"`
+	VendorClass string `json:"vendorClass"`
+
+	// Releases the lease when the router stops. Only makes sense without HA,
+	// since the lease floats to the next leader with the virtual MAC.
+	ReleaseOnStop bool `json:"releaseOnStop"`
+}
+
+type ConntrackSyncParams struct {
+	Port    int    `json:"port"`
+	KeyFile string `json:"keyFile"`
+	// The interface peers reach the pod IP on. Defaults to "eth0", the
+	// cluster network's interface.
+	Interface string `json:"interface"`
+}
+
 // Reads params from the file `/etc/config/egress.json`.
 func ParamsFromFile() (params Params, err error) {
+	return ParamsFromPath("/etc/config/egress.json")
+}
+
+// Reads params from the JSON file at path.
+func ParamsFromPath(path string) (params Params, err error) {
 	var f io.ReadCloser
-	f, err = os.Open("/etc/config/egress.json")
+	f, err = os.Open(path)
 	if err != nil {
 		return
 	}
@@ -49,6 +91,11 @@ func ParamsFromFile() (params Params, err error) {
 	return
 }
 
+// Checks that params are valid without resolving anything against the cluster.
+func (params Params) Validate() error {
+	return params.check()
+}
+
 func (params Params) check() error {
 	if params.LANNetwork == "" {
 		return fmt.Errorf("lanNetwork must be specified")
@@ -56,6 +103,17 @@ func (params Params) check() error {
 	if _, err := net.ParseMAC(params.LANMACAddress); err != nil && params.LANMACAddress != "" {
 		return fmt.Errorf("if lanMACAddress is specified, it must be valid: %w", err)
 	}
+	if err := checkLANGWAddress(params.LANGWAddress); params.LANGWAddress != "" && err != nil {
+		return fmt.Errorf("if lanGWAddress is specified, it must be valid: %w", err)
+	}
+	for iface, a := range params.LANGWAddresses {
+		if err := checkLANGWAddress(a); err != nil {
+			return fmt.Errorf("if lanGWAddresses[%q] is specified, it must be valid: %w", iface, err)
+		}
+	}
+	if err := params.checkLANs(); err != nil {
+		return fmt.Errorf("if lans are specified, they must be valid: %w", err)
+	}
 	if params.UplinkNetwork == "" && params.UplinkInterface == "" {
 		return fmt.Errorf("uplinkNetwork or uplinkInterface must be specified")
 	}
@@ -74,12 +132,54 @@ func (params Params) check() error {
 	if _, _, err := splitNamespaceName(params.UplinkLeaseConfigMap); params.UplinkLeaseConfigMap != "" && err != nil {
 		return fmt.Errorf("if uplinkLeaseStoreName is specified, it must be valid: %w", err)
 	}
+	if params.UplinkLeaseConfigMap != "" && params.UplinkLeaseFile != "" {
+		return fmt.Errorf("cannot specify both uplinkLeaseConfigMap and uplinkLeaseFile")
+	}
+	if params.UplinkVLAN < 0 || params.UplinkVLAN > 4094 {
+		return fmt.Errorf("if uplinkVLAN is specified, it must be valid: %d", params.UplinkVLAN)
+	}
+	if err := params.UplinkDHCP.check(); err != nil {
+		return fmt.Errorf("if uplinkDHCP is specified, it must be valid: %w", err)
+	}
+	if err := params.UplinkWireGuard.check(); err != nil {
+		return fmt.Errorf("if uplinkWireGuard is specified, it must be valid: %w", err)
+	}
+	if params.UplinkWireGuard != nil {
+		if params.UplinkInterface == "" {
+			return fmt.Errorf("uplinkWireGuard requires uplinkInterface to be specified")
+		}
+		if params.UplinkMACAddress != "" || params.UplinkIPAddress != "" ||
+			params.UplinkGWAddress != "" || params.UplinkVLAN != 0 {
+			return fmt.Errorf("uplinkWireGuard cannot be used with uplinkMACAddress, uplinkIPAddress, uplinkGWAddress or uplinkVLAN")
+		}
+	}
+	if err := params.UplinkSelection.check(); err != nil {
+		return fmt.Errorf("if uplinkSelection is specified, it must be valid: %w", err)
+	}
 	if err := params.HA.check(); err != nil {
 		return fmt.Errorf("if ha is specified, it must be valid: %w", err)
 	}
+	if params.ConntrackSync != nil && params.HA == nil {
+		return fmt.Errorf("conntrackSync requires ha to be specified")
+	}
+	if err := params.ConntrackSync.check(); err != nil {
+		return fmt.Errorf("if conntrackSync is specified, it must be valid: %w", err)
+	}
+	if params.ConntrackSync != nil && params.ConntrackSync.iface() == params.UplinkInterface {
+		return fmt.Errorf("conntrackSync must not listen on the uplink %q", params.UplinkInterface)
+	}
 	return nil
 }
 
+// A LAN gateway address may be an IP or a CIDR.
+func checkLANGWAddress(s string) error {
+	if net.ParseIP(s) != nil {
+		return nil
+	}
+	_, err := fw.ParseAddr(s)
+	return err
+}
+
 func (haParams *HAParams) check() error {
 	if haParams == nil {
 		return nil
@@ -99,13 +199,45 @@ func (haParams *HAParams) check() error {
 	return nil
 }
 
+func (dhcpParams *DHCPParams) check() error {
+	if dhcpParams == nil {
+		return nil
+	}
+	if _, err := parseClientID(dhcpParams.ClientID); err != nil {
+		return fmt.Errorf("if clientID is specified, it must be valid: %w", err)
+	}
+	return nil
+}
+
+func parseClientID(s string) ([]byte, error) {
+	return hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+}
+
+func (ctParams *ConntrackSyncParams) check() error {
+	if ctParams == nil {
+		return nil
+	}
+	if ctParams.Port < 0 || ctParams.Port > 65535 {
+		return fmt.Errorf("if port is specified, it must be valid: %d", ctParams.Port)
+	}
+	if ctParams.KeyFile == "" {
+		return fmt.Errorf("keyFile must be specified")
+	}
+	return nil
+}
+
 type Config struct {
 	params           Params
 	uplink           netlink.Link
 	uplinkLeaseStore dhcp.LeaseStore
+	uplinkWireGuard  *wireguard.Config
+	selectable       []srcroute.Uplink
 	lan              netlink.Link
 	lanAddr          fw.Addr
+	extraLANs        []fw.ExtraLAN
+	namedLANs        []fw.NamedLAN
 	flat             []fw.StaticRoute
+	conntrackSync    *ctsync.Member
 }
 
 type link struct{ *netlink.LinkAttrs }
@@ -130,6 +262,10 @@ func (cfg *Config) LANAddr() (a fw.Addr, ok bool) {
 	return cfg.lanAddr, true
 }
 
+func (cfg *Config) ExtraLANs() []fw.ExtraLAN {
+	return cfg.extraLANs
+}
+
 func (cfg *Config) Uplink() fw.Link {
 	return link{cfg.uplink.Attrs()}
 }
@@ -143,11 +279,15 @@ func (cfg *Config) UplinkHWAddr() net.HardwareAddr {
 }
 
 func (cfg *Config) UplinkAddr() (a fw.Addr, ok bool) {
-	if cfg.params.UplinkIPAddress == "" {
+	s := cfg.params.UplinkIPAddress
+	if wg := cfg.params.UplinkWireGuard; wg != nil {
+		s = wg.Address
+	}
+	if s == "" {
 		return
 	}
 	var err error
-	a, err = fw.ParseAddr(cfg.params.UplinkIPAddress)
+	a, err = fw.ParseAddr(s)
 	if err != nil {
 		panic("kubernetes: config should have been checked")
 	}
@@ -162,10 +302,58 @@ func (cfg *Config) UplinkGW() (a net.IP, ok bool) {
 	return net.ParseIP(cfg.params.UplinkGWAddress), true
 }
 
+func (cfg *Config) UplinkDHCPOptions() (o dhcp.ClientOptions) {
+	p := cfg.params.UplinkDHCP
+	if p == nil {
+		return
+	}
+	var err error
+	o.ClientID, err = parseClientID(p.ClientID)
+	if err != nil {
+		panic("kubernetes: config should have been checked")
+	}
+	o.Hostname = p.Hostname
+	o.VendorClass = p.VendorClass
+	return
+}
+
+func (cfg *Config) UplinkDHCPReleaseOnStop() bool {
+	return cfg.params.UplinkDHCP != nil && cfg.params.UplinkDHCP.ReleaseOnStop
+}
+
 func (cfg *Config) UplinkLeaseStore() dhcp.LeaseStore {
 	return cfg.uplinkLeaseStore
 }
 
+// Writes the DNS configuration from the uplink's lease to the uplinkResolvConf
+// param, if specified, for a resolver on the LAN to use.
+func (cfg *Config) UplinkLeaseBound(l dhcp.Lease) {
+	if cfg.params.UplinkResolvConf == "" {
+		return
+	}
+	if err := dhcp.WriteResolvConf(cfg.params.UplinkResolvConf, l); err != nil {
+		log.Warningf("kubernetes: could not write uplink resolv.conf: %v", err)
+	}
+}
+
+func (cfg *Config) UplinkWireGuard() *wireguard.Config {
+	return cfg.uplinkWireGuard
+}
+
+func (cfg *Config) SelectableUplinks() []srcroute.Uplink {
+	return cfg.selectable
+}
+
+func (cfg *Config) UplinkSelectionSource() srcroute.Source {
+	if cfg.params.UplinkSelection == nil {
+		return nil
+	}
+	return &uplinkSelectionSource{
+		params:     *cfg.params.UplinkSelection,
+		lanNetwork: cfg.params.LANNetwork,
+	}
+}
+
 func (cfg *Config) HACoordinator() ha.Coordinator {
 	if cfg.params.HA == nil {
 		return nil
@@ -199,10 +387,24 @@ func (cfg *Config) HACoordinator() ha.Coordinator {
 	}
 }
 
+func (cfg *Config) ConntrackSync() ha.Member {
+	if cfg.conntrackSync == nil {
+		return nil
+	}
+	return cfg.conntrackSync
+}
+
 func (cfg *Config) FlatNetworks() []fw.StaticRoute {
 	return cfg.flat
 }
 
-func (cfg *Config) ExtraRules() rules.RuleSet {
-	return nil
+func (cfg *Config) ExtraRules() (r rules.RuleSet) {
+	if cfg.params.ConntrackSync != nil {
+		ct := cfg.params.ConntrackSync
+		r = append(r, fw.OpenPortOnInterface("tcp", ct.port(), fw.LinkString(ct.iface())))
+	}
+	if cfg.params.UplinkSelection != nil {
+		r = append(r, srcroute.ChainRules()...)
+	}
+	return
 }
@@ -27,6 +27,16 @@ type Coordinator struct {
 	RetryPeriod   time.Duration
 }
 
+func (c *Coordinator) String() string {
+	name := c.LockName
+	if c.LockNamespace != "" {
+		name = c.LockNamespace + "/" + name
+	}
+	return fmt.Sprintf(
+		"Kubernetes lease %s (lease %v, renew deadline %v, retry %v)",
+		name, c.LeaseDuration, c.RenewDeadline, c.RetryPeriod)
+}
+
 func (c *Coordinator) Run(ctx context.Context, m ha.Member) (err error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -0,0 +1,100 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+
+	"go.jonnrb.io/egress/backend/kubernetes/client"
+	"go.jonnrb.io/egress/backend/kubernetes/metadata"
+	"go.jonnrb.io/egress/ctsync"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	defaultConntrackSyncPort      = 3780
+	defaultConntrackSyncInterface = "eth0"
+)
+
+func (ctParams *ConntrackSyncParams) port() int {
+	if ctParams.Port == 0 {
+		return defaultConntrackSyncPort
+	}
+	return ctParams.Port
+}
+
+func (ctParams *ConntrackSyncParams) iface() string {
+	if ctParams.Interface == "" {
+		return defaultConntrackSyncInterface
+	}
+	return ctParams.Interface
+}
+
+func getConntrackSync(env environment, params Params) (*ctsync.Member, error) {
+	if params.ConntrackSync == nil {
+		return nil, nil
+	}
+	port := strconv.Itoa(params.ConntrackSync.port())
+	if env.dryRun {
+		return &ctsync.Member{ListenAddr: net.JoinHostPort("", port)}, nil
+	}
+
+	key, err := ioutil.ReadFile(params.ConntrackSync.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"kubernetes: could not read conntrack sync key: %w", err)
+	}
+	key = []byte(strings.TrimSpace(string(key)))
+	if len(key) == 0 {
+		return nil, fmt.Errorf(
+			"kubernetes: conntrack sync key file %q is empty",
+			params.ConntrackSync.KeyFile)
+	}
+
+	ct, err := ctsync.NewNetlink()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ctsync.Member{
+		Conntrack:  ct,
+		ListenAddr: net.JoinHostPort("", port),
+		Key:        key,
+		ResolveLeader: func(ctx context.Context, leader string) (string, error) {
+			ip, err := getPodIP(ctx, leader)
+			if err != nil {
+				return "", err
+			}
+			return net.JoinHostPort(ip, port), nil
+		},
+	}, nil
+}
+
+// Leader identities are pod names (see coordinator.Coordinator), so the
+// leader's address is its pod IP.
+func getPodIP(ctx context.Context, name string) (string, error) {
+	restCfg, err := client.Get()
+	if err != nil {
+		return "", err
+	}
+	cli, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		return "", err
+	}
+	ns, err := metadata.GetPodNamespace()
+	if err != nil {
+		return "", fmt.Errorf("kubernetes: could not get pod namespace: %w", err)
+	}
+	pod, err := cli.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("kubernetes: could not get pod %q: %w", name, err)
+	}
+	if pod.Status.PodIP == "" {
+		return "", fmt.Errorf("kubernetes: pod %q has no IP", name)
+	}
+	return pod.Status.PodIP, nil
+}
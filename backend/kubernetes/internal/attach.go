@@ -4,10 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"os"
-	"time"
+	"strings"
 
 	cnitypes "github.com/containernetworking/cni/pkg/types"
+	"go.jonnrb.io/egress/backend/kubernetes/metadata"
 )
 
 type Attachment struct {
@@ -29,34 +29,22 @@ type Attachment struct {
 // Gets the networks this pod is attached to. Expects that the
 // `metadata.annotations['k8s.v1.cni.cncf.io/networks-status']` fieldPath is
 // exposed to this container via the downward API [1] at
-// `/etc/podinfo/attachments`.
+// `/etc/podinfo/attachments` (unless metadata.GetAttachments is overridden).
 //
 // [1] https://kubernetes.io/docs/tasks/inject-data-application/downward-api-volume-expose-pod-information/
 //
 func GetAttachments() ([]Attachment, error) {
-	return getAttachmentsInternal("/etc/podinfo/attachments")
-}
-
-func getAttachmentsInternal(path string) (attachments []Attachment, err error) {
-	// Do a backoff on the path.
-	for backoff := 100 * time.Millisecond; backoff < time.Second; backoff = backoff * 2 {
-		attachments, err = tryGetAttachments(path)
-		if err == nil {
-			return
-		}
-		time.Sleep(backoff)
+	s, err := metadata.GetAttachments()
+	if err != nil {
+		return nil, fmt.Errorf("error reading attachments: %v", err)
 	}
-	attachments, err = tryGetAttachments(path)
-	return
+	return ParseNetworkStatus(s)
 }
 
-func tryGetAttachments(path string) ([]Attachment, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, fmt.Errorf("error opening %q: %v", path, err)
-	}
-	defer f.Close()
-	return decodeNetworkStatus(f)
+// Parses the value of a pod's `k8s.v1.cni.cncf.io/networks-status`
+// annotation.
+func ParseNetworkStatus(s string) ([]Attachment, error) {
+	return decodeNetworkStatus(strings.NewReader(s))
 }
 
 func decodeNetworkStatus(r io.Reader) ([]Attachment, error) {
@@ -3,11 +3,11 @@ package internal
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
 
-	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/allocator"
 	clientset "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/typed/k8s.cni.cncf.io/v1"
 	"go.jonnrb.io/egress/backend/kubernetes/metadata"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -56,6 +56,7 @@ func (c *CNIClient) Get(ctx context.Context, netName string) (*NetworkDefinition
 
 	conf := net.Spec.Config
 	ranges, err := extractRanges([]byte(conf))
+	ranges, err = applyAnnotations(ranges, err, net.Annotations)
 	if err != nil {
 		return nil, err
 	}
@@ -72,37 +73,27 @@ func extractRanges(raw []byte) ([]Range, error) {
 	var cl confList
 	_ = json.Unmarshal(raw, &cl)
 	if len(cl.Plugins) != 0 {
-		var err error
+		err := errNoIPAM
 
 		for _, p := range cl.Plugins {
-			raw, err = json.Marshal(p)
-			if err != nil {
-				return nil, fmt.Errorf("could not remarshal sub-plugin: %w", err)
+			raw, merr := json.Marshal(p)
+			if merr != nil {
+				return nil, fmt.Errorf("could not remarshal sub-plugin: %w", merr)
 			}
-			ranges, err := extractRanges(raw)
-			if err == nil {
+			ranges, perr := parseIPAM(raw)
+			if perr == nil {
 				return ranges, nil
 			}
+			// Keep the error from the plugin that has IPAM config.
+			if !errors.Is(perr, errNoIPAM) {
+				err = perr
+			}
 		}
 
 		return nil, fmt.Errorf("could not find IPAM config in plugin chain: %w", err)
 	}
 
-	ipamCfg, _, err := allocator.LoadIPAMConfig(raw, "")
-	if err != nil {
-		return nil, err
-	}
-
-	var out []Range
-	for _, set := range ipamCfg.Ranges {
-		for _, r := range set {
-			out = append(out, Range{
-				Gateway: r.Gateway,
-				Subnet:  net.IPNet(r.Subnet),
-			})
-		}
-	}
-	return out, nil
+	return parseIPAM(raw)
 }
 
 // If fullName has a "/", returns the first split, otherwise returns
@@ -0,0 +1,236 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/allocator"
+)
+
+// Extracts the ranges a network's addresses are assigned from given the
+// network's plugin config (the plugin with an "ipam" section). Parsers should
+// return an error if the ranges can't be known from config alone.
+type IPAMParser func(pluginConf []byte) ([]Range, error)
+
+var ipamParsers = struct {
+	sync.RWMutex
+	m map[string]IPAMParser
+}{
+	m: map[string]IPAMParser{
+		"host-local":  parseHostLocal,
+		"whereabouts": parseWhereabouts,
+		"static":      parseStatic,
+		"dhcp":        parseDHCP,
+	},
+}
+
+// Makes networks whose IPAM is of type typ parseable. Replaces any existing
+// parser for typ.
+func RegisterIPAMParser(typ string, p IPAMParser) {
+	ipamParsers.Lock()
+	defer ipamParsers.Unlock()
+	ipamParsers.m[typ] = p
+}
+
+func ipamParser(typ string) (IPAMParser, error) {
+	ipamParsers.RLock()
+	defer ipamParsers.RUnlock()
+	p, ok := ipamParsers.m[typ]
+	if !ok {
+		var types []string
+		for t := range ipamParsers.m {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return nil, fmt.Errorf(
+			"unsupported IPAM type %q (supported: %s)", typ, strings.Join(types, ", "))
+	}
+	return p, nil
+}
+
+var errNoIPAM = errors.New("plugin has no IPAM config")
+
+func parseIPAM(pluginConf []byte) ([]Range, error) {
+	var conf struct {
+		IPAM *struct {
+			Type string `json:"type"`
+		} `json:"ipam"`
+	}
+	if err := json.Unmarshal(pluginConf, &conf); err != nil {
+		return nil, fmt.Errorf("could not parse plugin config: %w", err)
+	}
+	if conf.IPAM == nil {
+		return nil, errNoIPAM
+	}
+	p, err := ipamParser(conf.IPAM.Type)
+	if err != nil {
+		return nil, err
+	}
+	return p(pluginConf)
+}
+
+func parseHostLocal(pluginConf []byte) ([]Range, error) {
+	ipamCfg, _, err := allocator.LoadIPAMConfig(pluginConf, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Range
+	for _, set := range ipamCfg.Ranges {
+		for _, r := range set {
+			out = append(out, Range{
+				Gateway: r.Gateway,
+				Subnet:  net.IPNet(r.Subnet),
+			})
+		}
+	}
+	return out, nil
+}
+
+// Exclusions only narrow which addresses are handed out, so they don't affect
+// the range.
+func parseWhereabouts(pluginConf []byte) ([]Range, error) {
+	var conf struct {
+		IPAM struct {
+			Range   string   `json:"range"`
+			Exclude []string `json:"exclude"`
+			Gateway string   `json:"gateway"`
+		} `json:"ipam"`
+	}
+	if err := json.Unmarshal(pluginConf, &conf); err != nil {
+		return nil, fmt.Errorf("could not parse whereabouts config: %w", err)
+	}
+	_, subnet, err := net.ParseCIDR(conf.IPAM.Range)
+	if err != nil {
+		return nil, fmt.Errorf("invalid whereabouts range %q: %w", conf.IPAM.Range, err)
+	}
+	for _, e := range conf.IPAM.Exclude {
+		if _, _, err := net.ParseCIDR(e); err != nil {
+			return nil, fmt.Errorf("invalid whereabouts exclusion %q: %w", e, err)
+		}
+	}
+	r := Range{Subnet: *subnet}
+	if conf.IPAM.Gateway != "" {
+		if r.Gateway = net.ParseIP(conf.IPAM.Gateway); r.Gateway == nil {
+			return nil, fmt.Errorf("invalid whereabouts gateway %q", conf.IPAM.Gateway)
+		}
+	}
+	return []Range{r}, nil
+}
+
+// Each address's subnet is a range. Its gateway is the address's gateway or,
+// failing that, the gateway of a default route on the subnet.
+func parseStatic(pluginConf []byte) ([]Range, error) {
+	var conf struct {
+		IPAM struct {
+			Addresses []struct {
+				Address string `json:"address"`
+				Gateway string `json:"gateway"`
+			} `json:"addresses"`
+			Routes []struct {
+				Dst string `json:"dst"`
+				GW  string `json:"gw"`
+			} `json:"routes"`
+		} `json:"ipam"`
+	}
+	if err := json.Unmarshal(pluginConf, &conf); err != nil {
+		return nil, fmt.Errorf("could not parse static config: %w", err)
+	}
+
+	var defaultGWs []net.IP
+	for _, r := range conf.IPAM.Routes {
+		_, dst, err := net.ParseCIDR(r.Dst)
+		if err != nil {
+			return nil, fmt.Errorf("invalid static route %q: %w", r.Dst, err)
+		}
+		if ones, _ := dst.Mask.Size(); ones == 0 && r.GW != "" {
+			gw := net.ParseIP(r.GW)
+			if gw == nil {
+				return nil, fmt.Errorf("invalid static route gateway %q", r.GW)
+			}
+			defaultGWs = append(defaultGWs, gw)
+		}
+	}
+
+	var out []Range
+	for _, a := range conf.IPAM.Addresses {
+		_, subnet, err := net.ParseCIDR(a.Address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid static address %q: %w", a.Address, err)
+		}
+		r := Range{Subnet: *subnet}
+		if a.Gateway != "" {
+			if r.Gateway = net.ParseIP(a.Gateway); r.Gateway == nil {
+				return nil, fmt.Errorf("invalid static gateway %q", a.Gateway)
+			}
+		} else {
+			for _, gw := range defaultGWs {
+				if subnet.Contains(gw) {
+					r.Gateway = gw
+					break
+				}
+			}
+		}
+		out = append(out, r)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("static IPAM config has no addresses")
+	}
+	return out, nil
+}
+
+// Leases come from a server elsewhere on the network, so nothing is known
+// about the range until one is leased.
+func parseDHCP([]byte) ([]Range, error) {
+	return nil, fmt.Errorf("dhcp IPAM doesn't specify ranges; annotate the network definition with %s", AnnotationSubnets)
+}
+
+const (
+	// Comma separated subnets of a network, which replace those from its IPAM
+	// config.
+	AnnotationSubnets = "egress.jonnrb.io/subnets"
+
+	// The gateway of a network, which replaces the gateway of the range it is
+	// in.
+	AnnotationGateway = "egress.jonnrb.io/gateway"
+)
+
+// Applies the explicit annotations on a network definition to the ranges from
+// its IPAM config (which may have failed to parse with ipamErr).
+func applyAnnotations(ranges []Range, ipamErr error, annotations map[string]string) ([]Range, error) {
+	if s, ok := annotations[AnnotationSubnets]; ok {
+		ranges = nil
+		for _, cidr := range strings.Split(s, ",") {
+			_, subnet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s annotation %q: %w", AnnotationSubnets, s, err)
+			}
+			ranges = append(ranges, Range{Subnet: *subnet})
+		}
+	} else if ipamErr != nil {
+		return nil, ipamErr
+	}
+
+	if s, ok := annotations[AnnotationGateway]; ok {
+		gw := net.ParseIP(s)
+		if gw == nil {
+			return nil, fmt.Errorf("invalid %s annotation %q", AnnotationGateway, s)
+		}
+		found := false
+		for i := range ranges {
+			if ranges[i].Subnet.Contains(gw) {
+				ranges[i].Gateway = gw
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s annotation %v isn't in any of the network's subnets", AnnotationGateway, gw)
+		}
+	}
+	return ranges, nil
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractRanges_whereabouts(t *testing.T) {
+	r, err := extractRanges([]byte(`{
+		"cniVersion": "0.3.1",
+		"plugins": [
+			{
+				"type": "macvlan",
+				"master": "eth0",
+				"ipam": {
+					"type": "whereabouts",
+					"range": "192.168.2.0/24",
+					"exclude": ["192.168.2.0/28"],
+					"gateway": "192.168.2.1"
+				}
+			},
+			{"type": "egress-cni"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 1 {
+		t.Fatalf("expected 1 range; got %d", len(r))
+	}
+	if got := r[0].Subnet.String(); got != "192.168.2.0/24" {
+		t.Errorf("expected subnet 192.168.2.0/24; got %s", got)
+	}
+	if got := r[0].Gateway.String(); got != "192.168.2.1" {
+		t.Errorf("expected gw 192.168.2.1; got %s", got)
+	}
+}
+
+func TestExtractRanges_static(t *testing.T) {
+	r, err := extractRanges([]byte(`{
+		"cniVersion": "0.3.1",
+		"type": "macvlan",
+		"ipam": {
+			"type": "static",
+			"addresses": [
+				{"address": "10.1.0.2/24"},
+				{"address": "10.2.0.2/24", "gateway": "10.2.0.254"}
+			],
+			"routes": [{"dst": "0.0.0.0/0", "gw": "10.1.0.1"}]
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.1.0.0/24 via 10.1.0.1", "10.2.0.0/24 via 10.2.0.254"}
+	if len(r) != len(want) {
+		t.Fatalf("expected %d ranges; got %d", len(want), len(r))
+	}
+	for i := range r {
+		if got := r[i].Subnet.String() + " via " + r[i].Gateway.String(); got != want[i] {
+			t.Errorf("expected range %q; got %q", want[i], got)
+		}
+	}
+}
+
+func TestExtractRanges_dhcp(t *testing.T) {
+	_, err := extractRanges([]byte(`{
+		"cniVersion": "0.3.1",
+		"plugins": [
+			{"type": "macvlan", "ipam": {"type": "dhcp"}},
+			{"type": "egress-cni"}
+		]
+	}`))
+	if err == nil || !strings.Contains(err.Error(), AnnotationSubnets) {
+		t.Errorf("expected an error pointing to %s; got %v", AnnotationSubnets, err)
+	}
+}
+
+func TestExtractRanges_unsupported(t *testing.T) {
+	_, err := extractRanges([]byte(`{"type": "macvlan", "ipam": {"type": "magic"}}`))
+	if err == nil || !strings.Contains(err.Error(), "magic") {
+		t.Errorf("expected an unsupported IPAM error; got %v", err)
+	}
+}
+
+func TestApplyAnnotations(t *testing.T) {
+	_, ipamErr := extractRanges([]byte(`{"type": "macvlan", "ipam": {"type": "dhcp"}}`))
+	r, err := applyAnnotations(nil, ipamErr, map[string]string{
+		AnnotationSubnets: "192.168.1.0/24, 192.168.5.0/24",
+		AnnotationGateway: "192.168.5.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 2 {
+		t.Fatalf("expected 2 ranges; got %d", len(r))
+	}
+	if r[0].Gateway != nil {
+		t.Errorf("expected no gw for %v; got %v", &r[0].Subnet, r[0].Gateway)
+	}
+	if got := r[1].Gateway.String(); got != "192.168.5.1" {
+		t.Errorf("expected gw 192.168.5.1; got %s", got)
+	}
+
+	if _, err := applyAnnotations(r, nil, map[string]string{AnnotationGateway: "10.0.0.1"}); err == nil {
+		t.Error("expected an error for a gateway outside the subnets")
+	}
+	if _, err := applyAnnotations(nil, ipamErr, nil); err != ipamErr {
+		t.Errorf("expected the IPAM error without annotations; got %v", err)
+	}
+}
+
+func TestRegisterIPAMParser(t *testing.T) {
+	RegisterIPAMParser("test-ipam", func([]byte) ([]Range, error) {
+		return []Range{{}}, nil
+	})
+	r, err := extractRanges([]byte(`{"type": "macvlan", "ipam": {"type": "test-ipam"}}`))
+	if err != nil || len(r) != 1 {
+		t.Errorf("expected the registered parser to be used; got %v, %v", r, err)
+	}
+}
@@ -3,19 +3,36 @@ package kubernetes
 import (
 	"context"
 	"fmt"
+	"net"
 	"strings"
 
 	"github.com/vishvananda/netlink"
 	"go.jonnrb.io/egress/backend/kubernetes/client"
 	"go.jonnrb.io/egress/backend/kubernetes/internal"
 	"go.jonnrb.io/egress/backend/kubernetes/leasestore"
+	"go.jonnrb.io/egress/ctsync"
 	"go.jonnrb.io/egress/fw"
 	"go.jonnrb.io/egress/log"
+	"go.jonnrb.io/egress/util"
 	"go.jonnrb.io/egress/vaddr/dhcp"
+	"go.jonnrb.io/egress/vaddr/dhcp/leasefile"
+	"go.jonnrb.io/egress/vaddr/srcroute"
+	"go.jonnrb.io/egress/vaddr/wireguard"
 	"golang.org/x/sync/errgroup"
 )
 
 func GetConfig(ctx context.Context, params Params) (*Config, error) {
+	return getConfig(ctx, params, false)
+}
+
+// Like GetConfig() but doesn't change the host (e.g. by creating VLAN or
+// WireGuard links) or need the pod's links to exist, so what a router would do
+// can be explained from anywhere. The Config can't be used to run a router.
+func GetConfigDryRun(ctx context.Context, params Params) (*Config, error) {
+	return getConfig(ctx, params, true)
+}
+
+func getConfig(ctx context.Context, params Params, dryRun bool) (*Config, error) {
 	if err := params.check(); err != nil {
 		return nil, err
 	}
@@ -25,6 +42,7 @@ func GetConfig(ctx context.Context, params Params) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	env.dryRun = dryRun
 
 	// Create a sub-context so we can cancel any futures on the first failure.
 	ctx, cancel := context.WithCancel(ctx)
@@ -35,7 +53,10 @@ func GetConfig(ctx context.Context, params Params) (*Config, error) {
 
 type environment struct {
 	cli         *internal.CNIClient
-	attachments map[string]internal.Attachment
+	attachments map[string][]internal.Attachment
+
+	// See GetConfigDryRun().
+	dryRun bool
 }
 
 func loadEnvironment() (env environment, err error) {
@@ -65,8 +86,13 @@ func getConfigInternal(ctx context.Context, env environment, params Params) (*Co
 	var (
 		uplink, lan      netlink.Link
 		lanAddr          fw.Addr
+		extraLANs        []fw.ExtraLAN
+		namedLANs        []fw.NamedLAN
 		flat             []fw.StaticRoute
 		uplinkLeaseStore dhcp.LeaseStore
+		uplinkWireGuard  *wireguard.Config
+		selectable       []srcroute.Uplink
+		conntrackSync    *ctsync.Member
 	)
 	grp, ctx := errgroup.WithContext(ctx)
 
@@ -79,7 +105,11 @@ func getConfigInternal(ctx context.Context, env environment, params Params) (*Co
 		return
 	})
 	grp.Go(func() (err error) {
-		lanAddr, err = getLANGWAddr(ctx, env, params)
+		lanAddr, extraLANs, err = getLANAddrs(ctx, env, params)
+		return
+	})
+	grp.Go(func() (err error) {
+		namedLANs, err = getNamedLANs(ctx, env, params)
 		return
 	})
 	grp.Go(func() (err error) {
@@ -90,22 +120,51 @@ func getConfigInternal(ctx context.Context, env environment, params Params) (*Co
 		uplinkLeaseStore, err = getUplinkLeaseStore(params)
 		return
 	})
+	grp.Go(func() (err error) {
+		uplinkWireGuard, err = getUplinkWireGuard(env, params)
+		return
+	})
+	grp.Go(func() (err error) {
+		selectable, err = getSelectableUplinks(ctx, env, params)
+		return
+	})
+	grp.Go(func() (err error) {
+		conntrackSync, err = getConntrackSync(env, params)
+		return
+	})
 
 	if err := grp.Wait(); err != nil {
 		return nil, err
 	}
+	setNamedLANUplinks(namedLANs, params, selectable)
 
 	return &Config{
 		params:           params,
 		uplink:           uplink,
 		uplinkLeaseStore: uplinkLeaseStore,
+		uplinkWireGuard:  uplinkWireGuard,
+		selectable:       selectable,
 		lan:              lan,
 		lanAddr:          lanAddr,
+		extraLANs:        extraLANs,
+		namedLANs:        namedLANs,
 		flat:             flat,
+		conntrackSync:    conntrackSync,
 	}, nil
 }
 
 func getUplink(env environment, params Params) (netlink.Link, error) {
+	l, err := getUplinkParent(env, params)
+	if err != nil || params.UplinkVLAN == 0 {
+		return l, err
+	}
+	if env.dryRun {
+		return env.linkByName(vlanLinkName(l.Attrs().Name, params.UplinkVLAN))
+	}
+	return getOrCreateVLAN(l, params.UplinkVLAN)
+}
+
+func getUplinkParent(env environment, params Params) (netlink.Link, error) {
 	wrappedErr := func(l netlink.Link, err error) (netlink.Link, error) {
 		if err != nil {
 			err = fmt.Errorf("could not get uplink: %w", err)
@@ -113,17 +172,22 @@ func getUplink(env environment, params Params) (netlink.Link, error) {
 		return l, err
 	}
 
+	if params.UplinkWireGuard != nil && !env.dryRun {
+		if err := util.CreateWg(params.UplinkInterface); err != nil {
+			return wrappedErr(nil, err)
+		}
+	}
 	if params.UplinkInterface != "" {
-		return wrappedErr(netlink.LinkByName(params.UplinkInterface))
+		return wrappedErr(env.linkByName(params.UplinkInterface))
 	} else if params.UplinkNetwork != "" {
-		return wrappedErr(getLinkForNet(env.attachments, params.UplinkNetwork))
+		return wrappedErr(getLinkForNet(env, params.UplinkNetwork))
 	} else {
 		panic("params.check() should make this condition impossible")
 	}
 }
 
 func getLAN(env environment, params Params) (netlink.Link, error) {
-	lan, err := getLinkForNet(env.attachments, params.LANNetwork)
+	lan, err := getLinkForNet(env, params.LANNetwork)
 	if err != nil {
 		return nil, fmt.Errorf(
 			"could not get link for LAN network %q: %w", params.LANNetwork, err)
@@ -160,7 +224,7 @@ func getFlatNetworks(ctx context.Context, env environment, params Params) ([]fw.
 func getFlatNetLinks(env environment, params Params) (map[string]fw.Link, error) {
 	m := make(map[string]fw.Link)
 	for _, net := range params.FlatNetworks {
-		l, err := getLinkForNet(env.attachments, net)
+		l, err := getLinkForNet(env, net)
 		if err != nil {
 			return nil, fmt.Errorf("could not link link for flat network %q: %v", net, err)
 		}
@@ -169,8 +233,16 @@ func getFlatNetLinks(env environment, params Params) (map[string]fw.Link, error)
 	return m, nil
 }
 
-func getLANGWAddr(ctx context.Context, env environment, params Params) (a fw.Addr, err error) {
-	net, err := env.cli.Get(ctx, params.LANNetwork)
+// Gets the gateway address of the primary LAN attachment and the extra LANs
+// for any other attachments to the LAN network.
+func getLANAddrs(ctx context.Context, env environment, params Params) (a fw.Addr, extra []fw.ExtraLAN, err error) {
+	attachs := findAttachments(env.attachments, params.LANNetwork)
+	if len(attachs) == 0 {
+		err = fmt.Errorf("kubernetes: pod not attached to LAN network %q", params.LANNetwork)
+		return
+	}
+
+	def, err := env.cli.Get(ctx, params.LANNetwork)
 	if err != nil {
 		err = fmt.Errorf(
 			"kubernetes: error getting CNI config for network %q: %w",
@@ -178,22 +250,105 @@ func getLANGWAddr(ctx context.Context, env environment, params Params) (a fw.Add
 		return
 	}
 
-	for _, r := range net.Ranges {
-		if r.Gateway != nil {
-			ip := r.Gateway.String()
-			bits, _ := r.Subnet.Mask.Size()
-			if a, err = fw.ParseAddr(fmt.Sprintf("%s/%d", ip, bits)); err != nil {
-				panic(fmt.Sprintf(
-					"kubernetes: could not parse cidr address: %v", err))
-			}
+	override := params.LANGWAddresses[attachs[0].Interface]
+	if params.LANGWAddress != "" {
+		override = params.LANGWAddress
+	}
+	if a, err = getLANGWAddr(override, attachs[0], def.Ranges, true); err != nil {
+		return
+	}
+
+	seen := map[string]string{a.IP.String(): attachs[0].Interface}
+	for _, attach := range attachs[1:] {
+		var l fw.ExtraLAN
+		l.Addr, err = getLANGWAddr(params.LANGWAddresses[attach.Interface], attach, def.Ranges, false)
+		if err != nil {
+			return
+		}
+		if iface, ok := seen[l.Addr.IP.String()]; ok {
+			err = fmt.Errorf(
+				"kubernetes: LAN interfaces %q and %q would both have gateway address %v; specify lanGWAddresses",
+				iface, attach.Interface, l.Addr.IP)
 			return
 		}
+		seen[l.Addr.IP.String()] = attach.Interface
+
+		var nl netlink.Link
+		if nl, err = env.linkByName(attach.Interface); err != nil {
+			err = fmt.Errorf("kubernetes: could not get LAN interface %q: %w", attach.Interface, err)
+			return
+		}
+		l.Link = link{nl.Attrs()}
+		extra = append(extra, l)
 	}
-	err = fmt.Errorf("kubernetes: no gateway found in network definition")
 	return
 }
 
+// Gets the gateway address of a LAN attachment. If specified, override is used
+// instead of the gateway from the network's ranges. Otherwise, the gateway of
+// the range containing the attachment's IPs is used and, if primary, the
+// gateway of any range as a last resort.
+func getLANGWAddr(override string, attach internal.Attachment, ranges []internal.Range, primary bool) (fw.Addr, error) {
+	if override != "" {
+		// A CIDR override needs nothing from the network definition.
+		if net.ParseIP(override) == nil {
+			return fw.ParseAddr(override)
+		}
+		return lanGWAddrOverride(override, ranges)
+	}
+
+	for _, r := range ranges {
+		if r.Gateway != nil && rangeContainsAny(r, attach.IPs) {
+			return rangeAddr(r.Gateway, r)
+		}
+	}
+	if primary {
+		for _, r := range ranges {
+			if r.Gateway != nil {
+				return rangeAddr(r.Gateway, r)
+			}
+		}
+	}
+	return fw.Addr{}, fmt.Errorf(
+		"kubernetes: no gateway found in network definition for LAN interface %q; specify lanGWAddress or lanGWAddresses",
+		attach.Interface)
+}
+
+func rangeContainsAny(r internal.Range, ips []string) bool {
+	for _, ip := range ips {
+		if r.Subnet.Contains(net.ParseIP(ip)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Finds the mask for the gateway ip from the range containing it.
+func lanGWAddrOverride(ip string, ranges []internal.Range) (fw.Addr, error) {
+	gw := net.ParseIP(ip)
+	for _, r := range ranges {
+		if r.Subnet.Contains(gw) {
+			return rangeAddr(gw, r)
+		}
+	}
+	return fw.Addr{}, fmt.Errorf(
+		"kubernetes: LAN gateway address %v isn't in the LAN network's subnets; specify it as a CIDR", gw)
+}
+
+func rangeAddr(ip net.IP, r internal.Range) (fw.Addr, error) {
+	bits, _ := r.Subnet.Mask.Size()
+	a, err := fw.ParseAddr(fmt.Sprintf("%s/%d", ip, bits))
+	if err != nil {
+		panic(fmt.Sprintf(
+			"kubernetes: could not parse cidr address: %v", err))
+	}
+	return a, nil
+}
+
 func getUplinkLeaseStore(params Params) (dhcp.LeaseStore, error) {
+	if params.UplinkLeaseFile != "" {
+		return &leasefile.LeaseStore{Path: params.UplinkLeaseFile}, nil
+	}
 	if params.UplinkLeaseConfigMap == "" {
 		return nil, nil
 	}
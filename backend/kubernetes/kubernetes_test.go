@@ -0,0 +1,244 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"go.jonnrb.io/egress/backend/kubernetes/internal"
+	"go.jonnrb.io/egress/backend/kubernetes/metadata"
+	"go.jonnrb.io/egress/backend/kubernetes/metadata/metadatatesting"
+	"go.jonnrb.io/egress/fw/fwutil"
+	"go.jonnrb.io/egress/fw/rules"
+	"go.jonnrb.io/egress/vaddr/dhcp"
+)
+
+const testNAD = `{
+	"apiVersion": "k8s.cni.cncf.io/v1",
+	"kind": "NetworkAttachmentDefinition",
+	"metadata": {"name": "lan", "namespace": "egress"},
+	"spec": {
+		"config": "{\"cniVersion\": \"0.3.1\", \"type\": \"macvlan\", \"ipam\": {\"type\": \"host-local\", \"ranges\": [[{\"subnet\": \"10.0.0.0/24\", \"gateway\": \"10.0.0.1\"}]]}}"
+	}
+}`
+
+// Serves just enough of the API for GetConfig().
+func fakeAPIServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"/apis/k8s.cni.cncf.io/v1/namespaces/egress/network-attachment-definitions/lan",
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, testNAD)
+		})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+	})
+	return httptest.NewServer(mux)
+}
+
+func writeKubeconfig(t *testing.T, server string) string {
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	err := ioutil.WriteFile(path, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    namespace: egress
+current-context: test
+`, server)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGetConfig_outOfCluster(t *testing.T) {
+	if _, err := netlink.LinkByName("lo"); err != nil {
+		t.Skipf("no netlink: %v", err)
+	}
+
+	srv := fakeAPIServer(t)
+	defer srv.Close()
+
+	// Restore the metadata getters Install() replaces.
+	defer metadatatesting.Stub{
+		metadatatesting.InstallError(&metadata.GetPodName, fmt.Errorf("not in a pod")),
+		metadatatesting.InstallError(&metadata.GetPodNamespace, fmt.Errorf("not in a pod")),
+		metadatatesting.InstallError(&metadata.GetAttachments, fmt.Errorf("not in a pod")),
+	}.Uninstall()
+
+	err := Overrides{
+		Kubeconfig:  writeKubeconfig(t, srv.URL),
+		PodName:     "egress-0",
+		Attachments: map[string]string{"lan": "lo"},
+	}.Install()
+	if err != nil {
+		t.Fatalf("Install() failed: %v", err)
+	}
+
+	if ns, _ := metadata.GetPodNamespace(); ns != "egress" {
+		t.Errorf("expected namespace from kubeconfig; got %q", ns)
+	}
+	if name, _ := metadata.GetPodName(); name != "egress-0" {
+		t.Errorf("expected overridden pod name; got %q", name)
+	}
+
+	cfg, err := GetConfig(context.Background(), Params{
+		LANNetwork:      "lan",
+		UplinkInterface: "lo",
+	})
+	if err != nil {
+		t.Fatalf("GetConfig() failed: %v", err)
+	}
+	if name := cfg.LAN().Name(); name != "lo" {
+		t.Errorf("expected LAN on overridden attachment %q; got %q", "lo", name)
+	}
+	if a, ok := cfg.LANAddr(); !ok || a.String() != "10.0.0.1/24" {
+		t.Errorf("expected LAN address from the network definition; got %v", a)
+	}
+
+	// Links needn't exist for a dry run.
+	cfg, err = GetConfigDryRun(context.Background(), Params{
+		LANNetwork:      "lan",
+		UplinkInterface: "nope0",
+		UplinkVLAN:      100,
+	})
+	if err != nil {
+		t.Fatalf("GetConfigDryRun() failed: %v", err)
+	}
+	if name := cfg.Uplink().Name(); name != "nope0.100" {
+		t.Errorf("expected a stand-in VLAN link; got %q", name)
+	}
+	if _, err := netlink.LinkByName("nope0.100"); err == nil {
+		t.Error("expected a dry run not to create the VLAN link")
+	}
+}
+
+func TestLANGWAddrOverride(t *testing.T) {
+	ranges := []internal.Range{{Subnet: net.IPNet{
+		IP:   net.IPv4(192, 168, 1, 0),
+		Mask: net.CIDRMask(24, 32),
+	}}}
+
+	a, err := lanGWAddrOverride("192.168.1.254", ranges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.String(); got != "192.168.1.254/24" {
+		t.Errorf("expected 192.168.1.254/24; got %s", got)
+	}
+
+	if _, err := lanGWAddrOverride("10.0.0.1", ranges); err == nil {
+		t.Error("expected an error for a gateway outside the ranges")
+	}
+}
+
+func TestGetLANGWAddr(t *testing.T) {
+	ranges := []internal.Range{
+		{
+			Gateway: net.IPv4(192, 168, 1, 1),
+			Subnet:  net.IPNet{IP: net.IPv4(192, 168, 1, 0), Mask: net.CIDRMask(24, 32)},
+		},
+		{
+			Gateway: net.IPv4(192, 168, 2, 1),
+			Subnet:  net.IPNet{IP: net.IPv4(192, 168, 2, 0), Mask: net.CIDRMask(24, 32)},
+		},
+	}
+	attach := internal.Attachment{Interface: "net2", IPs: []string{"192.168.2.20"}}
+
+	for _, c := range []struct {
+		override string
+		attach   internal.Attachment
+		primary  bool
+		want     string
+	}{
+		{"", attach, false, "192.168.2.1/24"},
+		{"", internal.Attachment{Interface: "net1"}, true, "192.168.1.1/24"},
+		{"192.168.2.254", attach, false, "192.168.2.254/24"},
+		{"172.16.0.1/12", attach, false, "172.16.0.1/12"},
+	} {
+		a, err := getLANGWAddr(c.override, c.attach, ranges, c.primary)
+		if err != nil {
+			t.Errorf("getLANGWAddr(%q, %v) = %v", c.override, c.attach, err)
+		} else if got := a.String(); got != c.want {
+			t.Errorf("getLANGWAddr(%q, %v) = %s; want %s", c.override, c.attach, got, c.want)
+		}
+	}
+
+	if _, err := getLANGWAddr("", internal.Attachment{Interface: "net3"}, ranges, false); err == nil {
+		t.Error("expected an error for an extra LAN outside the ranges")
+	}
+}
+
+func TestMakeAttachmentMap_multipleInterfaces(t *testing.T) {
+	m := makeAttachmentMap([]internal.Attachment{
+		{Name: "egress/lan", Interface: "net1"},
+		{Name: "egress/uplink", Interface: "net2"},
+		{Name: "egress/lan", Interface: "net3"},
+	})
+	attachs := findAttachments(m, "egress/lan")
+	if len(attachs) != 2 || attachs[0].Interface != "net1" || attachs[1].Interface != "net3" {
+		t.Errorf("expected net1 and net3; got %+v", attachs)
+	}
+	if a, ok := findAttachment(m, "egress/lan"); !ok || a.Interface != "net1" {
+		t.Errorf("expected the primary attachment to be net1; got %+v", a)
+	}
+}
+
+func TestUplinkDHCPReleaseOnStop(t *testing.T) {
+	for _, release := range []bool{false, true} {
+		cfg := &Config{
+			params: Params{
+				UplinkMACAddress: "02:00:00:00:00:01",
+				UplinkDHCP:       &DHCPParams{ReleaseOnStop: release},
+			},
+			uplink: &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth1"}},
+		}
+
+		var found bool
+		for _, a := range fwutil.MakeVAddrUplink(cfg).Actives {
+			if d, ok := a.(*dhcp.VAddr); ok {
+				found = true
+				if d.ReleaseOnStop != release {
+					t.Errorf("expected ReleaseOnStop=%v from params; got %v", release, d.ReleaseOnStop)
+				}
+			}
+		}
+		if !found {
+			t.Error("expected the uplink to use DHCP")
+		}
+	}
+}
+
+func TestExtraRules_conntrackSyncOnClusterInterface(t *testing.T) {
+	cfg := &Config{params: Params{
+		ConntrackSync: &ConntrackSyncParams{KeyFile: "/etc/egress/ctsync.key"},
+	}}
+	want := rules.Rule("-I in-tcp -j ACCEPT -p tcp --dport 3780 -i eth0")
+	if rs := cfg.ExtraRules(); len(rs) != 1 || rs[0] != want {
+		t.Errorf("expected only %q; got %q", want, rs)
+	}
+
+	err := Params{
+		LANNetwork:      "lan",
+		UplinkInterface: "eth0",
+		HA:              &HAParams{},
+		ConntrackSync:   &ConntrackSyncParams{KeyFile: "/etc/egress/ctsync.key"},
+	}.check()
+	if err == nil {
+		t.Error("expected conntrack sync on the uplink to be refused")
+	}
+}
@@ -0,0 +1,158 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/vaddr/srcroute"
+)
+
+// The name of the lanNetwork LAN in lanPolicy unless lanName is specified.
+const defaultLANName = "lan"
+
+// A LAN besides lanNetwork.
+type LANParams struct {
+	// How the LAN is referred to in lanPolicy.
+	Name string `json:"name"`
+
+	// The network the LAN is attached by. Every interface attached to it is
+	// part of the LAN.
+	Network string `json:"network"`
+
+	// The gateway address (an IP or CIDR) of the LAN's primary interface.
+	// Defaults to the gateway of network's IPAM config. Addresses for other
+	// interfaces come from lanGWAddresses.
+	GWAddress string `json:"gwAddress"`
+
+	// The uplink the LAN's clients are forwarded to: one of the uplinks in
+	// uplinkSelection or "default".
+	Uplink string `json:"uplink"`
+}
+
+type LANPolicyParams struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// One of "allow", "deny" or "established".
+	Action string `json:"action"`
+}
+
+func (params Params) lanName() string {
+	if params.LANName == "" {
+		return defaultLANName
+	}
+	return params.LANName
+}
+
+func (params Params) checkLANs() error {
+	names := map[string]bool{params.lanName(): true}
+	for _, l := range params.LANs {
+		if l.Name == "" || l.Network == "" {
+			return fmt.Errorf("name and network must be specified for each LAN")
+		}
+		if names[l.Name] {
+			return fmt.Errorf("duplicate LAN %q", l.Name)
+		}
+		names[l.Name] = true
+		if err := checkLANGWAddress(l.GWAddress); l.GWAddress != "" && err != nil {
+			return fmt.Errorf("if gwAddress is specified for LAN %q, it must be valid: %w", l.Name, err)
+		}
+		if l.Uplink != "" && l.Uplink != defaultUplinkName && !params.UplinkSelection.has(l.Uplink) {
+			return fmt.Errorf("uplink %q of LAN %q must be in uplinkSelection", l.Uplink, l.Name)
+		}
+	}
+	seen := make(map[[2]string]bool)
+	for _, p := range params.LANPolicy {
+		if !names[p.From] || !names[p.To] || p.From == p.To {
+			return fmt.Errorf("lanPolicy from %q to %q must be between different LANs", p.From, p.To)
+		}
+		if seen[[2]string{p.From, p.To}] {
+			return fmt.Errorf("duplicate lanPolicy from %q to %q", p.From, p.To)
+		}
+		seen[[2]string{p.From, p.To}] = true
+		if !fw.LANAction(p.Action).Valid() {
+			return fmt.Errorf("invalid lanPolicy action %q from %q to %q", p.Action, p.From, p.To)
+		}
+	}
+	return nil
+}
+
+func (p *UplinkSelectionParams) has(name string) bool {
+	if p == nil {
+		return false
+	}
+	for _, u := range p.Uplinks {
+		if u.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Gets the links and gateway addresses of the LANs in params.LANs. Their
+// uplinks are filled in by setNamedLANUplinks().
+func getNamedLANs(ctx context.Context, env environment, params Params) ([]fw.NamedLAN, error) {
+	var lans []fw.NamedLAN
+	for _, p := range params.LANs {
+		attachs := findAttachments(env.attachments, p.Network)
+		if len(attachs) == 0 {
+			return nil, fmt.Errorf("kubernetes: pod not attached to network %q of LAN %q", p.Network, p.Name)
+		}
+		def, err := env.cli.Get(ctx, p.Network)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"kubernetes: error getting CNI config for network %q: %w", p.Network, err)
+		}
+
+		lan := fw.NamedLAN{Name: p.Name}
+		for i, attach := range attachs {
+			override := params.LANGWAddresses[attach.Interface]
+			if i == 0 && p.GWAddress != "" {
+				override = p.GWAddress
+			}
+			var l fw.ExtraLAN
+			if l.Addr, err = getLANGWAddr(override, attach, def.Ranges, i == 0); err != nil {
+				return nil, fmt.Errorf("kubernetes: LAN %q: %w", p.Name, err)
+			}
+			nl, err := env.linkByName(attach.Interface)
+			if err != nil {
+				return nil, fmt.Errorf("kubernetes: could not get interface %q of LAN %q: %w", attach.Interface, p.Name, err)
+			}
+			l.Link = link{nl.Attrs()}
+			lan.Links = append(lan.Links, l)
+		}
+		lans = append(lans, lan)
+	}
+	return lans, nil
+}
+
+func setNamedLANUplinks(lans []fw.NamedLAN, params Params, selectable []srcroute.Uplink) {
+	for i, p := range params.LANs {
+		for _, u := range selectable {
+			if u.Name == p.Uplink {
+				lans[i].Uplink = u.Link
+			}
+		}
+	}
+}
+
+func (cfg *Config) LANName() string {
+	return cfg.params.lanName()
+}
+
+func (cfg *Config) NamedLANs() []fw.NamedLAN {
+	return cfg.namedLANs
+}
+
+func (cfg *Config) LANPolicy() []fw.LANPolicy {
+	var policy []fw.LANPolicy
+	for _, p := range cfg.params.LANPolicy {
+		policy = append(policy, fw.LANPolicy{
+			From:   p.From,
+			To:     p.To,
+			Action: fw.LANAction(p.Action),
+		})
+	}
+	return policy
+}
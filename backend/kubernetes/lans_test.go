@@ -0,0 +1,55 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/vaddr/srcroute"
+)
+
+func TestCheckLANs(t *testing.T) {
+	sel := &UplinkSelectionParams{Uplinks: []SelectableUplinkParams{{Name: "vpn", Interface: "tun0"}}}
+	for _, c := range []struct {
+		name  string
+		p     Params
+		valid bool
+	}{
+		{"none", Params{}, true},
+		{"lans", Params{
+			LANs:            []LANParams{{Name: "guest", Network: "egress/guest", Uplink: "vpn"}},
+			UplinkSelection: sel,
+			LANPolicy:       []LANPolicyParams{{From: "lan", To: "guest", Action: "allow"}},
+		}, true},
+		{"renamed", Params{
+			LANName:   "trusted",
+			LANs:      []LANParams{{Name: "iot", Network: "egress/iot"}},
+			LANPolicy: []LANPolicyParams{{From: "trusted", To: "iot", Action: "established"}},
+		}, true},
+		{"no network", Params{LANs: []LANParams{{Name: "guest"}}}, false},
+		{"duplicate", Params{LANs: []LANParams{{Name: "lan", Network: "egress/guest"}}}, false},
+		{"bad gateway", Params{LANs: []LANParams{{Name: "guest", Network: "egress/guest", GWAddress: "nope"}}}, false},
+		{"unknown uplink", Params{LANs: []LANParams{{Name: "guest", Network: "egress/guest", Uplink: "vpn"}}}, false},
+		{"unknown LAN", Params{LANPolicy: []LANPolicyParams{{From: "lan", To: "guest", Action: "allow"}}}, false},
+		{"bad action", Params{
+			LANs:      []LANParams{{Name: "guest", Network: "egress/guest"}},
+			LANPolicy: []LANPolicyParams{{From: "lan", To: "guest", Action: "maybe"}},
+		}, false},
+	} {
+		if err := c.p.checkLANs(); (err == nil) != c.valid {
+			t.Errorf("%s: checkLANs() = %v; want valid = %v", c.name, err, c.valid)
+		}
+	}
+}
+
+func TestSetNamedLANUplinks(t *testing.T) {
+	lans := []fw.NamedLAN{{Name: "guest"}, {Name: "iot"}}
+	params := Params{LANs: []LANParams{{Name: "guest", Uplink: "vpn"}, {Name: "iot"}}}
+	setNamedLANUplinks(lans, params, []srcroute.Uplink{{Name: "vpn", Link: fw.LinkString("tun0")}})
+
+	if lans[0].Uplink == nil || lans[0].Uplink.Name() != "tun0" {
+		t.Errorf("expected guest to use tun0; got %v", lans[0].Uplink)
+	}
+	if lans[1].Uplink != nil {
+		t.Errorf("expected iot to use the default uplink; got %v", lans[1].Uplink)
+	}
+}
@@ -4,12 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net"
-	"time"
 
 	"go.jonnrb.io/egress/backend/kubernetes/client"
 	"go.jonnrb.io/egress/backend/kubernetes/metadata"
-	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/tracing"
 	"go.jonnrb.io/egress/vaddr/dhcp"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -43,6 +41,9 @@ func New() (*LeaseStore, error) {
 }
 
 func (c *LeaseStore) Get(ctx context.Context) (l dhcp.Lease, err error) {
+	ctx, span := tracing.Start(ctx, "leasestore.Get")
+	defer func() { tracing.End(span, err) }()
+
 	cmi, err := c.configMapInterface()
 	if err != nil {
 		err = fmt.Errorf("leasestore: could not load client: %w", err)
@@ -59,14 +60,21 @@ func (c *LeaseStore) Get(ctx context.Context) (l dhcp.Lease, err error) {
 		}
 		return
 	}
+	return leaseFromConfigMap(cm)
+}
+
+func leaseFromConfigMap(cm *corev1.ConfigMap) (dhcp.Lease, error) {
 	d, ok := cm.BinaryData[configMapKey]
 	if !ok {
 		d = []byte(cm.Data[configMapKey])
 	}
-	return deserializeLease(d)
+	return dhcp.UnmarshalLease(d)
 }
 
-func (c *LeaseStore) Put(ctx context.Context, l dhcp.Lease) error {
+func (c *LeaseStore) Put(ctx context.Context, l dhcp.Lease) (err error) {
+	ctx, span := tracing.Start(ctx, "leasestore.Put")
+	defer func() { tracing.End(span, err) }()
+
 	cmi, err := c.configMapInterface()
 	if err != nil {
 		return fmt.Errorf("leasestore: could not load client: %w", err)
@@ -74,7 +82,7 @@ func (c *LeaseStore) Put(ctx context.Context, l dhcp.Lease) error {
 	cm := corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{Name: c.Name},
 		BinaryData: map[string][]byte{
-			configMapKey: serializeLease(l),
+			configMapKey: dhcp.MarshalLease(l),
 		},
 	}
 	_, err = cmi.Create(ctx, &cm, metav1.CreateOptions{})
@@ -97,80 +105,75 @@ func (c *LeaseStore) Put(ctx context.Context, l dhcp.Lease) error {
 	return nil
 }
 
-func (c *LeaseStore) configMapInterface() (clientcorev1.ConfigMapInterface, error) {
-	ns := c.Namespace
-	if ns == "" {
-		var err error
-		ns, err = metadata.GetPodNamespace()
+// Replaces the lease only if it is still old. The ConfigMap's resourceVersion
+// guards against a write between reading and updating it.
+func (c *LeaseStore) CompareAndSwap(ctx context.Context, old, l dhcp.Lease) (err error) {
+	ctx, span := tracing.Start(ctx, "leasestore.CompareAndSwap")
+	defer func() { tracing.End(span, err) }()
+
+	cmi, err := c.configMapInterface()
+	if err != nil {
+		return fmt.Errorf("leasestore: could not load client: %w", err)
+	}
+	cm, err := cmi.Get(ctx, c.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if !old.Equal(dhcp.Lease{}) {
+			return dhcp.ErrLeaseChanged
+		}
+		cm := corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: c.Name},
+			BinaryData: map[string][]byte{
+				configMapKey: dhcp.MarshalLease(l),
+			},
+		}
+		_, err = cmi.Create(ctx, &cm, metav1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			return dhcp.ErrLeaseChanged
+		}
 		if err != nil {
-			return nil, err
+			return fmt.Errorf(
+				"leasestore: could not create lease configmap: %w", err)
 		}
+		return nil
 	}
-	return c.Client.CoreV1().ConfigMaps(ns), nil
-}
-
-const configMapKey = "lease.json"
-
-type serializableLease struct {
-	LeasedIP    string    `json:"leasedIP"`
-	GatewayIP   string    `json:"gatewayIP"`
-	ServerIP    string    `json:"serverIP"`
-	StartTime   time.Time `json:"startTime"`
-	Duration    int       `json:"duration"`
-	RenewAfter  int       `json:"renewAfter"`
-	RebindAfter int       `json:"rebindAfter"`
-}
-
-func serializeLease(l dhcp.Lease) []byte {
-	b, err := json.Marshal(
-		serializableLease{
-			LeasedIP:    fmt.Sprintf("%s/%d", l.LeasedIP, l.SubnetMask),
-			GatewayIP:   l.GatewayIP.String(),
-			ServerIP:    l.ServerIP.String(),
-			StartTime:   l.StartTime,
-			Duration:    int(l.Duration / time.Millisecond),
-			RenewAfter:  int(l.RenewAfter / time.Millisecond),
-			RebindAfter: int(l.RebindAfter / time.Millisecond),
-		})
 	if err != nil {
-		panic(fmt.Sprintf("leasestore: could not marshal lease: %+v", l))
+		return fmt.Errorf("leasestore: could not get lease: %w", err)
 	}
-	return b
-}
 
-func deserializeLease(d []byte) (l dhcp.Lease, err error) {
-	var s serializableLease
-	err = json.Unmarshal(d, &s)
+	cur, err := leaseFromConfigMap(cm)
 	if err != nil {
-		err = fmt.Errorf(
-			"leasestore: could not unmarshal lease %q: %w", d, err)
-		return
+		return err
+	}
+	if !cur.Equal(old) {
+		return dhcp.ErrLeaseChanged
 	}
-	return parseLease(s)
-}
 
-func parseLease(s serializableLease) (l dhcp.Lease, err error) {
-	leasedAddr, err := fw.ParseAddr(s.LeasedIP)
-	if err != nil {
-		err = fmt.Errorf(
-			"leasestore: %q is not a valid IP: %w", s.LeasedIP, err)
-		return
+	if cm.BinaryData == nil {
+		cm.BinaryData = make(map[string][]byte)
 	}
-	l.LeasedIP = leasedAddr.IP
-	l.SubnetMask, _ = leasedAddr.Mask.Size()
-	l.GatewayIP = net.ParseIP(s.GatewayIP)
-	if l.GatewayIP == nil {
-		err = fmt.Errorf("leasestore: %q is not a valid IP", s.GatewayIP)
-		return
+	cm.BinaryData[configMapKey] = dhcp.MarshalLease(l)
+	delete(cm.Data, configMapKey)
+	_, err = cmi.Update(ctx, cm, metav1.UpdateOptions{})
+	if errors.IsConflict(err) {
+		return dhcp.ErrLeaseChanged
 	}
-	l.ServerIP = net.ParseIP(s.ServerIP)
-	if l.ServerIP == nil {
-		err = fmt.Errorf("leasestore: %q is not a valid IP", s.ServerIP)
-		return
+	if err != nil {
+		return fmt.Errorf(
+			"leasestore: could not update lease configmap: %w", err)
 	}
-	l.StartTime = s.StartTime
-	l.Duration = time.Duration(s.Duration) * time.Millisecond
-	l.RenewAfter = time.Duration(s.RenewAfter) * time.Millisecond
-	l.RebindAfter = time.Duration(s.RebindAfter) * time.Millisecond
-	return
+	return nil
 }
+
+func (c *LeaseStore) configMapInterface() (clientcorev1.ConfigMapInterface, error) {
+	ns := c.Namespace
+	if ns == "" {
+		var err error
+		ns, err = metadata.GetPodNamespace()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.Client.CoreV1().ConfigMaps(ns), nil
+}
+
+const configMapKey = "lease.json"
@@ -252,3 +252,86 @@ func TestPut(t *testing.T) {
 		checkUpdated(t, l)(s.Get(context.Background()))
 	})
 }
+
+func TestCompareAndSwap(t *testing.T) {
+	defer installErrorMetadata().Uninstall()
+
+	old := dhcp.Lease{
+		LeasedIP:    net.IPv4(10, 11, 11, 17),
+		SubnetMask:  24,
+		GatewayIP:   net.IPv4(10, 11, 11, 1),
+		ServerIP:    net.IPv4(10, 11, 11, 32),
+		StartTime:   time.Date(2020, 10, 9, 11, 11, 11, 0, time.UTC),
+		Duration:    7 * 24 * time.Hour,
+		RenewAfter:  24 * time.Hour,
+		RebindAfter: 24 * time.Hour,
+	}
+	l := old
+	l.StartTime = time.Date(2020, 10, 10, 11, 11, 11, 0, time.UTC)
+
+	t.Run("PreviouslyAbsent", func(t *testing.T) {
+		s := leasestore.LeaseStore{
+			Name:      "my-lease",
+			Namespace: "some-namespace",
+			Client:    fake.NewSimpleClientset(),
+		}
+
+		err := s.CompareAndSwap(context.Background(), dhcp.Lease{}, l)
+
+		if err != nil {
+			t.Errorf("expected err == nil; got: %v", err)
+		}
+		checkUpdated(t, l)(s.Get(context.Background()))
+	})
+
+	t.Run("PreviouslyAbsentButExpected", func(t *testing.T) {
+		s := leasestore.LeaseStore{
+			Name:      "my-lease",
+			Namespace: "some-namespace",
+			Client:    fake.NewSimpleClientset(),
+		}
+
+		err := s.CompareAndSwap(context.Background(), old, l)
+
+		if err != dhcp.ErrLeaseChanged {
+			t.Errorf("expected err == dhcp.ErrLeaseChanged; got: %v", err)
+		}
+		checkEmptyLease(t)(s.Get(context.Background()))
+	})
+
+	t.Run("Matches", func(t *testing.T) {
+		s := leasestore.LeaseStore{
+			Name:      "my-lease",
+			Namespace: "some-namespace",
+			Client:    fake.NewSimpleClientset(),
+		}
+		if err := s.Put(context.Background(), old); err != nil {
+			t.Fatal(err)
+		}
+
+		err := s.CompareAndSwap(context.Background(), old, l)
+
+		if err != nil {
+			t.Errorf("expected err == nil; got: %v", err)
+		}
+		checkUpdated(t, l)(s.Get(context.Background()))
+	})
+
+	t.Run("Changed", func(t *testing.T) {
+		s := leasestore.LeaseStore{
+			Name:      "my-lease",
+			Namespace: "some-namespace",
+			Client:    fake.NewSimpleClientset(),
+		}
+		if err := s.Put(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+
+		err := s.CompareAndSwap(context.Background(), old, old)
+
+		if err != dhcp.ErrLeaseChanged {
+			t.Errorf("expected err == dhcp.ErrLeaseChanged; got: %v", err)
+		}
+		checkUpdated(t, l)(s.Get(context.Background()))
+	})
+}
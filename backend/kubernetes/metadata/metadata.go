@@ -20,6 +20,14 @@ var (
 	GetPodNamespace = Getter(podNamespace.Get)
 )
 
+// Returns a Getter that always returns val (e.g. to override what is read
+// from the pod when running outside of one).
+func Static(val string) Getter {
+	return func() (string, error) {
+		return val, nil
+	}
+}
+
 type podInfo struct {
 	path string
 
@@ -0,0 +1,464 @@
+// Package operator reconciles EgressGateway resources into router deployments.
+package operator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.jonnrb.io/egress/backend/kubernetes/client"
+	"go.jonnrb.io/egress/log"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const defaultResync = 30 * time.Second
+
+// Keeps each EgressGateway's ServiceAccount, Role, RoleBinding, ConfigMaps and
+// Deployment (and, for uplink selection, ClusterRole and ClusterRoleBinding) in
+// sync with its spec and reports the Deployment's status back. Objects
+// belonging to deleted gateways are garbage collected through their owner
+// references, except the cluster-scoped ones, which the operator deletes.
+type Operator struct {
+	Client  kubernetes.Interface
+	Dynamic dynamic.Interface
+
+	// The router image for gateways that don't specify one.
+	Image string
+
+	// If set, only gateways in Namespace are reconciled.
+	Namespace string
+
+	// How often every gateway is reconciled regardless of changes. Defaults to
+	// 30 seconds.
+	Resync time.Duration
+}
+
+func New() (*Operator, error) {
+	cfg, err := client.Get()
+	if err != nil {
+		return nil, err
+	}
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dc, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Operator{Client: cs, Dynamic: dc}, nil
+}
+
+// Reconciles gateways until ctx is canceled.
+func (o *Operator) Run(ctx context.Context) error {
+	resync := o.Resync
+	if resync == 0 {
+		resync = defaultResync
+	}
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+
+	gwInformer := dynamicinformer.NewFilteredDynamicSharedInformerFactory(
+		o.Dynamic, resync, o.Namespace, nil).ForResource(GatewayResource).Informer()
+	gwInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { enqueue(q, obj) },
+		UpdateFunc: func(_, obj interface{}) { enqueue(q, obj) },
+	})
+
+	// Changes to deployments (e.g. pods becoming ready or someone editing
+	// them) requeue the gateway that owns them.
+	deployInformer := informers.NewSharedInformerFactoryWithOptions(
+		o.Client, resync,
+		informers.WithNamespace(o.Namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = "app.kubernetes.io/managed-by=egress-operator"
+		}),
+	).Apps().V1().Deployments().Informer()
+	deployInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { enqueueOwner(q, obj) },
+		UpdateFunc: func(_, obj interface{}) { enqueueOwner(q, obj) },
+		DeleteFunc: func(obj interface{}) { enqueueOwner(q, obj) },
+	})
+
+	go gwInformer.Run(ctx.Done())
+	go deployInformer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), gwInformer.HasSynced, deployInformer.HasSynced) {
+		return ctx.Err()
+	}
+	log.Infof("operator: watching %v", GatewayResource)
+
+	go func() {
+		<-ctx.Done()
+		q.ShutDown()
+	}()
+	for o.processNext(ctx, q, gwInformer.GetIndexer()) {
+	}
+	return ctx.Err()
+}
+
+func enqueue(q workqueue.Interface, obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Errorf("operator: %v", err)
+		return
+	}
+	q.Add(key)
+}
+
+func enqueueOwner(q workqueue.Interface, obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	d, ok := obj.(*appsv1.Deployment)
+	if !ok {
+		return
+	}
+	for _, ref := range d.OwnerReferences {
+		if ref.Kind == Kind && ref.APIVersion == GatewayKind.GroupVersion().String() {
+			q.Add(d.Namespace + "/" + ref.Name)
+		}
+	}
+}
+
+func (o *Operator) processNext(
+	ctx context.Context,
+	q workqueue.RateLimitingInterface,
+	gateways cache.Indexer,
+) bool {
+	item, shutdown := q.Get()
+	if shutdown {
+		return false
+	}
+	defer q.Done(item)
+	key := item.(string)
+
+	obj, exists, err := gateways.GetByKey(key)
+	if err != nil {
+		log.Errorf("operator: error getting %q: %v", key, err)
+		q.AddRateLimited(key)
+		return true
+	}
+	if !exists {
+		// Everything else goes with the gateway's owner references.
+		if ns, name, err := cache.SplitMetaNamespaceKey(key); err == nil {
+			if err := o.deleteClusterRBAC(ctx, ns, name); err != nil {
+				log.Errorf("operator: error cleaning up after %q: %v", key, err)
+				q.AddRateLimited(key)
+				return true
+			}
+		}
+		q.Forget(key)
+		return true
+	}
+	gw, err := fromUnstructured(obj.(*unstructured.Unstructured))
+	if err != nil {
+		log.Errorf("operator: %v", err)
+		q.Forget(key)
+		return true
+	}
+
+	if err := o.Reconcile(ctx, gw); err != nil {
+		log.Errorf("operator: error reconciling %q: %v", key, err)
+		q.AddRateLimited(key)
+		return true
+	}
+	q.Forget(key)
+	return true
+}
+
+func fromUnstructured(u *unstructured.Unstructured) (*EgressGateway, error) {
+	gw := &EgressGateway{}
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), gw)
+	if err != nil {
+		return nil, fmt.Errorf("operator: could not parse %s/%s: %w", u.GetNamespace(), u.GetName(), err)
+	}
+	return gw, nil
+}
+
+// Brings gw's objects in line with its spec and updates its status. A spec
+// that can't be reconciled is reported in the status rather than returned.
+func (o *Operator) Reconcile(ctx context.Context, gw *EgressGateway) error {
+	g := *gw
+	gw = &g
+	gw.SetGroupVersionKind(GatewayKind)
+
+	status := EgressGatewayStatus{ObservedGeneration: gw.Generation}
+	res, err := makeResources(gw, o.Image)
+	if err != nil {
+		status.Error = err.Error()
+		return o.updateStatus(ctx, gw, status)
+	}
+
+	if err := o.applyServiceAccount(ctx, res.serviceAccount); err != nil {
+		return err
+	}
+	if err := o.applyRole(ctx, res.role); err != nil {
+		return err
+	}
+	if err := o.applyRoleBinding(ctx, res.roleBinding); err != nil {
+		return err
+	}
+	if res.clusterRole != nil {
+		if err := o.applyClusterRole(ctx, res.clusterRole); err != nil {
+			return err
+		}
+		if err := o.applyClusterRoleBinding(ctx, res.clusterRoleBinding); err != nil {
+			return err
+		}
+	} else if err := o.deleteClusterRBAC(ctx, gw.Namespace, gw.Name); err != nil {
+		return err
+	}
+	if err := o.applyConfigMap(ctx, res.config); err != nil {
+		return err
+	}
+	if res.lease != nil {
+		if err := o.createConfigMap(ctx, res.lease); err != nil {
+			return err
+		}
+	}
+	d, err := o.applyDeployment(ctx, res.deployment)
+	if err != nil {
+		return err
+	}
+
+	status.Replicas = d.Status.Replicas
+	status.ReadyReplicas = d.Status.ReadyReplicas
+	return o.updateStatus(ctx, gw, status)
+}
+
+func (o *Operator) updateStatus(ctx context.Context, gw *EgressGateway, status EgressGatewayStatus) error {
+	if gw.Status == status {
+		return nil
+	}
+	gw.Status = status
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(gw)
+	if err != nil {
+		return fmt.Errorf("operator: could not convert %s/%s: %w", gw.Namespace, gw.Name, err)
+	}
+	_, err = o.Dynamic.Resource(GatewayResource).Namespace(gw.Namespace).UpdateStatus(
+		ctx, &unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("operator: could not update status of %s/%s: %w", gw.Namespace, gw.Name, err)
+	}
+	return nil
+}
+
+// Whether cur has drifted from want. Fields unset in want (e.g. those
+// defaulted by the API server) are ignored.
+func drifted(want, cur metav1.Object, wantFields, curFields interface{}) bool {
+	return !equality.Semantic.DeepDerivative(want.GetLabels(), cur.GetLabels()) ||
+		!equality.Semantic.DeepDerivative(want.GetOwnerReferences(), cur.GetOwnerReferences()) ||
+		!equality.Semantic.DeepDerivative(wantFields, curFields)
+}
+
+// Copies the parts of metadata the operator manages from want to cur.
+func adopt(want, cur metav1.Object) {
+	l := cur.GetLabels()
+	if l == nil {
+		l = make(map[string]string)
+	}
+	for k, v := range want.GetLabels() {
+		l[k] = v
+	}
+	cur.SetLabels(l)
+	cur.SetOwnerReferences(want.GetOwnerReferences())
+}
+
+func (o *Operator) applyServiceAccount(ctx context.Context, want *corev1.ServiceAccount) error {
+	cli := o.Client.CoreV1().ServiceAccounts(want.Namespace)
+	cur, err := cli.Get(ctx, want.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Infof("operator: creating ServiceAccount %s/%s", want.Namespace, want.Name)
+		_, err = cli.Create(ctx, want, metav1.CreateOptions{})
+	} else if err == nil && drifted(want, cur, nil, nil) {
+		log.Infof("operator: updating ServiceAccount %s/%s", want.Namespace, want.Name)
+		adopt(want, cur)
+		_, err = cli.Update(ctx, cur, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("operator: could not apply ServiceAccount %s/%s: %w", want.Namespace, want.Name, err)
+	}
+	return nil
+}
+
+func (o *Operator) applyRole(ctx context.Context, want *rbacv1.Role) error {
+	cli := o.Client.RbacV1().Roles(want.Namespace)
+	cur, err := cli.Get(ctx, want.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Infof("operator: creating Role %s/%s", want.Namespace, want.Name)
+		_, err = cli.Create(ctx, want, metav1.CreateOptions{})
+	} else if err == nil && drifted(want, cur, want.Rules, cur.Rules) {
+		log.Infof("operator: updating Role %s/%s", want.Namespace, want.Name)
+		adopt(want, cur)
+		cur.Rules = want.Rules
+		_, err = cli.Update(ctx, cur, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("operator: could not apply Role %s/%s: %w", want.Namespace, want.Name, err)
+	}
+	return nil
+}
+
+func (o *Operator) applyRoleBinding(ctx context.Context, want *rbacv1.RoleBinding) error {
+	cli := o.Client.RbacV1().RoleBindings(want.Namespace)
+	cur, err := cli.Get(ctx, want.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Infof("operator: creating RoleBinding %s/%s", want.Namespace, want.Name)
+		_, err = cli.Create(ctx, want, metav1.CreateOptions{})
+	} else if err == nil && !equality.Semantic.DeepEqual(want.RoleRef, cur.RoleRef) {
+		// The role of a binding can't be changed, so it is recreated.
+		log.Infof("operator: recreating RoleBinding %s/%s", want.Namespace, want.Name)
+		err = cli.Delete(ctx, want.Name, metav1.DeleteOptions{})
+		if err == nil {
+			_, err = cli.Create(ctx, want, metav1.CreateOptions{})
+		}
+	} else if err == nil && drifted(want, cur, want.Subjects, cur.Subjects) {
+		log.Infof("operator: updating RoleBinding %s/%s", want.Namespace, want.Name)
+		adopt(want, cur)
+		cur.Subjects = want.Subjects
+		_, err = cli.Update(ctx, cur, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("operator: could not apply RoleBinding %s/%s: %w", want.Namespace, want.Name, err)
+	}
+	return nil
+}
+
+func (o *Operator) applyClusterRole(ctx context.Context, want *rbacv1.ClusterRole) error {
+	cli := o.Client.RbacV1().ClusterRoles()
+	cur, err := cli.Get(ctx, want.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Infof("operator: creating ClusterRole %s", want.Name)
+		_, err = cli.Create(ctx, want, metav1.CreateOptions{})
+	} else if err == nil && drifted(want, cur, want.Rules, cur.Rules) {
+		log.Infof("operator: updating ClusterRole %s", want.Name)
+		adopt(want, cur)
+		cur.Rules = want.Rules
+		_, err = cli.Update(ctx, cur, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("operator: could not apply ClusterRole %s: %w", want.Name, err)
+	}
+	return nil
+}
+
+func (o *Operator) applyClusterRoleBinding(ctx context.Context, want *rbacv1.ClusterRoleBinding) error {
+	cli := o.Client.RbacV1().ClusterRoleBindings()
+	cur, err := cli.Get(ctx, want.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Infof("operator: creating ClusterRoleBinding %s", want.Name)
+		_, err = cli.Create(ctx, want, metav1.CreateOptions{})
+	} else if err == nil && !equality.Semantic.DeepEqual(want.RoleRef, cur.RoleRef) {
+		// The role of a binding can't be changed, so it is recreated.
+		log.Infof("operator: recreating ClusterRoleBinding %s", want.Name)
+		err = cli.Delete(ctx, want.Name, metav1.DeleteOptions{})
+		if err == nil {
+			_, err = cli.Create(ctx, want, metav1.CreateOptions{})
+		}
+	} else if err == nil && drifted(want, cur, want.Subjects, cur.Subjects) {
+		log.Infof("operator: updating ClusterRoleBinding %s", want.Name)
+		adopt(want, cur)
+		cur.Subjects = want.Subjects
+		_, err = cli.Update(ctx, cur, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("operator: could not apply ClusterRoleBinding %s: %w", want.Name, err)
+	}
+	return nil
+}
+
+// Deletes the cluster-scoped objects of the gateway namespace/name, if any.
+func (o *Operator) deleteClusterRBAC(ctx context.Context, namespace, name string) error {
+	name = clusterRoleName(namespace, name)
+	rbac := o.Client.RbacV1()
+	for _, c := range []struct {
+		kind string
+		get  func() error
+		del  func() error
+	}{
+		{
+			"ClusterRoleBinding",
+			func() error { _, err := rbac.ClusterRoleBindings().Get(ctx, name, metav1.GetOptions{}); return err },
+			func() error { return rbac.ClusterRoleBindings().Delete(ctx, name, metav1.DeleteOptions{}) },
+		},
+		{
+			"ClusterRole",
+			func() error { _, err := rbac.ClusterRoles().Get(ctx, name, metav1.GetOptions{}); return err },
+			func() error { return rbac.ClusterRoles().Delete(ctx, name, metav1.DeleteOptions{}) },
+		},
+	} {
+		err := c.get()
+		if err == nil {
+			log.Infof("operator: deleting %s %s", c.kind, name)
+			err = c.del()
+		}
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("operator: could not delete %s %s: %w", c.kind, name, err)
+		}
+	}
+	return nil
+}
+
+func (o *Operator) applyConfigMap(ctx context.Context, want *corev1.ConfigMap) error {
+	cli := o.Client.CoreV1().ConfigMaps(want.Namespace)
+	cur, err := cli.Get(ctx, want.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Infof("operator: creating ConfigMap %s/%s", want.Namespace, want.Name)
+		_, err = cli.Create(ctx, want, metav1.CreateOptions{})
+	} else if err == nil && (drifted(want, cur, nil, nil) || !equality.Semantic.DeepEqual(want.Data, cur.Data)) {
+		log.Infof("operator: updating ConfigMap %s/%s", want.Namespace, want.Name)
+		adopt(want, cur)
+		cur.Data = want.Data
+		_, err = cli.Update(ctx, cur, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("operator: could not apply ConfigMap %s/%s: %w", want.Namespace, want.Name, err)
+	}
+	return nil
+}
+
+// Creates want if it doesn't exist. Its contents are left alone otherwise
+// since the routers write to it.
+func (o *Operator) createConfigMap(ctx context.Context, want *corev1.ConfigMap) error {
+	cli := o.Client.CoreV1().ConfigMaps(want.Namespace)
+	_, err := cli.Get(ctx, want.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Infof("operator: creating ConfigMap %s/%s", want.Namespace, want.Name)
+		_, err = cli.Create(ctx, want, metav1.CreateOptions{})
+	}
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("operator: could not create ConfigMap %s/%s: %w", want.Namespace, want.Name, err)
+	}
+	return nil
+}
+
+func (o *Operator) applyDeployment(ctx context.Context, want *appsv1.Deployment) (*appsv1.Deployment, error) {
+	cli := o.Client.AppsV1().Deployments(want.Namespace)
+	cur, err := cli.Get(ctx, want.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Infof("operator: creating Deployment %s/%s", want.Namespace, want.Name)
+		cur, err = cli.Create(ctx, want, metav1.CreateOptions{})
+	} else if err == nil && drifted(want, cur, want.Spec, cur.Spec) {
+		log.Infof("operator: updating Deployment %s/%s", want.Namespace, want.Name)
+		adopt(want, cur)
+		cur.Spec = want.Spec
+		cur, err = cli.Update(ctx, cur, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("operator: could not apply Deployment %s/%s: %w", want.Namespace, want.Name, err)
+	}
+	return cur, nil
+}
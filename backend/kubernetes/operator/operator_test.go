@@ -0,0 +1,258 @@
+package operator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	egressk8s "go.jonnrb.io/egress/backend/kubernetes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func testGateway() *EgressGateway {
+	return &EgressGateway{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: GatewayKind.GroupVersion().String(),
+			Kind:       Kind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "gw",
+			Namespace:  "egress",
+			UID:        "1234",
+			Generation: 1,
+		},
+		Spec: EgressGatewaySpec{
+			Config: egressk8s.Params{
+				LANNetwork:       "egress/lan",
+				UplinkNetwork:    "egress/wan",
+				UplinkMACAddress: "02:00:00:00:00:01",
+			},
+		},
+	}
+}
+
+func testOperator(t *testing.T, gw *EgressGateway) *Operator {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(gw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Created rather than passed to NewSimpleDynamicClient() since the fake
+	// would guess the resource to be "egressgatewaies".
+	dc := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	_, err = dc.Resource(GatewayResource).Namespace(gw.Namespace).Create(
+		context.Background(), &unstructured.Unstructured{Object: obj}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Operator{
+		Client:  fake.NewSimpleClientset(),
+		Dynamic: dc,
+		Image:   "egress:test",
+	}
+}
+
+func getGateway(t *testing.T, o *Operator, gw *EgressGateway) *EgressGateway {
+	u, err := o.Dynamic.Resource(GatewayResource).Namespace(gw.Namespace).Get(
+		context.Background(), gw.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw, err = fromUnstructured(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gw
+}
+
+func writes(actions []k8stesting.Action) (n int) {
+	for _, a := range actions {
+		switch a.GetVerb() {
+		case "create", "update", "delete":
+			n++
+		}
+	}
+	return
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	gw := testGateway()
+	o := testOperator(t, gw)
+
+	if err := o.Reconcile(ctx, gw); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	cs := o.Client.(*fake.Clientset)
+	if _, err := cs.CoreV1().ServiceAccounts("egress").Get(ctx, "gw", metav1.GetOptions{}); err != nil {
+		t.Errorf("ServiceAccount not created: %v", err)
+	}
+	if _, err := cs.RbacV1().Roles("egress").Get(ctx, "gw", metav1.GetOptions{}); err != nil {
+		t.Errorf("Role not created: %v", err)
+	}
+	if _, err := cs.RbacV1().RoleBindings("egress").Get(ctx, "gw", metav1.GetOptions{}); err != nil {
+		t.Errorf("RoleBinding not created: %v", err)
+	}
+	if _, err := cs.CoreV1().ConfigMaps("egress").Get(ctx, "gw-lease", metav1.GetOptions{}); err != nil {
+		t.Errorf("lease ConfigMap not created: %v", err)
+	}
+
+	cm, err := cs.CoreV1().ConfigMaps("egress").Get(ctx, "gw-config", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("config ConfigMap not created: %v", err)
+	}
+	for _, s := range []string{`"lockName": "egress/gw"`, `"uplinkLeaseConfigMap": "egress/gw-lease"`} {
+		if !strings.Contains(cm.Data[configKey], s) {
+			t.Errorf("config missing %s:\n%s", s, cm.Data[configKey])
+		}
+	}
+
+	d, err := cs.AppsV1().Deployments("egress").Get(ctx, "gw", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Deployment not created: %v", err)
+	}
+	if *d.Spec.Replicas != 2 {
+		t.Errorf("got %d replicas; want 2", *d.Spec.Replicas)
+	}
+	if got := d.Spec.Template.Annotations[networksAnnotation]; got != "egress/lan,egress/wan" {
+		t.Errorf("got networks %q; want %q", got, "egress/lan,egress/wan")
+	}
+	if d.Spec.Template.Spec.Affinity.PodAntiAffinity == nil {
+		t.Error("Deployment has no pod anti-affinity")
+	}
+	if got := d.Spec.Template.Spec.Containers[0].Image; got != "egress:test" {
+		t.Errorf("got image %q; want %q", got, "egress:test")
+	}
+	if len(d.OwnerReferences) != 1 || d.OwnerReferences[0].UID != gw.UID {
+		t.Errorf("Deployment not owned by the gateway: %+v", d.OwnerReferences)
+	}
+
+	if got := getGateway(t, o, gw).Status.ObservedGeneration; got != 1 {
+		t.Errorf("got observedGeneration %d; want 1", got)
+	}
+
+	// Nothing changed, so a second pass shouldn't write anything.
+	cs.ClearActions()
+	if err := o.Reconcile(ctx, getGateway(t, o, gw)); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	if n := writes(cs.Actions()); n != 0 {
+		t.Errorf("second Reconcile() made %d writes: %v", n, cs.Actions())
+	}
+}
+
+func TestReconcile_update(t *testing.T) {
+	ctx := context.Background()
+	gw := testGateway()
+	o := testOperator(t, gw)
+	if err := o.Reconcile(ctx, gw); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	cs := o.Client.(*fake.Clientset)
+	d, _ := cs.AppsV1().Deployments("egress").Get(ctx, "gw", metav1.GetOptions{})
+	oldHash := d.Spec.Template.Annotations[configHashAnnotation]
+
+	// Pretend the router wrote a lease; it should be left alone.
+	lease, _ := cs.CoreV1().ConfigMaps("egress").Get(ctx, "gw-lease", metav1.GetOptions{})
+	lease.Data = map[string]string{"lease": "something"}
+	cs.CoreV1().ConfigMaps("egress").Update(ctx, lease, metav1.UpdateOptions{})
+
+	gw = getGateway(t, o, gw)
+	three := int32(3)
+	gw.Spec.Replicas = &three
+	gw.Spec.Config.FlatNetworks = []string{"egress/flat"}
+	gw.Generation = 2
+	if err := o.Reconcile(ctx, gw); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	d, _ = cs.AppsV1().Deployments("egress").Get(ctx, "gw", metav1.GetOptions{})
+	if *d.Spec.Replicas != 3 {
+		t.Errorf("got %d replicas; want 3", *d.Spec.Replicas)
+	}
+	if d.Spec.Template.Annotations[configHashAnnotation] == oldHash {
+		t.Error("config hash didn't change with the config")
+	}
+	cm, _ := cs.CoreV1().ConfigMaps("egress").Get(ctx, "gw-config", metav1.GetOptions{})
+	if !strings.Contains(cm.Data[configKey], "egress/flat") {
+		t.Errorf("config not updated:\n%s", cm.Data[configKey])
+	}
+	lease, _ = cs.CoreV1().ConfigMaps("egress").Get(ctx, "gw-lease", metav1.GetOptions{})
+	if lease.Data["lease"] != "something" {
+		t.Errorf("lease ConfigMap was overwritten: %v", lease.Data)
+	}
+	if got := getGateway(t, o, gw).Status.ObservedGeneration; got != 2 {
+		t.Errorf("got observedGeneration %d; want 2", got)
+	}
+}
+
+func TestReconcile_uplinkSelection(t *testing.T) {
+	ctx := context.Background()
+	gw := testGateway()
+	gw.Spec.Config.UplinkSelection = &egressk8s.UplinkSelectionParams{
+		Uplinks: []egressk8s.SelectableUplinkParams{{Name: "vpn", Interface: "tun0", GWAddress: "10.8.0.1"}},
+	}
+	o := testOperator(t, gw)
+	if err := o.Reconcile(ctx, gw); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	cs := o.Client.(*fake.Clientset)
+	cr, err := cs.RbacV1().ClusterRoles().Get(ctx, "egress:egress:gw", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("ClusterRole not created: %v", err)
+	}
+	if r := cr.Rules[0]; len(r.Resources) != 2 || len(r.Verbs) != 3 || r.Verbs[2] != "watch" {
+		t.Errorf("ClusterRole doesn't let the router watch pods and namespaces: %+v", cr.Rules)
+	}
+	crb, err := cs.RbacV1().ClusterRoleBindings().Get(ctx, "egress:egress:gw", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("ClusterRoleBinding not created: %v", err)
+	}
+	if s := crb.Subjects; len(s) != 1 || s[0].Name != "gw" || s[0].Namespace != "egress" {
+		t.Errorf("ClusterRoleBinding not bound to the router's ServiceAccount: %+v", s)
+	}
+
+	cs.ClearActions()
+	if err := o.Reconcile(ctx, getGateway(t, o, gw)); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	if n := writes(cs.Actions()); n != 0 {
+		t.Errorf("second Reconcile() made %d writes: %v", n, cs.Actions())
+	}
+
+	gw = getGateway(t, o, gw)
+	gw.Spec.Config.UplinkSelection = nil
+	gw.Generation = 2
+	if err := o.Reconcile(ctx, gw); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	if _, err := cs.RbacV1().ClusterRoles().Get(ctx, "egress:egress:gw", metav1.GetOptions{}); err == nil {
+		t.Error("ClusterRole not deleted along with uplink selection")
+	}
+	if _, err := cs.RbacV1().ClusterRoleBindings().Get(ctx, "egress:egress:gw", metav1.GetOptions{}); err == nil {
+		t.Error("ClusterRoleBinding not deleted along with uplink selection")
+	}
+}
+
+func TestReconcile_invalid(t *testing.T) {
+	ctx := context.Background()
+	gw := testGateway()
+	gw.Spec.Config.LANNetwork = ""
+	o := testOperator(t, gw)
+
+	if err := o.Reconcile(ctx, gw); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	if got := getGateway(t, o, gw).Status.Error; !strings.Contains(got, "lanNetwork") {
+		t.Errorf("got status error %q; want it to mention lanNetwork", got)
+	}
+	if n := writes(o.Client.(*fake.Clientset).Actions()); n != 0 {
+		t.Errorf("invalid gateway made %d writes", n)
+	}
+}
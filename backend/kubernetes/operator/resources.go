@@ -0,0 +1,310 @@
+package operator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	egressk8s "go.jonnrb.io/egress/backend/kubernetes"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	networksAnnotation   = "k8s.v1.cni.cncf.io/networks"
+	configHashAnnotation = Group + "/config-hash"
+	configKey            = "egress.json"
+)
+
+// The objects a gateway is reconciled into.
+type resources struct {
+	serviceAccount *corev1.ServiceAccount
+	role           *rbacv1.Role
+	roleBinding    *rbacv1.RoleBinding
+	config         *corev1.ConfigMap
+	// Nil unless the uplink lease is stored in a ConfigMap the gateway owns.
+	lease      *corev1.ConfigMap
+	deployment *appsv1.Deployment
+
+	// Nil unless the router watches pods and namespaces for uplink selection.
+	// Being cluster-scoped, they can't be owned by the gateway and are deleted
+	// by the operator instead.
+	clusterRole        *rbacv1.ClusterRole
+	clusterRoleBinding *rbacv1.ClusterRoleBinding
+}
+
+func configMapName(gw *EgressGateway) string { return gw.Name + "-config" }
+func leaseMapName(gw *EgressGateway) string  { return gw.Name + "-lease" }
+
+// Names the cluster-scoped objects of the gateway namespace/name.
+func clusterRoleName(namespace, name string) string {
+	return "egress:" + namespace + ":" + name
+}
+
+func labels(gw *EgressGateway) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "egress",
+		"app.kubernetes.io/instance":   gw.Name,
+		"app.kubernetes.io/managed-by": "egress-operator",
+	}
+}
+
+func objectMeta(gw *EgressGateway, name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      name,
+		Namespace: gw.Namespace,
+		Labels:    labels(gw),
+		OwnerReferences: []metav1.OwnerReference{
+			*metav1.NewControllerRef(gw, GatewayKind),
+		},
+	}
+}
+
+// Returns the router's params with the defaults the operator fills in.
+func routerParams(gw *EgressGateway) egressk8s.Params {
+	p := gw.Spec.Config
+	if gw.replicas() > 1 {
+		if p.HA == nil {
+			p.HA = &egressk8s.HAParams{}
+		} else {
+			ha := *p.HA
+			p.HA = &ha
+		}
+		if p.HA.LockName == "" {
+			p.HA.LockName = gw.Namespace + "/" + gw.Name
+		}
+	}
+	usesDHCP := p.UplinkMACAddress != "" && p.UplinkIPAddress == ""
+	if usesDHCP && p.UplinkLeaseConfigMap == "" && p.UplinkLeaseFile == "" {
+		p.UplinkLeaseConfigMap = gw.Namespace + "/" + leaseMapName(gw)
+	}
+	return p
+}
+
+// The networks to have multus attach to router pods.
+func networks(p egressk8s.Params) []string {
+	nets := []string{p.LANNetwork}
+	if p.UplinkNetwork != "" {
+		nets = append(nets, p.UplinkNetwork)
+	}
+	if p.UplinkSelection != nil {
+		for _, u := range p.UplinkSelection.Uplinks {
+			if u.Network != "" {
+				nets = append(nets, u.Network)
+			}
+		}
+	}
+	for _, l := range p.LANs {
+		nets = append(nets, l.Network)
+	}
+	return append(nets, p.FlatNetworks...)
+}
+
+func makeResources(gw *EgressGateway, defaultImage string) (*resources, error) {
+	params := routerParams(gw)
+	if err := params.Validate(); err != nil {
+		return nil, fmt.Errorf("operator: invalid config: %w", err)
+	}
+	image := gw.Spec.Image
+	if image == "" {
+		image = defaultImage
+	}
+	if image == "" {
+		return nil, fmt.Errorf("operator: no image specified")
+	}
+
+	config, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("operator: could not marshal config: %w", err)
+	}
+	hash := sha256.Sum256(config)
+
+	r := &resources{
+		serviceAccount: &corev1.ServiceAccount{
+			ObjectMeta: objectMeta(gw, gw.Name),
+		},
+		role: &rbacv1.Role{
+			ObjectMeta: objectMeta(gw, gw.Name),
+			Rules:      roleRules(),
+		},
+		roleBinding: &rbacv1.RoleBinding{
+			ObjectMeta: objectMeta(gw, gw.Name),
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "Role",
+				Name:     gw.Name,
+			},
+			Subjects: []rbacv1.Subject{{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      gw.Name,
+				Namespace: gw.Namespace,
+			}},
+		},
+		config: &corev1.ConfigMap{
+			ObjectMeta: objectMeta(gw, configMapName(gw)),
+			Data:       map[string]string{configKey: string(config)},
+		},
+		deployment: makeDeployment(gw, image, params, hex.EncodeToString(hash[:])),
+	}
+	if params.UplinkLeaseConfigMap == gw.Namespace+"/"+leaseMapName(gw) {
+		r.lease = &corev1.ConfigMap{
+			ObjectMeta: objectMeta(gw, leaseMapName(gw)),
+		}
+	}
+	if params.UplinkSelection != nil {
+		meta := metav1.ObjectMeta{
+			Name:   clusterRoleName(gw.Namespace, gw.Name),
+			Labels: labels(gw),
+		}
+		r.clusterRole = &rbacv1.ClusterRole{
+			ObjectMeta: meta,
+			Rules:      uplinkSelectionRules(),
+		}
+		r.clusterRoleBinding = &rbacv1.ClusterRoleBinding{
+			ObjectMeta: meta,
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "ClusterRole",
+				Name:     meta.Name,
+			},
+			Subjects: r.roleBinding.Subjects,
+		}
+	}
+	return r, nil
+}
+
+// What a router pod needs: network definitions for addressing, leases for HA,
+// ConfigMaps for the uplink lease and pods to find the leader for conntrack
+// sync.
+func roleRules() []rbacv1.PolicyRule {
+	return []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{"k8s.cni.cncf.io"},
+			Resources: []string{"network-attachment-definitions"},
+			Verbs:     []string{"get"},
+		},
+		{
+			APIGroups: []string{"coordination.k8s.io"},
+			Resources: []string{"leases"},
+			Verbs:     []string{"get", "create", "update"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"configmaps"},
+			Verbs:     []string{"get", "create", "update", "patch"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"pods"},
+			Verbs:     []string{"get"},
+		},
+	}
+}
+
+// What a router pod needs to follow the uplink its LAN clients select: pods
+// and namespaces cluster-wide.
+func uplinkSelectionRules() []rbacv1.PolicyRule {
+	return []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"pods", "namespaces"},
+			Verbs:     []string{"get", "list", "watch"},
+		},
+	}
+}
+
+func makeDeployment(gw *EgressGateway, image string, params egressk8s.Params, configHash string) *appsv1.Deployment {
+	replicas := gw.replicas()
+	maxUnavailable := intstr.FromInt(1)
+	maxSurge := intstr.FromInt(0)
+
+	d := &appsv1.Deployment{
+		ObjectMeta: objectMeta(gw, gw.Name),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels(gw)},
+			// Routers are spread one per node, so a surge pod may have nowhere
+			// to go.
+			Strategy: appsv1.DeploymentStrategy{
+				Type: appsv1.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDeployment{
+					MaxUnavailable: &maxUnavailable,
+					MaxSurge:       &maxSurge,
+				},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels(gw),
+					Annotations: map[string]string{
+						networksAnnotation:   strings.Join(networks(params), ","),
+						configHashAnnotation: configHash,
+					},
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: gw.Name,
+					NodeSelector:       gw.Spec.NodeSelector,
+					Affinity: &corev1.Affinity{
+						PodAntiAffinity: &corev1.PodAntiAffinity{
+							RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+								LabelSelector: &metav1.LabelSelector{MatchLabels: labels(gw)},
+								TopologyKey:   corev1.LabelHostname,
+							}},
+						},
+					},
+					Containers: []corev1.Container{{
+						Name:  "router",
+						Image: image,
+						Args:  gw.Spec.Args,
+						SecurityContext: &corev1.SecurityContext{
+							Capabilities: &corev1.Capabilities{
+								Add: []corev1.Capability{"NET_ADMIN"},
+							},
+						},
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "podinfo", MountPath: "/etc/podinfo", ReadOnly: true},
+							{Name: "config", MountPath: "/etc/config", ReadOnly: true},
+						},
+					}},
+					Volumes: []corev1.Volume{
+						{
+							Name: "podinfo",
+							VolumeSource: corev1.VolumeSource{
+								DownwardAPI: &corev1.DownwardAPIVolumeSource{
+									Items: []corev1.DownwardAPIVolumeFile{
+										{
+											Path: "attachments",
+											FieldRef: &corev1.ObjectFieldSelector{
+												FieldPath: "metadata.annotations['k8s.v1.cni.cncf.io/networks-status']",
+											},
+										},
+										{
+											Path:     "pod-name",
+											FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+										},
+									},
+								},
+							},
+						},
+						{
+							Name: "config",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: configMapName(gw),
+									},
+									Items: []corev1.KeyToPath{{Key: configKey, Path: configKey}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	return d
+}
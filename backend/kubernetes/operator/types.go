@@ -0,0 +1,71 @@
+package operator
+
+import (
+	egressk8s "go.jonnrb.io/egress/backend/kubernetes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	Group    = "egress.jonnrb.io"
+	Version  = "v1alpha1"
+	Kind     = "EgressGateway"
+	Resource = "egressgateways"
+)
+
+var (
+	GatewayResource = schema.GroupVersionResource{
+		Group:    Group,
+		Version:  Version,
+		Resource: Resource,
+	}
+	GatewayKind = schema.GroupVersionKind{
+		Group:   Group,
+		Version: Version,
+		Kind:    Kind,
+	}
+)
+
+// A router deployed and managed by the operator.
+type EgressGateway struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   EgressGatewaySpec   `json:"spec"`
+	Status EgressGatewayStatus `json:"status,omitempty"`
+}
+
+type EgressGatewaySpec struct {
+	// How many router pods to run. More than one enables HA. Defaults to 2.
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// The router image. Defaults to the operator's -operator.image.
+	Image string `json:"image,omitempty"`
+
+	// Extra arguments to the router (e.g. "-c.leader=openvpn ...").
+	Args []string `json:"args,omitempty"`
+
+	// The router's parameters. HA and the uplink lease ConfigMap are filled in
+	// if not specified.
+	Config egressk8s.Params `json:"config"`
+
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+}
+
+type EgressGatewayStatus struct {
+	// The generation of the spec last reconciled.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	Replicas      int32 `json:"replicas"`
+	ReadyReplicas int32 `json:"readyReplicas"`
+
+	// Why the spec couldn't be reconciled, if it couldn't.
+	Error string `json:"error,omitempty"`
+}
+
+func (gw *EgressGateway) replicas() int32 {
+	if gw.Spec.Replicas == nil {
+		return 2
+	}
+	return *gw.Spec.Replicas
+}
@@ -0,0 +1,69 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"go.jonnrb.io/egress/backend/kubernetes/client"
+	"go.jonnrb.io/egress/backend/kubernetes/internal"
+	"go.jonnrb.io/egress/backend/kubernetes/metadata"
+)
+
+// Overrides what is normally discovered from within a pod so egress can run
+// outside of one (e.g. as a host-network daemon managing a node's router
+// network namespace).
+type Overrides struct {
+	// A kubeconfig to use instead of the pod's service account.
+	Kubeconfig string
+
+	// The identity to use for e.g. HA leases. Namespace defaults to the
+	// kubeconfig's current namespace.
+	PodName   string
+	Namespace string
+
+	// Maps the networks named in Params to interfaces, instead of reading
+	// multus's networks-status annotation.
+	Attachments map[string]string
+}
+
+// Installs the overrides. Must be called before GetConfig().
+func (o Overrides) Install() error {
+	if o.Kubeconfig != "" {
+		client.UseKubeconfig(o.Kubeconfig)
+	}
+	if o.PodName != "" {
+		metadata.GetPodName = metadata.Static(o.PodName)
+	}
+
+	ns := o.Namespace
+	if ns == "" {
+		var err error
+		ns, err = client.KubeconfigNamespace()
+		if err != nil {
+			return fmt.Errorf("kubernetes: could not get namespace from kubeconfig: %w", err)
+		}
+	}
+	if ns != "" {
+		metadata.GetPodNamespace = metadata.Static(ns)
+	}
+
+	if o.Attachments != nil {
+		var attachments []internal.Attachment
+		for name, iface := range o.Attachments {
+			attachments = append(attachments, internal.Attachment{
+				Name:      name,
+				Interface: iface,
+			})
+		}
+		sort.Slice(attachments, func(i, j int) bool {
+			return attachments[i].Name < attachments[j].Name
+		})
+		b, err := json.Marshal(attachments)
+		if err != nil {
+			panic(fmt.Sprintf("kubernetes: could not marshal attachments: %v", err))
+		}
+		metadata.GetAttachments = metadata.Static(string(b))
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"time"
+
+	"go.jonnrb.io/egress/backend/kubernetes/client"
+	"go.jonnrb.io/egress/backend/kubernetes/internal"
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/log"
+	"go.jonnrb.io/egress/vaddr/srcroute"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	defaultUplinkLabel = "egress.jonnrb.io/uplink"
+
+	// Selects Uplink() explicitly, e.g. to opt a pod out of its namespace's
+	// uplink.
+	defaultUplinkName = "default"
+
+	// Routing tables for selectable uplinks are numbered from here.
+	firstUplinkTable = 100
+
+	// How long to wait for the first list of pods and namespaces. Failing to
+	// list them (e.g. for lack of RBAC) otherwise blocks forever.
+	cacheSyncTimeout = time.Minute
+)
+
+// Lets pods on the LAN choose their uplink by label. A pod's label takes
+// precedence over its namespace's.
+type UplinkSelectionParams struct {
+	// The label naming the uplink. Defaults to "egress.jonnrb.io/uplink".
+	Label string `json:"label"`
+
+	// The namespaces whose pods can choose. Defaults to all of them.
+	Namespaces []string `json:"namespaces"`
+
+	// The uplinks that can be chosen besides the default ("default").
+	Uplinks []SelectableUplinkParams `json:"uplinks"`
+}
+
+type SelectableUplinkParams struct {
+	// The label value that selects the uplink.
+	Name string `json:"name"`
+
+	// Either the network the uplink is attached by or the interface (e.g. a
+	// tunnel) it is.
+	Network   string `json:"network"`
+	Interface string `json:"interface"`
+
+	// The next hop. Defaults to the gateway of network's IPAM config, if any.
+	GWAddress string `json:"gwAddress"`
+}
+
+func (p *UplinkSelectionParams) check() error {
+	if p == nil {
+		return nil
+	}
+	if len(p.Uplinks) == 0 {
+		return fmt.Errorf("uplinks must be specified")
+	}
+	names := make(map[string]bool)
+	for _, u := range p.Uplinks {
+		if u.Name == "" || u.Name == defaultUplinkName {
+			return fmt.Errorf("uplink names must be specified and not %q", defaultUplinkName)
+		}
+		if names[u.Name] {
+			return fmt.Errorf("duplicate uplink %q", u.Name)
+		}
+		names[u.Name] = true
+		if (u.Network == "") == (u.Interface == "") {
+			return fmt.Errorf("exactly one of network or interface must be specified for uplink %q", u.Name)
+		}
+		if u.GWAddress != "" && net.ParseIP(u.GWAddress).To4() == nil {
+			return fmt.Errorf("if gwAddress is specified for uplink %q, it must be a valid IPv4 address: %s", u.Name, u.GWAddress)
+		}
+	}
+	return nil
+}
+
+func (p *UplinkSelectionParams) label() string {
+	if p.Label == "" {
+		return defaultUplinkLabel
+	}
+	return p.Label
+}
+
+func getSelectableUplinks(ctx context.Context, env environment, params Params) ([]srcroute.Uplink, error) {
+	if params.UplinkSelection == nil {
+		return nil, nil
+	}
+	var uplinks []srcroute.Uplink
+	for i, p := range params.UplinkSelection.Uplinks {
+		u := srcroute.Uplink{
+			Name:  p.Name,
+			GW:    net.ParseIP(p.GWAddress),
+			Table: firstUplinkTable + i,
+		}
+		if p.Interface != "" {
+			u.Link = fw.LinkString(p.Interface)
+		} else {
+			l, err := getLinkForNet(env, p.Network)
+			if err != nil {
+				return nil, fmt.Errorf("kubernetes: could not get link for uplink %q: %w", p.Name, err)
+			}
+			u.Link = link{l.Attrs()}
+			if u.GW == nil {
+				if u.GW, err = getNetworkGW(ctx, env, p.Network); err != nil {
+					return nil, fmt.Errorf("kubernetes: could not get gateway for uplink %q: %w", p.Name, err)
+				}
+			}
+		}
+		uplinks = append(uplinks, u)
+	}
+	return uplinks, nil
+}
+
+// Returns the gateway of netName's IPAM config or nil if there isn't one.
+func getNetworkGW(ctx context.Context, env environment, netName string) (net.IP, error) {
+	def, err := env.cli.Get(ctx, netName)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range def.Ranges {
+		if r.Gateway != nil && r.Gateway.To4() != nil {
+			return r.Gateway, nil
+		}
+	}
+	return nil, nil
+}
+
+// Watches pods and namespaces for the uplink label.
+type uplinkSelectionSource struct {
+	params     UplinkSelectionParams
+	lanNetwork string
+}
+
+func (s *uplinkSelectionSource) Watch(ctx context.Context, update func(srcroute.Selection)) error {
+	restCfg, err := client.Get()
+	if err != nil {
+		return fmt.Errorf("kubernetes: could not get client: %w", err)
+	}
+	cli, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		return fmt.Errorf("kubernetes: could not get client: %w", err)
+	}
+
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(interface{}, interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	}
+
+	namespaces := s.params.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{corev1.NamespaceAll}
+	}
+	var (
+		podListers []listersv1.PodLister
+		synced     []cache.InformerSynced
+	)
+	for _, ns := range namespaces {
+		f := informers.NewSharedInformerFactoryWithOptions(cli, 0, informers.WithNamespace(ns))
+		pods := f.Core().V1().Pods()
+		pods.Informer().AddEventHandler(handler)
+		podListers = append(podListers, pods.Lister())
+		synced = append(synced, pods.Informer().HasSynced)
+		f.Start(ctx.Done())
+	}
+	f := informers.NewSharedInformerFactory(cli, 0)
+	nsInformer := f.Core().V1().Namespaces()
+	nsInformer.Informer().AddEventHandler(handler)
+	synced = append(synced, nsInformer.Informer().HasSynced)
+	f.Start(ctx.Done())
+
+	syncCtx, cancel := context.WithTimeout(ctx, cacheSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), synced...) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err := fmt.Errorf(
+			"kubernetes: could not list pods and namespaces for uplink selection within %v; can the router list and watch them?",
+			cacheSyncTimeout)
+		log.Error(err)
+		return err
+	}
+
+	var last srcroute.Selection
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+
+		var pods []*corev1.Pod
+		for _, l := range podListers {
+			ps, err := l.List(labels.Everything())
+			if err != nil {
+				log.Errorf("kubernetes: could not list pods: %v", err)
+			}
+			pods = append(pods, ps...)
+		}
+		nsLabels := make(map[string]map[string]string)
+		nss, err := nsInformer.Lister().List(labels.Everything())
+		if err != nil {
+			log.Errorf("kubernetes: could not list namespaces: %v", err)
+		}
+		for _, ns := range nss {
+			nsLabels[ns.Name] = ns.Labels
+		}
+
+		sel := selectUplinks(pods, nsLabels, s.params.label(), s.lanNetwork)
+		if !reflect.DeepEqual(sel, last) {
+			log.V(2).Infof("kubernetes: uplink selection is now %v", sel)
+			update(sel)
+			last = sel
+		}
+	}
+}
+
+// Maps the LAN IPs of pods to the uplinks they (or their namespaces) select.
+func selectUplinks(
+	pods []*corev1.Pod,
+	nsLabels map[string]map[string]string,
+	label, lanNetwork string,
+) srcroute.Selection {
+	sel := make(srcroute.Selection)
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		uplink, ok := pod.Labels[label]
+		if !ok {
+			uplink = nsLabels[pod.Namespace][label]
+		}
+		if uplink == "" || uplink == defaultUplinkName {
+			continue
+		}
+		for _, ip := range podLANIPs(pod, lanNetwork) {
+			sel[ip] = uplink
+		}
+	}
+	return sel
+}
+
+func podLANIPs(pod *corev1.Pod, lanNetwork string) []string {
+	status, ok := pod.Annotations["k8s.v1.cni.cncf.io/network-status"]
+	if !ok {
+		status, ok = pod.Annotations["k8s.v1.cni.cncf.io/networks-status"]
+	}
+	if !ok {
+		return nil
+	}
+	attachments, err := internal.ParseNetworkStatus(status)
+	if err != nil {
+		log.V(2).Infof("kubernetes: bad network status on pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return nil
+	}
+	var ips []string
+	for _, attach := range findAttachments(makeAttachmentMap(attachments), lanNetwork) {
+		ips = append(ips, attach.IPs...)
+	}
+	return ips
+}
@@ -0,0 +1,72 @@
+package kubernetes
+
+import (
+	"reflect"
+	"testing"
+
+	"go.jonnrb.io/egress/vaddr/srcroute"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testPod(ns, name, ip string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: ns,
+			Name:      name,
+			Labels:    labels,
+			Annotations: map[string]string{
+				"k8s.v1.cni.cncf.io/networks-status": `[
+					{"name": "cluster", "interface": "eth0", "ips": ["10.244.0.9"], "default": true},
+					{"name": "lan", "interface": "net1", "ips": ["` + ip + `"]}
+				]`,
+			},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestSelectUplinks(t *testing.T) {
+	const label = defaultUplinkLabel
+	done := testPod("apps", "job", "10.0.0.6", map[string]string{label: "vpn"})
+	done.Status.Phase = corev1.PodSucceeded
+	pods := []*corev1.Pod{
+		testPod("apps", "a", "10.0.0.2", map[string]string{label: "vpn"}),
+		testPod("apps", "b", "10.0.0.3", nil),
+		testPod("media", "c", "10.0.0.4", nil),
+		testPod("media", "d", "10.0.0.5", map[string]string{label: "default"}),
+		done,
+	}
+	nsLabels := map[string]map[string]string{
+		"media": {label: "isp2"},
+	}
+
+	got := selectUplinks(pods, nsLabels, label, "egress/lan")
+	want := srcroute.Selection{
+		"10.0.0.2": "vpn",
+		"10.0.0.4": "isp2",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("selectUplinks() = %v; want %v", got, want)
+	}
+}
+
+func TestUplinkSelectionParams_check(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		p     UplinkSelectionParams
+		valid bool
+	}{
+		{"network", UplinkSelectionParams{Uplinks: []SelectableUplinkParams{{Name: "vpn", Network: "egress/vpn"}}}, true},
+		{"interface", UplinkSelectionParams{Uplinks: []SelectableUplinkParams{{Name: "vpn", Interface: "tun0", GWAddress: "10.8.0.1"}}}, true},
+		{"no uplinks", UplinkSelectionParams{}, false},
+		{"reserved name", UplinkSelectionParams{Uplinks: []SelectableUplinkParams{{Name: "default", Interface: "tun0"}}}, false},
+		{"both", UplinkSelectionParams{Uplinks: []SelectableUplinkParams{{Name: "vpn", Interface: "tun0", Network: "egress/vpn"}}}, false},
+		{"duplicate", UplinkSelectionParams{Uplinks: []SelectableUplinkParams{{Name: "vpn", Interface: "tun0"}, {Name: "vpn", Interface: "tun1"}}}, false},
+		{"bad gateway", UplinkSelectionParams{Uplinks: []SelectableUplinkParams{{Name: "vpn", Interface: "tun0", GWAddress: "nope"}}}, false},
+	} {
+		if err := c.p.check(); (err == nil) != c.valid {
+			t.Errorf("%s: check() = %v; want valid = %v", c.name, err, c.valid)
+		}
+	}
+}
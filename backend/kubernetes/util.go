@@ -55,17 +55,45 @@ func getNetMap(ctx context.Context, cli *internal.CNIClient, netNames []string)
 	return m, nil
 }
 
-// XXX: A pod may be connected to a network by multiple interfaces. It is
-// assumed that all of these connections are equivalent.
-func makeAttachmentMap(attachs []internal.Attachment) map[string]internal.Attachment {
-	m := make(map[string]internal.Attachment)
+// A pod may be attached to a network by multiple interfaces (e.g. several
+// VLANs on one network definition). They are kept in the order they appear in
+// the network status, with the first being the primary attachment.
+func makeAttachmentMap(attachs []internal.Attachment) map[string][]internal.Attachment {
+	m := make(map[string][]internal.Attachment)
 	for _, a := range attachs {
-		m[a.Name] = a
+		m[a.Name] = append(m[a.Name], a)
 	}
 	return m
 }
 
-func getLinkForNet(attachments map[string]internal.Attachment, net string) (netlink.Link, error) {
+func getLinkForNet(env environment, net string) (netlink.Link, error) {
+	attach, ok := findAttachment(env.attachments, net)
+	if !ok {
+		return nil, fmt.Errorf("pod not attached to network %q", net)
+	}
+	return env.linkByName(attach.Interface)
+}
+
+// Gets the link named name. In dry runs, links that don't exist (e.g. when not
+// running in the router's pod) are stood in for by name.
+func (env environment) linkByName(name string) (netlink.Link, error) {
+	l, err := netlink.LinkByName(name)
+	if err != nil && env.dryRun {
+		return &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: name}}, nil
+	}
+	return l, err
+}
+
+// Finds the primary attachment to net.
+func findAttachment(attachments map[string][]internal.Attachment, net string) (internal.Attachment, bool) {
+	attachs := findAttachments(attachments, net)
+	if len(attachs) == 0 {
+		return internal.Attachment{}, false
+	}
+	return attachs[0], true
+}
+
+func findAttachments(attachments map[string][]internal.Attachment, net string) []internal.Attachment {
 	// net may be of the form namespace/name, but under older versions of
 	// multus, attachments only show up with the "name" bit.
 	netCompat := net
@@ -73,13 +101,10 @@ func getLinkForNet(attachments map[string]internal.Attachment, net string) (netl
 		netCompat = split[1]
 	}
 
-	attach, ok := attachments[net]
+	attachs, ok := attachments[net]
 	if !ok {
 		// Try the old-multus compatible name.
-		attach, ok = attachments[netCompat]
-	}
-	if !ok {
-		return nil, fmt.Errorf("pod not attached to network %q", net)
+		attachs = attachments[netCompat]
 	}
-	return netlink.LinkByName(attach.Interface)
+	return attachs
 }
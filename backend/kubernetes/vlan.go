@@ -0,0 +1,55 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Gets the VLAN link with the given id on parent, creating it if it doesn't
+// exist yet (it will if this container restarted).
+func getOrCreateVLAN(parent netlink.Link, id int) (netlink.Link, error) {
+	name := vlanLinkName(parent.Attrs().Name, id)
+
+	err := netlink.LinkAdd(&netlink.Vlan{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:        name,
+			ParentIndex: parent.Attrs().Index,
+		},
+		VlanId: id,
+	})
+	if err != nil && !errors.Is(err, unix.EEXIST) {
+		return nil, fmt.Errorf(
+			"kubernetes: could not create VLAN %d on %q: %w",
+			id, parent.Attrs().Name, err)
+	}
+
+	l, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: could not get VLAN link %q: %w", name, err)
+	}
+	if v, ok := l.(*netlink.Vlan); !ok || v.VlanId != id || v.ParentIndex != parent.Attrs().Index {
+		return nil, fmt.Errorf(
+			"kubernetes: link %q exists but isn't VLAN %d on %q",
+			name, id, parent.Attrs().Name)
+	}
+
+	// The VLAN link can't come up unless its parent is up.
+	if err := netlink.LinkSetUp(parent); err != nil {
+		return nil, fmt.Errorf(
+			"kubernetes: could not up link %q: %w", parent.Attrs().Name, err)
+	}
+	return l, nil
+}
+
+// Names the link like "eth0.100", truncating the parent's name to fit.
+func vlanLinkName(parent string, id int) string {
+	suffix := "." + strconv.Itoa(id)
+	if max := unix.IFNAMSIZ - 1 - len(suffix); len(parent) > max {
+		parent = parent[:max]
+	}
+	return parent + suffix
+}
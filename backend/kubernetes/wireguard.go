@@ -0,0 +1,181 @@
+package kubernetes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/vaddr/wireguard"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+type WireGuardParams struct {
+	// A file (e.g. a mounted Secret) holding the base64 private key.
+	PrivateKeyFile string `json:"privateKeyFile"`
+	ListenPort     int    `json:"listenPort"`
+	// The interface address in CIDR notation (e.g. "10.64.0.2/32").
+	Address string                `json:"address"`
+	Peers   []WireGuardPeerParams `json:"peers"`
+
+	// Interchangeable peers (e.g. a VPN provider's servers), one of which is
+	// used at a time and rotated through when its handshake goes stale.
+	Candidates []WireGuardPeerParams `json:"candidates"`
+
+	// How long the candidate in use can go without a handshake before
+	// rotating to another (e.g. "3m").
+	FailoverAfter string `json:"failoverAfter"`
+}
+
+type WireGuardPeerParams struct {
+	PublicKey string `json:"publicKey"`
+	// A file holding the base64 preshared key, if any.
+	PresharedKeyFile    string   `json:"presharedKeyFile"`
+	Endpoint            string   `json:"endpoint"`
+	AllowedIPs          []string `json:"allowedIPs"`
+	PersistentKeepalive string   `json:"persistentKeepalive"`
+}
+
+func (wgParams *WireGuardParams) check() error {
+	if wgParams == nil {
+		return nil
+	}
+	if wgParams.PrivateKeyFile == "" {
+		return fmt.Errorf("privateKeyFile must be specified")
+	}
+	if wgParams.ListenPort < 0 || wgParams.ListenPort > 65535 {
+		return fmt.Errorf("if listenPort is specified, it must be valid: %d", wgParams.ListenPort)
+	}
+	if _, err := fw.ParseAddr(wgParams.Address); err != nil {
+		return fmt.Errorf("address must be valid: %w", err)
+	}
+	if len(wgParams.Peers) == 0 && len(wgParams.Candidates) == 0 {
+		return fmt.Errorf("at least one peer or candidate must be specified")
+	}
+	for i, p := range wgParams.Peers {
+		if err := p.check(); err != nil {
+			return fmt.Errorf("peer %d must be valid: %w", i, err)
+		}
+	}
+	for i, p := range wgParams.Candidates {
+		if err := p.check(); err != nil {
+			return fmt.Errorf("candidate %d must be valid: %w", i, err)
+		}
+		if p.Endpoint == "" {
+			return fmt.Errorf("candidate %d must have an endpoint", i)
+		}
+	}
+	if _, err := time.ParseDuration(wgParams.FailoverAfter); wgParams.FailoverAfter != "" && err != nil {
+		return fmt.Errorf("if failoverAfter is specified, it must be valid: %w", err)
+	}
+	return nil
+}
+
+func (p WireGuardPeerParams) check() error {
+	if _, err := wgtypes.ParseKey(p.PublicKey); err != nil {
+		return fmt.Errorf("publicKey must be valid: %w", err)
+	}
+	if p.Endpoint != "" {
+		if _, _, err := net.SplitHostPort(p.Endpoint); err != nil {
+			return fmt.Errorf("if endpoint is specified, it must be valid: %w", err)
+		}
+	}
+	for _, s := range p.AllowedIPs {
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			return fmt.Errorf("allowedIPs must be valid: %w", err)
+		}
+	}
+	if _, err := time.ParseDuration(p.PersistentKeepalive); p.PersistentKeepalive != "" && err != nil {
+		return fmt.Errorf("if persistentKeepalive is specified, it must be valid: %w", err)
+	}
+	return nil
+}
+
+// Loads the uplink's WireGuard config. Dry runs leave the keys zero and only
+// use endpoints given as IPs, since key files and DNS may be unavailable where
+// the config is explained.
+func getUplinkWireGuard(env environment, params Params) (*wireguard.Config, error) {
+	wgParams := params.UplinkWireGuard
+	if wgParams == nil {
+		return nil, nil
+	}
+
+	cfg := &wireguard.Config{
+		ListenPort: wgParams.ListenPort,
+	}
+	if !env.dryRun {
+		key, err := readKeyFile(wgParams.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.PrivateKey = key
+	}
+	for _, pp := range wgParams.Peers {
+		p, err := pp.load(env.dryRun)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Peers = append(cfg.Peers, p)
+	}
+	for _, pp := range wgParams.Candidates {
+		p, err := pp.load(env.dryRun)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Candidates = append(cfg.Candidates, p)
+	}
+	cfg.FailoverAfter, _ = time.ParseDuration(wgParams.FailoverAfter)
+	return cfg, nil
+}
+
+func (pp WireGuardPeerParams) load(dryRun bool) (p wireguard.Peer, err error) {
+	p.PublicKey, err = wgtypes.ParseKey(pp.PublicKey)
+	if err != nil {
+		panic("kubernetes: config should have been checked")
+	}
+	switch {
+	case pp.PresharedKeyFile != "" && dryRun:
+		p.PresharedKey = &wgtypes.Key{}
+	case pp.PresharedKeyFile != "":
+		psk, err := readKeyFile(pp.PresharedKeyFile)
+		if err != nil {
+			return p, err
+		}
+		p.PresharedKey = &psk
+	}
+	switch {
+	case pp.Endpoint != "" && dryRun:
+		if ap, err := netip.ParseAddrPort(pp.Endpoint); err == nil {
+			p.Endpoint = net.UDPAddrFromAddrPort(ap)
+		}
+	case pp.Endpoint != "":
+		p.Endpoint, err = net.ResolveUDPAddr("udp", pp.Endpoint)
+		if err != nil {
+			return p, fmt.Errorf(
+				"kubernetes: could not resolve wireguard endpoint %q: %w", pp.Endpoint, err)
+		}
+	}
+	for _, s := range pp.AllowedIPs {
+		_, n, _ := net.ParseCIDR(s)
+		p.AllowedIPs = append(p.AllowedIPs, *n)
+	}
+	p.PersistentKeepalive, _ = time.ParseDuration(pp.PersistentKeepalive)
+	return p, nil
+}
+
+func readKeyFile(path string) (wgtypes.Key, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf(
+			"kubernetes: could not read wireguard key: %w", err)
+	}
+	k, err := wgtypes.ParseKey(strings.TrimSpace(string(b)))
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf(
+			"kubernetes: bad wireguard key in %q: %w", path, err)
+	}
+	return k, nil
+}
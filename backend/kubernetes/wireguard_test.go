@@ -0,0 +1,52 @@
+package kubernetes
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestGetUplinkWireGuard_dryRun(t *testing.T) {
+	k, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing")
+	params := Params{UplinkWireGuard: &WireGuardParams{
+		PrivateKeyFile: missing,
+		Address:        "10.64.0.2/32",
+		Peers: []WireGuardPeerParams{{
+			PublicKey:        k.PublicKey().String(),
+			PresharedKeyFile: missing,
+			Endpoint:         "192.0.2.1:51820",
+			AllowedIPs:       []string{"0.0.0.0/0"},
+		}},
+		Candidates: []WireGuardPeerParams{{
+			PublicKey: k.PublicKey().String(),
+			Endpoint:  "vpn.invalid:51820",
+		}},
+	}}
+
+	if _, err := getUplinkWireGuard(environment{}, params); err == nil {
+		t.Error("expected missing key files to fail outside a dry run")
+	}
+
+	cfg, err := getUplinkWireGuard(environment{dryRun: true}, params)
+	if err != nil {
+		t.Fatalf("getUplinkWireGuard() failed in a dry run: %v", err)
+	}
+	if cfg.PrivateKey != (wgtypes.Key{}) {
+		t.Error("expected no private key in a dry run")
+	}
+	p := cfg.Peers[0]
+	if p.PresharedKey == nil || *p.PresharedKey != (wgtypes.Key{}) {
+		t.Errorf("expected a zero preshared key standing in for the file; got %v", p.PresharedKey)
+	}
+	if p.Endpoint == nil || p.Endpoint.String() != "192.0.2.1:51820" {
+		t.Errorf("expected the peer's endpoint to be taken from its params; got %v", p.Endpoint)
+	}
+	if e := cfg.Candidates[0].Endpoint; e != nil {
+		t.Errorf("expected the candidate's hostname not to be resolved; got %v", e)
+	}
+}
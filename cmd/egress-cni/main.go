@@ -0,0 +1,13 @@
+// Command egress-cni is a chained CNI plugin that routes pods attached to an
+// egress-managed network through the egress gateway. See package cniroute.
+package main
+
+import (
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/version"
+	"go.jonnrb.io/egress/backend/kubernetes/cniroute"
+)
+
+func main() {
+	skel.PluginMain(cniroute.Add, cniroute.Check, cniroute.Del, version.All, "egress-cni")
+}
@@ -1,16 +1,44 @@
 package main
 
-import "flag"
+import (
+	"flag"
+	"time"
+
+	"go.jonnrb.io/egress/fwdebug"
+)
 
 var (
 	healthCheck            = flag.Bool("health_check", false, "If set, connects to the internal healthcheck endpoint and exits.")
 	tunCreateName          = flag.String("create_tun", "", "If set, creates a tun interface with the specified name (to be used with -docker.uplink_interface and probably a VPN client")
 	wgCreateName           = flag.String("create_wg", "", "If set, creates a wireguard interface with the specified name (to be used with -docker.uplink_interface and probably a VPN client")
-	cmd                    = flag.String("c", "", "Command to run after initialization")
+	cmd                    = flag.String("c", "", "Command to run after initialization (same as -c.leader)")
+	cmdLeader              = flag.String("c.leader", "", "Command to run on the HA leader (or when HA isn't configured) once the virtual addresses are up; it is stopped before they are torn down")
+	cmdFollower            = flag.String("c.follower", "", "Command to run while following an HA leader")
+	cmdAlways              = flag.String("c.always", "", "Command to run regardless of HA role")
+	cmdRestart             = flag.String("c.restart", "never", "When to restart commands after they exit: never, on-failure or always")
+	cmdMaxRestarts         = flag.Int("c.max_restarts", 0, "How many times in a row to restart a command before giving up (0 is unlimited)")
+	cmdBackoff             = flag.Duration("c.backoff", time.Second, "How long to wait before restarting a command (doubled on each consecutive restart)")
+	cmdMaxBackoff          = flag.Duration("c.max_backoff", time.Minute, "The longest to wait before restarting a command")
 	httpAddr               = flag.String("http.addr", "0.0.0.0:8080", "Port to serve metrics and health status on")
 	httpIface              = flag.String("http.iface", "", "Interface allowed to receive HTTP traffic (if empty, all interfaces can be queried for health and metrics unless otherwise blocked)")
 	openPortsCSV           = flag.String("open_ports", "", "Additional ports to open (tcp/1234,udp/2345,tcp/654/lo)")
+	dryRun                 = flag.Bool("dry_run", false, "If set, prints the firewall rules, virtual addresses and HA plan the router would use and exits without changing anything")
+	dryRunFormat           = flag.String("dry_run.format", "text", "The format of -dry_run's output: text or json")
+	policyPath             = flag.String("policy", "", "Path to a firewall policy (see go.jonnrb.io/egress/fw/policy) applied ahead of the rest of the firewall")
+	policyDryRun           = flag.Bool("policy.dry_run", false, "If set, prints the rules compiled from -policy and exits")
 	blockInterfaceInputCSV = flag.String("block_interface_input", "", "Interfaces that cannot connect to ports on this router (e.g. eth0,eth1)")
 	noCmd                  = flag.Bool("no_cmd", false, "Exit on success (the default when no cmd is specified is to sleep)")
+	fwTraceMax             = flag.Duration("fw_debug.max_trace", 0, "If set, enables firewall traces on /debug/fw/trace and limits how long they may run. Traces see every LAN client's connections, so also set -http.iface")
+	fwTraceGroup           = flag.Uint("fw_debug.nflog_group", fwdebug.DefaultGroup, "The NFLOG group firewall traces log to")
+	acctClients            = flag.Bool("acct", false, "If set, accounts for uplink usage per LAN client (served as JSON on /clients and in metrics)")
+	wgMaxHandshakeAge      = flag.Duration("wg.max_handshake_age", 3*time.Minute, "How long since the last handshake before a configured WireGuard uplink is considered unhealthy")
+	k8sParams              = flag.String("k8s.params", "/etc/config/egress.json", "Path to the Kubernetes backend's JSON parameters")
+	k8sKubeconfig          = flag.String("k8s.kubeconfig", "", "If set, talks to Kubernetes using this kubeconfig rather than the pod's service account (to run outside of a pod)")
+	k8sPodName             = flag.String("k8s.pod_name", "", "Overrides the pod name normally read from the downward API")
+	k8sNamespace           = flag.String("k8s.namespace", "", "Overrides the namespace normally read from the service account (defaults to the kubeconfig's namespace with -k8s.kubeconfig)")
+	k8sAttachmentsCSV      = flag.String("k8s.attachments", "", "Overrides the network attachments normally read from the downward API as network=interface pairs (e.g. default/lan=eth1,default/wan=eth2)")
+	operatorMode           = flag.Bool("operator", false, "If set, runs the EgressGateway operator instead of a router")
+	operatorImage          = flag.String("operator.image", "", "The router image for EgressGateways that don't specify one")
+	operatorNamespace      = flag.String("operator.namespace", "", "If set, only reconciles EgressGateways in this namespace")
 	justMetrics            = flag.Bool("just_metrics", false, "Just serves metrics without doing any setup (meant to be used in a pod)")
 )
@@ -2,43 +2,88 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/google/shlex"
+	"go.jonnrb.io/egress/acct"
 	"go.jonnrb.io/egress/backend/kubernetes"
+	"go.jonnrb.io/egress/backend/kubernetes/operator"
+	"go.jonnrb.io/egress/explain"
 	"go.jonnrb.io/egress/fw"
 	"go.jonnrb.io/egress/fw/fwutil"
+	"go.jonnrb.io/egress/fw/policy"
 	"go.jonnrb.io/egress/fw/rules"
+	"go.jonnrb.io/egress/fwdebug"
 	"go.jonnrb.io/egress/ha"
 	"go.jonnrb.io/egress/health"
 	"go.jonnrb.io/egress/log"
 	"go.jonnrb.io/egress/metrics"
+	"go.jonnrb.io/egress/status"
+	"go.jonnrb.io/egress/supervisor"
+	"go.jonnrb.io/egress/tracing"
 	"go.jonnrb.io/egress/util"
 	"go.jonnrb.io/egress/vaddr"
+	"go.jonnrb.io/egress/vaddr/dhcp"
 	"go.jonnrb.io/egress/vaddr/vaddrha"
+	"golang.org/x/sync/errgroup"
 )
 
 func main() {
+	// Deferred first so it runs after everything else is cleaned up.
+	var exitCode int
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
 	flag.Parse()
-	args, extraRules := processArgs()
+	cmds, extraRules := processArgs()
+	pol := loadPolicy()
+	if *policyDryRun {
+		printPolicy(pol)
+		return
+	}
+	if *dryRun {
+		dryRunMain(cmds, extraRules, pol)
+		return
+	}
 
 	if *healthCheck {
 		healthCheckMain()
 		return
 	}
+	if *operatorMode {
+		operatorMain()
+		return
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		log.Fatalf("Error setting up tracing: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Warningf("Error flushing traces: %v", err)
+		}
+	}()
 
 	// Create things that aren't bound by the main context.Context.
 	maybeCreateNetworks()
-	cfg := getFWConfig()
+	cfg := getFWConfig(false)
 
 	// Get the ha.Coordinator (if configured).
 	hac := fwutil.GetHACoordinator(cfg)
@@ -53,7 +98,7 @@ func main() {
 			log.Warning("HA is configured but -noCmd was specified.")
 		}
 		// Skip some stuff if noCmd.
-		applyFWRules(cfg, extraRules)
+		applyFWRules(renderFWRules(cfg, extraRules, pol))
 		onlyStartVAddr(va)
 		return
 	}
@@ -68,17 +113,62 @@ func main() {
 			log.Warning("Running with -justMetrics but HA is configured.")
 		}
 		ctx := context.Background()
-		setupHTTPHandlers(ctx, cfg, httpCfg, nil)
+		rs := renderFWRules(cfg, extraRules, pol)
+		setupHTTPHandlers(ctx, cfg, httpCfg, nil, metrics.Config{
+			Rules: rs,
+		}, status.New(status.Config{FW: cfg, Rules: rs}))
 		httpServeContext(ctx, httpCfg)
 		return
 	}
 
-	applyFWRules(cfg, extraRules)
+	// The firewall is the same regardless of HA role, so it is rendered and
+	// applied up front rather than when becoming the leader.
+	rs := renderFWRules(cfg, extraRules, pol)
+	applyFWRules(rs)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	setupHTTPHandlers(ctx, cfg, httpCfg, &m)
+	var checks []func(context.Context) error
+	for _, d := range fwutil.WireGuardDevices(va) {
+		d := d
+		checks = append(checks, func(context.Context) error {
+			return d.CheckHandshake(*wgMaxHandshakeAge)
+		})
+	}
+
+	st := status.New(status.Config{FW: cfg, Rules: rs, HA: hac != nil})
+	leaderSup := newSupervisor("leader", cmds.leader, st)
+	followerSup := newSupervisor("follower", cmds.follower, st)
+	alwaysSup := newSupervisor("always", cmds.always, st)
+	for _, sup := range []*supervisor.Supervisor{leaderSup, followerSup, alwaysSup} {
+		if sup != nil {
+			checks = append(checks, sup.Check)
+		}
+	}
+	if followerSup != nil && hac == nil {
+		log.Fatal("-c.follower was specified but HA isn't configured")
+	}
+	st.TrackVAddr(&va)
+	fwutil.ObserveLeases(va, st.ObserveLease)
+
+	var observeActivation func(d time.Duration)
+	metricsCfg := metrics.Config{
+		Rules: rs,
+		ActivationHandler: func(f func(d time.Duration)) {
+			observeActivation = f
+		},
+		LeaseHandler: func(f func(link string, l dhcp.Lease)) {
+			fwutil.ObserveLeases(va, f)
+		},
+	}
+	if *acctClients {
+		a := acct.New(fwutil.ClientLinks(cfg), fwutil.Uplinks(cfg))
+		metricsCfg.Clients = a.Clients
+		httpCfg.mux.Handle("/clients", restrictToHTTPIface(a))
+		va.Actives = append(va.Actives, a)
+	}
+	setupHTTPHandlers(ctx, cfg, httpCfg, &m, metricsCfg, st, checks...)
 
 	// Create the steady-state.
 	va.Actives = append(va.Actives,
@@ -87,23 +177,82 @@ func main() {
 		}))
 	va.Actives = append(va.Actives,
 		vaddr.ActiveFunc(func(ctx context.Context) error {
-			return runSubprocess(ctx, args)
+			return runSubprocess(ctx, leaderSup)
 		}))
 
-	if hac != nil {
-		m.Add(vaddrha.New(va))
-		switch err := hac.Run(ctx, &m); err {
-		case errSubprocessExited, http.ErrServerClosed, nil:
-		default:
-			log.Fatal(err)
+	// The -c.always command runs alongside everything else and stops it if it
+	// exits for good (and vice versa).
+	eg, egCtx := errgroup.WithContext(ctx)
+	if alwaysSup != nil {
+		eg.Go(func() error {
+			return runSubprocess(egCtx, alwaysSup)
+		})
+	}
+	eg.Go(func() error {
+		defer cancel()
+		if hac != nil {
+			m.Add(vaddrha.Member{VAddr: va, OnActivated: observeActivation})
+			m.Add(st)
+			if cts := fwutil.GetConntrackSync(cfg); cts != nil {
+				m.Add(cts)
+			}
+			if followerSup != nil {
+				m.Add(followerCommand{followerSup})
+			}
+			return hac.Run(egCtx, &m)
 		}
-	} else {
-		switch err := va.Run(ctx); err {
-		case errSubprocessExited, http.ErrServerClosed, nil:
-		default:
-			log.Fatal(err)
+		return va.Run(egCtx)
+	})
+	exitCode = exitStatus(eg.Wait())
+}
+
+// Runs a subprocess while following an HA leader.
+type followerCommand struct {
+	sup *supervisor.Supervisor
+}
+
+func (f followerCommand) Lead(ctx context.Context, _ func(time.Duration) error) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (f followerCommand) Follow(ctx context.Context, _ string) error {
+	return runSubprocess(ctx, f.sup)
+}
+
+// Prints what would be done without touching the system.
+func dryRunMain(cmds commands, extraRules rules.RuleSet, pol *policy.Policy) {
+	cfg := getFWConfig(true)
+	hac := fwutil.GetHACoordinator(cfg)
+	va := vaddr.Join(
+		fwutil.MakeVAddrLAN(cfg),
+		fwutil.MakeVAddrUplink(cfg))
+	if *acctClients {
+		va.Actives = append(va.Actives, acct.New(fwutil.ClientLinks(cfg), fwutil.Uplinks(cfg)))
+	}
+
+	plan := explain.New(cfg, renderFWRules(cfg, extraRules, pol), va, hac)
+	if hac != nil {
+		if cts := fwutil.GetConntrackSync(cfg); cts != nil {
+			plan.AddMember(cts)
 		}
 	}
+	plan.HA.Leader = cmds.leader
+	plan.HA.Follower = cmds.follower
+	plan.HA.Always = cmds.always
+
+	var err error
+	switch *dryRunFormat {
+	case "text":
+		err = plan.WriteText(os.Stdout)
+	case "json":
+		err = plan.WriteJSON(os.Stdout)
+	default:
+		log.Fatalf("Flag \"-dry_run.format\" should be text or json; got %q", *dryRunFormat)
+	}
+	if err != nil {
+		log.Fatalf("Error writing dry run: %v", err)
+	}
 }
 
 func healthCheckMain() {
@@ -124,26 +273,65 @@ func healthCheckMain() {
 	}
 }
 
-func processArgs() (args []string, extraRules rules.RuleSet) {
+func operatorMain() {
+	if err := kubernetesOverrides().Install(); err != nil {
+		log.Fatalf("Error overriding Kubernetes environment: %v", err)
+	}
+	op, err := operator.New()
+	if err != nil {
+		log.Fatalf("Error creating operator: %v", err)
+	}
+	op.Image = *operatorImage
+	op.Namespace = *operatorNamespace
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	if err := op.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatalf("Error running operator: %v", err)
+	}
+}
+
+// The delegate commands, by the HA role they run under.
+type commands struct {
+	leader, follower, always []string
+}
+
+func processArgs() (cmds commands, extraRules rules.RuleSet) {
 	extraRules = getOpenPortRules()
 	extraRules = append(extraRules, openHTTPPort())
 	extraRules = append(extraRules, getBlockInterfaceInputRules()...)
-	args = flag.Args()
-
-	if *cmd == "" {
-		return
+	if *acctClients {
+		extraRules = append(extraRules, acct.Rules()...)
 	}
+	cmds.leader = flag.Args()
 
-	if len(args) > 0 {
-		log.Fatal("Delegate process can be specifed by -c and a string or a list of args, but not both")
+	leader := *cmdLeader
+	switch {
+	case *cmd != "" && leader != "":
+		log.Fatal("Only one of -c and -c.leader can be specified")
+	case *cmd != "":
+		leader = *cmd
 	}
+	if leader != "" {
+		if len(cmds.leader) > 0 {
+			log.Fatal("Delegate process can be specifed by -c (or -c.leader) and a string or a list of args, but not both")
+		}
+		cmds.leader = splitCommand(leader)
+	}
+	cmds.follower = splitCommand(*cmdFollower)
+	cmds.always = splitCommand(*cmdAlways)
+	return
+}
 
-	var err error
-	args, err = shlex.Split(*cmd)
+func splitCommand(s string) []string {
+	if s == "" {
+		return nil
+	}
+	args, err := shlex.Split(s)
 	if err != nil {
-		log.Fatalf("Error parsing shell command %q: %v", *cmd, err)
+		log.Fatalf("Error parsing shell command %q: %v", s, err)
 	}
-	return
+	return args
 }
 
 func maybeCreateNetworks() {
@@ -166,18 +354,27 @@ func maybeCreateNetworks() {
 	}
 }
 
-func getFWConfig() fw.Config {
+// Gets the fw.Config from the backend. A dry run config can only be explained.
+func getFWConfig(dryRun bool) fw.Config {
 	log.V(2).Info("Getting fw.Config")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if kubernetes.InCluster() {
-		params, err := kubernetes.ParamsFromFile()
+	overrides := kubernetesOverrides()
+	if kubernetes.InCluster() || overrides.Kubeconfig != "" {
+		if err := overrides.Install(); err != nil {
+			log.Fatalf("Error overriding Kubernetes environment: %v", err)
+		}
+		params, err := kubernetes.ParamsFromPath(*k8sParams)
 		if err != nil {
 			log.Fatalf("Error getting Kubernetes router parameters: %v", err)
 		}
-		cfg, err := kubernetes.GetConfig(ctx, params)
+		getConfig := kubernetes.GetConfig
+		if dryRun {
+			getConfig = kubernetes.GetConfigDryRun
+		}
+		cfg, err := getConfig(ctx, params)
 		if err != nil {
 			log.Fatalf("Error configuring router from Kubernetes environment: %v", err)
 		}
@@ -188,6 +385,26 @@ func getFWConfig() fw.Config {
 	}
 }
 
+func kubernetesOverrides() kubernetes.Overrides {
+	o := kubernetes.Overrides{
+		Kubeconfig: *k8sKubeconfig,
+		PodName:    *k8sPodName,
+		Namespace:  *k8sNamespace,
+	}
+	if *k8sAttachmentsCSV == "" {
+		return o
+	}
+	o.Attachments = make(map[string]string)
+	for _, s := range strings.Split(*k8sAttachmentsCSV, ",") {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			log.Fatalf("Flag \"-k8s.attachments\" should be a CSV of network=interface pairs; got %q", *k8sAttachmentsCSV)
+		}
+		o.Attachments[kv[0]] = kv[1]
+	}
+	return o
+}
+
 func maybeActivateFWConfig(cfg fw.Config) {
 	type dormantConfig interface {
 		Activate(ctx context.Context) error
@@ -239,9 +456,34 @@ func openHTTPPort() rules.Rule {
 	}
 }
 
-func applyFWRules(cfg fw.Config, extraRules rules.RuleSet) {
-	log.V(2).Info("Applying fw rules from environment")
-	if err := fw.Apply(fw.WithExtraRules(cfg, extraRules)); err != nil {
+func renderFWRules(cfg fw.Config, extraRules rules.RuleSet, pol *policy.Policy) rules.RuleSet {
+	log.V(2).Info("Rendering fw rules from environment")
+	return fw.Render(fw.WithExtraRules(cfg, extraRules), pol.Apply)
+}
+
+func loadPolicy() *policy.Policy {
+	if *policyPath == "" {
+		if *policyDryRun {
+			log.Fatal("-policy.dry_run requires -policy")
+		}
+		return nil
+	}
+	pol, err := policy.ParseFile(*policyPath)
+	if err != nil {
+		log.Fatalf("Error loading firewall policy: %v", err)
+	}
+	return pol
+}
+
+func printPolicy(pol *policy.Policy) {
+	for _, r := range pol.RuleSet() {
+		fmt.Println(r)
+	}
+}
+
+func applyFWRules(r rules.RuleSet) {
+	log.V(2).Info("Applying fw rules")
+	if err := fw.ApplyRules(r); err != nil {
 		log.Fatalf("Error applying fw rules: %v", err)
 	}
 }
@@ -294,20 +536,116 @@ func getOpenPortRules() (r rules.RuleSet) {
 	return
 }
 
-func setupHTTPHandlers(ctx context.Context, cfg fw.Config, httpCfg httpConfig, m *ha.MemberGroup) {
-	metricsHandler, err := metrics.New(ctx, metrics.Config{
-		UplinkName: cfg.Uplink().Name(),
-	})
-	if err != nil {
-		log.Fatalf("Error setting up metrics: %v", err)
-	}
+func setupHTTPHandlers(
+	ctx context.Context,
+	cfg fw.Config,
+	httpCfg httpConfig,
+	m *ha.MemberGroup,
+	metricsCfg metrics.Config,
+	st *status.Status,
+	checks ...func(context.Context) error,
+) {
 	var mr func(m ha.Member)
 	if m != nil {
 		mr = m.Add
 	}
 
+	metricsCfg.UplinkName = cfg.Uplink().Name()
+	metricsCfg.Links = metricsLinks(cfg)
+	metricsCfg.HAHandler = mr
+	metricsCfg.WireGuard = wireGuardLinks(cfg)
+	metricsHandler, err := metrics.New(ctx, metricsCfg)
+	if err != nil {
+		log.Fatalf("Error setting up metrics: %v", err)
+	}
+
 	httpCfg.mux.Handle("/metrics", metricsHandler)
-	httpCfg.mux.Handle("/healthz", health.New(ctx, mr))
+	hc := health.New(ctx, mr)
+	for _, check := range checks {
+		hc.AddCheck(check)
+	}
+	httpCfg.mux.Handle("/healthz", hc)
+	httpCfg.mux.Handle("/status", restrictToHTTPIface(st))
+
+	if *fwTraceGroup > 0xffff {
+		log.Fatalf("Bad \"-fw_debug.nflog_group\" %d: groups are 16 bits", *fwTraceGroup)
+	}
+	dbg := fwdebug.New(metricsCfg.Rules)
+	dbg.MaxDuration = *fwTraceMax
+	dbg.Group = uint16(*fwTraceGroup)
+	if dbg.MaxDuration != 0 {
+		if *httpIface == "" {
+			log.Warning("Firewall tracing is enabled without -http.iface; anyone who can reach -http.addr can trace")
+		}
+		dbg.Cleanup()
+	}
+	httpCfg.mux.Handle("/debug/fw/", restrictToHTTPIface(http.StripPrefix("/debug/fw", dbg)))
+}
+
+// Only serves requests that came in on -http.iface (or loopback, which the
+// firewall always lets through) so the status page stays as private as the
+// port's firewall rule intends, even if that rule is bypassed.
+func restrictToHTTPIface(h http.Handler) http.Handler {
+	if *httpIface == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, _ := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr)
+		if addr == nil || !(addr.IP.IsLoopback() || ifaceHasIP(*httpIface, addr.IP)) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func ifaceHasIP(name string, ip net.IP) bool {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		log.V(2).Infof("Could not get -http.iface %q: %v", name, err)
+		return false
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		log.V(2).Infof("Could not get addresses of -http.iface %q: %v", name, err)
+		return false
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func metricsLinks(cfg fw.Config) []metrics.Link {
+	var links []metrics.Link
+	for _, l := range fw.LANs(cfg) {
+		links = append(links, metrics.Link{Name: l.Name(), Role: "lan"})
+	}
+	if c, ok := cfg.(fw.ConfigNamedLANs); ok {
+		for _, lan := range c.NamedLANs() {
+			for _, l := range lan.Links {
+				links = append(links, metrics.Link{Name: l.Link.Name(), Role: "lan"})
+			}
+		}
+	}
+	links = append(links, metrics.Link{Name: cfg.Uplink().Name(), Role: "uplink"})
+	seen := make(map[string]bool)
+	for _, r := range cfg.FlatNetworks() {
+		if name := r.Link.Name(); !seen[name] {
+			seen[name] = true
+			links = append(links, metrics.Link{Name: name, Role: "flat"})
+		}
+	}
+	return links
+}
+
+func wireGuardLinks(cfg fw.Config) []string {
+	if i, ok := cfg.(fwutil.ConfigUplinkWireGuard); ok && i.UplinkWireGuard() != nil {
+		return []string{cfg.Uplink().Name()}
+	}
+	return nil
 }
 
 func httpServeContext(ctx context.Context, cfg httpConfig) error {
@@ -333,22 +671,55 @@ func httpServeContext(ctx context.Context, cfg httpConfig) error {
 
 var errSubprocessExited = fmt.Errorf("subprocess exited")
 
-func runSubprocess(ctx context.Context, args []string) error {
-	if len(args) > 0 {
-		log.Infof("running %q", strings.Join(args, " "))
-		cmd := exec.Command(args[0], args[1:]...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Start(); err != nil {
-			return fmt.Errorf("error starting subprocess: %v", err)
-		}
-		if err := util.ReapChildren(cmd.Process); err != nil {
-			return fmt.Errorf("error waiting for subprocess: %v", err)
-		}
-		return errSubprocessExited
-	} else {
+// Returns nil if there is no subprocess to run.
+func newSupervisor(role string, args []string, st *status.Status) *supervisor.Supervisor {
+	if len(args) == 0 {
+		return nil
+	}
+	policy, err := supervisor.ParsePolicy(*cmdRestart)
+	if err != nil {
+		log.Fatalf("Bad -c.restart: %v", err)
+	}
+	return &supervisor.Supervisor{
+		Args:       args,
+		Policy:     policy,
+		MaxRetries: *cmdMaxRestarts,
+		Backoff:    *cmdBackoff,
+		MaxBackoff: *cmdMaxBackoff,
+		OnStart: func(args []string, pid int) {
+			st.ObserveSubprocessStart(role, args, pid)
+		},
+		OnExit: func(err error) {
+			st.ObserveSubprocessExit(role, err)
+		},
+	}
+}
+
+func runSubprocess(ctx context.Context, sup *supervisor.Supervisor) error {
+	if sup == nil {
 		log.Info("sleeping forever")
 		<-ctx.Done()
 		return nil
 	}
+	if err := sup.Run(ctx); err != nil {
+		return err
+	}
+	return errSubprocessExited
+}
+
+// Returns the status to exit with after running returned err. A subprocess
+// that exits for good with a nonzero code has its code propagated.
+func exitStatus(err error) int {
+	var exitErr *supervisor.ExitError
+	switch {
+	case err == nil, err == errSubprocessExited, err == http.ErrServerClosed,
+		err == context.Canceled:
+		return 0
+	case errors.As(err, &exitErr):
+		log.Errorf("Exiting: %v", err)
+		return exitErr.Code
+	default:
+		log.Fatal(err)
+		return 1
+	}
 }
@@ -0,0 +1,98 @@
+package ctsync
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"go.jonnrb.io/egress/log"
+)
+
+const (
+	// Followers hang up if they haven't heard from the leader in this long.
+	readTimeout = 3 * heartbeatInterval
+
+	minRedialBackoff = 100 * time.Millisecond
+	maxRedialBackoff = 5 * time.Second
+)
+
+// Receives conntrack state from a leader and injects it locally. Runs on
+// followers.
+type Client struct {
+	Conntrack Conntrack
+
+	// The address of the leader's Server.
+	Addr string
+
+	// The shared key to authenticate with.
+	Key []byte
+}
+
+// Replicates the leader's conntrack table until ctx is canceled, reconnecting
+// as necessary.
+func (c *Client) Run(ctx context.Context) error {
+	backoff := minRedialBackoff
+	for {
+		err := c.runOnce(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Warningf("ctsync: lost connection to leader at %q: %v", c.Addr, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > maxRedialBackoff {
+			backoff = maxRedialBackoff
+		}
+	}
+}
+
+func (c *Client) runOnce(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.Addr)
+	if err != nil {
+		return fmt.Errorf("ctsync: could not dial leader: %w", err)
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	sess, err := clientHandshake(conn, c.Key)
+	if err != nil {
+		return err
+	}
+	log.V(2).Infof("ctsync: connected to leader at %q", c.Addr)
+
+	for {
+		conn.SetDeadline(time.Now().Add(readTimeout))
+		e, err := sess.read()
+		if err != nil {
+			return err
+		}
+		c.apply(e)
+	}
+}
+
+func (c *Client) apply(e Event) {
+	var err error
+	switch e.Type {
+	case EventNew, EventUpdate:
+		err = c.Conntrack.Put(e.Flow)
+	case EventDestroy:
+		err = c.Conntrack.Delete(e.Flow)
+	case eventHeartbeat:
+	default:
+		log.Warningf("ctsync: ignoring unknown event type %v", e.Type)
+	}
+	if err != nil {
+		// Failing to sync a single flow isn't worth tearing down the stream.
+		log.V(3).Infof("ctsync: could not apply %v event for %v: %v", e.Type, e.Flow, err)
+	}
+}
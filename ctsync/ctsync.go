@@ -0,0 +1,84 @@
+// Synchronizes connection tracking state from an HA leader to its followers so
+// established (and especially NATed) flows survive a failover.
+package ctsync // import "go.jonnrb.io/egress/ctsync"
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// The parts of the kernel's connection tracking table needed to replicate it
+// elsewhere.
+type Conntrack interface {
+	// Returns all flows currently in the table.
+	Dump() ([]Flow, error)
+
+	// Sends events to c until ctx is canceled or an error occurs.
+	Listen(ctx context.Context, c chan<- Event) error
+
+	// Creates f or updates it if an equivalent flow already exists.
+	Put(f Flow) error
+
+	// Removes f if it exists.
+	Delete(f Flow) error
+}
+
+type EventType int
+
+const (
+	EventNew EventType = iota + 1
+	EventUpdate
+	EventDestroy
+
+	// Sent periodically by the leader so followers can detect a dead stream.
+	eventHeartbeat
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventNew:
+		return "new"
+	case EventUpdate:
+		return "update"
+	case EventDestroy:
+		return "destroy"
+	case eventHeartbeat:
+		return "heartbeat"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+type Event struct {
+	Type EventType `json:"type"`
+	Flow Flow      `json:"flow"`
+}
+
+// A single conntrack entry. Only attributes that can be set when creating an
+// entry are included.
+type Flow struct {
+	Proto uint8 `json:"proto"`
+	Orig  Tuple `json:"orig"`
+	Reply Tuple `json:"reply"`
+
+	Status  uint32 `json:"status"`
+	Timeout uint32 `json:"timeout"`
+	Mark    uint32 `json:"mark"`
+	Zone    uint16 `json:"zone"`
+
+	// The TCP conntrack state, if Proto is TCP.
+	TCPState uint8 `json:"tcpState,omitempty"`
+}
+
+type Tuple struct {
+	Src     net.IP `json:"src"`
+	Dst     net.IP `json:"dst"`
+	SrcPort uint16 `json:"srcPort"`
+	DstPort uint16 `json:"dstPort"`
+}
+
+func (f Flow) String() string {
+	return fmt.Sprintf("%d %s:%d->%s:%d",
+		f.Proto, f.Orig.Src, f.Orig.SrcPort, f.Orig.Dst, f.Orig.DstPort)
+}
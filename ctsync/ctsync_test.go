@@ -0,0 +1,188 @@
+package ctsync
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// An in-memory Conntrack keyed on the original tuple.
+type fakeConntrack struct {
+	mu     sync.Mutex
+	flows  map[string]Flow
+	events chan Event
+}
+
+func newFakeConntrack(flows ...Flow) *fakeConntrack {
+	ct := &fakeConntrack{
+		flows:  make(map[string]Flow),
+		events: make(chan Event, 16),
+	}
+	for _, f := range flows {
+		ct.flows[f.String()] = f
+	}
+	return ct
+}
+
+func (ct *fakeConntrack) Dump() ([]Flow, error) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	var fs []Flow
+	for _, f := range ct.flows {
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+func (ct *fakeConntrack) Listen(ctx context.Context, c chan<- Event) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e := <-ct.events:
+			c <- e
+		}
+	}
+}
+
+func (ct *fakeConntrack) Put(f Flow) error {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	ct.flows[f.String()] = f
+	return nil
+}
+
+func (ct *fakeConntrack) Delete(f Flow) error {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	delete(ct.flows, f.String())
+	return nil
+}
+
+func (ct *fakeConntrack) has(f Flow) bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	_, ok := ct.flows[f.String()]
+	return ok
+}
+
+func (ct *fakeConntrack) len() int {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	return len(ct.flows)
+}
+
+func testFlow(srcPort uint16) Flow {
+	return Flow{
+		Proto:   6,
+		Orig:    Tuple{net.IPv4(10, 0, 0, 2), net.IPv4(1, 1, 1, 1), srcPort, 443},
+		Reply:   Tuple{net.IPv4(1, 1, 1, 1), net.IPv4(203, 0, 113, 5), 443, srcPort},
+		Timeout: 120,
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func startServer(t *testing.T, ctx context.Context, ct Conntrack, key string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Conntrack: ct, Key: []byte(key)}
+	go s.Serve(ctx, l)
+	return l.Addr().String()
+}
+
+func TestLoopback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leader := newFakeConntrack(testFlow(1000), testFlow(1001))
+	follower := newFakeConntrack()
+
+	addr := startServer(t, ctx, leader, "secret")
+	c := &Client{Conntrack: follower, Addr: addr, Key: []byte("secret")}
+	go c.Run(ctx)
+
+	waitFor(t, "initial dump", func() bool {
+		return follower.has(testFlow(1000)) && follower.has(testFlow(1001))
+	})
+
+	leader.events <- Event{Type: EventNew, Flow: testFlow(1002)}
+	waitFor(t, "new flow", func() bool { return follower.has(testFlow(1002)) })
+
+	leader.events <- Event{Type: EventDestroy, Flow: testFlow(1000)}
+	waitFor(t, "destroyed flow", func() bool { return !follower.has(testFlow(1000)) })
+
+	if n := follower.len(); n != 2 {
+		t.Errorf("expected follower to have 2 flows; got %d", n)
+	}
+}
+
+func TestLoopback_wrongKey(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leader := newFakeConntrack(testFlow(1000))
+	follower := newFakeConntrack()
+
+	addr := startServer(t, ctx, leader, "secret")
+	c := &Client{Conntrack: follower, Addr: addr, Key: []byte("not the secret")}
+
+	err := c.runOnce(ctx)
+
+	if err == nil {
+		t.Error("expected handshake with wrong key to fail")
+	}
+	if n := follower.len(); n != 0 {
+		t.Errorf("expected follower to have 0 flows; got %d", n)
+	}
+}
+
+func TestSession_rejectsTampering(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	ec := make(chan error, 1)
+	go func() {
+		s, err := serverHandshake(a, []byte("k"))
+		if err != nil {
+			ec <- err
+			return
+		}
+		s.seq++ // Desynchronize so the MAC won't verify.
+		if err := s.write(Event{Type: EventNew, Flow: testFlow(1)}); err != nil {
+			ec <- err
+			return
+		}
+		ec <- s.flush()
+	}()
+
+	s, err := clientHandshake(b, []byte("k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.read(); err != errBadMAC {
+		t.Errorf("expected err == errBadMAC; got err == %v", err)
+	}
+	if err := <-ec; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,60 @@
+package ctsync
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.jonnrb.io/egress/log"
+)
+
+// An ha.Member that serves conntrack state while leading and replicates it
+// while following. Sync is best-effort: failures are logged but never cause a
+// step-down.
+type Member struct {
+	Conntrack Conntrack
+
+	// The address the leader listens on.
+	ListenAddr string
+
+	// The key shared by all members.
+	Key []byte
+
+	// Finds the address of the leader's Server given the leader's identity as
+	// reported by the ha.Coordinator.
+	ResolveLeader func(ctx context.Context, leader string) (addr string, err error)
+}
+
+func (m *Member) String() string {
+	return fmt.Sprintf("conntrack sync (served on %s while leading)", m.ListenAddr)
+}
+
+func (m *Member) Lead(ctx context.Context, _ func(time.Duration) error) error {
+	s := &Server{
+		Conntrack: m.Conntrack,
+		Addr:      m.ListenAddr,
+		Key:       m.Key,
+	}
+	if err := s.Run(ctx); err != nil && ctx.Err() == nil {
+		log.Errorf("ctsync: not serving conntrack state: %v", err)
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (m *Member) Follow(ctx context.Context, leader string) error {
+	addr, err := m.ResolveLeader(ctx, leader)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.With(log.Leader(leader)).Errorf("ctsync: could not find leader: %v", err)
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	c := &Client{
+		Conntrack: m.Conntrack,
+		Addr:      addr,
+		Key:       m.Key,
+	}
+	return c.Run(ctx)
+}
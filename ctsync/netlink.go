@@ -0,0 +1,165 @@
+package ctsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/ti-mo/netfilter"
+	"golang.org/x/sys/unix"
+)
+
+// Only these status bits can be set on a new entry; the rest are managed by
+// the kernel.
+const settableStatus = conntrack.StatusSeenReply |
+	conntrack.StatusAssured |
+	conntrack.StatusNATMask
+
+// Conntrack backed by the kernel's conntrack table in the current network
+// namespace.
+type Netlink struct {
+	c *conntrack.Conn
+}
+
+func NewNetlink() (*Netlink, error) {
+	c, err := conntrack.Dial(nil)
+	if err != nil {
+		return nil, fmt.Errorf("ctsync: could not dial conntrack netlink: %w", err)
+	}
+	return &Netlink{c}, nil
+}
+
+func (n *Netlink) Dump() ([]Flow, error) {
+	fs, err := n.c.Dump(nil)
+	if err != nil {
+		return nil, fmt.Errorf("ctsync: could not dump conntrack table: %w", err)
+	}
+	var out []Flow
+	for _, f := range fs {
+		out = append(out, fromNetlinkFlow(f))
+	}
+	return out, nil
+}
+
+func (n *Netlink) Listen(ctx context.Context, c chan<- Event) error {
+	// Event subscriptions get their own socket so they can't interleave with
+	// replies to queries on n.c.
+	lc, err := conntrack.Dial(nil)
+	if err != nil {
+		return fmt.Errorf("ctsync: could not dial conntrack netlink: %w", err)
+	}
+	defer lc.Close()
+
+	ec := make(chan conntrack.Event, cap(c))
+	errc, err := lc.Listen(ec, 1, netfilter.GroupsCT)
+	if err != nil {
+		return fmt.Errorf("ctsync: could not listen for conntrack events: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errc:
+			return fmt.Errorf("ctsync: error receiving conntrack events: %w", err)
+		case e := <-ec:
+			if e.Flow == nil {
+				continue
+			}
+			var t EventType
+			switch e.Type {
+			case conntrack.EventNew:
+				t = EventNew
+			case conntrack.EventUpdate:
+				t = EventUpdate
+			case conntrack.EventDestroy:
+				t = EventDestroy
+			default:
+				continue
+			}
+			select {
+			case c <- Event{Type: t, Flow: fromNetlinkFlow(*e.Flow)}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func (n *Netlink) Put(f Flow) error {
+	nf := toNetlinkFlow(f)
+	err := n.c.Create(nf)
+	if errors.Is(err, unix.EEXIST) {
+		err = n.c.Update(nf)
+	}
+	return err
+}
+
+func (n *Netlink) Delete(f Flow) error {
+	err := n.c.Delete(toNetlinkFlow(f))
+	if errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	return err
+}
+
+func (n *Netlink) Close() error {
+	return n.c.Close()
+}
+
+func fromNetlinkFlow(nf conntrack.Flow) Flow {
+	f := Flow{
+		Proto:   nf.TupleOrig.Proto.Protocol,
+		Orig:    fromNetlinkTuple(nf.TupleOrig),
+		Reply:   fromNetlinkTuple(nf.TupleReply),
+		Status:  uint32(nf.Status.Value),
+		Timeout: nf.Timeout,
+		Mark:    nf.Mark,
+		Zone:    nf.Zone,
+	}
+	if nf.ProtoInfo.TCP != nil {
+		f.TCPState = nf.ProtoInfo.TCP.State
+	}
+	return f
+}
+
+func fromNetlinkTuple(t conntrack.Tuple) Tuple {
+	return Tuple{
+		Src:     net.IP(t.IP.SourceAddress.AsSlice()),
+		Dst:     net.IP(t.IP.DestinationAddress.AsSlice()),
+		SrcPort: t.Proto.SourcePort,
+		DstPort: t.Proto.DestinationPort,
+	}
+}
+
+func toNetlinkFlow(f Flow) conntrack.Flow {
+	var nf conntrack.Flow
+	nf.TupleOrig = toNetlinkTuple(f.Proto, f.Orig)
+	nf.TupleReply = toNetlinkTuple(f.Proto, f.Reply)
+	nf.Status.Value = conntrack.StatusFlag(f.Status) & settableStatus
+	nf.Timeout = f.Timeout
+	nf.Mark = f.Mark
+	nf.Zone = f.Zone
+	if f.Proto == unix.IPPROTO_TCP && f.TCPState != 0 {
+		nf.ProtoInfo.TCP = &conntrack.ProtoInfoTCP{State: f.TCPState}
+	}
+	return nf
+}
+
+func toNetlinkTuple(proto uint8, t Tuple) conntrack.Tuple {
+	var nt conntrack.Tuple
+	nt.IP.SourceAddress = toNetipAddr(t.Src)
+	nt.IP.DestinationAddress = toNetipAddr(t.Dst)
+	nt.Proto.Protocol = proto
+	nt.Proto.SourcePort = t.SrcPort
+	nt.Proto.DestinationPort = t.DstPort
+	return nt
+}
+
+func toNetipAddr(ip net.IP) netip.Addr {
+	a, _ := netip.AddrFromSlice(ip)
+	return a.Unmap()
+}
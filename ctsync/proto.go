@@ -0,0 +1,181 @@
+package ctsync
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// The wire protocol is a mutual challenge-response handshake proving both ends
+// know the shared key, followed by a stream of length-prefixed JSON events
+// from the leader, each authenticated with an HMAC keyed on the session. The
+// stream is not encrypted; conntrack entries aren't secret, but injecting
+// forged ones would be bad.
+
+const (
+	nonceSize    = 32
+	macSize      = sha256.Size
+	maxFrameSize = 1 << 16
+)
+
+var errBadMAC = errors.New("ctsync: bad message authentication code")
+
+type session struct {
+	rw  *bufio.ReadWriter
+	key []byte
+	seq uint64
+}
+
+func mac(key []byte, parts ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+func newNonce() ([]byte, error) {
+	n := make([]byte, nonceSize)
+	if _, err := rand.Read(n); err != nil {
+		return nil, fmt.Errorf("ctsync: could not generate nonce: %w", err)
+	}
+	return n, nil
+}
+
+// Runs the leader side of the handshake on c.
+func serverHandshake(c net.Conn, key []byte) (*session, error) {
+	rw := bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c))
+
+	sn, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rw.Write(sn); err != nil {
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		return nil, err
+	}
+
+	resp := make([]byte, nonceSize+macSize)
+	if _, err := io.ReadFull(rw, resp); err != nil {
+		return nil, err
+	}
+	cn, cm := resp[:nonceSize], resp[nonceSize:]
+	if !hmac.Equal(cm, mac(key, []byte("client"), sn, cn)) {
+		return nil, fmt.Errorf("ctsync: client failed authentication")
+	}
+
+	if _, err := rw.Write(mac(key, []byte("server"), sn, cn)); err != nil {
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		return nil, err
+	}
+
+	return &session{rw: rw, key: mac(key, []byte("session"), sn, cn)}, nil
+}
+
+// Runs the follower side of the handshake on c.
+func clientHandshake(c net.Conn, key []byte) (*session, error) {
+	rw := bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c))
+
+	sn := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rw, sn); err != nil {
+		return nil, err
+	}
+	cn, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rw.Write(cn); err != nil {
+		return nil, err
+	}
+	if _, err := rw.Write(mac(key, []byte("client"), sn, cn)); err != nil {
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		return nil, err
+	}
+
+	sm := make([]byte, macSize)
+	if _, err := io.ReadFull(rw, sm); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(sm, mac(key, []byte("server"), sn, cn)) {
+		return nil, fmt.Errorf("ctsync: server failed authentication")
+	}
+
+	return &session{rw: rw, key: mac(key, []byte("session"), sn, cn)}, nil
+}
+
+func (s *session) seqBytes() []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], s.seq)
+	return b[:]
+}
+
+// Writes e to the stream. The caller should flush.
+func (s *session) write(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("ctsync: could not marshal event: %w", err)
+	}
+	if len(b) > maxFrameSize {
+		return fmt.Errorf("ctsync: event too large (%d bytes)", len(b))
+	}
+
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(b)))
+	if _, err := s.rw.Write(l[:]); err != nil {
+		return err
+	}
+	if _, err := s.rw.Write(b); err != nil {
+		return err
+	}
+	if _, err := s.rw.Write(mac(s.key, s.seqBytes(), b)); err != nil {
+		return err
+	}
+	s.seq++
+	return nil
+}
+
+func (s *session) flush() error {
+	return s.rw.Flush()
+}
+
+// Reads the next event from the stream.
+func (s *session) read() (e Event, err error) {
+	var l [4]byte
+	if _, err = io.ReadFull(s.rw, l[:]); err != nil {
+		return
+	}
+	n := binary.BigEndian.Uint32(l[:])
+	if n > maxFrameSize {
+		err = fmt.Errorf("ctsync: frame too large (%d bytes)", n)
+		return
+	}
+
+	b := make([]byte, int(n)+macSize)
+	if _, err = io.ReadFull(s.rw, b); err != nil {
+		return
+	}
+	b, m := b[:n], b[n:]
+	if !hmac.Equal(m, mac(s.key, s.seqBytes(), b)) {
+		err = errBadMAC
+		return
+	}
+	s.seq++
+
+	if err = json.Unmarshal(b, &e); err != nil {
+		err = fmt.Errorf("ctsync: could not unmarshal event: %w", err)
+	}
+	return
+}
@@ -0,0 +1,221 @@
+package ctsync
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.jonnrb.io/egress/log"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	heartbeatInterval = 5 * time.Second
+	handshakeTimeout  = 10 * time.Second
+
+	// If a follower falls this far behind, it is disconnected and expected to
+	// reconnect and resync from a fresh dump.
+	subscriberBacklog = 4096
+)
+
+// Streams conntrack state to followers. Runs on the leader.
+type Server struct {
+	Conntrack Conntrack
+
+	// The address to listen on (e.g. ":7946").
+	Addr string
+
+	// The shared key followers must authenticate with.
+	Key []byte
+
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// Listens on s.Addr and serves followers until ctx is canceled.
+func (s *Server) Run(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("ctsync: could not listen on %q: %w", s.Addr, err)
+	}
+	return s.Serve(ctx, l)
+}
+
+// Serves followers on l until ctx is canceled. l is closed on return.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	eg, ctx := errgroup.WithContext(ctx)
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	eg.Go(func() error {
+		return s.broadcast(ctx)
+	})
+
+	eg.Go(func() error {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return fmt.Errorf("ctsync: error accepting follower: %w", err)
+			}
+			go s.serveConn(ctx, c)
+		}
+	})
+
+	return eg.Wait()
+}
+
+// Fans events from the kernel out to all connected followers.
+func (s *Server) broadcast(ctx context.Context) error {
+	ec := make(chan Event, subscriberBacklog)
+	eg, ctx := errgroup.WithContext(ctx)
+
+	eg.Go(func() error {
+		err := s.Conntrack.Listen(ctx, ec)
+		if err != nil && ctx.Err() == nil {
+			return fmt.Errorf("ctsync: error listening for conntrack events: %w", err)
+		}
+		return ctx.Err()
+	})
+
+	eg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case e := <-ec:
+				s.publish(e)
+			}
+		}
+	})
+
+	return eg.Wait()
+}
+
+func (s *Server) publish(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.subs {
+		select {
+		case c <- e:
+		default:
+			// Too far behind. Closing the channel makes serveConn hang up.
+			delete(s.subs, c)
+			close(c)
+		}
+	}
+}
+
+func (s *Server) subscribe() chan Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.subs == nil {
+		s.subs = make(map[chan Event]struct{})
+	}
+	c := make(chan Event, subscriberBacklog)
+	s.subs[c] = struct{}{}
+	return c
+}
+
+func (s *Server) unsubscribe(c chan Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.subs[c]; ok {
+		delete(s.subs, c)
+		close(c)
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, c net.Conn) {
+	defer c.Close()
+	go func() {
+		<-ctx.Done()
+		c.Close()
+	}()
+
+	c.SetDeadline(time.Now().Add(handshakeTimeout))
+	sess, err := serverHandshake(c, s.Key)
+	if err != nil {
+		log.Warningf("ctsync: handshake with %v failed: %v", c.RemoteAddr(), err)
+		return
+	}
+	c.SetDeadline(time.Time{})
+	log.V(2).Infof("ctsync: follower %v connected", c.RemoteAddr())
+
+	// Subscribe before dumping so no events are missed. Events that race the
+	// dump are replayed, which is harmless since Put() is idempotent.
+	sub := s.subscribe()
+	defer s.unsubscribe(sub)
+
+	if err := s.sendDump(sess); err != nil {
+		log.Warningf("ctsync: error sending table to %v: %v", c.RemoteAddr(), err)
+		return
+	}
+
+	t := time.NewTicker(heartbeatInterval)
+	defer t.Stop()
+	for {
+		var e Event
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			e = Event{Type: eventHeartbeat}
+		case ev, ok := <-sub:
+			if !ok {
+				log.Warningf("ctsync: follower %v fell behind; disconnecting", c.RemoteAddr())
+				return
+			}
+			e = ev
+		}
+		if err := s.send(sess, e, sub); err != nil {
+			log.V(2).Infof("ctsync: follower %v disconnected: %v", c.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+func (s *Server) sendDump(sess *session) error {
+	flows, err := s.Conntrack.Dump()
+	if err != nil {
+		return fmt.Errorf("ctsync: could not dump conntrack table: %w", err)
+	}
+	for _, f := range flows {
+		if err := sess.write(Event{Type: EventNew, Flow: f}); err != nil {
+			return err
+		}
+	}
+	return sess.flush()
+}
+
+// Writes e and anything else already queued on sub before flushing.
+func (s *Server) send(sess *session, e Event, sub <-chan Event) error {
+	if err := sess.write(e); err != nil {
+		return err
+	}
+	for {
+		select {
+		case e, ok := <-sub:
+			if !ok {
+				return fmt.Errorf("ctsync: fell behind")
+			}
+			if err := sess.write(e); err != nil {
+				return err
+			}
+		default:
+			return sess.flush()
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Package explain describes what a router would do without doing it.
+package explain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/fw/rules"
+	"go.jonnrb.io/egress/ha"
+	"go.jonnrb.io/egress/vaddr"
+)
+
+type Plan struct {
+	Firewall FirewallPlan `json:"firewall"`
+
+	// The iptables rules in the order they're applied.
+	Rules []string `json:"rules"`
+
+	// Started in order (and stopped in reverse) when becoming the leader or,
+	// without HA, on startup.
+	Wrappers []Step `json:"wrappers"`
+
+	// Run once the Wrappers are up.
+	Actives []Step `json:"actives"`
+
+	HA HAPlan `json:"ha"`
+}
+
+type FirewallPlan struct {
+	LANs   []string `json:"lans"`
+	Uplink string   `json:"uplink"`
+}
+
+type Step struct {
+	Kind        string `json:"kind"`
+	Description string `json:"description"`
+
+	// Whether some of the work is done ahead of time while following.
+	Staged bool `json:"staged,omitempty"`
+}
+
+type HAPlan struct {
+	Enabled     bool   `json:"enabled"`
+	Coordinator string `json:"coordinator,omitempty"`
+
+	// What besides the virtual addresses leads and follows.
+	Members []string `json:"members,omitempty"`
+
+	// The commands run in each role (or, for "always", regardless of role).
+	Leader   []string `json:"leader,omitempty"`
+	Follower []string `json:"follower,omitempty"`
+	Always   []string `json:"always,omitempty"`
+}
+
+// Describes the router for cfg that applies rs and brings up va. hac is nil
+// if HA isn't configured.
+func New(cfg fw.Config, rs rules.RuleSet, va vaddr.Suite, hac ha.Coordinator) Plan {
+	p := Plan{
+		Rules:    []string{},
+		Wrappers: []Step{},
+		Actives:  []Step{},
+	}
+	for _, l := range fw.LANs(cfg) {
+		p.Firewall.LANs = append(p.Firewall.LANs, l.Name())
+	}
+	if c, ok := cfg.(fw.ConfigNamedLANs); ok {
+		for _, lan := range c.NamedLANs() {
+			for _, l := range lan.Links {
+				p.Firewall.LANs = append(p.Firewall.LANs, fmt.Sprintf("%s (%s)", l.Link.Name(), lan.Name))
+			}
+		}
+	}
+	p.Firewall.Uplink = cfg.Uplink().Name()
+
+	for _, r := range rs {
+		p.Rules = append(p.Rules, string(r))
+	}
+	for _, w := range va.Wrappers {
+		p.Wrappers = append(p.Wrappers, step(w))
+	}
+	for _, a := range va.Actives {
+		p.Actives = append(p.Actives, step(a))
+	}
+	if hac != nil {
+		p.HA.Enabled = true
+		p.HA.Coordinator = describe(hac)
+	}
+	return p
+}
+
+func step(v interface{}) Step {
+	_, staged := v.(vaddr.Stager)
+	return Step{
+		Kind:        kind(v),
+		Description: describe(v),
+		Staged:      staged,
+	}
+}
+
+func kind(v interface{}) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", v), "*")
+}
+
+func describe(v interface{}) string {
+	if s, ok := v.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return kind(v)
+}
+
+// Adds m to the members taking part in HA.
+func (p *Plan) AddMember(m ha.Member) {
+	p.HA.Members = append(p.HA.Members, describe(m))
+}
+
+func (p Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+func (p Plan) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "LANs: %s\n", strings.Join(p.Firewall.LANs, ", "))
+	fmt.Fprintf(&b, "Uplink: %s\n", p.Firewall.Uplink)
+
+	fmt.Fprintf(&b, "\nFirewall rules (%d, in order):\n", len(p.Rules))
+	for _, r := range p.Rules {
+		fmt.Fprintf(&b, "  %s\n", r)
+	}
+
+	fmt.Fprintf(&b, "\nVirtual addresses (started in order):\n")
+	writeSteps(&b, p.Wrappers)
+	fmt.Fprintf(&b, "\nActive once up:\n")
+	writeSteps(&b, p.Actives)
+
+	fmt.Fprintf(&b, "\nHA:\n")
+	if !p.HA.Enabled {
+		fmt.Fprintf(&b, "  disabled; virtual addresses are brought up on startup\n")
+	} else {
+		fmt.Fprintf(&b, "  coordinator: %s\n", p.HA.Coordinator)
+		fmt.Fprintf(&b, "  as leader: brings up the virtual addresses and runs the actives\n")
+		fmt.Fprintf(&b, "  as follower: stages the steps marked [staged]\n")
+		for _, m := range p.HA.Members {
+			fmt.Fprintf(&b, "  also leads and follows: %s\n", m)
+		}
+	}
+	writeCommand(&b, "leader", p.HA.Leader)
+	writeCommand(&b, "follower", p.HA.Follower)
+	writeCommand(&b, "always", p.HA.Always)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeSteps(b *strings.Builder, steps []Step) {
+	if len(steps) == 0 {
+		fmt.Fprintf(b, "  (none)\n")
+	}
+	for _, s := range steps {
+		staged := ""
+		if s.Staged {
+			staged = " [staged]"
+		}
+		fmt.Fprintf(b, "  %s: %s%s\n", s.Kind, s.Description, staged)
+	}
+}
+
+func writeCommand(b *strings.Builder, role string, args []string) {
+	if len(args) != 0 {
+		fmt.Fprintf(b, "  %s command: %q\n", role, args)
+	}
+}
@@ -0,0 +1,97 @@
+package explain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/fw/rules"
+	"go.jonnrb.io/egress/ha"
+	"go.jonnrb.io/egress/vaddr"
+	"go.jonnrb.io/egress/vaddr/dhcp"
+	"go.jonnrb.io/egress/vaddr/vaddrutil"
+)
+
+type testConfig struct{}
+
+func (testConfig) LAN() fw.Link                   { return fw.LinkString("eth0") }
+func (testConfig) Uplink() fw.Link                { return fw.LinkString("eth1") }
+func (testConfig) FlatNetworks() []fw.StaticRoute { return nil }
+func (testConfig) ExtraRules() rules.RuleSet      { return nil }
+
+type testCoordinator struct{}
+
+func (testCoordinator) Run(context.Context, ha.Member) error { return nil }
+func (testCoordinator) String() string                       { return "test lease" }
+
+func testPlan() Plan {
+	a, _ := fw.ParseAddr("10.0.0.1/24")
+	va := vaddr.Suite{
+		Wrappers: []vaddr.Wrapper{
+			&vaddrutil.Up{Link: fw.LinkString("eth0")},
+			&vaddrutil.IP{Link: fw.LinkString("eth0"), Addr: a},
+		},
+		Actives: []vaddr.Active{&dhcp.VAddr{Link: fw.LinkString("eth1")}},
+	}
+	p := New(testConfig{}, rules.RuleSet{"-F", "-X"}, va, testCoordinator{})
+	p.HA.Leader = []string{"dnsmasq", "-k"}
+	return p
+}
+
+func TestNew(t *testing.T) {
+	p := testPlan()
+	want := Plan{
+		Firewall: FirewallPlan{LANs: []string{"eth0"}, Uplink: "eth1"},
+		Rules:    []string{"-F", "-X"},
+		Wrappers: []Step{
+			{Kind: "vaddrutil.Up", Description: "eth0 up", Staged: true},
+			{Kind: "vaddrutil.IP", Description: "10.0.0.1/24 on eth0", Staged: true},
+		},
+		Actives: []Step{
+			{Kind: "dhcp.VAddr", Description: "DHCP on eth1 ()", Staged: true},
+		},
+		HA: HAPlan{
+			Enabled:     true,
+			Coordinator: "test lease",
+			Leader:      []string{"dnsmasq", "-k"},
+		},
+	}
+	if diff := cmp.Diff(want, p); diff != "" {
+		t.Errorf("unexpected plan; diff: %v", diff)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := testPlan().WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+	var got Plan
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", b.String(), err)
+	}
+	if diff := cmp.Diff(testPlan(), got); diff != "" {
+		t.Errorf("plan didn't round trip; diff: %v", diff)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	var b bytes.Buffer
+	if err := testPlan().WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"Firewall rules (2, in order):\n  -F\n  -X\n",
+		"  vaddrutil.IP: 10.0.0.1/24 on eth0 [staged]\n",
+		"  coordinator: test lease\n",
+		`  leader command: ["dnsmasq" "-k"]`,
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected output to contain %q; got:\n%s", s, b.String())
+		}
+	}
+}
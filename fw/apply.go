@@ -6,23 +6,43 @@ import (
 
 // Creates a RuleSet from cfg and applies it.
 func Apply(cfg Config) error {
-	return ApplyRules(
-		rules.NewBuilder().
-			Apply(rules.BaseRules).
-			Apply(addFlatNetworkForwarding(cfg)).
-			Add(50, []rules.Rule{
-				Forward(cfg.LAN(), cfg.Uplink()),
-				Masquerade(cfg.Uplink()),
-			}).
-			Add(60, cfg.ExtraRules()).
-			Build())
+	return ApplyRules(Render(cfg))
+}
+
+// Creates the RuleSet Apply() would apply for cfg without applying it. Each of
+// extra (e.g. a compiled policy) is applied to the builder last.
+func Render(cfg Config, extra ...func(rules.RuleSetBuilder)) rules.RuleSet {
+	b := rules.NewBuilder().
+		Apply(rules.BaseRules).
+		Apply(addFlatNetworkForwarding(cfg)).
+		Apply(addUplinkForwarding(cfg)).
+		Apply(addNamedLANForwarding(cfg)).
+		Add(60, cfg.ExtraRules())
+	for _, e := range extra {
+		b.Apply(e)
+	}
+	return b.Build()
 }
 
 func addFlatNetworkForwarding(cfg Config) func(rb rules.RuleSetBuilder) {
 	var rs rules.RuleSet
-	for _, s := range cfg.FlatNetworks() {
-		rs = append(rs, ForwardToSubnet(cfg.LAN(), s.Link, s.Subnet))
+	for _, lan := range LANs(cfg) {
+		for _, s := range cfg.FlatNetworks() {
+			rs = append(rs, ForwardToSubnet(lan, s.Link, s.Subnet))
+		}
+	}
+
+	return func(rb rules.RuleSetBuilder) {
+		rb.Add(50, rs)
+	}
+}
+
+func addUplinkForwarding(cfg Config) func(rb rules.RuleSetBuilder) {
+	var rs rules.RuleSet
+	for _, lan := range LANs(cfg) {
+		rs = append(rs, Forward(lan, cfg.Uplink()))
 	}
+	rs = append(rs, Masquerade(cfg.Uplink()))
 
 	return func(rb rules.RuleSetBuilder) {
 		rb.Add(50, rs)
@@ -0,0 +1,58 @@
+package fw
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.jonnrb.io/egress/fw/rules"
+)
+
+type testConfig struct{}
+
+func (testConfig) LAN() Link    { return LinkString("eth0") }
+func (testConfig) Uplink() Link { return LinkString("eth1") }
+func (testConfig) FlatNetworks() []StaticRoute {
+	a, _ := ParseAddr("10.1.0.0/16")
+	return []StaticRoute{{Link: LinkString("eth2"), Subnet: a}}
+}
+func (testConfig) ExtraRules() rules.RuleSet { return nil }
+
+type testConfigExtraLANs struct{ testConfig }
+
+func (testConfigExtraLANs) ExtraLANs() []ExtraLAN {
+	return []ExtraLAN{{Link: LinkString("eth3")}}
+}
+
+func TestRender_extraLANs(t *testing.T) {
+	rs := Render(testConfigExtraLANs{})
+
+	var got []rules.Rule
+	for _, r := range rs {
+		switch r {
+		case "-t filter -A fw-interfaces -j ACCEPT -d 10.1.0.0/16 -i eth0 -o eth2",
+			"-t filter -A fw-interfaces -j ACCEPT -d 10.1.0.0/16 -i eth3 -o eth2",
+			"-t filter -A fw-interfaces -j ACCEPT -i eth0 -o eth1",
+			"-t filter -A fw-interfaces -j ACCEPT -i eth3 -o eth1":
+			got = append(got, r)
+		}
+	}
+	want := []rules.Rule{
+		"-t filter -A fw-interfaces -j ACCEPT -d 10.1.0.0/16 -i eth0 -o eth2",
+		"-t filter -A fw-interfaces -j ACCEPT -d 10.1.0.0/16 -i eth3 -o eth2",
+		"-t filter -A fw-interfaces -j ACCEPT -i eth0 -o eth1",
+		"-t filter -A fw-interfaces -j ACCEPT -i eth3 -o eth1",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected forwarding rules; diff: %v", diff)
+	}
+}
+
+func TestLANs(t *testing.T) {
+	if got := LANs(testConfig{}); len(got) != 1 || got[0].Name() != "eth0" {
+		t.Errorf("expected just eth0; got %v", got)
+	}
+	got := LANs(testConfigExtraLANs{})
+	if len(got) != 2 || got[0].Name() != "eth0" || got[1].Name() != "eth3" {
+		t.Errorf("expected eth0 and eth3; got %v", got)
+	}
+}
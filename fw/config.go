@@ -23,6 +23,85 @@ type Config interface {
 	ExtraRules() rules.RuleSet
 }
 
+// Implemented by a Config with local clients on more than one link.
+type ConfigExtraLANs interface {
+	// Links besides LAN() with local clients. They are forwarded the same as
+	// LAN().
+	ExtraLANs() []ExtraLAN
+}
+
+// A link with local clients and the address they use as their gateway on it.
+type ExtraLAN struct {
+	Link Link
+	Addr Addr
+}
+
+// Returns LAN() followed by the links of any ExtraLANs().
+func LANs(cfg Config) []Link {
+	links := []Link{cfg.LAN()}
+	if c, ok := cfg.(ConfigExtraLANs); ok {
+		for _, l := range c.ExtraLANs() {
+			links = append(links, l.Link)
+		}
+	}
+	return links
+}
+
+// Implemented by a Config with LANs besides LAN() (e.g. for guests or IoT
+// devices) kept apart from each other by a policy.
+type ConfigNamedLANs interface {
+	// The name of LAN() (and its ExtraLANs()) in LANPolicy().
+	LANName() string
+
+	// LANs besides LAN(). Unlike ExtraLANs(), they aren't forwarded to
+	// FlatNetworks().
+	NamedLANs() []NamedLAN
+
+	// What may be forwarded between LANs. A pair of LANs missing from the
+	// policy is denied, except for replies to connections allowed the other
+	// way.
+	LANPolicy() []LANPolicy
+}
+
+type NamedLAN struct {
+	Name string
+
+	// The links with clients on the LAN and the address they use as their
+	// gateway on each.
+	Links []ExtraLAN
+
+	// Where the LAN's clients are forwarded. If nil, Uplink() is used.
+	Uplink Link
+}
+
+// What may be forwarded from one LAN to another.
+type LANPolicy struct {
+	From, To string
+	Action   LANAction
+}
+
+type LANAction string
+
+const (
+	// All traffic.
+	LANAllow LANAction = "allow"
+
+	// No traffic, including replies to connections allowed the other way.
+	LANDeny LANAction = "deny"
+
+	// Only replies to connections allowed the other way.
+	LANEstablished LANAction = "established"
+)
+
+func (a LANAction) Valid() bool {
+	switch a {
+	case LANAllow, LANDeny, LANEstablished:
+		return true
+	default:
+		return false
+	}
+}
+
 // Union of a subnet specified in CIDR and the Link it can be reached on.
 type StaticRoute struct {
 	Link   Link
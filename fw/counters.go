@@ -0,0 +1,150 @@
+package fw
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/shlex"
+	"go.jonnrb.io/egress/fw/rules"
+	"go.jonnrb.io/egress/util"
+)
+
+// An iptables chain.
+type Chain struct {
+	Table string
+	Name  string
+}
+
+// Returns the chains that rs creates or adds rules to.
+func ManagedChains(rs rules.RuleSet) []Chain {
+	seen := make(map[Chain]bool)
+	var chains []Chain
+	for _, r := range rs {
+		args, err := shlex.Split(string(r))
+		if err != nil {
+			continue
+		}
+		c := Chain{Table: "filter"}
+		for i := 0; i < len(args)-1; i++ {
+			switch args[i] {
+			case "-t":
+				c.Table = args[i+1]
+			case "-A", "-I", "-N":
+				c.Name = args[i+1]
+			}
+		}
+		if c.Name != "" && !seen[c] {
+			seen[c] = true
+			chains = append(chains, c)
+		}
+	}
+	return chains
+}
+
+// The packet and byte counters of an iptables rule.
+type RuleCounter struct {
+	Chain Chain
+	// The rule as printed by iptables without the chain or counters.
+	Rule    string
+	Packets uint64
+	Bytes   uint64
+}
+
+// Reads the counters of the rules in chains. Identical rules in the same chain
+// are reported as one.
+func ReadRuleCounters(chains []Chain) ([]RuleCounter, error) {
+	byTable := make(map[string][]string)
+	for _, c := range chains {
+		byTable[c.Table] = append(byTable[c.Table], c.Name)
+	}
+	var tables []string
+	for t := range byTable {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+
+	var counters []RuleCounter
+	for _, t := range tables {
+		out, err := util.Output(exec.Command(*iptablesBin, "-t", t, "-S", "-v"))
+		if err != nil {
+			return nil, fmt.Errorf("fw: could not list rules in table %q: %w", t, err)
+		}
+		cs, err := parseRuleCounters(t, string(out))
+		if err != nil {
+			return nil, err
+		}
+		want := make(map[string]bool)
+		for _, name := range byTable[t] {
+			want[name] = true
+		}
+		for _, c := range cs {
+			if want[c.Chain.Name] {
+				counters = append(counters, c)
+			}
+		}
+	}
+	return counters, nil
+}
+
+// Parses the output of `iptables -S -v` for table.
+func parseRuleCounters(table, out string) ([]RuleCounter, error) {
+	var counters []RuleCounter
+	index := make(map[RuleCounter]int)
+	for _, line := range strings.Split(out, "\n") {
+		args := strings.Fields(line)
+		if len(args) < 2 || args[0] != "-A" {
+			continue
+		}
+
+		c := RuleCounter{Chain: Chain{Table: table, Name: args[1]}}
+		var spec []string
+		for i := 2; i < len(args); i++ {
+			if args[i] != "-c" {
+				spec = append(spec, args[i])
+				continue
+			}
+			if i+2 >= len(args) {
+				return nil, fmt.Errorf("fw: truncated counters in rule %q", line)
+			}
+			var err error
+			c.Packets, err = strconv.ParseUint(args[i+1], 10, 64)
+			if err == nil {
+				c.Bytes, err = strconv.ParseUint(args[i+2], 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("fw: bad counters in rule %q: %w", line, err)
+			}
+			i += 2
+		}
+		c.Rule = strings.Join(spec, " ")
+
+		key := RuleCounter{Chain: c.Chain, Rule: c.Rule}
+		if i, ok := index[key]; ok {
+			counters[i].Packets += c.Packets
+			counters[i].Bytes += c.Bytes
+			continue
+		}
+		index[key] = len(counters)
+		counters = append(counters, c)
+	}
+	return counters, nil
+}
+
+// Lists the rules in c as printed by `iptables -S` (e.g. "-A FORWARD -j
+// ACCEPT"), preceded by the chain's policy or creation.
+func ListRules(c Chain) ([]string, error) {
+	out, err := util.Output(exec.Command(*iptablesBin, "-t", c.Table, "-S", c.Name))
+	if err != nil {
+		return nil, fmt.Errorf("fw: could not list rules in chain %q: %w", c.Name, err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
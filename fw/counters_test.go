@@ -0,0 +1,61 @@
+package fw
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.jonnrb.io/egress/fw/rules"
+)
+
+func TestManagedChains(t *testing.T) {
+	rs := rules.RuleSet{
+		"-F",
+		"-t filter -N in-tcp",
+		"-t filter -A INPUT -j in-tcp -p tcp",
+		"-I in-tcp -j ACCEPT -p tcp --dport 22",
+		"-t nat -A POSTROUTING -j MASQUERADE -o eth1",
+	}
+
+	got := ManagedChains(rs)
+
+	want := []Chain{
+		{"filter", "in-tcp"},
+		{"filter", "INPUT"},
+		{"nat", "POSTROUTING"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected chains; diff: %v", diff)
+	}
+}
+
+func TestParseRuleCounters(t *testing.T) {
+	out := `-P INPUT DROP -c 10 600
+-N in-tcp
+-A INPUT -m conntrack --ctstate RELATED,ESTABLISHED -c 42 4200 -j ACCEPT
+-A in-tcp -p tcp -m tcp --dport 22 -c 1 60 -j ACCEPT
+-A in-tcp -p tcp -m tcp --dport 22 -c 2 120 -j ACCEPT
+`
+
+	got, err := parseRuleCounters("filter", out)
+
+	if err != nil {
+		t.Fatalf("expected err == nil; got: %v", err)
+	}
+	want := []RuleCounter{
+		{
+			Chain:   Chain{"filter", "INPUT"},
+			Rule:    "-m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT",
+			Packets: 42,
+			Bytes:   4200,
+		},
+		{
+			Chain:   Chain{"filter", "in-tcp"},
+			Rule:    "-p tcp -m tcp --dport 22 -j ACCEPT",
+			Packets: 3,
+			Bytes:   180,
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected counters; diff: %v", diff)
+	}
+}
@@ -0,0 +1,20 @@
+package fwutil
+
+import (
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/ha"
+)
+
+type ConfigConntrackSync interface {
+	// If non-nil, an ha.Member that synchronizes connection tracking state
+	// from the leader to followers.
+	ConntrackSync() ha.Member
+}
+
+func GetConntrackSync(c fw.Config) ha.Member {
+	i, ok := c.(ConfigConntrackSync)
+	if !ok {
+		return nil
+	}
+	return i.ConntrackSync()
+}
@@ -1,6 +1,7 @@
 package fwutil
 
 import (
+	"crypto/sha256"
 	"net"
 
 	"go.jonnrb.io/egress/fw"
@@ -25,9 +26,24 @@ func MakeVAddrLAN(c fw.Config) vaddr.Suite {
 	w = append(w, contributeLANVirtualMAC(c)...)
 	w = append(w, contributeLANIP(c)...)
 	w = append(w, contributeLANGratuitousARP(c)...)
+	w = append(w, contributeExtraLANs(c)...)
+	w = append(w, contributeNamedLANs(c)...)
 	return vaddr.Suite{Wrappers: w}
 }
 
+// Every link with LAN clients: fw.LANs() and the links of named LANs.
+func ClientLinks(c fw.Config) []fw.Link {
+	links := fw.LANs(c)
+	if i, ok := c.(fw.ConfigNamedLANs); ok {
+		for _, lan := range i.NamedLANs() {
+			for _, l := range lan.Links {
+				links = append(links, l.Link)
+			}
+		}
+	}
+	return links
+}
+
 func contributeLANUp(c fw.Config) []vaddr.Wrapper {
 	return []vaddr.Wrapper{&vaddrutil.Up{Link: c.LAN()}}
 }
@@ -91,3 +107,77 @@ func contributeLANGratuitousARP(c fw.Config) (w []vaddr.Wrapper) {
 		})
 	return
 }
+
+func contributeExtraLANs(c fw.Config) (w []vaddr.Wrapper) {
+	i, ok := c.(fw.ConfigExtraLANs)
+	if !ok {
+		return
+	}
+	return extraLANWrappers(lanHWAddr(c), i.ExtraLANs())
+}
+
+func contributeNamedLANs(c fw.Config) (w []vaddr.Wrapper) {
+	i, ok := c.(fw.ConfigNamedLANs)
+	if !ok {
+		return
+	}
+	for _, lan := range i.NamedLANs() {
+		w = append(w, extraLANWrappers(lanHWAddr(c), lan.Links)...)
+	}
+	return
+}
+
+func lanHWAddr(c fw.Config) net.HardwareAddr {
+	i, ok := c.(ConfigLANHWAddr)
+	if !ok {
+		return nil
+	}
+	return i.LANHWAddr()
+}
+
+// Brings up lans like the primary LAN. If the LAN has a virtual MAC, each link
+// gets one derived from it (see linkHWAddr()) so clients follow failovers.
+func extraLANWrappers(hwAddr net.HardwareAddr, lans []fw.ExtraLAN) (w []vaddr.Wrapper) {
+	for _, l := range lans {
+		w = append(w, &vaddrutil.Up{Link: l.Link})
+		a := linkHWAddr(hwAddr, l.Link)
+		if a != nil {
+			w = append(w,
+				&vaddrutil.VirtualMAC{
+					Link: l.Link,
+					Addr: a,
+				})
+		}
+		if l.Addr.IP == nil {
+			continue
+		}
+		w = append(w,
+			&vaddrutil.IP{
+				Link: l.Link,
+				Addr: l.Addr,
+			})
+		if a != nil {
+			w = append(w,
+				&vaddrutil.GratuitousARP{
+					IP:     l.Addr.IP,
+					HWAddr: a,
+					Link:   l.Link,
+				})
+		}
+	}
+	return
+}
+
+// Derives the virtual MAC of link from the LAN's. Links may share a segment, so
+// each gets its own, and it is the same on every replica since it only depends
+// on the LAN's MAC and the link's name.
+func linkHWAddr(lanHWAddr net.HardwareAddr, link fw.Link) net.HardwareAddr {
+	if lanHWAddr == nil {
+		return nil
+	}
+	h := sha256.Sum256(append(append([]byte(nil), lanHWAddr...), link.Name()...))
+	a := net.HardwareAddr(h[:6])
+	// Unicast and locally administered.
+	a[0] = a[0]&^0x01 | 0x02
+	return a
+}
@@ -0,0 +1,88 @@
+package fwutil
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/fw/rules"
+	"go.jonnrb.io/egress/vaddr/vaddrutil"
+)
+
+type testLANConfig struct{}
+
+func (testLANConfig) LAN() fw.Link                   { return fw.LinkString("eth0") }
+func (testLANConfig) Uplink() fw.Link                { return fw.LinkString("eth1") }
+func (testLANConfig) FlatNetworks() []fw.StaticRoute { return nil }
+func (testLANConfig) ExtraRules() rules.RuleSet      { return nil }
+func (testLANConfig) LANHWAddr() net.HardwareAddr {
+	return net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+}
+func (testLANConfig) LANAddr() (fw.Addr, bool) {
+	a, _ := fw.ParseAddr("10.0.0.1/24")
+	return a, true
+}
+func (testLANConfig) ExtraLANs() []fw.ExtraLAN {
+	a, _ := fw.ParseAddr("10.0.0.1/24")
+	return []fw.ExtraLAN{{Link: fw.LinkString("net2"), Addr: a}}
+}
+func (testLANConfig) LANName() string { return "home" }
+func (testLANConfig) NamedLANs() []fw.NamedLAN {
+	a, _ := fw.ParseAddr("10.1.0.1/24")
+	return []fw.NamedLAN{{
+		Name:   "guest",
+		Links:  []fw.ExtraLAN{{Link: fw.LinkString("net3"), Addr: a}},
+		Uplink: fw.LinkString("wg0"),
+	}}
+}
+func (testLANConfig) LANPolicy() []fw.LANPolicy { return nil }
+
+// Clients on every LAN link must follow the virtual MAC across failovers.
+func TestMakeVAddrLAN_virtualMACs(t *testing.T) {
+	macs := make(map[string]net.HardwareAddr)
+	arps := make(map[string]net.HardwareAddr)
+	for _, w := range MakeVAddrLAN(testLANConfig{}).Wrappers {
+		switch w := w.(type) {
+		case *vaddrutil.VirtualMAC:
+			macs[w.Link.Name()] = w.Addr
+		case *vaddrutil.GratuitousARP:
+			arps[w.Link.Name()] = w.HWAddr
+		}
+	}
+
+	for _, link := range []string{"eth0", "net2", "net3"} {
+		if macs[link] == nil {
+			t.Errorf("expected a virtual MAC on %s", link)
+		}
+		if !bytes.Equal(arps[link], macs[link]) {
+			t.Errorf("expected gratuitous ARPs on %s from %v; got %v", link, macs[link], arps[link])
+		}
+	}
+	if bytes.Equal(macs["net2"], macs["eth0"]) || bytes.Equal(macs["net2"], macs["net3"]) {
+		t.Errorf("expected each link to get its own MAC; got %v", macs)
+	}
+	if a := macs["net2"]; a[0]&0x03 != 0x02 {
+		t.Errorf("expected a unicast, locally administered MAC; got %v", a)
+	}
+	if a := linkHWAddr(testLANConfig{}.LANHWAddr(), fw.LinkString("net2")); !bytes.Equal(a, macs["net2"]) {
+		t.Errorf("expected the same MAC on every replica; got %v and %v", a, macs["net2"])
+	}
+}
+
+func TestClientLinksAndUplinks(t *testing.T) {
+	names := func(links []fw.Link) (s []string) {
+		for _, l := range links {
+			s = append(s, l.Name())
+		}
+		return
+	}
+
+	if got, want := names(ClientLinks(testLANConfig{})), []string{"eth0", "net2", "net3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected client links %v; got %v", want, got)
+	}
+	if got, want := names(Uplinks(testLANConfig{})), []string{"eth1", "wg0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected uplinks %v; got %v", want, got)
+	}
+}
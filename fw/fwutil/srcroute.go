@@ -0,0 +1,63 @@
+package fwutil
+
+import (
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/vaddr"
+	"go.jonnrb.io/egress/vaddr/srcroute"
+)
+
+// Implemented by configs that let LAN clients choose an uplink other than
+// Uplink(). The chains from srcroute.ChainRules() should be included in the
+// config's ExtraRules().
+type ConfigUplinkSelection interface {
+	// The uplinks clients can choose from.
+	SelectableUplinks() []srcroute.Uplink
+
+	// Where clients' choices come from. If nil, uplink selection is disabled.
+	UplinkSelectionSource() srcroute.Source
+}
+
+func contributeUplinkSelection(c fw.Config) (w []vaddr.Wrapper, a []vaddr.Active) {
+	i, ok := c.(ConfigUplinkSelection)
+	if !ok {
+		return
+	}
+	src := i.UplinkSelectionSource()
+	links := namedLANUplinks(c, i.SelectableUplinks())
+	if src == nil && len(links) == 0 {
+		return
+	}
+	r := &srcroute.Router{
+		LAN:     c.LAN(),
+		Uplinks: i.SelectableUplinks(),
+		Links:   links,
+	}
+	w = append(w, r)
+	if src != nil {
+		a = append(a, &srcroute.Selector{Router: r, Source: src})
+	}
+	return
+}
+
+// Maps the links of named LANs to the selectable uplinks they're forwarded to.
+func namedLANUplinks(c fw.Config, uplinks []srcroute.Uplink) map[string]string {
+	i, ok := c.(fw.ConfigNamedLANs)
+	if !ok {
+		return nil
+	}
+	m := make(map[string]string)
+	for _, lan := range i.NamedLANs() {
+		if lan.Uplink == nil {
+			continue
+		}
+		for _, u := range uplinks {
+			if u.Link.Name() != lan.Uplink.Name() {
+				continue
+			}
+			for _, l := range lan.Links {
+				m[l.Link.Name()] = u.Name
+			}
+		}
+	}
+	return m
+}
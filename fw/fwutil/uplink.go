@@ -39,18 +39,66 @@ type ConfigUplinkLeaseStore interface {
 	UplinkLeaseStore() dhcp.LeaseStore
 }
 
+// Allows specifying how the client identifies itself when DHCP is used.
+type ConfigUplinkDHCPOptions interface {
+	UplinkDHCPOptions() dhcp.ClientOptions
+}
+
+// Allows releasing the uplink's lease when DHCP is used and the router stops.
+type ConfigUplinkDHCPRelease interface {
+	UplinkDHCPReleaseOnStop() bool
+}
+
+// Allows following the lease on the uplink when DHCP is used.
+type ConfigUplinkLeaseObserver interface {
+	// Called whenever a lease is bound or extended on the uplink. This can be
+	// used to e.g. point a LAN DNS resolver at the uplink's DNS servers.
+	UplinkLeaseBound(l dhcp.Lease)
+}
+
 func MakeVAddrUplink(c fw.Config) vaddr.Suite {
 	var w []vaddr.Wrapper
 	var a []vaddr.Active
 	w = append(w, contributeUplinkUp(c)...)
 	w = append(w, contributeUplinkVirtualMAC(c)...)
+	wg, wgActives := contributeUplinkWireGuard(c)
+	w = append(w, wg...)
 	w = append(w, contributeUplinkIP(c)...)
 	w = append(w, contributeUplinkGW(c)...)
+	w = append(w, contributeUplinkWireGuardRoutes(c)...)
 	w = append(w, contributeUplinkGratuitousARP(c)...)
+	sel, selActives := contributeUplinkSelection(c)
+	w = append(w, sel...)
 	a = append(a, contributeUplinkDHCP(c)...)
+	a = append(a, wgActives...)
+	a = append(a, selActives...)
 	return vaddr.Suite{Wrappers: w, Actives: a}
 }
 
+// Every link LAN clients may be forwarded out of: Uplink(), the uplinks of
+// named LANs and the selectable uplinks.
+func Uplinks(c fw.Config) []fw.Link {
+	links := []fw.Link{c.Uplink()}
+	seen := map[string]bool{c.Uplink().Name(): true}
+	add := func(l fw.Link) {
+		if l != nil && !seen[l.Name()] {
+			seen[l.Name()] = true
+			links = append(links, l)
+		}
+	}
+	if i, ok := c.(fw.ConfigNamedLANs); ok {
+		for _, lan := range i.NamedLANs() {
+			add(lan.Uplink)
+		}
+	}
+	if i, ok := c.(ConfigUplinkSelection); ok {
+		for _, u := range i.SelectableUplinks() {
+			add(u.Link)
+		}
+	}
+	return links
+}
+
 func contributeUplinkUp(c fw.Config) []vaddr.Wrapper {
 	return []vaddr.Wrapper{&vaddrutil.Up{Link: c.Uplink()}}
 }
@@ -154,11 +202,44 @@ func contributeUplinkDHCP(c fw.Config) (a []vaddr.Active) {
 	if j, ok := c.(ConfigUplinkLeaseStore); ok {
 		ls = j.UplinkLeaseStore()
 	}
+	var opts dhcp.ClientOptions
+	if j, ok := c.(ConfigUplinkDHCPOptions); ok {
+		opts = j.UplinkDHCPOptions()
+	}
+	var release bool
+	if j, ok := c.(ConfigUplinkDHCPRelease); ok {
+		release = j.UplinkDHCPReleaseOnStop()
+	}
+	var onBound func(dhcp.Lease)
+	if j, ok := c.(ConfigUplinkLeaseObserver); ok {
+		onBound = j.UplinkLeaseBound
+	}
 	a = append(a,
 		&dhcp.VAddr{
-			HWAddr:     hwAddr,
-			Link:       c.Uplink(),
-			LeaseStore: ls,
+			HWAddr:        hwAddr,
+			Link:          c.Uplink(),
+			LeaseStore:    ls,
+			ReleaseOnStop: release,
+			ClientOptions: opts,
+			OnBound:       onBound,
 		})
 	return
 }
+
+// Calls f with each lease bound by the DHCP actives in s (as made by
+// MakeVAddrUplink), in addition to any ConfigUplinkLeaseObserver.
+func ObserveLeases(s vaddr.Suite, f func(link string, l dhcp.Lease)) {
+	for _, a := range s.Actives {
+		d, ok := a.(*dhcp.VAddr)
+		if !ok {
+			continue
+		}
+		prev, link := d.OnBound, d.Link.Name()
+		d.OnBound = func(l dhcp.Lease) {
+			if prev != nil {
+				prev(l)
+			}
+			f(link, l)
+		}
+	}
+}
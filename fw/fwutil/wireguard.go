@@ -0,0 +1,60 @@
+package fwutil
+
+import (
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/vaddr"
+	"go.jonnrb.io/egress/vaddr/wireguard"
+)
+
+// Implemented by configs whose uplink is a WireGuard device that egress should
+// configure. The device's address is specified by ConfigUplinkAddr.
+type ConfigUplinkWireGuard interface {
+	// If non-nil, the configuration to apply to the uplink.
+	UplinkWireGuard() *wireguard.Config
+}
+
+func getUplinkWireGuard(c fw.Config) *wireguard.Config {
+	i, ok := c.(ConfigUplinkWireGuard)
+	if !ok {
+		return nil
+	}
+	return i.UplinkWireGuard()
+}
+
+func contributeUplinkWireGuard(c fw.Config) (w []vaddr.Wrapper, a []vaddr.Active) {
+	cfg := getUplinkWireGuard(c)
+	if cfg == nil {
+		return
+	}
+	d := &wireguard.Device{
+		Link:   c.Uplink(),
+		Config: *cfg,
+	}
+	w = append(w, d)
+	if len(cfg.Candidates) > 1 {
+		a = append(a,
+			&wireguard.Failover{
+				Device:          d,
+				MaxHandshakeAge: cfg.FailoverAfter,
+			})
+	}
+	return
+}
+
+func contributeUplinkWireGuardRoutes(c fw.Config) (w []vaddr.Wrapper) {
+	cfg := getUplinkWireGuard(c)
+	if cfg == nil {
+		return
+	}
+	return wireguard.Routes(c.Uplink(), *cfg)
+}
+
+// Returns the WireGuard devices in s (as made by MakeVAddrUplink).
+func WireGuardDevices(s vaddr.Suite) (ds []*wireguard.Device) {
+	for _, w := range s.Wrappers {
+		if d, ok := w.(*wireguard.Device); ok {
+			ds = append(ds, d)
+		}
+	}
+	return
+}
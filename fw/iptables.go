@@ -1,22 +1,35 @@
 package fw
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os/exec"
+	"strings"
 
 	"github.com/google/shlex"
 	"go.jonnrb.io/egress/fw/rules"
 	"go.jonnrb.io/egress/log"
+	"go.jonnrb.io/egress/tracing"
+	"go.jonnrb.io/egress/util"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-var iptablesBin = flag.String("iptables.bin", "/sbin/iptables", "Path to iptables binary")
+var (
+	iptablesBin        = flag.String("iptables.bin", "/sbin/iptables", "Path to iptables binary")
+	iptablesRestoreBin = flag.String("iptables.restore_bin", "/sbin/iptables-restore", "Path to iptables-restore binary")
+)
 
 // Applies a set of iptables rules in order.
-func ApplyRules(iptablesRules rules.RuleSet) error {
+func ApplyRules(iptablesRules rules.RuleSet) (err error) {
+	_, span := tracing.Start(context.Background(), "fw.ApplyRules",
+		attribute.Int("rules", len(iptablesRules)))
+	defer func() { tracing.End(span, err) }()
+
 	for _, r := range iptablesRules {
-		log.V(3).Infof("Applying rule %q", r)
+		log.With(log.Rule(string(r))).V(3).Info("Applying rule")
 		if err := runIptables(r); err != nil {
-			return err
+			return fmt.Errorf("fw: could not apply rule %q: %w", r, err)
 		}
 	}
 	return nil
@@ -27,5 +40,33 @@ func runIptables(rule rules.Rule) error {
 	if err != nil {
 		return err
 	}
-	return exec.Command(*iptablesBin, args...).Run()
+	return util.Run(exec.Command(*iptablesBin, args...))
+}
+
+// Replaces the rules in c with specs (each what follows "-A <chain>") in one
+// iptables-restore transaction, so packets never see c partially filled. c
+// must be a user-defined chain; it is created if missing.
+func ReplaceChain(c Chain, specs []string) (err error) {
+	_, span := tracing.Start(context.Background(), "fw.ReplaceChain",
+		attribute.String("chain", c.Name), attribute.Int("rules", len(specs)))
+	defer func() { tracing.End(span, err) }()
+
+	cmd := exec.Command(*iptablesRestoreBin, "--noflush")
+	cmd.Stdin = strings.NewReader(restoreInput(c, specs))
+	if out, err := util.CombinedOutput(cmd); err != nil {
+		return fmt.Errorf("fw: could not replace rules in chain %q: %w: %s", c.Name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Input for `iptables-restore --noflush` replacing the rules in c. Declaring a
+// user-defined chain flushes it even with --noflush.
+func restoreInput(c Chain, specs []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s\n:%s - [0:0]\n", c.Table, c.Name)
+	for _, s := range specs {
+		fmt.Fprintf(&b, "-A %s %s\n", c.Name, s)
+	}
+	b.WriteString("COMMIT\n")
+	return b.String()
 }
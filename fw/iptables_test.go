@@ -0,0 +1,19 @@
+package fw
+
+import "testing"
+
+func TestRestoreInput(t *testing.T) {
+	got := restoreInput(Chain{Table: "nat", Name: "masq-uplinks"}, []string{
+		"-j MASQUERADE -s 10.0.0.2/32 -o tun0",
+		`-m comment --comment "a b" -j RETURN`,
+	})
+	want := `*nat
+:masq-uplinks - [0:0]
+-A masq-uplinks -j MASQUERADE -s 10.0.0.2/32 -o tun0
+-A masq-uplinks -m comment --comment "a b" -j RETURN
+COMMIT
+`
+	if got != want {
+		t.Errorf("restoreInput() = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,108 @@
+package fw
+
+import (
+	"fmt"
+
+	"go.jonnrb.io/egress/fw/rules"
+)
+
+// Holds the inter-LAN policy. It is jumped to before established connections
+// are accepted so "deny" can drop replies too.
+const lanPolicyChain = "fw-lans"
+
+func addNamedLANForwarding(cfg Config) func(rb rules.RuleSetBuilder) {
+	c, ok := cfg.(ConfigNamedLANs)
+	if !ok || len(c.NamedLANs()) == 0 {
+		return func(rules.RuleSetBuilder) {}
+	}
+
+	var rs rules.RuleSet
+	masqueraded := map[string]bool{cfg.Uplink().Name(): true}
+	for _, lan := range c.NamedLANs() {
+		uplink := lan.Uplink
+		if uplink == nil {
+			uplink = cfg.Uplink()
+		}
+		for _, l := range lan.Links {
+			rs = append(rs, Forward(l.Link, uplink))
+		}
+		if !masqueraded[uplink.Name()] {
+			masqueraded[uplink.Name()] = true
+			rs = append(rs, Masquerade(uplink))
+		}
+	}
+
+	return func(rb rules.RuleSetBuilder) {
+		rb.Add(40, renderLANPolicy(cfg, c)).
+			Add(50, rs)
+	}
+}
+
+func renderLANPolicy(cfg Config, c ConfigNamedLANs) rules.RuleSet {
+	type lan struct {
+		name  string
+		links []Link
+	}
+	lans := []lan{{c.LANName(), LANs(cfg)}}
+	for _, l := range c.NamedLANs() {
+		var links []Link
+		for _, e := range l.Links {
+			links = append(links, e.Link)
+		}
+		lans = append(lans, lan{l.Name, links})
+	}
+
+	actions := make(map[[2]string]LANAction)
+	for _, p := range c.LANPolicy() {
+		actions[[2]string{p.From, p.To}] = p.Action
+	}
+	action := func(from, to string) LANAction {
+		if a, ok := actions[[2]string{from, to}]; ok {
+			return a
+		}
+		if actions[[2]string{to, from}] == LANAllow {
+			return LANEstablished
+		}
+		return LANDeny
+	}
+
+	rs := rules.RuleSet{
+		rules.Rule("-t filter -N " + lanPolicyChain),
+		rules.Rule("-t filter -I FORWARD -j " + lanPolicyChain),
+	}
+	for _, from := range lans {
+		for _, to := range lans {
+			if from.name == to.name {
+				continue
+			}
+			a := action(from.name, to.name)
+			for _, in := range from.links {
+				for _, out := range to.links {
+					rs = append(rs, lanPolicyRules(a, in, out)...)
+				}
+			}
+		}
+	}
+	return rs
+}
+
+func lanPolicyRules(a LANAction, in, out Link) []rules.Rule {
+	reject := rules.Rule(fmt.Sprintf(
+		"-t filter -A %s -j REJECT -i %v -o %v --reject-with icmp-host-unreach",
+		lanPolicyChain, in.Name(), out.Name()))
+	switch a {
+	case LANAllow:
+		return []rules.Rule{rules.Rule(fmt.Sprintf(
+			"-t filter -A %s -j ACCEPT -i %v -o %v",
+			lanPolicyChain, in.Name(), out.Name()))}
+	case LANEstablished:
+		return []rules.Rule{
+			rules.Rule(fmt.Sprintf(
+				"-t filter -A %s -j ACCEPT -i %v -o %v -m conntrack --ctstate ESTABLISHED,RELATED",
+				lanPolicyChain, in.Name(), out.Name())),
+			reject,
+		}
+	default:
+		return []rules.Rule{reject}
+	}
+}
@@ -0,0 +1,70 @@
+package fw
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.jonnrb.io/egress/fw/rules"
+)
+
+type testConfigNamedLANs struct{ testConfig }
+
+func (testConfigNamedLANs) LANName() string { return "trusted" }
+
+func (testConfigNamedLANs) NamedLANs() []NamedLAN {
+	return []NamedLAN{
+		{Name: "iot", Links: []ExtraLAN{{Link: LinkString("eth4")}}},
+		{Name: "guest", Links: []ExtraLAN{{Link: LinkString("eth5")}}, Uplink: LinkString("tun0")},
+	}
+}
+
+func (testConfigNamedLANs) LANPolicy() []LANPolicy {
+	return []LANPolicy{
+		{From: "trusted", To: "iot", Action: LANAllow},
+		{From: "guest", To: "iot", Action: LANDeny},
+	}
+}
+
+func TestRender_namedLANs(t *testing.T) {
+	rs := Render(testConfigNamedLANs{})
+
+	want := rules.RuleSet{
+		"-t filter -N fw-lans",
+		"-t filter -I FORWARD -j fw-lans",
+		"-t filter -A fw-lans -j ACCEPT -i eth0 -o eth4",
+		"-t filter -A fw-lans -j REJECT -i eth0 -o eth5 --reject-with icmp-host-unreach",
+		"-t filter -A fw-lans -j ACCEPT -i eth4 -o eth0 -m conntrack --ctstate ESTABLISHED,RELATED",
+		"-t filter -A fw-lans -j REJECT -i eth4 -o eth0 --reject-with icmp-host-unreach",
+		"-t filter -A fw-lans -j REJECT -i eth4 -o eth5 --reject-with icmp-host-unreach",
+		"-t filter -A fw-lans -j REJECT -i eth5 -o eth0 --reject-with icmp-host-unreach",
+		"-t filter -A fw-lans -j REJECT -i eth5 -o eth4 --reject-with icmp-host-unreach",
+		"-t filter -A fw-interfaces -j ACCEPT -i eth4 -o eth1",
+		"-t filter -A fw-interfaces -j ACCEPT -i eth5 -o tun0",
+		"-t nat -A POSTROUTING -j MASQUERADE -o tun0",
+	}
+	var got rules.RuleSet
+	for _, r := range rs {
+		for _, w := range want {
+			if r == w {
+				got = append(got, r)
+			}
+		}
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected LAN rules; diff: %v", diff)
+	}
+
+	for _, r := range rs {
+		if r == "-t filter -A fw-interfaces -j ACCEPT -d 10.1.0.0/16 -i eth4 -o eth2" {
+			t.Error("named LANs shouldn't be forwarded to flat networks")
+		}
+	}
+}
+
+func TestRender_noNamedLANs(t *testing.T) {
+	for _, r := range Render(testConfig{}) {
+		if r == "-t filter -N fw-lans" {
+			t.Error("expected no LAN policy chain without named LANs")
+		}
+	}
+}
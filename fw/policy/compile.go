@@ -0,0 +1,133 @@
+package policy
+
+import (
+	"fmt"
+
+	"go.jonnrb.io/egress/fw/rules"
+)
+
+// Chains holding the policy, jumped to for new connections.
+const (
+	inputChain   = "policy-in"
+	forwardChain = "policy-fwd"
+	outputChain  = "policy-out"
+)
+
+// Adds the policy's rules to b at Priority. Meant to be passed to
+// RuleSetBuilder.Apply().
+func (p *Policy) Apply(b rules.RuleSetBuilder) {
+	if p == nil || len(p.Rules) == 0 {
+		return
+	}
+	b.Add(Priority, p.compile())
+}
+
+// The rules Apply() adds.
+func (p *Policy) RuleSet() rules.RuleSet {
+	return rules.NewBuilder().Apply(p.Apply).Build()
+}
+
+func (p *Policy) compile() rules.RuleSet {
+	var rs rules.RuleSet
+	for _, c := range []struct{ chain, builtin string }{
+		{inputChain, "INPUT"},
+		{forwardChain, "FORWARD"},
+		{outputChain, "OUTPUT"},
+	} {
+		rs = append(rs,
+			rules.Rule("-t filter -N "+c.chain),
+			rules.Rule(fmt.Sprintf(
+				"-t filter -I %s -j %s -m conntrack --ctstate NEW", c.builtin, c.chain)))
+	}
+	for _, r := range p.Rules {
+		rs = append(rs, p.compileRule(r)...)
+	}
+	return rs
+}
+
+func (p *Policy) compileRule(r Rule) (rs rules.RuleSet) {
+	protos := r.Protos
+	if len(protos) == 0 {
+		protos = []Proto{{}}
+	}
+	for _, from := range r.From {
+		for _, src := range p.matchers(from, "-i", "-s") {
+			for _, to := range r.To {
+				for _, dst := range p.matchers(to, "-o", "-d") {
+					for _, proto := range protos {
+						rs = append(rs, r.rules(chain(from, to), src+dst+proto.match())...)
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+func chain(from, to string) string {
+	switch {
+	case from == ZoneRouter:
+		return outputChain
+	case to == ZoneRouter:
+		return inputChain
+	default:
+		return forwardChain
+	}
+}
+
+// The alternative ways of matching zone in a direction. Each starts with a
+// space unless it is empty.
+func (p *Policy) matchers(zone, linkFlag, netFlag string) []string {
+	z, ok := p.Zones[zone]
+	if !ok {
+		// ZoneRouter and ZoneAny are matched by chain alone.
+		return []string{""}
+	}
+	var m []string
+	for _, l := range z.Links {
+		m = append(m, fmt.Sprintf(" %s %s", linkFlag, l))
+	}
+	for _, n := range z.Nets {
+		m = append(m, fmt.Sprintf(" %s %s", netFlag, n))
+	}
+	return m
+}
+
+func (proto Proto) match() string {
+	if proto.Name == "" {
+		return ""
+	}
+	if proto.Ports == "" {
+		return " -p " + proto.Name
+	}
+	return fmt.Sprintf(" -p %s --dport %s", proto.Name, proto.Ports)
+}
+
+func (r Rule) rules(chain, match string) (rs rules.RuleSet) {
+	if r.Limit != "" {
+		match += " -m limit --limit " + r.Limit
+		if r.Burst != 0 {
+			match += fmt.Sprintf(" --limit-burst %d", r.Burst)
+		}
+	}
+	prefix := fmt.Sprintf("-t filter -A %s -j ", chain)
+	if r.Log {
+		logPrefix := r.LogPrefix
+		if logPrefix == "" {
+			logPrefix = fmt.Sprintf("policy:%d %s: ", r.Line, r.Action)
+		}
+		rs = append(rs, rules.Rule(fmt.Sprintf("%sLOG%s --log-prefix %q", prefix, match, logPrefix)))
+	}
+	return append(rs, rules.Rule(prefix+r.target()+match))
+}
+
+func (r Rule) target() string {
+	switch r.Action {
+	case Allow:
+		return "ACCEPT"
+	case Reject:
+		return "REJECT"
+	default:
+		return "DROP"
+	}
+}
@@ -0,0 +1,328 @@
+// Package policy compiles a small declarative firewall policy into rules.
+//
+// A policy is a list of statements, one per line. Blank lines and anything
+// after a "#" are ignored. Zones name the links and subnets traffic comes from
+// and goes to:
+//
+//	zone lan iface eth1
+//	zone guest iface eth3 net 192.168.50.0/24
+//
+// Rules allow, deny (drop) or reject new connections between zones. "router"
+// is this host and "any" is anywhere (but the router when used with "to"):
+//
+//	allow tcp/22 from lan to router
+//	allow udp/53 tcp/53 from lan,guest to router
+//	deny from guest to lan log "guest->lan: "
+//	allow tcp/443 tcp/8000-8080 from guest to any limit 10/second burst 20
+//
+// Protocols are "tcp", "udp" or "icmp", optionally with a port or port range
+// for tcp and udp. Without any, a rule matches all protocols. Rules with
+// limits only apply to connections within the rate; the rest fall through to
+// the following rules. Rules are evaluated in order and ahead of the rest of
+// the firewall, but established connections are never affected.
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Zones that always exist.
+	ZoneRouter = "router"
+	ZoneAny    = "any"
+
+	// The priority the policy's rules are added to a rules.RuleSetBuilder at.
+	// It is after fw.Render() adds a Config's ExtraRules() so the policy's
+	// chains come first.
+	Priority = 70
+)
+
+type Action string
+
+const (
+	Allow  Action = "allow"
+	Deny   Action = "deny"
+	Reject Action = "reject"
+)
+
+// A compiled policy. The zero value is an empty policy.
+type Policy struct {
+	Zones map[string]Zone
+	Rules []Rule
+}
+
+// The links and subnets traffic in a zone comes from or goes to.
+type Zone struct {
+	Links []string
+	Nets  []*net.IPNet
+}
+
+type Rule struct {
+	// The line the rule came from, for errors and logging.
+	Line int
+
+	Action Action
+	Protos []Proto
+	From   []string
+	To     []string
+
+	// An iptables limit (e.g. "10/second") and burst. Unlimited if empty.
+	Limit string
+	Burst int
+
+	// Whether to log matching connections and the prefix to log them with.
+	Log       bool
+	LogPrefix string
+}
+
+type Proto struct {
+	// "tcp", "udp" or "icmp".
+	Name string
+
+	// An iptables port or port range (e.g. "22" or "8000:8080"). All ports if
+	// empty.
+	Ports string
+}
+
+// Reads the policy at path.
+func ParseFile(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("policy: %w", err)
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+func Parse(r io.Reader) (*Policy, error) {
+	p := &Policy{Zones: make(map[string]Zone)}
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		words, err := split(s.Text())
+		if err != nil {
+			return nil, fmt.Errorf("policy: line %d: %w", line, err)
+		}
+		if len(words) == 0 {
+			continue
+		}
+		switch words[0] {
+		case "zone":
+			err = p.parseZone(words[1:])
+		case string(Allow), string(Deny), string(Reject):
+			err = p.parseRule(line, Action(words[0]), words[1:])
+		default:
+			err = fmt.Errorf("unknown statement %q", words[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("policy: line %d: %w", line, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("policy: %w", err)
+	}
+	return p, nil
+}
+
+// Splits a line into words, dropping comments and keeping quoted strings
+// together.
+func split(line string) ([]string, error) {
+	var (
+		words          []string
+		word           strings.Builder
+		inWord, quoted bool
+	)
+	for _, c := range line {
+		switch {
+		case quoted && c == '"':
+			quoted = false
+		case quoted:
+			word.WriteRune(c)
+		case c == '"':
+			quoted, inWord = true, true
+		case c == '#':
+			goto end
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+end:
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+var zoneName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
+
+func (p *Policy) parseZone(words []string) error {
+	if len(words) == 0 {
+		return fmt.Errorf("zone needs a name")
+	}
+	name := words[0]
+	if !zoneName.MatchString(name) || name == ZoneRouter || name == ZoneAny {
+		return fmt.Errorf("invalid zone name %q", name)
+	}
+	if _, ok := p.Zones[name]; ok {
+		return fmt.Errorf("duplicate zone %q", name)
+	}
+
+	var z Zone
+	words = words[1:]
+	for len(words) > 0 {
+		if len(words) < 2 {
+			return fmt.Errorf("%q needs a value", words[0])
+		}
+		switch words[0] {
+		case "iface":
+			z.Links = append(z.Links, words[1])
+		case "net":
+			_, n, err := net.ParseCIDR(words[1])
+			if err != nil {
+				return fmt.Errorf("invalid net %q: %w", words[1], err)
+			}
+			z.Nets = append(z.Nets, n)
+		default:
+			return fmt.Errorf("unknown zone member %q", words[0])
+		}
+		words = words[2:]
+	}
+	if len(z.Links) == 0 && len(z.Nets) == 0 {
+		return fmt.Errorf("zone %q needs an iface or net", name)
+	}
+	p.Zones[name] = z
+	return nil
+}
+
+func (p *Policy) parseRule(line int, a Action, words []string) error {
+	r := Rule{Line: line, Action: a}
+	for len(words) > 0 && words[0] != "from" {
+		proto, err := parseProto(words[0])
+		if err != nil {
+			return err
+		}
+		r.Protos = append(r.Protos, proto)
+		words = words[1:]
+	}
+
+	if len(words) < 4 || words[0] != "from" || words[2] != "to" {
+		return fmt.Errorf("%s needs \"from ZONES to ZONES\"", a)
+	}
+	var err error
+	if r.From, err = p.zones(words[1]); err != nil {
+		return err
+	}
+	if r.To, err = p.zones(words[3]); err != nil {
+		return err
+	}
+	if err := r.checkDirection(); err != nil {
+		return err
+	}
+
+	words = words[4:]
+	for len(words) > 0 {
+		switch words[0] {
+		case "limit":
+			if len(words) < 2 || !validLimit(words[1]) {
+				return fmt.Errorf("limit needs a rate like 10/second")
+			}
+			r.Limit = words[1]
+			words = words[2:]
+			if len(words) > 0 && words[0] == "burst" {
+				if len(words) < 2 {
+					return fmt.Errorf("burst needs a count")
+				}
+				if r.Burst, err = strconv.Atoi(words[1]); err != nil || r.Burst <= 0 {
+					return fmt.Errorf("invalid burst %q", words[1])
+				}
+				words = words[2:]
+			}
+		case "log":
+			r.Log = true
+			words = words[1:]
+			if len(words) > 0 && words[0] != "limit" {
+				r.LogPrefix = words[0]
+				words = words[1:]
+				if len(r.LogPrefix) > maxLogPrefix || strings.ContainsAny(r.LogPrefix, `"\`) {
+					return fmt.Errorf("log prefix %q must be at most %d characters without quotes or backslashes", r.LogPrefix, maxLogPrefix)
+				}
+			}
+		default:
+			return fmt.Errorf("unexpected %q", words[0])
+		}
+	}
+	p.Rules = append(p.Rules, r)
+	return nil
+}
+
+func parseProto(s string) (Proto, error) {
+	v := strings.SplitN(s, "/", 2)
+	proto := Proto{Name: v[0]}
+	switch proto.Name {
+	case "tcp", "udp":
+	case "icmp":
+		if len(v) == 2 {
+			return proto, fmt.Errorf("icmp doesn't have ports: %q", s)
+		}
+	default:
+		return proto, fmt.Errorf("unknown protocol %q", s)
+	}
+	if len(v) == 1 {
+		return proto, nil
+	}
+
+	ports := strings.SplitN(v[1], "-", 2)
+	for _, port := range ports {
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return proto, fmt.Errorf("invalid port in %q", s)
+		}
+	}
+	proto.Ports = strings.Join(ports, ":")
+	return proto, nil
+}
+
+func (p *Policy) zones(s string) ([]string, error) {
+	zones := strings.Split(s, ",")
+	for _, z := range zones {
+		if _, ok := p.Zones[z]; !ok && z != ZoneRouter && z != ZoneAny {
+			return nil, fmt.Errorf("unknown zone %q", z)
+		}
+	}
+	return zones, nil
+}
+
+func (r Rule) checkDirection() error {
+	for _, from := range r.From {
+		for _, to := range r.To {
+			if from == ZoneRouter && to == ZoneRouter {
+				return fmt.Errorf("can't go from %q to itself", ZoneRouter)
+			}
+		}
+	}
+	return nil
+}
+
+// The longest prefix iptables' LOG target takes.
+const maxLogPrefix = 29
+
+var limitRE = regexp.MustCompile(`^[0-9]+/(second|minute|hour|day)$`)
+
+func validLimit(s string) bool {
+	return limitRE.MatchString(s)
+}
@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.jonnrb.io/egress/fw/rules"
+)
+
+const testPolicy = `
+# Zones.
+zone lan iface eth1
+zone guest iface eth3 net 192.168.50.0/24
+
+allow tcp/22 from lan to router
+deny from guest to lan log
+allow tcp/8000-8080 udp/53 from guest to any limit 10/second burst 20
+reject from router to guest log "to guest: "
+`
+
+func TestParse(t *testing.T) {
+	p, err := Parse(strings.NewReader(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Zones) != 2 || len(p.Rules) != 4 {
+		t.Fatalf("expected 2 zones and 4 rules; got %+v", p)
+	}
+	r := p.Rules[2]
+	want := []Proto{{"tcp", "8000:8080"}, {"udp", "53"}}
+	if diff := cmp.Diff(want, r.Protos); diff != "" {
+		t.Errorf("unexpected protos; diff: %v", diff)
+	}
+	if r.Limit != "10/second" || r.Burst != 20 {
+		t.Errorf("expected limit 10/second burst 20; got %q %d", r.Limit, r.Burst)
+	}
+	if r := p.Rules[3]; !r.Log || r.LogPrefix != "to guest: " {
+		t.Errorf("expected log prefix %q; got %+v", "to guest: ", r)
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	for _, s := range []string{
+		"zone",
+		"zone lan",
+		"zone router iface eth0",
+		"zone lan iface eth0\nzone lan iface eth1",
+		"zone lan net 10.0.0.0",
+		"allow from lan to router",
+		"allow tcp/22 from router to router",
+		"allow sctp/22 from any to router",
+		"allow tcp/0 from any to router",
+		"allow icmp/8 from any to router",
+		"allow from any to router limit 10",
+		"allow from any to router burst 10",
+		"allow from any to router log \"unterminated",
+		"allow from any",
+		"permit from any to router",
+	} {
+		if _, err := Parse(strings.NewReader(s)); err == nil {
+			t.Errorf("expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestRuleSet(t *testing.T) {
+	p, err := Parse(strings.NewReader(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := rules.RuleSet{
+		"-t filter -N policy-in",
+		"-t filter -I INPUT -j policy-in -m conntrack --ctstate NEW",
+		"-t filter -N policy-fwd",
+		"-t filter -I FORWARD -j policy-fwd -m conntrack --ctstate NEW",
+		"-t filter -N policy-out",
+		"-t filter -I OUTPUT -j policy-out -m conntrack --ctstate NEW",
+		"-t filter -A policy-in -j ACCEPT -i eth1 -p tcp --dport 22",
+		`-t filter -A policy-fwd -j LOG -i eth3 -o eth1 --log-prefix "policy:7 deny: "`,
+		"-t filter -A policy-fwd -j DROP -i eth3 -o eth1",
+		`-t filter -A policy-fwd -j LOG -s 192.168.50.0/24 -o eth1 --log-prefix "policy:7 deny: "`,
+		"-t filter -A policy-fwd -j DROP -s 192.168.50.0/24 -o eth1",
+		"-t filter -A policy-fwd -j ACCEPT -i eth3 -p tcp --dport 8000:8080 -m limit --limit 10/second --limit-burst 20",
+		"-t filter -A policy-fwd -j ACCEPT -i eth3 -p udp --dport 53 -m limit --limit 10/second --limit-burst 20",
+		"-t filter -A policy-fwd -j ACCEPT -s 192.168.50.0/24 -p tcp --dport 8000:8080 -m limit --limit 10/second --limit-burst 20",
+		"-t filter -A policy-fwd -j ACCEPT -s 192.168.50.0/24 -p udp --dport 53 -m limit --limit 10/second --limit-burst 20",
+		`-t filter -A policy-out -j LOG -o eth3 --log-prefix "to guest: "`,
+		"-t filter -A policy-out -j REJECT -o eth3",
+		`-t filter -A policy-out -j LOG -d 192.168.50.0/24 --log-prefix "to guest: "`,
+		"-t filter -A policy-out -j REJECT -d 192.168.50.0/24",
+	}
+	if diff := cmp.Diff(want, p.RuleSet()); diff != "" {
+		t.Errorf("unexpected rules; diff: %v", diff)
+	}
+}
+
+func TestApply_empty(t *testing.T) {
+	var p *Policy
+	if rs := p.RuleSet(); len(rs) != 0 {
+		t.Errorf("expected no rules from a nil policy; got %q", rs)
+	}
+	p = new(Policy)
+	if rs := p.RuleSet(); len(rs) != 0 {
+		t.Errorf("expected no rules from an empty policy; got %q", rs)
+	}
+}
@@ -0,0 +1,111 @@
+package fwdebug
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Selects the packets to trace. Unset fields match anything.
+type Filter struct {
+	Src   *net.IPNet
+	Dst   *net.IPNet
+	Proto string
+	SPort uint16
+	DPort uint16
+}
+
+// Parses a filter from the query parameters src, dst, proto, sport and dport.
+// Addresses may be IPs or CIDRs.
+func ParseFilter(q url.Values) (Filter, error) {
+	var f Filter
+	var err error
+	if f.Src, err = parseNet(q.Get("src")); err != nil {
+		return Filter{}, fmt.Errorf("bad src: %w", err)
+	}
+	if f.Dst, err = parseNet(q.Get("dst")); err != nil {
+		return Filter{}, fmt.Errorf("bad dst: %w", err)
+	}
+
+	f.Proto = strings.ToLower(q.Get("proto"))
+	switch f.Proto {
+	case "", "tcp", "udp", "icmp":
+	default:
+		return Filter{}, fmt.Errorf("bad proto %q; should be tcp, udp or icmp", f.Proto)
+	}
+
+	if f.SPort, err = parsePort(q.Get("sport")); err != nil {
+		return Filter{}, fmt.Errorf("bad sport: %w", err)
+	}
+	if f.DPort, err = parsePort(q.Get("dport")); err != nil {
+		return Filter{}, fmt.Errorf("bad dport: %w", err)
+	}
+	if (f.SPort != 0 || f.DPort != 0) && f.Proto != "tcp" && f.Proto != "udp" {
+		return Filter{}, errors.New("ports need proto tcp or udp")
+	}
+
+	if f == (Filter{}) {
+		return Filter{}, errors.New("refusing to trace all packets; give at least one of src, dst, proto, sport or dport")
+	}
+	return f, nil
+}
+
+func parseNet(s string) (*net.IPNet, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("%q is not an IPv4 address", s)
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	ip, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("%q is not an IPv4 network", s)
+	}
+	return n, nil
+}
+
+func parsePort(s string) (uint16, error) {
+	if s == "" {
+		return 0, nil
+	}
+	p, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || p == 0 {
+		return 0, fmt.Errorf("%q is not a port", s)
+	}
+	return uint16(p), nil
+}
+
+// The iptables matches for f.
+func (f Filter) args() []string {
+	var args []string
+	if f.Src != nil {
+		args = append(args, "-s", f.Src.String())
+	}
+	if f.Dst != nil {
+		args = append(args, "-d", f.Dst.String())
+	}
+	if f.Proto != "" {
+		args = append(args, "-p", f.Proto)
+	}
+	if f.SPort != 0 {
+		args = append(args, "--sport", strconv.Itoa(int(f.SPort)))
+	}
+	if f.DPort != 0 {
+		args = append(args, "--dport", strconv.Itoa(int(f.DPort)))
+	}
+	return args
+}
+
+func (f Filter) String() string {
+	return strings.Join(f.args(), " ")
+}
@@ -0,0 +1,373 @@
+// Package fwdebug serves endpoints for debugging the firewall without exec-ing
+// into the router: per-rule counters of the chains egress manages, and traces
+// streaming which rules packets matching a filter hit.
+//
+// A trace marks packets matching its filter as they enter the raw table and
+// logs marked packets to NFLOG just ahead of each rule in the managed chains
+// they would match. The trace's rules are removed when it expires or its
+// client goes away, and any left behind by a crash by Cleanup().
+//
+// Trace rules are inserted at fixed positions in chains egress also manages
+// elsewhere. A chain rewritten during a trace (e.g. the per-client chains
+// srcroute replaces whenever clients' uplink selections change) loses its
+// trace rules, so packets passing through it go unreported until the next
+// trace, and hits report rule numbers as of when the trace started.
+package fwdebug
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/fw/rules"
+	"go.jonnrb.io/egress/log"
+)
+
+const (
+	// The NFLOG group traces log to by default.
+	DefaultGroup = 32
+
+	defaultDuration = 30 * time.Second
+)
+
+// Serves the counters of Chains on /counters and traces on /trace.
+type Debugger struct {
+	Chains []fw.Chain
+
+	// The longest a trace may run. Tracing is disabled if zero, the default,
+	// since traces can add rules and see every client's connections.
+	MaxDuration time.Duration
+
+	// The NFLOG group to log traced packets to.
+	Group uint16
+
+	mu      sync.Mutex
+	tracing bool
+
+	// Dependencies, overridden in tests.
+	listRules func(fw.Chain) ([]string, error)
+	apply     func(rules.RuleSet) error
+	counters  func([]fw.Chain) ([]fw.RuleCounter, error)
+	listen    func(group uint16) (packetSource, error)
+	now       func() time.Time
+}
+
+type packetSource interface {
+	Receive() ([]logged, error)
+	Close() error
+}
+
+// Debugs the chains rs creates or adds rules to.
+func New(rs rules.RuleSet) *Debugger {
+	return &Debugger{
+		Chains:    fw.ManagedChains(rs),
+		Group:     DefaultGroup,
+		listRules: fw.ListRules,
+		apply:     fw.ApplyRules,
+		counters:  fw.ReadRuleCounters,
+		listen: func(group uint16) (packetSource, error) {
+			return listenNFLOG(group)
+		},
+		now: time.Now,
+	}
+}
+
+func (d *Debugger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/counters":
+		d.serveCounters(w, r)
+	case "/trace":
+		d.serveTrace(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// The counters of a rule.
+type Counter struct {
+	Table   string `json:"table"`
+	Chain   string `json:"chain"`
+	Rule    string `json:"rule"`
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// Serves the counters of the managed chains, optionally only those of ?chain=
+// or (with ?nonzero=1) the rules that have seen packets. ?format= may be json
+// (the default) or text.
+func (d *Debugger) serveCounters(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	nonzero, _ := strconv.ParseBool(q.Get("nonzero"))
+
+	var chains []fw.Chain
+	for _, c := range d.Chains {
+		if name := q.Get("chain"); name == "" || name == c.Name {
+			chains = append(chains, c)
+		}
+	}
+	rcs, err := d.counters(chains)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	counters := []Counter{}
+	for _, rc := range rcs {
+		// Trace rules come and go; leave them out.
+		if (nonzero && rc.Packets == 0) || isTraceRule(strings.Fields(rc.Rule)) {
+			continue
+		}
+		counters = append(counters, Counter{
+			Table:   rc.Chain.Table,
+			Chain:   rc.Chain.Name,
+			Rule:    rc.Rule,
+			Packets: rc.Packets,
+			Bytes:   rc.Bytes,
+		})
+	}
+
+	switch format := q.Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(counters)
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "TABLE\tCHAIN\tPACKETS\tBYTES\tRULE")
+		for _, c := range counters {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", c.Table, c.Chain, c.Packets, c.Bytes, c.Rule)
+		}
+		err = tw.Flush()
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.V(2).Infof("fwdebug: error writing counters: %v", err)
+	}
+}
+
+// A traced packet about to be matched against a rule (or, if RuleNum is 0,
+// falling through to its chain's policy).
+type Hit struct {
+	Time time.Time `json:"time"`
+
+	Table string `json:"table"`
+	Chain string `json:"chain"`
+	// The rule's position in its chain when the trace started.
+	RuleNum int    `json:"rule_num"`
+	Rule    string `json:"rule"`
+
+	In    string `json:"in,omitempty"`
+	Out   string `json:"out,omitempty"`
+	Src   net.IP `json:"src,omitempty"`
+	Dst   net.IP `json:"dst,omitempty"`
+	Proto string `json:"proto,omitempty"`
+	SPort uint16 `json:"sport,omitempty"`
+	DPort uint16 `json:"dport,omitempty"`
+}
+
+// Traces packets matching the filter in the query (see ParseFilter) for
+// ?duration= (30s by default), streaming hits as JSON lines. One trace may run
+// at a time.
+func (d *Debugger) serveTrace(w http.ResponseWriter, r *http.Request) {
+	if d.MaxDuration == 0 {
+		http.Error(w, "tracing is disabled", http.StatusForbidden)
+		return
+	}
+
+	f, err := ParseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dur := defaultDuration
+	if s := r.URL.Query().Get("duration"); s != "" {
+		dur, err = time.ParseDuration(s)
+		if err != nil || dur <= 0 {
+			http.Error(w, fmt.Sprintf("bad duration %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+	if dur > d.MaxDuration {
+		http.Error(w, fmt.Sprintf("duration %v is longer than the limit of %v", dur, d.MaxDuration), http.StatusBadRequest)
+		return
+	}
+
+	d.mu.Lock()
+	if d.tracing {
+		d.mu.Unlock()
+		http.Error(w, "a trace is already running", http.StatusConflict)
+		return
+	}
+	d.tracing = true
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.tracing = false
+		d.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(r.Context(), dur)
+	defer cancel()
+
+	src, points, err := d.startTrace(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Infof("fwdebug: tracing %q for %v", f, dur)
+	defer func() {
+		d.Cleanup()
+		log.Infof("fwdebug: stopped tracing %q", f)
+	}()
+
+	go func() {
+		<-ctx.Done()
+		src.Close()
+	}()
+	hits := make(chan []logged)
+	go func() {
+		defer close(hits)
+		for {
+			ps, err := src.Receive()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Errorf("fwdebug: could not receive traced packets: %v", err)
+				}
+				return
+			}
+			select {
+			case hits <- ps:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Streams outlive the server's write timeout.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(d.now().Add(dur + 10*time.Second))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	enc := json.NewEncoder(w)
+	ifaces := make(map[uint32]string)
+	for ps := range hits {
+		for _, p := range ps {
+			h, ok := d.hit(p, points, ifaces)
+			if !ok {
+				continue
+			}
+			if err := enc.Encode(h); err != nil {
+				log.V(2).Infof("fwdebug: error writing hit: %v", err)
+				return
+			}
+		}
+		rc.Flush()
+	}
+}
+
+// Sets up a trace of the packets matching f, returning where its hits are
+// logged and the rules being traced by NFLOG prefix.
+func (d *Debugger) startTrace(f Filter) (packetSource, map[string]tracePoint, error) {
+	src, err := d.listen(d.Group)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Remove what a crashed trace may have left behind so it isn't listed as
+	// rules to trace.
+	d.Cleanup()
+
+	var rs rules.RuleSet
+	points := make(map[string]tracePoint)
+	for _, c := range d.Chains {
+		lines, err := d.listRules(c)
+		if err != nil {
+			src.Close()
+			return nil, nil, err
+		}
+		crs, cps, err := traceRules(c, lines, d.Group)
+		if err != nil {
+			src.Close()
+			return nil, nil, err
+		}
+		rs = append(rs, crs...)
+		for _, p := range cps {
+			points[p.prefix()] = p
+		}
+	}
+	// Mark packets once everything is in place to log them.
+	rs = append(rs, markRules(f)...)
+
+	if err := d.apply(rs); err != nil {
+		d.Cleanup()
+		src.Close()
+		return nil, nil, fmt.Errorf("fwdebug: could not add trace rules: %w", err)
+	}
+	return src, points, nil
+}
+
+// Removes the rules of any trace, starting with those marking packets. Traces
+// clean up after themselves; this is for those cut short by a crash.
+func (d *Debugger) Cleanup() {
+	for _, c := range append(append([]fw.Chain(nil), markChains...), d.Chains...) {
+		lines, err := d.listRules(c)
+		if err != nil {
+			log.Warningf("fwdebug: could not clean up chain %q: %v", c.Name, err)
+			continue
+		}
+		for _, r := range cleanupRules(c, lines) {
+			if err := d.apply(rules.RuleSet{r}); err != nil {
+				log.Warningf("fwdebug: could not remove trace rule %q: %v", r, err)
+			}
+		}
+	}
+}
+
+func (d *Debugger) hit(p logged, points map[string]tracePoint, ifaces map[uint32]string) (Hit, bool) {
+	tp, ok := points[p.Prefix]
+	if !ok {
+		return Hit{}, false
+	}
+	h := Hit{
+		Time:    d.now(),
+		Table:   tp.Chain.Table,
+		Chain:   tp.Chain.Name,
+		RuleNum: tp.Num,
+		Rule:    tp.Rule,
+		In:      ifaceName(p.InDev, ifaces),
+		Out:     ifaceName(p.OutDev, ifaces),
+	}
+	if pi, ok := parsePacket(p.Payload); ok {
+		h.Src, h.Dst, h.Proto, h.SPort, h.DPort = pi.Src, pi.Dst, pi.Proto, pi.SPort, pi.DPort
+	}
+	return h, true
+}
+
+func ifaceName(index uint32, cache map[uint32]string) string {
+	if index == 0 {
+		return ""
+	}
+	if name, ok := cache[index]; ok {
+		return name
+	}
+	name := strconv.Itoa(int(index))
+	if iface, err := net.InterfaceByIndex(int(index)); err == nil {
+		name = iface.Name
+	}
+	cache[index] = name
+	return name
+}
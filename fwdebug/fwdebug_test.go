@@ -0,0 +1,255 @@
+package fwdebug
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/fw/rules"
+)
+
+func TestParseFilter(t *testing.T) {
+	f, err := ParseFilter(url.Values{
+		"src":   {"10.0.0.5"},
+		"dst":   {"1.1.1.0/24"},
+		"proto": {"TCP"},
+		"dport": {"443"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.String(), "-s 10.0.0.5/32 -d 1.1.1.0/24 -p tcp --dport 443"; got != want {
+		t.Errorf("got filter %q; want %q", got, want)
+	}
+
+	for _, q := range []url.Values{
+		{},
+		{"src": {"nope"}},
+		{"src": {"fd00::1"}},
+		{"proto": {"sctp"}},
+		{"dport": {"53"}},
+		{"proto": {"icmp"}, "dport": {"53"}},
+		{"proto": {"udp"}, "dport": {"65536"}},
+	} {
+		if _, err := ParseFilter(q); err == nil {
+			t.Errorf("expected an error parsing %v", q)
+		}
+	}
+}
+
+const forwardRules = `-P FORWARD ACCEPT
+-A FORWARD -j fw-lans
+-A FORWARD -i eth0 -o eth1 -m comment --comment "lan out" -j ACCEPT
+-A FORWARD -j REJECT --reject-with icmp-host-unreach
+`
+
+func TestTraceRules(t *testing.T) {
+	c := fw.Chain{Table: "filter", Name: "FORWARD"}
+	rs, points, err := traceRules(c, strings.Split(forwardRules, "\n"), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := rules.RuleSet{
+		`-t filter -A FORWARD -m mark --mark 0x40000000/0x40000000 -m comment --comment egress-fwdebug -j NFLOG --nflog-group 32 --nflog-prefix filter:FORWARD:policy`,
+		`-t filter -I FORWARD 3 -m mark --mark 0x40000000/0x40000000 -m comment --comment egress-fwdebug -j NFLOG --nflog-group 32 --nflog-prefix filter:FORWARD:3`,
+		`-t filter -I FORWARD 2 -m mark --mark 0x40000000/0x40000000 -i eth0 -o eth1 -m comment --comment "lan out" -m comment --comment egress-fwdebug -j NFLOG --nflog-group 32 --nflog-prefix filter:FORWARD:2`,
+		`-t filter -I FORWARD 1 -m mark --mark 0x40000000/0x40000000 -m comment --comment egress-fwdebug -j NFLOG --nflog-group 32 --nflog-prefix filter:FORWARD:1`,
+	}
+	if diff := cmp.Diff(want, rs); diff != "" {
+		t.Errorf("unexpected rules; diff: %v", diff)
+	}
+
+	if len(points) != 4 {
+		t.Fatalf("expected 4 trace points; got %+v", points)
+	}
+	if p := points[2]; p.Num != 3 || p.Rule != "-j REJECT --reject-with icmp-host-unreach" {
+		t.Errorf("unexpected trace point for the REJECT rule: %+v", p)
+	}
+	if p := points[3]; p.Num != 0 || p.prefix() != "filter:FORWARD:policy" {
+		t.Errorf("unexpected trace point for the policy: %+v", p)
+	}
+}
+
+func TestCleanupRules(t *testing.T) {
+	lines := []string{
+		`-P PREROUTING ACCEPT`,
+		`-A PREROUTING -s 10.0.0.5/32 -m comment --comment egress-fwdebug -j MARK --set-xmark 0x40000000/0x40000000`,
+		`-A PREROUTING -m mark --mark 0x40000000/0x40000000 -m comment --comment egress-fwdebug -j NFLOG --nflog-prefix "raw:PREROUTING:1" --nflog-group 7`,
+		// Rules that only look like a trace's are someone else's.
+		`-A PREROUTING -j NFLOG --nflog-group 32`,
+		`-A PREROUTING -m mark --mark 0x40000000/0x40000000 -j MARK --set-xmark 0x40000000/0x40000000`,
+		`-A PREROUTING -j CT --notrack`,
+	}
+	got := cleanupRules(fw.Chain{Table: "raw", Name: "PREROUTING"}, lines)
+	want := rules.RuleSet{
+		`-t raw -D PREROUTING -s 10.0.0.5/32 -m comment --comment egress-fwdebug -j MARK --set-xmark 0x40000000/0x40000000`,
+		`-t raw -D PREROUTING -m mark --mark 0x40000000/0x40000000 -m comment --comment egress-fwdebug -j NFLOG --nflog-prefix raw:PREROUTING:1 --nflog-group 7`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected rules; diff: %v", diff)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	d, k := newTestDebugger()
+	k.chains[fw.Chain{Table: "raw", Name: "PREROUTING"}] = []string{
+		`-P PREROUTING ACCEPT`,
+		`-A PREROUTING -s 10.0.0.5/32 -m comment --comment egress-fwdebug -j MARK --set-xmark 0x40000000/0x40000000`,
+	}
+
+	d.Cleanup()
+	want := rules.RuleSet{
+		`-t raw -D PREROUTING -s 10.0.0.5/32 -m comment --comment egress-fwdebug -j MARK --set-xmark 0x40000000/0x40000000`,
+	}
+	if diff := cmp.Diff(want, k.applied); diff != "" {
+		t.Errorf("unexpected rules; diff: %v", diff)
+	}
+}
+
+func TestParsePacket(t *testing.T) {
+	b := []byte{
+		0x45, 0, 0, 40, 0, 0, 0x40, 0, 64, 6, 0, 0,
+		10, 0, 0, 5,
+		1, 1, 1, 1,
+		0xc3, 0x50, 0x01, 0xbb,
+	}
+	pi, ok := parsePacket(b)
+	if !ok {
+		t.Fatal("expected to parse the packet")
+	}
+	if pi.Src.String() != "10.0.0.5" || pi.Dst.String() != "1.1.1.1" || pi.Proto != "tcp" || pi.SPort != 50000 || pi.DPort != 443 {
+		t.Errorf("unexpected packet info: %+v", pi)
+	}
+
+	if _, ok := parsePacket(b[:10]); ok {
+		t.Error("expected a truncated packet not to parse")
+	}
+}
+
+// Stands in for iptables and NFLOG.
+type fakeKernel struct {
+	chains  map[fw.Chain][]string
+	applied rules.RuleSet
+	packets chan []logged
+	closed  chan struct{}
+}
+
+func (k *fakeKernel) Receive() ([]logged, error) {
+	select {
+	case ps := <-k.packets:
+		return ps, nil
+	case <-k.closed:
+		return nil, errors.New("closed")
+	}
+}
+
+func (k *fakeKernel) Close() error {
+	close(k.closed)
+	return nil
+}
+
+func newTestDebugger() (*Debugger, *fakeKernel) {
+	k := &fakeKernel{
+		chains: map[fw.Chain][]string{
+			{Table: "filter", Name: "FORWARD"}: strings.Split(forwardRules, "\n"),
+		},
+		packets: make(chan []logged),
+		closed:  make(chan struct{}),
+	}
+	d := New(rules.RuleSet{"-t filter -A FORWARD -j ACCEPT"})
+	d.MaxDuration = 5 * time.Minute
+	d.listRules = func(c fw.Chain) ([]string, error) { return k.chains[c], nil }
+	d.apply = func(rs rules.RuleSet) error {
+		k.applied = append(k.applied, rs...)
+		return nil
+	}
+	d.counters = func(cs []fw.Chain) ([]fw.RuleCounter, error) {
+		c := fw.Chain{Table: "filter", Name: "FORWARD"}
+		return []fw.RuleCounter{
+			{Chain: c, Rule: "-j fw-lans", Packets: 10, Bytes: 1000},
+			{Chain: c, Rule: "-m mark --mark 0x40000000/0x40000000 -m comment --comment egress-fwdebug -j NFLOG --nflog-group 32", Packets: 3},
+			{Chain: c, Rule: "-j REJECT --reject-with icmp-host-unreach"},
+		}, nil
+	}
+	d.listen = func(uint16) (packetSource, error) { return k, nil }
+	d.now = func() time.Time { return time.Unix(1600000000, 0) }
+	return d, k
+}
+
+func TestServeCounters(t *testing.T) {
+	d, _ := newTestDebugger()
+
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest("GET", "/counters?nonzero=1", nil))
+
+	var got []Counter
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []Counter{{Table: "filter", Chain: "FORWARD", Rule: "-j fw-lans", Packets: 10, Bytes: 1000}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected counters; diff: %v", diff)
+	}
+}
+
+func TestServeTrace(t *testing.T) {
+	d, k := newTestDebugger()
+
+	go func() {
+		k.packets <- []logged{
+			{Prefix: "filter:FORWARD:3", Payload: []byte{
+				0x45, 0, 0, 28, 0, 0, 0, 0, 64, 17, 0, 0,
+				10, 0, 0, 5,
+				8, 8, 8, 8,
+				0x30, 0x39, 0, 53,
+			}},
+			{Prefix: "unknown"},
+		}
+	}()
+
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest("GET", "/trace?src=10.0.0.5&proto=udp&dport=53&duration=100ms", nil))
+
+	sc := bufio.NewScanner(w.Body)
+	var hits []Hit
+	for sc.Scan() {
+		var h Hit
+		if err := json.Unmarshal(sc.Bytes(), &h); err != nil {
+			t.Fatalf("bad hit %q: %v", sc.Text(), err)
+		}
+		hits = append(hits, h)
+	}
+	if len(hits) != 1 {
+		t.Fatalf("expected one hit; got %+v", hits)
+	}
+	if h := hits[0]; h.RuleNum != 3 || h.Rule != "-j REJECT --reject-with icmp-host-unreach" || h.Dst.String() != "8.8.8.8" || h.DPort != 53 {
+		t.Errorf("unexpected hit: %+v", h)
+	}
+
+	var marked bool
+	for _, r := range k.applied {
+		if r == "-t raw -I PREROUTING -s 10.0.0.5/32 -p udp --dport 53 -m comment --comment egress-fwdebug -j MARK --set-xmark 0x40000000/0x40000000" {
+			marked = true
+		}
+	}
+	if !marked {
+		t.Errorf("expected packets matching the filter to be marked; applied %v", k.applied)
+	}
+	if d.tracing {
+		t.Error("expected the trace to have ended")
+	}
+
+	w = httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest("GET", "/trace?src=10.0.0.5&duration=1h", nil))
+	if w.Code != 400 {
+		t.Errorf("expected a trace longer than the limit to be refused; got %d", w.Code)
+	}
+}
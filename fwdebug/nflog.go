@@ -0,0 +1,151 @@
+package fwdebug
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/mdlayher/netlink"
+	"github.com/ti-mo/netfilter"
+)
+
+// From linux/netfilter/nfnetlink_log.h.
+const (
+	nfulnlMsgPacket = 0
+	nfulnlMsgConfig = 1
+
+	nfulaIfindexIndev  = 4
+	nfulaIfindexOutdev = 5
+	nfulaPayload       = 9
+	nfulaPrefix        = 10
+
+	nfulaCfgCmd  = 1
+	nfulaCfgMode = 2
+
+	nfulnlCfgCmdBind = 1
+	nfulnlCopyPacket = 2
+
+	// Enough of a packet for its IP header and ports.
+	copyRange = 80
+)
+
+// A packet logged to NFLOG.
+type logged struct {
+	Prefix  string
+	InDev   uint32
+	OutDev  uint32
+	Payload []byte
+}
+
+// A connection receiving the packets logged to an NFLOG group.
+type nflogConn struct {
+	c *netfilter.Conn
+}
+
+// Binds to NFLOG group. Only one socket can be bound to a group at a time.
+func listenNFLOG(group uint16) (*nflogConn, error) {
+	c, err := netfilter.Dial(nil)
+	if err != nil {
+		return nil, fmt.Errorf("fwdebug: could not dial netfilter: %w", err)
+	}
+
+	mode := make([]byte, 6)
+	binary.BigEndian.PutUint32(mode, copyRange)
+	mode[4] = nfulnlCopyPacket
+	for _, a := range []netfilter.Attribute{
+		{Type: nfulaCfgCmd, Data: []byte{nfulnlCfgCmdBind}},
+		{Type: nfulaCfgMode, Data: mode},
+	} {
+		if err := configNFLOG(c, group, a); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("fwdebug: could not bind to NFLOG group %d: %w", group, err)
+		}
+	}
+	return &nflogConn{c}, nil
+}
+
+func configNFLOG(c *netfilter.Conn, group uint16, a netfilter.Attribute) error {
+	msg, err := netfilter.MarshalNetlink(netfilter.Header{
+		SubsystemID: netfilter.NFSubsysULOG,
+		MessageType: nfulnlMsgConfig,
+		Flags:       netlink.Request | netlink.Acknowledge,
+		Family:      netfilter.ProtoUnspec,
+		ResourceID:  group,
+	}, []netfilter.Attribute{a})
+	if err != nil {
+		return err
+	}
+	_, err = c.Query(msg)
+	return err
+}
+
+// Blocks until packets are logged or the connection is closed.
+func (n *nflogConn) Receive() ([]logged, error) {
+	msgs, err := n.c.Receive()
+	if err != nil {
+		return nil, err
+	}
+	var ps []logged
+	for _, m := range msgs {
+		h, attrs, err := netfilter.UnmarshalNetlink(m)
+		if err != nil || h.SubsystemID != netfilter.NFSubsysULOG || h.MessageType != nfulnlMsgPacket {
+			continue
+		}
+		var p logged
+		for _, a := range attrs {
+			switch a.Type {
+			case nfulaPrefix:
+				p.Prefix = strings.TrimRight(string(a.Data), "\x00")
+			case nfulaIfindexIndev:
+				p.InDev = a.Uint32()
+			case nfulaIfindexOutdev:
+				p.OutDev = a.Uint32()
+			case nfulaPayload:
+				p.Payload = a.Data
+			}
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+func (n *nflogConn) Close() error {
+	return n.c.Close()
+}
+
+// The addresses, protocol and ports of an IPv4 packet.
+type packetInfo struct {
+	Src   net.IP
+	Dst   net.IP
+	Proto string
+	SPort uint16
+	DPort uint16
+}
+
+func parsePacket(b []byte) (packetInfo, bool) {
+	if len(b) < 20 || b[0]>>4 != 4 {
+		return packetInfo{}, false
+	}
+	ihl := int(b[0]&0x0f) * 4
+	pi := packetInfo{
+		Src: net.IP(append([]byte(nil), b[12:16]...)),
+		Dst: net.IP(append([]byte(nil), b[16:20]...)),
+	}
+	switch b[9] {
+	case 1:
+		pi.Proto = "icmp"
+	case 6:
+		pi.Proto = "tcp"
+	case 17:
+		pi.Proto = "udp"
+	default:
+		pi.Proto = fmt.Sprint(b[9])
+	}
+	first := binary.BigEndian.Uint16(b[6:])&0x1fff == 0
+	if (pi.Proto == "tcp" || pi.Proto == "udp") && first && len(b) >= ihl+4 {
+		pi.SPort = binary.BigEndian.Uint16(b[ihl:])
+		pi.DPort = binary.BigEndian.Uint16(b[ihl+2:])
+	}
+	return pi, true
+}
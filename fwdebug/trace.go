@@ -0,0 +1,154 @@
+package fwdebug
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/shlex"
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/fw/rules"
+)
+
+// The packet mark bit set on packets matching a trace's filter. Marking
+// packets as they enter the raw table (before NAT) lets the trace follow them
+// through chains that see rewritten addresses.
+const traceMark = "0x40000000/0x40000000"
+
+// Tags every rule a trace adds, so they can be told apart from the firewall's
+// own rules (including those of a crashed process) no matter their target.
+const traceComment = "egress-fwdebug"
+
+// Where packets are marked; these see every packet before anything else.
+var markChains = []fw.Chain{
+	{Table: "raw", Name: "PREROUTING"},
+	{Table: "raw", Name: "OUTPUT"},
+}
+
+// A rule (or chain policy) being traced. Num is the rule's 1-based position in
+// its chain when the trace started, or 0 for the policy.
+type tracePoint struct {
+	Chain fw.Chain
+	Num   int
+	Rule  string
+}
+
+// The NFLOG prefix identifying p's hits. Chain names are at most 28 characters
+// so this fits within NFLOG's 64.
+func (p tracePoint) prefix() string {
+	n := "policy"
+	if p.Num != 0 {
+		n = strconv.Itoa(p.Num)
+	}
+	return p.Chain.Table + ":" + p.Chain.Name + ":" + n
+}
+
+// Rules that mark packets matching f.
+func markRules(f Filter) rules.RuleSet {
+	var rs rules.RuleSet
+	for _, c := range markChains {
+		rs = append(rs, rules.Rule(fmt.Sprintf("-t %s -I %s %s -m comment --comment %s -j MARK --set-xmark %s",
+			c.Table, c.Name, f, traceComment, traceMark)))
+	}
+	return rs
+}
+
+// Rules that log marked packets to NFLOG group just ahead of each rule in c
+// they would match, given c's rules as listed by `iptables -S`. Built-in
+// chains also log marked packets that fall through to their policy. The rules
+// are ordered so each insertion leaves the positions of those to come intact.
+func traceRules(c fw.Chain, lines []string, group uint16) (rules.RuleSet, []tracePoint, error) {
+	var (
+		points  []tracePoint
+		matches [][]string
+		policy  string
+	)
+	for _, line := range lines {
+		args, err := shlex.Split(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("fwdebug: could not parse rule %q: %w", line, err)
+		}
+		if len(args) < 3 || args[1] != c.Name {
+			continue
+		}
+		switch args[0] {
+		case "-P":
+			policy = args[2]
+		case "-A":
+			points = append(points, tracePoint{
+				Chain: c,
+				Num:   len(points) + 1,
+				Rule:  strings.Join(args[2:], " "),
+			})
+			matches = append(matches, stripTarget(args[2:]))
+		}
+	}
+
+	var rs rules.RuleSet
+	rule := func(op string, p tracePoint, match []string) rules.Rule {
+		args := []string{"-t", c.Table, op, c.Name}
+		if op == "-I" {
+			args = append(args, strconv.Itoa(p.Num))
+		}
+		args = append(args, "-m", "mark", "--mark", traceMark)
+		args = append(args, match...)
+		args = append(args, "-m", "comment", "--comment", traceComment)
+		args = append(args, "-j", "NFLOG", "--nflog-group", strconv.Itoa(int(group)), "--nflog-prefix", p.prefix())
+		return rules.Rule(joinArgs(args))
+	}
+	if policy != "" {
+		p := tracePoint{Chain: c, Rule: "-P " + policy}
+		rs = append(rs, rule("-A", p, nil))
+		points = append(points, p)
+	}
+	for i := len(matches) - 1; i >= 0; i-- {
+		rs = append(rs, rule("-I", points[i], matches[i]))
+	}
+	return rs, points, nil
+}
+
+// Drops the target (and its options) from a rule's spec. iptables lists
+// matches ahead of the target.
+func stripTarget(args []string) []string {
+	for i, a := range args {
+		if a == "-j" || a == "-g" {
+			return args[:i]
+		}
+	}
+	return args
+}
+
+// Rules deleting the trace rules among lines (as listed by `iptables -S`),
+// including those left behind by an earlier process.
+func cleanupRules(c fw.Chain, lines []string) rules.RuleSet {
+	var rs rules.RuleSet
+	for _, line := range lines {
+		args, err := shlex.Split(line)
+		if err != nil || len(args) < 2 || args[0] != "-A" || !isTraceRule(args) {
+			continue
+		}
+		rs = append(rs, rules.Rule("-t "+c.Table+" -D "+joinArgs(args[1:])))
+	}
+	return rs
+}
+
+func isTraceRule(args []string) bool {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "--comment" && args[i+1] == traceComment {
+			return true
+		}
+	}
+	return false
+}
+
+// Joins args into a rule, quoting those that need it.
+func joinArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\"'\\") {
+			a = strconv.Quote(a)
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"time"
 
+	"go.jonnrb.io/egress/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -89,13 +91,32 @@ func runControlLoop(
 				// notification is a valid leader state.
 				panic("ha: nc shouldn't ever get closed (this is bad!)")
 			}
+			// The transition span covers stepping down from the previous role.
+			// The new role gets its own span for as long as it lasts.
+			role, attrs := "leader", []attribute.KeyValue(nil)
+			if n.leader != nil {
+				role = "follower"
+				attrs = append(attrs, attribute.String("leader", *n.leader))
+			}
+			attrs = append(attrs, attribute.String("role", role))
+			_, span := tracing.Start(ctx, "ha.Transition", attrs...)
 			switch err := cancelAndJoinTask(); err {
 			case nil, context.Canceled:
+				tracing.End(span, nil)
 			default:
+				tracing.End(span, err)
 				ec <- err
 				return
 			}
-			cancelAndJoinTask = runTask(ctx, cancel, func(ctx context.Context) error {
+			cancelAndJoinTask = runTask(ctx, cancel, func(ctx context.Context) (err error) {
+				ctx, span := tracing.Start(ctx, "ha."+role, attrs...)
+				defer func() {
+					if err == context.Canceled {
+						tracing.End(span, nil)
+					} else {
+						tracing.End(span, err)
+					}
+				}()
 				if n.leader == nil {
 					return m.Lead(ctx, n.isLeaseAcceptable)
 				} else {
@@ -15,6 +15,9 @@ import (
 type HealthChecker struct {
 	c chan chan error
 	*haObserver
+
+	mu     sync.Mutex
+	checks []func(context.Context) error
 }
 
 func New(ctx context.Context, haHandler func(m ha.Member)) *HealthChecker {
@@ -29,6 +32,14 @@ func New(ctx context.Context, haHandler func(m ha.Member)) *HealthChecker {
 	return hc
 }
 
+// Adds a check to run (after the connectivity check) when health is queried.
+func (hc *HealthChecker) AddCheck(check func(ctx context.Context) error) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	hc.checks = append(hc.checks, check)
+}
+
 func (hc *HealthChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -74,11 +85,27 @@ func (hc *HealthChecker) loop(ctx context.Context) {
 			defer cancel()
 
 			err := httpHeadCheck(ctx)
+			if err == nil {
+				err = hc.runChecks(ctx)
+			}
 			ret <- err
 		}()
 	}
 }
 
+func (hc *HealthChecker) runChecks(ctx context.Context) error {
+	hc.mu.Lock()
+	checks := hc.checks
+	hc.mu.Unlock()
+
+	for _, check := range checks {
+		if err := check(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func httpHeadCheck(ctx context.Context) error {
 	if _, err := ctxhttp.Head(ctx, nil, "https://google.com/"); err != nil {
 		return err
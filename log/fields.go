@@ -0,0 +1,105 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A key/value pair attached to a message. Structured backends emit fields as
+// separate keys; others append them to the message.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{key, value}
+}
+
+// The name of the network link a message is about.
+func Link(name string) Field {
+	return F("link", name)
+}
+
+// The IP address of the DHCP lease a message is about.
+func LeaseIP(ip fmt.Stringer) Field {
+	return F("lease_ip", ip.String())
+}
+
+// The identity of the HA leader a message is about.
+func Leader(id string) Field {
+	return F("leader", id)
+}
+
+// The firewall rule a message is about.
+func Rule(r string) Field {
+	return F("rule", r)
+}
+
+// A Log with fields attached to every message.
+type Logger struct {
+	fields []Field
+}
+
+// Returns a Logger that attaches fs to every message.
+func With(fs ...Field) Logger {
+	return Logger{fs}
+}
+
+// Returns a Logger with fs attached in addition to l's fields.
+func (l Logger) With(fs ...Field) Logger {
+	return Logger{append(append([]Field(nil), l.fields...), fs...)}
+}
+
+func (l Logger) Fatal(args ...interface{}) {
+	getWith(l.fields).Fatal(args...)
+}
+
+func (l Logger) Fatalf(format string, args ...interface{}) {
+	getWith(l.fields).Fatalf(format, args...)
+}
+
+func (l Logger) Error(args ...interface{}) {
+	getWith(l.fields).Error(args...)
+}
+
+func (l Logger) Errorf(format string, args ...interface{}) {
+	getWith(l.fields).Errorf(format, args...)
+}
+
+func (l Logger) Warning(args ...interface{}) {
+	getWith(l.fields).Warning(args...)
+}
+
+func (l Logger) Warningf(format string, args ...interface{}) {
+	getWith(l.fields).Warningf(format, args...)
+}
+
+func (l Logger) Info(args ...interface{}) {
+	getWith(l.fields).Info(args...)
+}
+
+func (l Logger) Infof(format string, args ...interface{}) {
+	getWith(l.fields).Infof(format, args...)
+}
+
+func (l Logger) V(level Level) InfoLog {
+	return wrapI{getVWith(level, l.fields)}
+}
+
+// Formats fs as " [k=v k=v]" for unstructured backends.
+func formatFields(fs []Field) string {
+	if len(fs) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(" [")
+	for i, f := range fs {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s=%v", f.Key, f.Value)
+	}
+	b.WriteByte(']')
+	return b.String()
+}
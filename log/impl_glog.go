@@ -2,52 +2,56 @@ package log
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/golang/glog"
 )
 
-func get() Log {
-	return glogger{}
+func enabled(level Level) bool {
+	// The -v flag is glog's regardless of the backend.
+	return bool(glog.V(glog.Level(level)))
 }
 
-func getV(level Level) InfoLog {
-	if glog.V(glog.Level(level)) {
-		return glogger{}
-	} else {
-		return emptyI{}
-	}
+type glogger struct {
+	fields []Field
 }
 
-type glogger struct{}
+// Appends the fields to a message, keeping any trailing newline last.
+func (g glogger) msg(s string) string {
+	if len(g.fields) == 0 {
+		return s
+	}
+	return strings.TrimSuffix(s, "\n") + formatFields(g.fields)
+}
 
-func (glogger) Fatal(args ...interface{}) {
-	glog.FatalDepth(2, fmt.Sprintln(args...))
+func (g glogger) Fatal(args ...interface{}) {
+	glog.FatalDepth(2, g.msg(fmt.Sprintln(args...)))
 }
 
-func (glogger) Fatalf(format string, args ...interface{}) {
-	glog.FatalDepth(2, fmt.Sprintf(format, args...))
+func (g glogger) Fatalf(format string, args ...interface{}) {
+	glog.FatalDepth(2, g.msg(fmt.Sprintf(format, args...)))
 }
 
-func (glogger) Error(args ...interface{}) {
-	glog.ErrorDepth(2, fmt.Sprintln(args...))
+func (g glogger) Error(args ...interface{}) {
+	glog.ErrorDepth(2, g.msg(fmt.Sprintln(args...)))
 }
 
-func (glogger) Errorf(format string, args ...interface{}) {
-	glog.ErrorDepth(2, fmt.Sprintf(format, args...))
+func (g glogger) Errorf(format string, args ...interface{}) {
+	glog.ErrorDepth(2, g.msg(fmt.Sprintf(format, args...)))
 }
 
-func (glogger) Warning(args ...interface{}) {
-	glog.WarningDepth(2, fmt.Sprintln(args...))
+func (g glogger) Warning(args ...interface{}) {
+	glog.WarningDepth(2, g.msg(fmt.Sprintln(args...)))
 }
 
-func (glogger) Warningf(format string, args ...interface{}) {
-	glog.WarningDepth(2, fmt.Sprintf(format, args...))
+func (g glogger) Warningf(format string, args ...interface{}) {
+	glog.WarningDepth(2, g.msg(fmt.Sprintf(format, args...)))
 }
 
-func (glogger) Info(args ...interface{}) {
-	glog.InfoDepth(2, fmt.Sprintln(args...))
+func (g glogger) Info(args ...interface{}) {
+	glog.InfoDepth(2, g.msg(fmt.Sprintln(args...)))
 }
 
-func (glogger) Infof(format string, args ...interface{}) {
-	glog.InfoDepth(2, fmt.Sprintf(format, args...))
+func (g glogger) Infof(format string, args ...interface{}) {
+	glog.InfoDepth(2, g.msg(fmt.Sprintf(format, args...)))
 }
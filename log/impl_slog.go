@@ -0,0 +1,126 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Severity for Fatal messages, which slog has no level for.
+const levelFatal = slog.LevelError + 4
+
+var (
+	slogOnce    sync.Once
+	slogHandler slog.Handler
+)
+
+// The handler for -log.format, created on first use so flags are parsed by
+// then.
+func getSlogHandler() slog.Handler {
+	slogOnce.Do(func() {
+		slogHandler = newSlogHandler(os.Stderr, *format == "text")
+	})
+	return slogHandler
+}
+
+func newSlogHandler(w io.Writer, text bool) slog.Handler {
+	opts := &slog.HandlerOptions{
+		AddSource: true,
+		// Let everything through; verbosity is decided by V().
+		Level: slog.Level(-1 << 10),
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey {
+				a.Value = slog.StringValue(levelName(a.Value.Any().(slog.Level)))
+			}
+			return a
+		},
+	}
+	if text {
+		return slog.NewTextHandler(w, opts)
+	}
+	return slog.NewJSONHandler(w, opts)
+}
+
+func levelName(l slog.Level) string {
+	switch {
+	case l >= levelFatal:
+		return "FATAL"
+	case l >= slog.LevelError:
+		return "ERROR"
+	case l >= slog.LevelWarn:
+		return "WARNING"
+	default:
+		return "INFO"
+	}
+}
+
+type slogger struct {
+	h      slog.Handler
+	fields []Field
+	// The V() level of info messages, if any.
+	verbosity Level
+}
+
+// Emits a message attributed to the caller of the top-level logging func,
+// which is 2 frames above this logger's exported methods.
+func (s slogger) log(level slog.Level, msg string) {
+	var pcs [1]uintptr
+	// Skip runtime.Callers, log, the slogger method and its wrapper.
+	runtime.Callers(4, pcs[:])
+	r := slog.NewRecord(time.Now(), level, strings.TrimSuffix(msg, "\n"), pcs[0])
+	for _, f := range s.fields {
+		r.AddAttrs(slog.Any(f.Key, fieldValue(f.Value)))
+	}
+	if level < slog.LevelWarn && s.verbosity > 0 {
+		r.AddAttrs(slog.Int("v", int(s.verbosity)))
+	}
+	s.h.Handle(context.Background(), r)
+}
+
+// Stringers are logged as strings so (e.g.) IPs don't show up as byte arrays.
+func fieldValue(v interface{}) interface{} {
+	if s, ok := v.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return v
+}
+
+func (s slogger) Fatal(args ...interface{}) {
+	s.log(levelFatal, fmt.Sprintln(args...))
+	os.Exit(255)
+}
+
+func (s slogger) Fatalf(format string, args ...interface{}) {
+	s.log(levelFatal, fmt.Sprintf(format, args...))
+	os.Exit(255)
+}
+
+func (s slogger) Error(args ...interface{}) {
+	s.log(slog.LevelError, fmt.Sprintln(args...))
+}
+
+func (s slogger) Errorf(format string, args ...interface{}) {
+	s.log(slog.LevelError, fmt.Sprintf(format, args...))
+}
+
+func (s slogger) Warning(args ...interface{}) {
+	s.log(slog.LevelWarn, fmt.Sprintln(args...))
+}
+
+func (s slogger) Warningf(format string, args ...interface{}) {
+	s.log(slog.LevelWarn, fmt.Sprintf(format, args...))
+}
+
+func (s slogger) Info(args ...interface{}) {
+	s.log(slog.LevelInfo, fmt.Sprintln(args...))
+}
+
+func (s slogger) Infof(format string, args ...interface{}) {
+	s.log(slog.LevelInfo, fmt.Sprintf(format, args...))
+}
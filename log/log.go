@@ -1,5 +1,12 @@
 package log // import "go.jonnrb.io/egress/log"
 
+import "flag"
+
+var format = flag.String(
+	"log.format",
+	"glog",
+	`Log format: "glog", or "json" or "text" for structured logs on stderr`)
+
 type Log interface {
 	FatalLog
 	ErrorLog
@@ -64,3 +71,32 @@ func Infof(format string, args ...interface{}) {
 func V(level Level) InfoLog {
 	return wrapI{getV(level)}
 }
+
+func get() Log {
+	return getWith(nil)
+}
+
+func getWith(fields []Field) Log {
+	switch *format {
+	case "json", "text":
+		return slogger{h: getSlogHandler(), fields: fields}
+	default:
+		return glogger{fields}
+	}
+}
+
+func getV(level Level) InfoLog {
+	return getVWith(level, nil)
+}
+
+func getVWith(level Level, fields []Field) InfoLog {
+	if !enabled(level) {
+		return emptyI{}
+	}
+	switch *format {
+	case "json", "text":
+		return slogger{h: getSlogHandler(), fields: fields, verbosity: level}
+	default:
+		return glogger{fields}
+	}
+}
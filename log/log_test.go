@@ -0,0 +1,69 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	*format = "json"
+	defer func() { *format = "glog" }()
+	slogOnce.Do(func() {
+		slogHandler = newSlogHandler(&buf, false)
+	})
+	if slogHandler == nil {
+		t.Fatal("slog handler was already initialized")
+	}
+	if err := flag.Set("v", "2"); err != nil {
+		t.Fatal(err)
+	}
+	defer flag.Set("v", "0")
+
+	With(Link("eth1"), LeaseIP(net.IPv4(203, 0, 113, 5))).Warningf("no offer: %v", "timeout")
+	V(2).Info("verbose")
+	V(3).Info("too verbose")
+
+	var msgs []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("bad JSON line %q: %v", line, err)
+		}
+		msgs = append(msgs, m)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages; got %d:\n%s", len(msgs), buf.String())
+	}
+
+	m := msgs[0]
+	for k, want := range map[string]interface{}{
+		"level":    "WARNING",
+		"msg":      "no offer: timeout",
+		"link":     "eth1",
+		"lease_ip": "203.0.113.5",
+	} {
+		if m[k] != want {
+			t.Errorf("expected %s=%v; got %v", k, want, m[k])
+		}
+	}
+	src, _ := m["source"].(map[string]interface{})
+	if file, _ := src["file"].(string); !strings.HasSuffix(file, "log_test.go") {
+		t.Errorf("expected source to be the caller; got %v", m["source"])
+	}
+
+	if m := msgs[1]; m["msg"] != "verbose" || m["level"] != "INFO" || m["v"] != float64(2) {
+		t.Errorf("unexpected verbose message: %v", m)
+	}
+}
+
+func TestFormatFields(t *testing.T) {
+	got := formatFields([]Field{Leader("egress-0"), Rule("-A FORWARD -j ACCEPT")})
+	if want := " [leader=egress-0 rule=-A FORWARD -j ACCEPT]"; got != want {
+		t.Errorf("expected %q; got %q", want, got)
+	}
+}
@@ -0,0 +1,438 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ti-mo/conntrack"
+	"go.jonnrb.io/egress/acct"
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/log"
+	"go.jonnrb.io/egress/vaddr/dhcp"
+	"golang.zx2c4.com/wireguard/wgctrl"
+)
+
+// A link to export stats for.
+type Link struct {
+	Name string
+	// What the link is used for (e.g. "lan" or "uplink").
+	Role string
+}
+
+var (
+	netDevLabels = []string{"interface", "role"}
+
+	// Maps /proc/net/dev columns to metric names.
+	netDevCounters = map[string]*prometheus.Desc{}
+)
+
+func init() {
+	for dir, verb := range map[string]string{
+		"receive":  "received",
+		"transmit": "transmitted",
+	} {
+		for col, name := range map[string]string{
+			"bytes":   "bytes",
+			"packets": "packets",
+			"errs":    "errors",
+			"drop":    "drops",
+		} {
+			netDevCounters[dir+"_"+col] = prometheus.NewDesc(
+				"network_"+dir+"_"+name+"_total",
+				"Counter of "+name+" "+verb+" on an interface.",
+				netDevLabels, nil)
+		}
+	}
+}
+
+var (
+	conntrackEntries = prometheus.NewDesc(
+		"conntrack_entries",
+		"Number of entries in the conntrack table.",
+		nil, nil)
+	conntrackEntriesLimit = prometheus.NewDesc(
+		"conntrack_entries_limit",
+		"Maximum size of the conntrack table.",
+		nil, nil)
+	conntrackNATEntries = prometheus.NewDesc(
+		"conntrack_nat_entries",
+		"Number of conntrack entries being NAT'd, by type (recounted at most once a minute).",
+		[]string{"type"}, nil)
+	conntrackInsertFailed = prometheus.NewDesc(
+		"conntrack_insert_failed_total",
+		"Counter of conntrack entries that could not be inserted.",
+		nil, nil)
+	conntrackDrops = prometheus.NewDesc(
+		"conntrack_drops_total",
+		"Counter of packets dropped due to conntrack failures.",
+		nil, nil)
+	conntrackEarlyDrops = prometheus.NewDesc(
+		"conntrack_early_drops_total",
+		"Counter of conntrack entries dropped to make room when the table was full.",
+		nil, nil)
+
+	ruleLabels  = []string{"table", "chain", "rule"}
+	rulePackets = prometheus.NewDesc(
+		"iptables_rule_packets_total",
+		"Counter of packets matched by an egress-managed iptables rule.",
+		ruleLabels, nil)
+	ruleBytes = prometheus.NewDesc(
+		"iptables_rule_bytes_total",
+		"Counter of bytes matched by an egress-managed iptables rule.",
+		ruleLabels, nil)
+
+	leaseLabels = []string{"interface"}
+	leaseExpiry = prometheus.NewDesc(
+		"dhcp_lease_expiry_seconds",
+		"Seconds until the DHCP lease on an interface expires.",
+		leaseLabels, nil)
+	leaseRenewal = prometheus.NewDesc(
+		"dhcp_lease_renewal_seconds",
+		"Seconds until the DHCP lease on an interface should be renewed.",
+		leaseLabels, nil)
+	leaseRebinding = prometheus.NewDesc(
+		"dhcp_lease_rebinding_seconds",
+		"Seconds until the DHCP lease on an interface should be rebound.",
+		leaseLabels, nil)
+
+	clientLabels      = []string{"client", "mac"}
+	clientUploadBytes = prometheus.NewDesc(
+		"lan_client_upload_bytes_total",
+		"Counter of bytes sent out the uplink by a LAN client.",
+		clientLabels, nil)
+	clientUploadPackets = prometheus.NewDesc(
+		"lan_client_upload_packets_total",
+		"Counter of packets sent out the uplink by a LAN client.",
+		clientLabels, nil)
+	clientDownloadBytes = prometheus.NewDesc(
+		"lan_client_download_bytes_total",
+		"Counter of bytes received from the uplink by a LAN client.",
+		clientLabels, nil)
+	clientDownloadPackets = prometheus.NewDesc(
+		"lan_client_download_packets_total",
+		"Counter of packets received from the uplink by a LAN client.",
+		clientLabels, nil)
+	// Usage by LAN clients beyond the top ones is summed. The sum isn't a
+	// counter since it drops whenever a client joins the top ones.
+	otherClientsLabels = []string{"direction"}
+	otherClientsBytes  = prometheus.NewDesc(
+		"lan_other_clients_bytes",
+		"Bytes sent (upload) or received (download) over the uplink by LAN clients beyond the top ones.",
+		otherClientsLabels, nil)
+	otherClientsPackets = prometheus.NewDesc(
+		"lan_other_clients_packets",
+		"Packets sent (upload) or received (download) over the uplink by LAN clients beyond the top ones.",
+		otherClientsLabels, nil)
+	clientCount = prometheus.NewDesc(
+		"lan_clients",
+		"Number of LAN clients being accounted for.",
+		nil, nil)
+
+	wgPeerLabels       = []string{"interface", "peer"}
+	wgPeerReceiveBytes = prometheus.NewDesc(
+		"wireguard_peer_receive_bytes_total",
+		"Counter of bytes received from a WireGuard peer.",
+		wgPeerLabels, nil)
+	wgPeerTransmitBytes = prometheus.NewDesc(
+		"wireguard_peer_transmit_bytes_total",
+		"Counter of bytes transmitted to a WireGuard peer.",
+		wgPeerLabels, nil)
+	wgPeerLastHandshake = prometheus.NewDesc(
+		"wireguard_peer_last_handshake_timestamp_seconds",
+		"Unix time of the last handshake with a WireGuard peer (0 if none).",
+		wgPeerLabels, nil)
+)
+
+// Reads stats from the kernel when metrics are collected rather than on an
+// interval, so counters are as fresh as possible.
+type collector struct {
+	links      []Link
+	chains     []fw.Chain
+	clients    func() []acct.Client
+	topClients int
+	wireGuard  []string
+
+	mu     sync.Mutex
+	leases map[string]dhcp.Lease
+
+	nat natCounts
+}
+
+// How long counts of NAT'd conntrack entries are reused. Counting them takes a
+// dump of the whole conntrack table, which is too much for every scrape of a
+// busy router.
+const natCountTTL = time.Minute
+
+// Caches the counts of NAT'd conntrack entries.
+type natCounts struct {
+	mu         sync.Mutex
+	at         time.Time
+	snat, dnat int
+
+	// Dependencies, overridden in tests.
+	count func(*conntrack.Conn) (snat, dnat int, err error)
+	now   func() time.Time
+}
+
+func (n *natCounts) get(conn *conntrack.Conn) (snat, dnat int, err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	if n.now != nil {
+		now = n.now()
+	}
+	if !n.at.IsZero() && now.Sub(n.at) < natCountTTL {
+		return n.snat, n.dnat, nil
+	}
+
+	count := countNAT
+	if n.count != nil {
+		count = n.count
+	}
+	snat, dnat, err = count(conn)
+	if err != nil {
+		return 0, 0, err
+	}
+	n.at, n.snat, n.dnat = now, snat, dnat
+	return snat, dnat, nil
+}
+
+func countNAT(conn *conntrack.Conn) (snat, dnat int, err error) {
+	flows, err := conn.Dump(nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, f := range flows {
+		if f.Status.SrcNAT() {
+			snat++
+		}
+		if f.Status.DstNAT() {
+			dnat++
+		}
+	}
+	return snat, dnat, nil
+}
+
+func (c *collector) observeLease(link string, l dhcp.Lease) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.leases == nil {
+		c.leases = make(map[string]dhcp.Lease)
+	}
+	c.leases[link] = l
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range netDevCounters {
+		ch <- d
+	}
+	for _, d := range []*prometheus.Desc{
+		conntrackEntries, conntrackEntriesLimit, conntrackNATEntries,
+		conntrackInsertFailed, conntrackDrops, conntrackEarlyDrops,
+		rulePackets, ruleBytes,
+		leaseExpiry, leaseRenewal, leaseRebinding,
+		clientUploadBytes, clientUploadPackets,
+		clientDownloadBytes, clientDownloadPackets,
+		otherClientsBytes, otherClientsPackets, clientCount,
+		wgPeerReceiveBytes, wgPeerTransmitBytes, wgPeerLastHandshake,
+	} {
+		ch <- d
+	}
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	c.collectNetDev(ch)
+	c.collectConntrack(ch)
+	c.collectRules(ch)
+	c.collectLeases(ch)
+	c.collectClients(ch)
+	c.collectWireGuard(ch)
+}
+
+func (c *collector) collectNetDev(ch chan<- prometheus.Metric) {
+	if len(c.links) == 0 {
+		return
+	}
+	stats, err := getNetDevStats()
+	if err != nil {
+		log.Errorf("error scraping network stats: %v", err)
+		return
+	}
+	for _, l := range c.links {
+		ifaceStats, ok := stats[l.Name]
+		if !ok {
+			log.V(2).Infof("iface %q not found in kernel network stats table", l.Name)
+			continue
+		}
+		for col, d := range netDevCounters {
+			v, ok := ifaceStats[col]
+			if !ok {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(
+				d, prometheus.CounterValue, float64(v), l.Name, l.Role)
+		}
+	}
+}
+
+func (c *collector) collectConntrack(ch chan<- prometheus.Metric) {
+	conn, err := conntrack.Dial(nil)
+	if err != nil {
+		log.Errorf("error dialing conntrack: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	if g, err := conn.StatsGlobal(); err != nil {
+		log.Errorf("error getting conntrack table stats: %v", err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(
+			conntrackEntries, prometheus.GaugeValue, float64(g.Entries))
+		ch <- prometheus.MustNewConstMetric(
+			conntrackEntriesLimit, prometheus.GaugeValue, float64(g.MaxEntries))
+	}
+
+	if stats, err := conn.Stats(); err != nil {
+		log.Errorf("error getting conntrack stats: %v", err)
+	} else {
+		var insertFailed, drops, earlyDrops uint64
+		for _, s := range stats {
+			insertFailed += uint64(s.InsertFailed)
+			drops += uint64(s.Drop)
+			earlyDrops += uint64(s.EarlyDrop)
+		}
+		ch <- prometheus.MustNewConstMetric(
+			conntrackInsertFailed, prometheus.CounterValue, float64(insertFailed))
+		ch <- prometheus.MustNewConstMetric(
+			conntrackDrops, prometheus.CounterValue, float64(drops))
+		ch <- prometheus.MustNewConstMetric(
+			conntrackEarlyDrops, prometheus.CounterValue, float64(earlyDrops))
+	}
+
+	snat, dnat, err := c.nat.get(conn)
+	if err != nil {
+		log.Errorf("error dumping conntrack table: %v", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(
+		conntrackNATEntries, prometheus.GaugeValue, float64(snat), "snat")
+	ch <- prometheus.MustNewConstMetric(
+		conntrackNATEntries, prometheus.GaugeValue, float64(dnat), "dnat")
+}
+
+func (c *collector) collectRules(ch chan<- prometheus.Metric) {
+	if len(c.chains) == 0 {
+		return
+	}
+	counters, err := fw.ReadRuleCounters(c.chains)
+	if err != nil {
+		log.Errorf("error reading iptables counters: %v", err)
+		return
+	}
+	for _, rc := range counters {
+		labels := []string{rc.Chain.Table, rc.Chain.Name, rc.Rule}
+		ch <- prometheus.MustNewConstMetric(
+			rulePackets, prometheus.CounterValue, float64(rc.Packets), labels...)
+		ch <- prometheus.MustNewConstMetric(
+			ruleBytes, prometheus.CounterValue, float64(rc.Bytes), labels...)
+	}
+}
+
+func (c *collector) collectLeases(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for link, l := range c.leases {
+		for d, after := range map[*prometheus.Desc]time.Duration{
+			leaseExpiry:    l.Duration,
+			leaseRenewal:   l.RenewAfter,
+			leaseRebinding: l.RebindAfter,
+		} {
+			ch <- prometheus.MustNewConstMetric(
+				d, prometheus.GaugeValue,
+				l.StartTime.Add(after).Sub(now).Seconds(), link)
+		}
+	}
+}
+
+func (c *collector) collectClients(ch chan<- prometheus.Metric) {
+	if c.clients == nil {
+		return
+	}
+	cs := c.clients()
+	ch <- prometheus.MustNewConstMetric(
+		clientCount, prometheus.GaugeValue, float64(len(cs)))
+
+	var other acct.Client
+	for i, cl := range cs {
+		if i < c.topClients {
+			for d, v := range map[*prometheus.Desc]uint64{
+				clientUploadBytes:     cl.UploadBytes,
+				clientUploadPackets:   cl.UploadPackets,
+				clientDownloadBytes:   cl.DownloadBytes,
+				clientDownloadPackets: cl.DownloadPackets,
+			} {
+				ch <- prometheus.MustNewConstMetric(
+					d, prometheus.CounterValue, float64(v), cl.IP.String(), cl.MAC)
+			}
+			continue
+		}
+		other.UploadBytes += cl.UploadBytes
+		other.UploadPackets += cl.UploadPackets
+		other.DownloadBytes += cl.DownloadBytes
+		other.DownloadPackets += cl.DownloadPackets
+	}
+
+	for _, m := range []struct {
+		d         *prometheus.Desc
+		v         uint64
+		direction string
+	}{
+		{otherClientsBytes, other.UploadBytes, "upload"},
+		{otherClientsPackets, other.UploadPackets, "upload"},
+		{otherClientsBytes, other.DownloadBytes, "download"},
+		{otherClientsPackets, other.DownloadPackets, "download"},
+	} {
+		ch <- prometheus.MustNewConstMetric(
+			m.d, prometheus.GaugeValue, float64(m.v), m.direction)
+	}
+}
+
+func (c *collector) collectWireGuard(ch chan<- prometheus.Metric) {
+	if len(c.wireGuard) == 0 {
+		return
+	}
+	wg, err := wgctrl.New()
+	if err != nil {
+		log.V(2).Infof("metrics: could not open wgctrl: %v", err)
+		return
+	}
+	defer wg.Close()
+
+	for _, name := range c.wireGuard {
+		dev, err := wg.Device(name)
+		if err != nil {
+			log.With(log.Link(name)).V(2).Infof("metrics: could not get wireguard device: %v", err)
+			continue
+		}
+		for _, p := range dev.Peers {
+			peer := p.PublicKey.String()
+			ch <- prometheus.MustNewConstMetric(
+				wgPeerReceiveBytes, prometheus.CounterValue,
+				float64(p.ReceiveBytes), name, peer)
+			ch <- prometheus.MustNewConstMetric(
+				wgPeerTransmitBytes, prometheus.CounterValue,
+				float64(p.TransmitBytes), name, peer)
+			var last float64
+			if !p.LastHandshakeTime.IsZero() {
+				last = float64(p.LastHandshakeTime.Unix())
+			}
+			ch <- prometheus.MustNewConstMetric(
+				wgPeerLastHandshake, prometheus.GaugeValue, last, name, peer)
+		}
+	}
+}
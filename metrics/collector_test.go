@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/ti-mo/conntrack"
+	"go.jonnrb.io/egress/acct"
+)
+
+func TestNATCounts_cached(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	var dumps int
+	n := natCounts{
+		count: func(*conntrack.Conn) (int, int, error) {
+			dumps++
+			return dumps, 0, nil
+		},
+		now: func() time.Time { return now },
+	}
+
+	for _, want := range []int{1, 1} {
+		if snat, _, _ := n.get(nil); snat != want {
+			t.Errorf("expected %d SNAT entries; got %d", want, snat)
+		}
+		now = now.Add(natCountTTL / 2)
+	}
+	now = now.Add(natCountTTL)
+	if snat, _, _ := n.get(nil); snat != 2 {
+		t.Errorf("expected the counts to be refreshed after %v; got %d dumps", natCountTTL, dumps)
+	}
+}
+
+func TestCollectClients_otherIsGauge(t *testing.T) {
+	c := &collector{
+		topClients: 1,
+		clients: func() []acct.Client {
+			return []acct.Client{
+				{IP: net.IPv4(10, 0, 0, 2), UploadBytes: 300},
+				{IP: net.IPv4(10, 0, 0, 3), UploadBytes: 200},
+				{IP: net.IPv4(10, 0, 0, 4), UploadBytes: 100},
+			}
+		},
+	}
+	ch := make(chan prometheus.Metric, 100)
+	c.collectClients(ch)
+	close(ch)
+
+	var otherUpload float64
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(m.Desc().String(), "lan_other_clients") {
+			continue
+		}
+		if pb.Gauge == nil {
+			t.Errorf("expected %v to be a gauge", m.Desc())
+			continue
+		}
+		if strings.Contains(m.Desc().String(), "bytes") && pb.Label[0].GetValue() == "upload" {
+			otherUpload = pb.Gauge.GetValue()
+		}
+	}
+	if otherUpload != 300 {
+		t.Errorf("expected other clients to have uploaded 300 bytes; got %v", otherUpload)
+	}
+}
@@ -8,8 +8,12 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.jonnrb.io/egress/acct"
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/fw/rules"
 	"go.jonnrb.io/egress/ha"
 	"go.jonnrb.io/egress/log"
+	"go.jonnrb.io/egress/vaddr/dhcp"
 )
 
 var (
@@ -17,18 +21,45 @@ var (
 		"metrics.scrape_interval",
 		5*time.Second,
 		"How often to scrape metrics from the kernel.")
+	topClients = flag.Int(
+		"metrics.top_clients",
+		10,
+		"How many of the heaviest LAN clients to export usage for individually (the rest are summed in lan_other_clients_* gauges).")
 )
 
 type Config struct {
 	UplinkName string
 	HAHandler  func(m ha.Member)
+
+	// If non-nil, is passed a func to call with how long it took to activate
+	// each time this node becomes the leader.
+	ActivationHandler func(observe func(d time.Duration))
+
+	// Links to export interface counters for.
+	Links []Link
+
+	// The rules applied by egress. Counters are exported for the rules in the
+	// chains these rules use.
+	Rules rules.RuleSet
+
+	// If non-nil, is passed a func to call with each DHCP lease bound on a
+	// link.
+	LeaseHandler func(observe func(link string, l dhcp.Lease))
+
+	// If non-nil, returns the LAN clients being accounted for, heaviest
+	// users first.
+	Clients func() []acct.Client
+
+	// WireGuard devices to export per-peer stats for.
+	WireGuard []string
 }
 
 type metrics struct {
-	receiveBytes  prometheus.Gauge
-	transmitBytes prometheus.Gauge
-	isLeader      prometheus.Gauge
-	isFollower    prometheus.Gauge
+	receiveBytes      prometheus.Gauge
+	transmitBytes     prometheus.Gauge
+	isLeader          prometheus.Gauge
+	isFollower        prometheus.Gauge
+	leaderActivations prometheus.Histogram
 }
 
 // Returns a metrics handler that will scrape metrics during ctx.
@@ -50,6 +81,11 @@ func New(ctx context.Context, cfg Config) (http.Handler, error) {
 			Name: "is_follower",
 			Help: "Reports if the current node is a follower in an HA deployment.",
 		}),
+		leaderActivations: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "leader_activation_seconds",
+			Help:    "Time taken to bring up virtual addresses after becoming the leader.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+		}),
 	}
 
 	r := prometheus.NewRegistry()
@@ -65,16 +101,51 @@ func New(ctx context.Context, cfg Config) (http.Handler, error) {
 	if err := r.Register(m.isFollower); err != nil {
 		return nil, err
 	}
+	if err := r.Register(m.leaderActivations); err != nil {
+		return nil, err
+	}
+	c := &collector{
+		links:      cfg.Links,
+		chains:     ruleChains(cfg.Rules),
+		clients:    cfg.Clients,
+		topClients: *topClients,
+		wireGuard:  cfg.WireGuard,
+	}
+	if err := r.Register(c); err != nil {
+		return nil, err
+	}
 
 	if cfg.HAHandler != nil {
 		cfg.HAHandler(haObserver(m))
 	}
+	if cfg.ActivationHandler != nil {
+		cfg.ActivationHandler(func(d time.Duration) {
+			m.leaderActivations.Observe(d.Seconds())
+		})
+	}
+
+	if cfg.LeaseHandler != nil {
+		cfg.LeaseHandler(c.observeLease)
+	}
 
 	go scrapeOnInterval(ctx, m, cfg.UplinkName)
 
 	return promhttp.HandlerFor(r, promhttp.HandlerOpts{}), nil
 }
 
+// The chains to export rule counters for. Per-client accounting rules are
+// exported by client instead, so their chain is left out to keep cardinality
+// bounded.
+func ruleChains(rs rules.RuleSet) []fw.Chain {
+	var chains []fw.Chain
+	for _, c := range fw.ManagedChains(rs) {
+		if c.Name != acct.Chain {
+			chains = append(chains, c)
+		}
+	}
+	return chains
+}
+
 func scrapeOnInterval(ctx context.Context, m metrics, uplinkName string) {
 	log.V(2).Infof("scraping metrics every %v", *metricScrapeInterval)
 
@@ -105,12 +176,12 @@ func (m haObserver) Lead(ctx context.Context, _ func(time.Duration) error) error
 }
 
 func (m haObserver) Follow(ctx context.Context, leader string) error {
-	log.V(2).Infof("setting metric noting that %q became the leader", leader)
+	log.With(log.Leader(leader)).V(2).Info("setting metric noting that the leader changed")
 	m.isFollower.Inc()
 
 	<-ctx.Done()
 
-	log.V(2).Infof("setting metric noting that %q is no longer the leader", leader)
+	log.With(log.Leader(leader)).V(2).Info("setting metric noting that the leader is stepping down")
 	m.isFollower.Dec()
 
 	return ctx.Err()
@@ -0,0 +1,62 @@
+package status
+
+import "html/template"
+
+var statusPage = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>egress status</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.5em; text-align: left; }
+pre { background: #f4f4f4; padding: 0.5em; }
+</style>
+</head>
+<body>
+<h1>egress status</h1>
+<p>As of {{.Time.Format "2006-01-02 15:04:05 MST"}}; up {{.Uptime}}.</p>
+
+<h2>Firewall</h2>
+<table>
+<tr><th>LAN</th><td>{{.Firewall.LAN}}</td></tr>
+{{range .Firewall.ExtraLANs}}<tr><th>LAN</th><td>{{.}}</td></tr>
+{{end}}<tr><th>Uplink</th><td>{{.Firewall.Uplink}}</td></tr>
+{{range .Firewall.FlatNetworks}}<tr><th>Flat network</th><td>{{.Subnet}} via {{.Link}}</td></tr>
+{{end}}</table>
+
+<h2>HA</h2>
+<table>
+<tr><th>Enabled</th><td>{{.HA.Enabled}}</td></tr>
+<tr><th>Role</th><td>{{.HA.Role}}</td></tr>
+{{if .HA.Leader}}<tr><th>Leader</th><td>{{.HA.Leader}}</td></tr>
+{{end}}</table>
+
+<h2>Virtual addresses</h2>
+<table>
+<tr><th>Kind</th><th>Description</th><th>State</th><th>Error</th></tr>
+{{range .VAddrs}}<tr><td>{{.Kind}}</td><td>{{.Description}}</td><td>{{.State}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+
+<h2>DHCP leases</h2>
+<table>
+<tr><th>Link</th><th>IP</th><th>Gateway</th><th>Server</th><th>Renews</th><th>Expires</th></tr>
+{{range .Leases}}<tr><td>{{.Link}}</td><td>{{.IP}}</td><td>{{.Gateway}}</td><td>{{.Server}}</td><td>{{.RenewAt.Format "15:04:05"}}</td><td>{{.ExpiresAt.Format "15:04:05"}}</td></tr>
+{{end}}</table>
+
+{{range .Subprocesses}}<h2>Subprocess ({{.Role}})</h2>
+<table>
+<tr><th>Command</th><td>{{range .Args}}{{.}} {{end}}</td></tr>
+<tr><th>PID</th><td>{{.PID}}</td></tr>
+<tr><th>Running</th><td>{{.Running}}{{if .Uptime}} (up {{.Uptime}}){{end}}</td></tr>
+<tr><th>Restarts</th><td>{{.Restarts}}</td></tr>
+{{if .Error}}<tr><th>Error</th><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Rules</h2>
+<pre>{{range .Rules}}{{.}}
+{{end}}</pre>
+</body>
+</html>
+`))
@@ -0,0 +1,53 @@
+package status
+
+import (
+	"net/url"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// Words that mark a flag's value as secret.
+var secretWords = []string{"pass", "secret", "token", "key", "auth", "cred"}
+
+func isSecretFlag(arg string) bool {
+	if !strings.HasPrefix(arg, "-") {
+		return false
+	}
+	arg = strings.ToLower(arg)
+	for _, w := range secretWords {
+		if strings.Contains(arg, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns args with the values of secret-looking flags and any passwords in
+// URLs replaced.
+func redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		switch {
+		case i > 0 && isSecretFlag(args[i-1]) && !strings.Contains(args[i-1], "="):
+			out[i] = redacted
+		case strings.Contains(arg, "=") && isSecretFlag(arg[:strings.Index(arg, "=")]):
+			out[i] = arg[:strings.Index(arg, "=")+1] + redacted
+		default:
+			out[i] = redactURL(arg)
+		}
+	}
+	return out
+}
+
+func redactURL(arg string) string {
+	u, err := url.Parse(arg)
+	if err != nil || u.User == nil {
+		return arg
+	}
+	if _, ok := u.User.Password(); !ok {
+		return arg
+	}
+	u.User = url.UserPassword(u.User.Username(), redacted)
+	return u.String()
+}
@@ -0,0 +1,413 @@
+// Package status serves a read-only view of what egress has set up.
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/fw/rules"
+	"go.jonnrb.io/egress/log"
+	"go.jonnrb.io/egress/vaddr"
+	"go.jonnrb.io/egress/vaddr/dhcp"
+)
+
+type Config struct {
+	FW    fw.Config
+	Rules rules.RuleSet
+
+	// Whether an ha.Coordinator is configured. If so, the Status should be
+	// added to its ha.MemberGroup to follow the HA role.
+	HA bool
+}
+
+// Collects status as things are set up and serves it as JSON or HTML.
+type Status struct {
+	cfg   Config
+	start time.Time
+
+	mu           sync.Mutex
+	role         string
+	leader       string
+	wrappers     []*trackedWrapper
+	actives      []vaddr.Active
+	leases       map[string]dhcp.Lease
+	subprocesses map[string]*subprocess
+}
+
+func New(cfg Config) *Status {
+	role := "standalone"
+	if cfg.HA {
+		role = "none"
+	}
+	return &Status{
+		cfg:          cfg,
+		start:        time.Now(),
+		role:         role,
+		leases:       make(map[string]dhcp.Lease),
+		subprocesses: make(map[string]*subprocess),
+	}
+}
+
+// Replaces the wrappers in s with ones that record their state and notes the
+// actives in s. Must be called before s is used.
+func (st *Status) TrackVAddr(s *vaddr.Suite) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for i, w := range s.Wrappers {
+		tw := &trackedWrapper{w: w, state: "stopped"}
+		st.wrappers = append(st.wrappers, tw)
+		s.Wrappers[i] = tw
+	}
+	st.actives = append(st.actives, s.Actives...)
+}
+
+// Records a DHCP lease bound on link.
+func (st *Status) ObserveLease(link string, l dhcp.Lease) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.leases[link] = l
+}
+
+// Records that the subprocess run for role (e.g. "leader") was started (or
+// restarted).
+func (st *Status) ObserveSubprocessStart(role string, args []string, pid int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var restarts int
+	if p := st.subprocesses[role]; p != nil {
+		restarts = p.restarts + 1
+	}
+	st.subprocesses[role] = &subprocess{
+		args:     args,
+		pid:      pid,
+		started:  time.Now(),
+		restarts: restarts,
+	}
+}
+
+// Records that the subprocess run for role exited.
+func (st *Status) ObserveSubprocessExit(role string, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	p := st.subprocesses[role]
+	if p == nil {
+		return
+	}
+	p.exited = time.Now()
+	p.err = err
+}
+
+// Follows the HA role as an ha.Member.
+func (st *Status) Lead(ctx context.Context, _ func(time.Duration) error) error {
+	st.setRole("leader", "")
+	<-ctx.Done()
+	st.setRole("none", "")
+	return ctx.Err()
+}
+
+func (st *Status) Follow(ctx context.Context, leader string) error {
+	st.setRole("follower", leader)
+	<-ctx.Done()
+	st.setRole("none", "")
+	return ctx.Err()
+}
+
+func (st *Status) setRole(role, leader string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.role, st.leader = role, leader
+}
+
+type trackedWrapper struct {
+	w vaddr.Wrapper
+
+	mu    sync.Mutex
+	state string
+	since time.Time
+	err   error
+}
+
+func (t *trackedWrapper) Start() error {
+	err := t.w.Start()
+	if err != nil {
+		t.set("failed", err)
+	} else {
+		t.set("started", nil)
+	}
+	return err
+}
+
+func (t *trackedWrapper) Stop() error {
+	err := t.w.Stop()
+	t.set("stopped", err)
+	return err
+}
+
+// Stages the wrapped Wrapper if it is a vaddr.Stager so tracking doesn't hide
+// it from vaddr.Stage().
+func (t *trackedWrapper) Stage(ctx context.Context) error {
+	if s, ok := t.w.(vaddr.Stager); ok {
+		return s.Stage(ctx)
+	}
+	return nil
+}
+
+func (t *trackedWrapper) String() string {
+	return describe(t.w)
+}
+
+func (t *trackedWrapper) Unwrap() interface{} {
+	return t.w
+}
+
+func (t *trackedWrapper) set(state string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.state, t.since, t.err = state, time.Now(), err
+}
+
+type subprocess struct {
+	args     []string
+	pid      int
+	started  time.Time
+	exited   time.Time
+	err      error
+	restarts int
+}
+
+// The status as served.
+type Report struct {
+	Time     time.Time      `json:"time"`
+	Uptime   string         `json:"uptime"`
+	Firewall FirewallReport `json:"firewall"`
+	HA       HAReport       `json:"ha"`
+	VAddrs   []VAddrReport  `json:"vaddrs"`
+	Leases   []LeaseReport  `json:"leases"`
+	Rules    []string       `json:"rules"`
+
+	Subprocesses []SubprocessReport `json:"subprocesses,omitempty"`
+}
+
+type FirewallReport struct {
+	LAN          string              `json:"lan"`
+	ExtraLANs    []string            `json:"extra_lans,omitempty"`
+	Uplink       string              `json:"uplink"`
+	FlatNetworks []FlatNetworkReport `json:"flat_networks,omitempty"`
+}
+
+type FlatNetworkReport struct {
+	Link   string `json:"link"`
+	Subnet string `json:"subnet"`
+}
+
+type HAReport struct {
+	Enabled bool   `json:"enabled"`
+	Role    string `json:"role"`
+	Leader  string `json:"leader,omitempty"`
+}
+
+type VAddrReport struct {
+	Kind        string    `json:"kind"`
+	Description string    `json:"description"`
+	State       string    `json:"state"`
+	Since       time.Time `json:"since,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+type LeaseReport struct {
+	Link      string    `json:"link"`
+	IP        string    `json:"ip"`
+	Gateway   string    `json:"gateway,omitempty"`
+	Server    string    `json:"server,omitempty"`
+	DNS       []string  `json:"dns,omitempty"`
+	MTU       int       `json:"mtu,omitempty"`
+	Start     time.Time `json:"start"`
+	RenewAt   time.Time `json:"renew_at"`
+	RebindAt  time.Time `json:"rebind_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type SubprocessReport struct {
+	Role     string    `json:"role"`
+	Args     []string  `json:"args"`
+	PID      int       `json:"pid"`
+	Started  time.Time `json:"started"`
+	Running  bool      `json:"running"`
+	Uptime   string    `json:"uptime,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Restarts int       `json:"restarts"`
+}
+
+// Returns the current status.
+func (st *Status) Report() Report {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	r := Report{
+		Time:   now,
+		Uptime: now.Sub(st.start).Round(time.Second).String(),
+		HA: HAReport{
+			Enabled: st.cfg.HA,
+			Role:    st.role,
+			Leader:  st.leader,
+		},
+		VAddrs: []VAddrReport{},
+		Leases: []LeaseReport{},
+		Rules:  []string{},
+	}
+
+	if c := st.cfg.FW; c != nil {
+		r.Firewall.LAN = c.LAN().Name()
+		for _, l := range fw.LANs(c)[1:] {
+			r.Firewall.ExtraLANs = append(r.Firewall.ExtraLANs, l.Name())
+		}
+		r.Firewall.Uplink = c.Uplink().Name()
+		for _, n := range c.FlatNetworks() {
+			r.Firewall.FlatNetworks = append(r.Firewall.FlatNetworks, FlatNetworkReport{
+				Link:   n.Link.Name(),
+				Subnet: n.Subnet.String(),
+			})
+		}
+	}
+
+	for _, t := range st.wrappers {
+		t.mu.Lock()
+		vr := VAddrReport{
+			Kind:        kind(t.w),
+			Description: describe(t.w),
+			State:       t.state,
+			Since:       t.since,
+		}
+		if t.err != nil {
+			vr.Error = t.err.Error()
+		}
+		t.mu.Unlock()
+		r.VAddrs = append(r.VAddrs, vr)
+	}
+	for _, a := range st.actives {
+		s, ok := a.(interface{ State() string })
+		if !ok {
+			continue
+		}
+		r.VAddrs = append(r.VAddrs, VAddrReport{
+			Kind:        kind(a),
+			Description: describe(a),
+			State:       s.State(),
+		})
+	}
+
+	var links []string
+	for link := range st.leases {
+		links = append(links, link)
+	}
+	sort.Strings(links)
+	for _, link := range links {
+		l := st.leases[link]
+		lr := LeaseReport{
+			Link:      link,
+			IP:        fmt.Sprintf("%v/%d", l.LeasedIP, l.SubnetMask),
+			MTU:       l.MTU,
+			Start:     l.StartTime,
+			RenewAt:   l.StartTime.Add(l.RenewAfter),
+			RebindAt:  l.StartTime.Add(l.RebindAfter),
+			ExpiresAt: l.StartTime.Add(l.Duration),
+		}
+		if l.GatewayIP != nil {
+			lr.Gateway = l.GatewayIP.String()
+		}
+		if l.ServerIP != nil {
+			lr.Server = l.ServerIP.String()
+		}
+		for _, ip := range l.DNS {
+			lr.DNS = append(lr.DNS, ip.String())
+		}
+		r.Leases = append(r.Leases, lr)
+	}
+
+	for _, rule := range st.cfg.Rules {
+		r.Rules = append(r.Rules, string(rule))
+	}
+
+	var roles []string
+	for role := range st.subprocesses {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		p := st.subprocesses[role]
+		sr := SubprocessReport{
+			Role:     role,
+			Args:     redactArgs(p.args),
+			PID:      p.pid,
+			Started:  p.started,
+			Running:  p.exited.IsZero(),
+			Restarts: p.restarts,
+		}
+		if sr.Running {
+			sr.Uptime = now.Sub(p.started).Round(time.Second).String()
+		}
+		if p.err != nil {
+			sr.Error = p.err.Error()
+		}
+		r.Subprocesses = append(r.Subprocesses, sr)
+	}
+	return r
+}
+
+func kind(v interface{}) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", v), "*")
+}
+
+// Describes a virtual address by its String() method, falling back to its
+// type. Its fields are never printed since they may hold secrets.
+func describe(v interface{}) string {
+	if s, ok := v.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return kind(v)
+}
+
+// Serves the status as HTML to browsers and as JSON otherwise. The format can
+// be forced with the "format" query parameter.
+func (st *Status) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+		if strings.Contains(r.Header.Get("Accept"), "text/html") {
+			format = "html"
+		}
+	}
+
+	rep := st.Report()
+	var err error
+	switch format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(rep)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = statusPage.Execute(w, rep)
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.V(2).Infof("status: error writing status: %v", err)
+	}
+}
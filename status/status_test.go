@@ -0,0 +1,290 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/fw/rules"
+	"go.jonnrb.io/egress/tracing"
+	"go.jonnrb.io/egress/vaddr"
+	"go.jonnrb.io/egress/vaddr/dhcp"
+	"go.jonnrb.io/egress/vaddr/wireguard"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+type fakeConfig struct{}
+
+func (fakeConfig) LAN() fw.Link    { return fw.LinkString("eth0") }
+func (fakeConfig) Uplink() fw.Link { return fw.LinkString("eth1") }
+func (fakeConfig) FlatNetworks() []fw.StaticRoute {
+	a, _ := fw.ParseAddr("10.1.0.0/16")
+	return []fw.StaticRoute{{Link: fw.LinkString("eth2"), Subnet: a}}
+}
+func (fakeConfig) ExtraRules() rules.RuleSet { return nil }
+
+func newTestStatus() *Status {
+	return New(Config{
+		FW:    fakeConfig{},
+		Rules: rules.RuleSet{"-t filter -A FORWARD -j fw-open"},
+		HA:    true,
+	})
+}
+
+func TestReport(t *testing.T) {
+	st := newTestStatus()
+
+	s := vaddr.Suite{
+		Wrappers: []vaddr.Wrapper{
+			vaddr.WrapperStruct{
+				StartFunc: func() error { return nil },
+				StopFunc:  func() error { return nil },
+			},
+			vaddr.WrapperStruct{
+				StartFunc: func() error { return errors.New("no such device") },
+				StopFunc:  func() error { return nil },
+			},
+		},
+		Actives: []vaddr.Active{&dhcp.VAddr{Link: fw.LinkString("eth1")}},
+	}
+	st.TrackVAddr(&s)
+	s.Wrappers[0].Start()
+	s.Wrappers[1].Start()
+
+	start := time.Unix(1600000000, 0)
+	st.ObserveLease("eth1", dhcp.Lease{
+		LeasedIP:   net.IPv4(203, 0, 113, 5),
+		SubnetMask: 24,
+		GatewayIP:  net.IPv4(203, 0, 113, 1),
+		StartTime:  start,
+		Duration:   time.Hour,
+		RenewAfter: 30 * time.Minute,
+	})
+
+	r := st.Report()
+
+	if diff := cmp.Diff(FirewallReport{
+		LAN:          "eth0",
+		Uplink:       "eth1",
+		FlatNetworks: []FlatNetworkReport{{Link: "eth2", Subnet: "10.1.0.0/16"}},
+	}, r.Firewall); diff != "" {
+		t.Errorf("firewall mismatch (-want +got):\n%s", diff)
+	}
+	if r.HA.Role != "none" || !r.HA.Enabled {
+		t.Errorf("unexpected HA status: %+v", r.HA)
+	}
+	var states []string
+	for _, v := range r.VAddrs {
+		states = append(states, v.State)
+	}
+	if diff := cmp.Diff([]string{"started", "failed", "STOPPED"}, states); diff != "" {
+		t.Errorf("vaddr states mismatch (-want +got):\n%s", diff)
+	}
+	if r.VAddrs[1].Error != "no such device" {
+		t.Errorf("expected start error to be reported; got %q", r.VAddrs[1].Error)
+	}
+	if len(r.Leases) != 1 || r.Leases[0].IP != "203.0.113.5/24" ||
+		!r.Leases[0].RenewAt.Equal(start.Add(30*time.Minute)) {
+		t.Errorf("unexpected leases: %+v", r.Leases)
+	}
+	if diff := cmp.Diff([]string{"-t filter -A FORWARD -j fw-open"}, r.Rules); diff != "" {
+		t.Errorf("rules mismatch (-want +got):\n%s", diff)
+	}
+	if len(r.Subprocesses) != 0 {
+		t.Errorf("expected no subprocesses; got %+v", r.Subprocesses)
+	}
+}
+
+func TestReport_haRole(t *testing.T) {
+	st := newTestStatus()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		st.Follow(ctx, "egress-1")
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for st.Report().HA.Role != "follower" {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting to follow")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if l := st.Report().HA.Leader; l != "egress-1" {
+		t.Errorf("expected leader %q; got %q", "egress-1", l)
+	}
+
+	cancel()
+	<-done
+	if r := st.Report().HA.Role; r != "none" {
+		t.Errorf("expected role %q after stepping down; got %q", "none", r)
+	}
+}
+
+func TestReport_subprocess(t *testing.T) {
+	st := newTestStatus()
+	st.ObserveSubprocessStart("leader", []string{"openvpn", "--auth-pass", "hunter2"}, 42)
+
+	r := st.Report()
+	if len(r.Subprocesses) != 1 {
+		t.Fatalf("expected 1 subprocess; got %+v", r.Subprocesses)
+	}
+	if p := r.Subprocesses[0]; p.Role != "leader" || p.PID != 42 || !p.Running {
+		t.Fatalf("unexpected subprocess: %+v", p)
+	}
+	if diff := cmp.Diff([]string{"openvpn", "--auth-pass", redacted}, r.Subprocesses[0].Args); diff != "" {
+		t.Errorf("args mismatch (-want +got):\n%s", diff)
+	}
+
+	st.ObserveSubprocessExit("leader", errors.New("exit status 1"))
+	r = st.Report()
+	if p := r.Subprocesses[0]; p.Running || p.Error != "exit status 1" {
+		t.Errorf("expected subprocess to have exited; got %+v", p)
+	}
+
+	st.ObserveSubprocessStart("leader", []string{"openvpn"}, 43)
+	st.ObserveSubprocessStart("always", []string{"sshd"}, 44)
+	r = st.Report()
+	if len(r.Subprocesses) != 2 || r.Subprocesses[0].Role != "always" {
+		t.Fatalf("expected subprocesses sorted by role; got %+v", r.Subprocesses)
+	}
+	if p := r.Subprocesses[1]; !p.Running || p.PID != 43 || p.Restarts != 1 {
+		t.Errorf("expected subprocess to have restarted; got %+v", p)
+	}
+}
+
+type keyedWrapper struct {
+	vaddr.WrapperStruct
+	PrivateKey string
+}
+
+func TestDescribe_noFields(t *testing.T) {
+	got := describe(&keyedWrapper{PrivateKey: "c2VjcmV0"})
+	if got != "status.keyedWrapper" {
+		t.Errorf("expected a wrapper without String() to be described by its type; got %q", got)
+	}
+}
+
+type stagedWrapper struct {
+	vaddr.WrapperStruct
+	staged int
+}
+
+func (w *stagedWrapper) Stage(context.Context) error {
+	w.staged++
+	return nil
+}
+
+func (w *stagedWrapper) String() string { return "staged wrapper" }
+
+func TestTrackVAddr_keepsStagers(t *testing.T) {
+	st := newTestStatus()
+	w := &stagedWrapper{}
+	s := vaddr.Suite{Wrappers: []vaddr.Wrapper{w, &keyedWrapper{}}}
+	st.TrackVAddr(&s)
+
+	if err := vaddr.Stage(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if w.staged != 1 {
+		t.Errorf("expected the tracked wrapper to be staged once; got %d", w.staged)
+	}
+	if got := describe(s.Wrappers[0]); got != "staged wrapper" {
+		t.Errorf("expected the tracked wrapper to be described by what it wraps; got %q", got)
+	}
+	if got := tracing.Type(s.Wrappers[1]).Value.AsString(); got != "status.keyedWrapper" {
+		t.Errorf("expected spans to be typed by the wrapped wrapper; got %q", got)
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	for _, c := range []struct {
+		in, want []string
+	}{
+		{
+			in:   []string{"run", "-v", "3"},
+			want: []string{"run", "-v", "3"},
+		},
+		{
+			in:   []string{"client", "--token", "abc", "--private-key=xyz"},
+			want: []string{"client", "--token", redacted, "--private-key=" + redacted},
+		},
+		{
+			in:   []string{"proxy", "http://user:pw@example.com/"},
+			want: []string{"proxy", "http://user:" + redacted + "@example.com/"},
+		},
+	} {
+		if diff := cmp.Diff(c.want, redactArgs(c.in)); diff != "" {
+			t.Errorf("redactArgs(%q) mismatch (-want +got):\n%s", c.in, diff)
+		}
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	st := newTestStatus()
+
+	w := httptest.NewRecorder()
+	st.ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+	var r Report
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatalf("expected JSON by default: %v", err)
+	}
+	if r.Firewall.Uplink != "eth1" {
+		t.Errorf("unexpected report: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/status", nil)
+	req.Header.Set("Accept", "text/html,*/*")
+	st.ServeHTTP(w, req)
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("expected HTML for browsers; got %q", ct)
+	}
+	if !strings.Contains(w.Body.String(), "-t filter -A FORWARD -j fw-open") {
+		t.Errorf("expected rules in page; got:\n%s", w.Body.String())
+	}
+}
+
+func TestServeHTTP_noWireGuardKeys(t *testing.T) {
+	priv, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	psk, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := newTestStatus()
+	s := vaddr.Suite{Wrappers: []vaddr.Wrapper{&wireguard.Device{
+		Link: fw.LinkString("wg0"),
+		Config: wireguard.Config{
+			PrivateKey: priv,
+			Peers:      []wireguard.Peer{{PublicKey: priv.PublicKey(), PresharedKey: &psk}},
+		},
+	}}}
+	st.TrackVAddr(&s)
+
+	for _, accept := range []string{"application/json", "text/html"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/status", nil)
+		req.Header.Set("Accept", accept)
+		st.ServeHTTP(w, req)
+		body := w.Body.String()
+		if !strings.Contains(body, "wg0") {
+			t.Errorf("expected the device to be described; got:\n%s", body)
+		}
+		for _, k := range []wgtypes.Key{priv, psk} {
+			if strings.Contains(body, k.String()) {
+				t.Errorf("key leaked in %s status:\n%s", accept, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package supervisor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.jonnrb.io/egress/log"
+	"go.jonnrb.io/egress/util"
+)
+
+// How long to wait for the rest of the subprocess's output after it exits.
+// Anything it forked could keep its stdout open indefinitely.
+const outputDrainTimeout = time.Second
+
+func (s *Supervisor) exec(ctx context.Context) (code int, err error) {
+	log.Infof("running %q", strings.Join(s.Args, " "))
+	cmd := exec.Command(s.Args[0], s.Args[1:]...)
+
+	prefix := "[" + s.name() + "] "
+	stdout, stdoutDone, err := prefixOutput(os.Stdout, prefix)
+	if err != nil {
+		return -1, err
+	}
+	stderr, stderrDone, err := prefixOutput(os.Stderr, prefix)
+	if err != nil {
+		stdout.Close()
+		return -1, err
+	}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	err = util.StartChild(cmd)
+	// The child has its own copies now.
+	stdout.Close()
+	stderr.Close()
+	if err != nil {
+		return -1, fmt.Errorf("supervisor: error starting subprocess: %w", err)
+	}
+	if s.OnStart != nil {
+		s.OnStart(s.Args, cmd.Process.Pid)
+	}
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+			return
+		}
+		log.V(2).Infof("supervisor: stopping %q", s.name())
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-time.After(s.stopTimeout()):
+			log.Warningf("supervisor: killing %q after %v", s.name(), s.stopTimeout())
+			cmd.Process.Kill()
+		case <-stopped:
+		}
+	}()
+
+	code, err = util.ReapChildren(cmd.Process)
+	if err != nil {
+		err = fmt.Errorf("supervisor: error waiting for subprocess: %w", err)
+	}
+	if s.OnExit != nil {
+		if err != nil {
+			s.OnExit(err)
+		} else {
+			s.OnExit(exitErr(code))
+		}
+	}
+
+	drain := time.After(outputDrainTimeout)
+	for _, done := range []<-chan struct{}{stdoutDone, stderrDone} {
+		select {
+		case <-done:
+			continue
+		case <-drain:
+		}
+		log.V(2).Infof("supervisor: gave up waiting for the rest of %q's output", s.name())
+		break
+	}
+
+	return code, err
+}
+
+// Returns a file to hand to a subprocess whose output is copied to w with each
+// line prefixed. done is closed once the output is fully copied.
+func prefixOutput(w io.Writer, prefix string) (f *os.File, done <-chan struct{}, err error) {
+	r, f, err := os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("supervisor: could not create pipe: %w", err)
+	}
+	c := make(chan struct{})
+	go func() {
+		defer close(c)
+		defer r.Close()
+		copyPrefixed(w, r, prefix)
+	}()
+	return f, c, nil
+}
+
+func copyPrefixed(w io.Writer, r io.Reader, prefix string) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		fmt.Fprintf(w, "%s%s\n", prefix, sc.Bytes())
+	}
+}
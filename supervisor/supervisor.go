@@ -0,0 +1,272 @@
+// Package supervisor runs a subprocess and restarts it according to a policy.
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.jonnrb.io/egress/log"
+)
+
+// When to restart the subprocess after it exits.
+type Policy int
+
+const (
+	Never Policy = iota
+	OnFailure
+	Always
+)
+
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "never", "":
+		return Never, nil
+	case "on-failure":
+		return OnFailure, nil
+	case "always":
+		return Always, nil
+	default:
+		return Never, fmt.Errorf(
+			"supervisor: unknown restart policy %q (want never, on-failure or always)", s)
+	}
+}
+
+func (p Policy) String() string {
+	switch p {
+	case Never:
+		return "never"
+	case OnFailure:
+		return "on-failure"
+	case Always:
+		return "always"
+	default:
+		return fmt.Sprintf("Policy(%d)", int(p))
+	}
+}
+
+// Returned by Supervisor.Run() when the subprocess exits for good with a
+// nonzero code.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("subprocess exited with code %d", e.Code)
+}
+
+const (
+	defaultBackoff     = time.Second
+	defaultMaxBackoff  = time.Minute
+	defaultResetAfter  = time.Minute
+	defaultFlapWindow  = 5 * time.Minute
+	defaultFlapRestart = 5
+	defaultStopTimeout = 10 * time.Second
+)
+
+// Runs Args until it exits for good, restarting it according to Policy.
+//
+// Restarts back off exponentially from Backoff to MaxBackoff. A run lasting
+// ResetAfter resets the backoff and the count of retries.
+type Supervisor struct {
+	Args   []string
+	Policy Policy
+
+	// How many restarts in a row to allow before giving up. Zero allows
+	// unlimited restarts.
+	MaxRetries int
+
+	// Default to 1 second, 1 minute and 1 minute respectively.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	ResetAfter time.Duration
+
+	// How long the subprocess has to exit after being sent SIGTERM when ctx
+	// is canceled before it is killed. Defaults to 10 seconds.
+	StopTimeout time.Duration
+
+	// If non-nil, called when the subprocess starts and exits.
+	OnStart func(args []string, pid int)
+	OnExit  func(err error)
+
+	// Runs the subprocess once. For tests.
+	runOnce func(ctx context.Context) (code int, err error)
+
+	mu       sync.Mutex
+	restarts []time.Time
+	gaveUp   error
+}
+
+// Runs the supervised subprocess. Returns nil if it exited successfully and
+// isn't to be restarted and an *ExitError if it failed and isn't to be
+// restarted. If ctx is canceled or egress is asked to terminate, the
+// subprocess is stopped (it is sent the same signal when egress is asked to
+// terminate and SIGTERM when ctx is canceled) and not restarted.
+func (s *Supervisor) Run(ctx context.Context) error {
+	if len(s.Args) == 0 {
+		return fmt.Errorf("supervisor: no command")
+	}
+
+	terminating := make(chan os.Signal, 1)
+	signal.Notify(terminating, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(terminating)
+
+	backoff := s.backoff()
+	retries := 0
+	for {
+		started := time.Now()
+		code, err := s.run(ctx)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-terminating:
+			return exitErr(code)
+		default:
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !s.shouldRestart(code) {
+			return exitErr(code)
+		}
+
+		if time.Since(started) >= s.resetAfter() {
+			backoff, retries = s.backoff(), 0
+		}
+		if s.MaxRetries != 0 && retries >= s.MaxRetries {
+			err := exitErr(code)
+			if err == nil {
+				err = fmt.Errorf("supervisor: %q keeps exiting", s.name())
+			}
+			s.setGaveUp(fmt.Errorf("supervisor: gave up after %d restarts: %w", retries, err))
+			log.Errorf("supervisor: giving up on %q after %d restarts", s.name(), retries)
+			return err
+		}
+		retries++
+
+		log.Warningf(
+			"supervisor: %q exited with code %d; restarting in %v", s.name(), code, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-terminating:
+			return exitErr(code)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		s.observeRestart()
+
+		backoff *= 2
+		if max := s.maxBackoff(); backoff > max {
+			backoff = max
+		}
+	}
+}
+
+func exitErr(code int) error {
+	if code == 0 {
+		return nil
+	}
+	return &ExitError{Code: code}
+}
+
+func (s *Supervisor) shouldRestart(code int) bool {
+	switch s.Policy {
+	case Always:
+		return true
+	case OnFailure:
+		return code != 0
+	default:
+		return false
+	}
+}
+
+func (s *Supervisor) run(ctx context.Context) (int, error) {
+	if s.runOnce != nil {
+		return s.runOnce(ctx)
+	}
+	return s.exec(ctx)
+}
+
+// Returns an error if the subprocess is flapping (restarted 5 times in 5
+// minutes) or was given up on.
+func (s *Supervisor) Check(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.gaveUp != nil {
+		return s.gaveUp
+	}
+	n := 0
+	for _, t := range s.restarts {
+		if time.Since(t) < defaultFlapWindow {
+			n++
+		}
+	}
+	if n >= defaultFlapRestart {
+		return fmt.Errorf(
+			"supervisor: %q is flapping (restarted %d times in %v)", s.name(), n, defaultFlapWindow)
+	}
+	return nil
+}
+
+func (s *Supervisor) observeRestart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.restarts = append(s.restarts, now)
+	for len(s.restarts) > 0 && now.Sub(s.restarts[0]) >= defaultFlapWindow {
+		s.restarts = s.restarts[1:]
+	}
+}
+
+func (s *Supervisor) setGaveUp(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.gaveUp = err
+}
+
+func (s *Supervisor) name() string {
+	return filepath.Base(s.Args[0])
+}
+
+func (s *Supervisor) String() string {
+	return fmt.Sprintf("%q (restart %v)", strings.Join(s.Args, " "), s.Policy)
+}
+
+func (s *Supervisor) backoff() time.Duration {
+	if s.Backoff == 0 {
+		return defaultBackoff
+	}
+	return s.Backoff
+}
+
+func (s *Supervisor) maxBackoff() time.Duration {
+	if s.MaxBackoff == 0 {
+		return defaultMaxBackoff
+	}
+	return s.MaxBackoff
+}
+
+func (s *Supervisor) stopTimeout() time.Duration {
+	if s.StopTimeout == 0 {
+		return defaultStopTimeout
+	}
+	return s.StopTimeout
+}
+
+func (s *Supervisor) resetAfter() time.Duration {
+	if s.ResetAfter == 0 {
+		return defaultResetAfter
+	}
+	return s.ResetAfter
+}
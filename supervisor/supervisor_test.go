@@ -0,0 +1,194 @@
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a runOnce that exits with each of codes in turn and then blocks
+// until ctx is done.
+func fakeRuns(codes ...int) (func(context.Context) (int, error), *int) {
+	runs := 0
+	return func(ctx context.Context) (int, error) {
+		runs++
+		if len(codes) == 0 {
+			<-ctx.Done()
+			return 143, nil
+		}
+		code := codes[0]
+		codes = codes[1:]
+		return code, nil
+	}, &runs
+}
+
+func TestRun_policies(t *testing.T) {
+	for _, c := range []struct {
+		policy Policy
+		codes  []int
+		runs   int
+		code   int
+	}{
+		{Never, []int{0}, 1, 0},
+		{Never, []int{3}, 1, 3},
+		{OnFailure, []int{3, 3, 0}, 3, 0},
+		{OnFailure, []int{0, 3}, 1, 0},
+		{Always, []int{0, 3, 0, 1}, 4, 1},
+	} {
+		runOnce, runs := fakeRuns(c.codes...)
+		s := &Supervisor{
+			Args:       []string{"openvpn"},
+			Policy:     c.policy,
+			MaxRetries: 3,
+			Backoff:    time.Millisecond,
+			runOnce:    runOnce,
+		}
+		err := s.Run(context.Background())
+
+		var code int
+		var exitErr *ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.Code
+		} else if err != nil {
+			t.Errorf("%v %v: unexpected error: %v", c.policy, c.codes, err)
+			continue
+		}
+		if code != c.code || *runs != c.runs {
+			t.Errorf("%v %v: expected %d runs and code %d; got %d runs and code %d",
+				c.policy, c.codes, c.runs, c.code, *runs, code)
+		}
+	}
+}
+
+func TestRun_gaveUp(t *testing.T) {
+	runOnce, runs := fakeRuns(1, 1, 1, 1, 1, 1)
+	s := &Supervisor{
+		Args:       []string{"openvpn"},
+		Policy:     Always,
+		MaxRetries: 5,
+		Backoff:    time.Millisecond,
+		runOnce:    runOnce,
+	}
+	if err := s.Check(context.Background()); err != nil {
+		t.Errorf("expected to be healthy before running; got %v", err)
+	}
+
+	var exitErr *ExitError
+	if err := s.Run(context.Background()); !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Errorf("expected exit code 1 after giving up; got %v", err)
+	}
+	if *runs != 6 {
+		t.Errorf("expected 6 runs; got %d", *runs)
+	}
+	if err := s.Check(context.Background()); err == nil {
+		t.Error("expected to be unhealthy after giving up")
+	}
+}
+
+func TestCheck_flapping(t *testing.T) {
+	runOnce, _ := fakeRuns(1, 1, 1, 1, 1)
+	s := &Supervisor{
+		Args:    []string{"openvpn"},
+		Policy:  Always,
+		Backoff: time.Millisecond,
+		runOnce: runOnce,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Check(ctx) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting to be flapping")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected Run() to return context.Canceled; got %v", err)
+	}
+}
+
+func TestExec(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh")
+	}
+
+	var exited error
+	s := &Supervisor{
+		Args:   []string{sh, "-c", "exit 7"},
+		OnExit: func(err error) { exited = err },
+	}
+	var exitErr *ExitError
+	if err := s.Run(context.Background()); !errors.As(err, &exitErr) || exitErr.Code != 7 {
+		t.Errorf("expected exit code 7; got %v", err)
+	}
+	if !errors.As(exited, &exitErr) || exitErr.Code != 7 {
+		t.Errorf("expected OnExit to see exit code 7; got %v", exited)
+	}
+}
+
+func TestCopyPrefixed(t *testing.T) {
+	var b bytes.Buffer
+	copyPrefixed(&b, strings.NewReader("one\ntwo\nthree"), "[openvpn] ")
+	if want := "[openvpn] one\n[openvpn] two\n[openvpn] three\n"; b.String() != want {
+		t.Errorf("expected %q; got %q", want, b.String())
+	}
+}
+
+func TestExec_concurrent(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh")
+	}
+
+	codes := make(chan int, 2)
+	for _, script := range []string{"sleep 0.2; exit 4", "exit 3"} {
+		s := &Supervisor{Args: []string{sh, "-c", script}}
+		go func() {
+			var exitErr *ExitError
+			if err := s.Run(context.Background()); errors.As(err, &exitErr) {
+				codes <- exitErr.Code
+			} else {
+				codes <- -1
+			}
+		}()
+	}
+	if a, b := <-codes, <-codes; a != 3 || b != 4 {
+		t.Errorf("expected each child's own exit code (3 then 4); got %d then %d", a, b)
+	}
+}
+
+func TestExec_stop(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh")
+	}
+
+	started := make(chan struct{})
+	s := &Supervisor{
+		Args:    []string{sh, "-c", "sleep 10"},
+		Policy:  Always,
+		OnStart: func([]string, int) { close(started) },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	<-started
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled; got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the subprocess to be stopped")
+	}
+}
@@ -0,0 +1,114 @@
+// Package tracing sets up OpenTelemetry tracing and has helpers for the spans
+// egress records around startup and failover.
+package tracing
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "go.jonnrb.io/egress"
+
+var exporter = flag.String(
+	"trace.exporter",
+	"",
+	`Where to export traces: "otlp" (configured by the standard OTEL_EXPORTER_OTLP_* environment variables), "stdout", "file:<path>", or empty to disable tracing`)
+
+// Starts a span named name as a child of any span in ctx. Spans are no-ops
+// until Setup() configures an exporter.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Ends span, marking it as failed if err is non-nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Implemented by decorators (e.g. of a vaddr.Wrapper) whose spans should be
+// typed by what they decorate.
+type Unwrapper interface {
+	Unwrap() interface{}
+}
+
+// Returns an attribute naming the type of v (e.g. a vaddr.Wrapper), looking
+// through any Unwrappers.
+func Type(v interface{}) attribute.KeyValue {
+	for {
+		u, ok := v.(Unwrapper)
+		if !ok {
+			break
+		}
+		v = u.Unwrap()
+	}
+	return attribute.String("type", strings.TrimPrefix(fmt.Sprintf("%T", v), "*"))
+}
+
+// Installs the exporter selected by -trace.exporter as the global tracer
+// provider. The returned func flushes and stops the exporter.
+func Setup(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	noop := func(context.Context) error { return nil }
+
+	var exp sdktrace.SpanExporter
+	var closer io.Closer
+	switch e := *exporter; {
+	case e == "":
+		return noop, nil
+	case e == "otlp":
+		exp, err = otlptracehttp.New(ctx)
+	case e == "stdout":
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case strings.HasPrefix(e, "file:"):
+		var f *os.File
+		f, err = os.OpenFile(
+			strings.TrimPrefix(e, "file:"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return noop, fmt.Errorf("tracing: could not open trace file: %w", err)
+		}
+		closer = f
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return noop, fmt.Errorf("tracing: unknown -trace.exporter %q", e)
+	}
+	if err != nil {
+		return noop, fmt.Errorf("tracing: could not create exporter: %w", err)
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(attribute.String("service.name", "egress")))
+	if err != nil {
+		return noop, fmt.Errorf("tracing: could not create resource: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			if errClose := closer.Close(); err == nil {
+				err = errClose
+			}
+		}
+		return err
+	}, nil
+}
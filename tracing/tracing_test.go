@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSetup_file(t *testing.T) {
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+
+	path := filepath.Join(t.TempDir(), "traces.json")
+	*exporter = "file:" + path
+	defer func() { *exporter = "" }()
+
+	shutdown, err := Setup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := Start(context.Background(), "test.Span")
+	End(span, nil)
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"test.Span"`) {
+		t.Errorf("expected span in trace file; got:\n%s", b)
+	}
+}
+
+func TestSetup_unknownExporter(t *testing.T) {
+	*exporter = "carrier-pigeon"
+	defer func() { *exporter = "" }()
+
+	if _, err := Setup(context.Background()); err == nil {
+		t.Error("expected unknown exporter to fail")
+	}
+}
+
+func TestEnd_recordsError(t *testing.T) {
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+
+	_, span := Start(context.Background(), "test.Span", Type(&sdktrace.TracerProvider{}))
+	End(span, errors.New("oops"))
+
+	spans := sr.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span; got %d", len(spans))
+	}
+	s := spans[0]
+	if s.Status().Code != codes.Error || s.Status().Description != "oops" {
+		t.Errorf("expected error status; got %+v", s.Status())
+	}
+	if a := s.Attributes(); len(a) != 1 || a[0].Value.AsString() != "trace.TracerProvider" {
+		t.Errorf("unexpected attributes: %v", a)
+	}
+}
@@ -1,16 +1,149 @@
 package util
 
 import (
+	"bytes"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"go.jonnrb.io/egress/log"
 )
 
-func ReapChildren(child *os.Process) error {
+// How long a child must have been a zombie before the reaper takes it as an
+// orphan. Children started with os/exec are waited on by whoever started them
+// as soon as they exit, so this keeps the reaper from taking their exit
+// statuses even if they weren't started through this package.
+const orphanGrace = time.Second
+
+// Reaps orphans reparented to us as init while leaving the children we start
+// to whoever waits on them. Children started through this package are never
+// reaped here.
+var reaper struct {
+	once  sync.Once
+	mu    sync.Mutex
+	owned map[int]bool
+}
+
+// Starts cmd for ReapChildren() to wait on.
+func StartChild(cmd *exec.Cmd) error {
+	reaper.once.Do(func() { go reapOrphans() })
+
+	reaper.mu.Lock()
+	defer reaper.mu.Unlock()
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if reaper.owned == nil {
+		reaper.owned = make(map[int]bool)
+	}
+	reaper.owned[cmd.Process.Pid] = true
+	return nil
+}
+
+func releaseChild(pid int) {
+	reaper.mu.Lock()
+	defer reaper.mu.Unlock()
+
+	delete(reaper.owned, pid)
+}
+
+// Runs cmd like cmd.Run() while reaping orphans.
+func Run(cmd *exec.Cmd) error {
+	if err := StartChild(cmd); err != nil {
+		return err
+	}
+	defer releaseChild(cmd.Process.Pid)
+	return cmd.Wait()
+}
+
+// Runs cmd like cmd.Run() while reaping orphans, returning its stdout.
+func Output(cmd *exec.Cmd) ([]byte, error) {
+	var b bytes.Buffer
+	cmd.Stdout = &b
+	err := Run(cmd)
+	return b.Bytes(), err
+}
+
+// Runs cmd like cmd.Run() while reaping orphans, returning its stdout and
+// stderr.
+func CombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	var b bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &b, &b
+	err := Run(cmd)
+	return b.Bytes(), err
+}
+
+func reapOrphans() {
+	var seen map[int]bool
+	for range time.Tick(orphanGrace) {
+		seen = reapZombies(seen)
+	}
+}
+
+// Reaps the zombie children in seen that are still zombies and not ours.
+// Returns the zombies to reap next time.
+func reapZombies(seen map[int]bool) map[int]bool {
+	reaper.mu.Lock()
+	defer reaper.mu.Unlock()
+
+	next := make(map[int]bool)
+	for _, pid := range zombieChildren() {
+		switch {
+		case reaper.owned[pid]:
+		case !seen[pid]:
+			next[pid] = true
+		default:
+			var ws syscall.WaitStatus
+			if p, err := syscall.Wait4(pid, &ws, syscall.WNOHANG, nil); err == nil && p == pid {
+				log.V(2).Infof("reaped orphan pid %v", pid)
+			}
+		}
+	}
+	return next
+}
+
+// Lists our children that have exited but not been waited on.
+func zombieChildren() []int {
+	paths, _ := filepath.Glob("/proc/[0-9]*/stat")
+	self := os.Getpid()
+	var pids []int
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		// The fields after the parenthesized command are the state and ppid.
+		s := string(b)
+		fields := strings.Fields(s[strings.LastIndexByte(s, ')')+1:])
+		if len(fields) < 2 || fields[0] != "Z" {
+			continue
+		}
+		if ppid, err := strconv.Atoi(fields[1]); err != nil || ppid != self {
+			continue
+		}
+		if pid, err := strconv.Atoi(filepath.Base(filepath.Dir(p))); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// Waits for child to exit, forwarding all signals to it. Returns the child's
+// exit code, which is 128 plus the signal number if it was killed by a signal.
+// Orphans (e.g. the child's own children, reparented to us as init) are reaped
+// in the background. child should have been started with StartChild().
+func ReapChildren(child *os.Process) (exitCode int, err error) {
+	defer releaseChild(child.Pid)
+
 	// forward all signals to child
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	defer close(c)
 	signal.Notify(c)
 	defer signal.Stop(c)
@@ -22,21 +155,21 @@ func ReapChildren(child *os.Process) error {
 
 	log.V(2).Infof("waiting for child %v to exit; forwarding all signals", child.Pid)
 
-	var wstatus syscall.WaitStatus
-	var err error
-	for pid := -1; pid != child.Pid; {
-		pid, err = syscall.Wait4(-1, &wstatus, 0, nil)
-		if err != nil {
-			return err
-		}
-		log.Infof("reaped pid %v", pid)
+	ps, err := child.Wait()
+	if err != nil {
+		return -1, err
 	}
+	log.Infof("reaped pid %v", child.Pid)
 
-	if exitCode := wstatus.ExitStatus(); exitCode != 0 {
+	exitCode = ps.ExitCode()
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		exitCode = 128 + int(ws.Signal())
+	}
+	if exitCode != 0 {
 		log.Errorf("child exited with code %v", exitCode)
 	} else {
 		log.V(2).Infof("child exited with code %v", exitCode)
 	}
 
-	return nil
+	return exitCode, nil
 }
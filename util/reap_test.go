@@ -0,0 +1,75 @@
+package util
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestReapChildren_leavesOtherChildren(t *testing.T) {
+	cmd := exec.Command("sleep", "0.5")
+	if err := StartChild(cmd); err != nil {
+		t.Skipf("could not start sleep: %v", err)
+	}
+	type result struct {
+		code int
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		code, err := ReapChildren(cmd.Process)
+		done <- result{code, err}
+	}()
+
+	// Commands run elsewhere (e.g. iptables) must still be able to wait on
+	// their own children.
+	for i := 0; i < 10; i++ {
+		if err := exec.Command("true").Run(); err != nil {
+			t.Fatalf("exec.Command(\"true\").Run() = %v while reaping", err)
+		}
+	}
+
+	if r := <-done; r.err != nil || r.code != 0 {
+		t.Errorf("ReapChildren() = %d, %v; want 0, nil", r.code, r.err)
+	}
+}
+
+func TestReapOrphans(t *testing.T) {
+	// Stand in for init: orphaned descendants are reparented to us.
+	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+		t.Skipf("could not become a subreaper: %v", err)
+	}
+	defer unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 0, 0, 0, 0)
+
+	// The shell exits right away, orphaning its sleep.
+	out, err := Output(exec.Command("sh", "-c", "sleep 0.1 >/dev/null & echo $!"))
+	if err != nil {
+		t.Skipf("could not run sh: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		t.Fatalf("bad pid %q: %v", out, err)
+	}
+
+	// Our own children keep their exit statuses meanwhile.
+	err = Run(exec.Command("sh", "-c", "sleep 0.2; exit 3"))
+	if ee, ok := err.(*exec.ExitError); !ok || ee.ExitCode() != 3 {
+		t.Errorf("Run() = %v; want exit status 3", err)
+	}
+
+	deadline := time.Now().Add(10 * orphanGrace)
+	for {
+		if _, err := os.Stat("/proc/" + strconv.Itoa(pid)); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("orphan %d was not reaped", pid)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -2,25 +2,116 @@ package dhcp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
+	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
 	"go.jonnrb.io/egress/fw"
 	"go.jonnrb.io/egress/log"
+	"go.jonnrb.io/egress/tracing"
 	"go.jonnrb.io/egress/vaddr"
 	"go.jonnrb.io/egress/vaddr/vaddrutil"
-	"golang.org/x/sync/errgroup"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// Initial retransmission timeout. nclient4 doubles it on each retry, so
+	// with the default retries a transaction gives up after ~1 minute, like
+	// RFC 2131 section 4.1 suggests.
+	defaultTimeout = 4 * time.Second
+	defaultRetries = 4
+
+	// How long to wait before starting over after a failed or declined
+	// lease. RFC 2131 section 3.1 recommends 10 seconds after a DECLINE.
+	defaultBackoff = 10 * time.Second
+
+	leaseStoreTimeout = 10 * time.Second
 )
 
 type VAddr struct {
 	HWAddr     net.HardwareAddr
 	Link       fw.Link
 	LeaseStore LeaseStore
+
+	// If set, the lease is released when Run() returns. This is off by
+	// default since the virtual MAC (and with it, the lease) floats to the
+	// next leader, and a release from the old leader would race the new
+	// leader's request.
+	ReleaseOnStop bool
+
+	// Included in every message sent to the server.
+	ClientOptions ClientOptions
+
+	// If non-nil, called whenever a lease is bound or extended. This lets
+	// other things (e.g. a LAN DNS resolver) follow the uplink's configuration.
+	OnBound func(l Lease)
+
+	mu      sync.Mutex
+	staged  *Lease
+	running bool
+	state   state
+}
+
+// Returns the RFC 2131 state of the client or "STOPPED" if it isn't running.
+func (a *VAddr) State() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.running {
+		return "STOPPED"
+	}
+	return a.state.String()
+}
+
+func (a *VAddr) setState(running bool, st state) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.running, a.state = running, st
+}
+
+// Validates the link and pre-loads the last lease from the LeaseStore so that
+// Run() can bind it without waiting on the network.
+func (a *VAddr) Stage(ctx context.Context) error {
+	if _, err := net.InterfaceByName(a.Link.Name()); err != nil {
+		return fmt.Errorf(
+			"dhcp: could not get interface %q: %w", a.Link.Name(), err)
+	}
+	if a.LeaseStore == nil {
+		return nil
+	}
+
+	l, err := a.LeaseStore.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("dhcp: could not pre-load lease: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if l.StartTime.Add(l.RenewAfter).Before(time.Now()) {
+		a.staged = nil
+	} else {
+		a.staged = &l
+	}
+	return nil
 }
 
-func (a VAddr) Run(ctx context.Context) error {
+func (a *VAddr) takeStagedLease() *Lease {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	l := a.staged
+	a.staged = nil
+	return l
+}
+
+func (a *VAddr) Run(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -31,146 +122,473 @@ func (a VAddr) Run(ctx context.Context) error {
 				Addr: a.HWAddr,
 			},
 		},
-		Actives: []vaddr.Active{
-			&vaddrState{addr: &a},
-		},
+		Actives: []vaddr.Active{newVAddrState(a)},
 	}.Run(ctx)
 }
 
+// DHCP client states from RFC 2131 section 4.4.
+type state int
+
+const (
+	stateInit state = iota
+	stateInitReboot
+	stateSelecting
+	stateRequesting
+	stateBound
+	stateRenewing
+	stateRebinding
+)
+
+func (st state) String() string {
+	switch st {
+	case stateInit:
+		return "INIT"
+	case stateInitReboot:
+		return "INIT-REBOOT"
+	case stateSelecting:
+		return "SELECTING"
+	case stateRequesting:
+		return "REQUESTING"
+	case stateBound:
+		return "BOUND"
+	case stateRenewing:
+		return "RENEWING"
+	case stateRebinding:
+		return "REBINDING"
+	default:
+		return fmt.Sprintf("state(%d)", int(st))
+	}
+}
+
 type vaddrState struct {
-	addr        *VAddr
+	addr   *VAddr
+	staged *Lease
+
+	// Overridden in tests.
+	dial     func() (net.PacketConn, error)
+	newVAddr func(l Lease) vaddr.Wrapper
+	probe    func(ctx context.Context, ip net.IP) (inUse bool, err error)
+	timeout  time.Duration
+	retries  int
+	backoff  time.Duration
+
+	conn        net.PacketConn
+	client      *nclient4.Client
+	offer       *dhcpv4.DHCPv4
+	rebootLease *Lease
 	curLease    *Lease
 	activeVAddr vaddr.Wrapper
 }
 
+func newVAddrState(a *VAddr) *vaddrState {
+	s := &vaddrState{
+		addr:   a,
+		staged: a.takeStagedLease(),
+		dial: func() (net.PacketConn, error) {
+			return nclient4.NewRawUDPConn(a.Link.Name(), nclient4.ClientPort)
+		},
+		probe: func(ctx context.Context, ip net.IP) (bool, error) {
+			return vaddrutil.ProbeARP(ctx, a.Link, a.HWAddr, ip)
+		},
+		timeout: defaultTimeout,
+		retries: defaultRetries,
+		backoff: defaultBackoff,
+	}
+	s.newVAddr = s.vaddrForLease
+	return s
+}
+
+func (s *vaddrState) logger() log.Logger {
+	return log.With(log.Link(s.addr.Link.Name()))
+}
+
 func (s *vaddrState) Run(ctx context.Context) error {
-	defer func() {
-		if s.activeVAddr != nil {
-			s.activeVAddr.Stop()
-		}
-	}()
+	if err := s.open(); err != nil {
+		return err
+	}
+	defer s.client.Close()
+	defer s.stop()
 
-	for {
-		log.V(2).Infof("Requesting a DHCP lease on %v", s.addr.Link.Name())
+	defer s.addr.setState(false, stateInit)
+
+	st, err := s.start(ctx)
+	for err == nil && ctx.Err() == nil {
+		s.logger().V(2).Infof("dhcp: in state %v", st)
+		s.addr.setState(true, st)
+		st, err = s.step(ctx, st)
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
 
-		l, err := s.getLease(ctx)
+func (s *vaddrState) open() (err error) {
+	s.conn, err = s.dial()
+	if err != nil {
+		return fmt.Errorf("dhcp: could not open dhcp socket: %w", err)
+	}
+	s.client, err = nclient4.NewWithConn(
+		s.conn,
+		s.addr.HWAddr,
+		nclient4.WithTimeout(s.timeout),
+		nclient4.WithRetry(s.retries),
+		nclient4.WithSummaryLogger())
+	if err != nil {
+		s.conn.Close()
+		return fmt.Errorf("dhcp: could not create dhcp client: %w", err)
+	}
+	return nil
+}
+
+// Runs the state st and returns the next state. States that exchange messages
+// with the server are traced; BOUND isn't since it mostly waits.
+func (s *vaddrState) step(ctx context.Context, st state) (next state, err error) {
+	if st != stateInit && st != stateBound {
+		var span trace.Span
+		ctx, span = tracing.Start(ctx, "dhcp."+st.String(),
+			attribute.String("link", s.addr.Link.Name()))
+		defer func() { tracing.End(span, err) }()
+	}
+
+	switch st {
+	case stateInit:
+		return s.init()
+	case stateInitReboot:
+		return s.initReboot(ctx)
+	case stateSelecting:
+		return s.selecting(ctx)
+	case stateRequesting:
+		return s.requesting(ctx)
+	case stateBound:
+		return s.bound(ctx)
+	case stateRenewing:
+		return s.renewing(ctx)
+	case stateRebinding:
+		return s.rebinding(ctx)
+	default:
+		panic(fmt.Sprintf("dhcp: invalid state %v", st))
+	}
+}
+
+// Picks the initial state. A remembered lease (staged by VAddr.Stage() or in
+// the LeaseStore) is verified with the server from INIT-REBOOT, and is bound
+// right away if it hasn't entered its renewal phase.
+func (s *vaddrState) start(ctx context.Context) (state, error) {
+	l := s.staged
+	s.staged = nil
+	if l != nil {
+		s.logger().V(2).Info("Using staged DHCP lease")
+	} else if s.addr.LeaseStore != nil {
+		storeCtx, cancel := context.WithTimeout(ctx, leaseStoreTimeout)
+		sl, err := s.addr.LeaseStore.Get(storeCtx)
+		cancel()
 		if err != nil {
-			return err
+			s.logger().Warningf("dhcp: could not get lease from lease store: %v", err)
+		} else {
+			l = &sl
 		}
+	}
 
-		log.V(2).Infof("Got DHCP lease for %v: %+v", s.addr.Link.Name(), l)
+	now := time.Now()
+	if l == nil || l.LeasedIP == nil ||
+		!now.Before(l.StartTime.Add(l.Duration)) {
+		return stateInit, nil
+	}
 
-		if l == nil {
-			panic("dhcp: impossible nil lease")
-		}
-		err = s.maybeBind(*l)
-		if err != nil {
-			return fmt.Errorf("dhcp: error rebinding: %w", err)
+	s.rebootLease = l
+	if now.Before(l.StartTime.Add(l.RenewAfter)) {
+		if err := s.bind(*l); err != nil {
+			return 0, fmt.Errorf("dhcp: error binding stored lease: %w", err)
 		}
+	}
+	return stateInitReboot, nil
+}
 
-		err = s.holdLease(ctx)
-		if err != nil {
-			return err
+// INIT: Drops any lease and starts over.
+func (s *vaddrState) init() (state, error) {
+	s.offer = nil
+	if err := s.unbind(); err != nil {
+		return 0, fmt.Errorf("dhcp: error unbinding: %w", err)
+	}
+	return stateSelecting, nil
+}
+
+// INIT-REBOOT: Asks to keep a remembered lease.
+func (s *vaddrState) initReboot(ctx context.Context) (state, error) {
+	l := *s.rebootLease
+	s.rebootLease = nil
+
+	req, err := s.newRequest(
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(l.LeasedIP)))
+	if err != nil {
+		return 0, err
+	}
+
+	sent := time.Now()
+	reply, err := s.client.SendAndRead(
+		ctx, nclient4.DefaultServers, req, isACKOrNAK)
+	if err != nil {
+		if s.curLease != nil {
+			s.logger().Warningf(
+				"dhcp: could not verify stored lease; keeping it: %v", err)
+			return stateBound, nil
 		}
+		s.logger().Warningf("dhcp: could not verify stored lease: %v", err)
+		return stateInit, nil
+	}
+	return s.handleReply(ctx, reply, reply, sent)
+}
+
+// SELECTING: Broadcasts a DISCOVER and takes the first offer.
+func (s *vaddrState) selecting(ctx context.Context) (state, error) {
+	s.logger().V(2).Info("Requesting a DHCP lease")
 
-		log.V(2).Infof("Lease for %v expired", s.addr.Link.Name())
+	offer, err := s.client.DiscoverOffer(ctx, append(
+		s.addr.ClientOptions.modifiers(), withRequestedOptions)...)
+	if err != nil {
+		s.logger().Warningf("dhcp: no offer: %v", err)
+		s.sleep(ctx, s.backoff)
+		return stateInit, nil
 	}
+	s.offer = offer
+	return stateRequesting, nil
 }
 
-// Races requesting a lease with getting an existing lease from the LeaseStore.
-func (s *vaddrState) getLease(ctx context.Context) (*Lease, error) {
-	var (
-		requestLeaseChan = make(chan Lease, 1)
-		requestLeaseDone = false
-		leaseStoreChan   = make(chan Lease, 1)
-		leaseStoreDone   = false
-	)
+// REQUESTING: Broadcasts a REQUEST for the selected offer.
+func (s *vaddrState) requesting(ctx context.Context) (state, error) {
+	req, err := dhcpv4.NewRequestFromOffer(s.offer, append(
+		s.addr.ClientOptions.modifiers(),
+		dhcpv4.WithOption(dhcpv4.OptMaxMessageSize(nclient4.MaxMessageSize)),
+		withRequestedOptions)...)
+	if err != nil {
+		s.logger().Warningf("dhcp: bad offer: %v", err)
+		return stateInit, nil
+	}
 
-	eg, ctx := errgroup.WithContext(ctx)
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	sent := time.Now()
+	reply, err := s.client.SendAndRead(
+		ctx, nclient4.DefaultServers, req, isACKOrNAK)
+	if err != nil {
+		s.logger().Warningf("dhcp: no response to request: %v", err)
+		return stateInit, nil
+	}
+	return s.handleReply(ctx, s.offer, reply, sent)
+}
 
-	eg.Go(func() error {
-		l, err := s.requestLease(ctx)
-		requestLeaseChan <- l
-		return err
-	})
+// BOUND: Stores the lease and waits until it should be renewed.
+func (s *vaddrState) bound(ctx context.Context) (state, error) {
+	l := *s.curLease
+
+	ctx, cancel := context.WithDeadline(ctx, l.StartTime.Add(l.RenewAfter))
+	defer cancel()
 
 	if s.addr.LeaseStore != nil {
-		eg.Go(func() error {
-			l, err := s.addr.LeaseStore.Get(ctx)
-			leaseStoreChan <- l
-			return err
-		})
-	} else {
-		leaseStoreDone = true
-	}
-
-	for !requestLeaseDone && !leaseStoreDone {
-		select {
-		case l := <-requestLeaseChan:
-			// Discard if the lease would have entered the renew phase by now.
-			// This is somewhat sensible to do at its face, but also consider
-			// that getLease() runs immediately after the previous lease, which
-			// may have been saved to the LeaseStore, had entered the renew
-			// phase. This avoids the LeaseStore racing a DHCP request in a loop
-			// until the lease actually expires.
-			if l.StartTime.Add(l.RenewAfter).Before(time.Now()) {
-				requestLeaseDone = true
-				continue
-			}
-
-			cancel()
-			eg.Wait()
-
-			select {
-			case newLease := <-requestLeaseChan:
-				renewTime := newLease.StartTime.Add(newLease.RenewAfter)
-				if !renewTime.Before(time.Now()) {
-					// Favor a lease just requested. The server would probably
-					// expect us to use it...
-					l = newLease
-				}
-			default:
-			}
-
-			return &l, nil
-		case l := <-requestLeaseChan:
-			if l.StartTime.Add(l.RenewAfter).Before(time.Now()) {
-				requestLeaseDone = true
-				continue
-			}
-			cancel()
-			eg.Wait()
-			return &l, nil
-		case <-s.timeToRebind():
-			s.unbind()
+		err := putLease(ctx, s.logger(), s.addr.LeaseStore, l)
+		if err != nil && ctx.Err() == nil {
+			return 0, fmt.Errorf(
+				"dhcp: error putting lease into lease store: %w", err)
+		}
+	}
+
+	<-ctx.Done()
+	return stateRenewing, nil
+}
+
+// RENEWING: Unicasts REQUESTs to the server that granted the lease until the
+// rebinding time.
+func (s *vaddrState) renewing(ctx context.Context) (state, error) {
+	l := *s.curLease
+	server := &net.UDPAddr{IP: l.ServerIP, Port: nclient4.ServerPort}
+	return s.extend(
+		ctx, l, server, l.StartTime.Add(l.RebindAfter), stateRebinding)
+}
+
+// REBINDING: Broadcasts REQUESTs to any server until the lease expires.
+func (s *vaddrState) rebinding(ctx context.Context) (state, error) {
+	l := *s.curLease
+	return s.extend(
+		ctx, l, nclient4.DefaultServers, l.StartTime.Add(l.Duration), stateInit)
+}
+
+// Sends REQUESTs to extend l to dest until deadline, after which the next
+// state is onTimeout.
+func (s *vaddrState) extend(
+	ctx context.Context,
+	l Lease,
+	dest *net.UDPAddr,
+	deadline time.Time,
+	onTimeout state,
+) (state, error) {
+	reqCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	for reqCtx.Err() == nil {
+		req, err := s.newRequest(dhcpv4.WithClientIP(l.LeasedIP))
+		if err != nil {
+			return 0, err
+		}
+
+		sent := time.Now()
+		reply, err := s.client.SendAndRead(reqCtx, dest, req, isACKOrNAK)
+		if err != nil {
+			s.logger().V(2).Infof(
+				"dhcp: no response to request sent to %v: %v", dest, err)
 			continue
-		case <-ctx.Done():
-			return nil, eg.Wait()
 		}
+		return s.handleReply(ctx, reply, reply, sent)
+	}
+
+	s.logger().Warningf("dhcp: lease was not extended by %v", dest)
+	return onTimeout, nil
+}
+
+func (s *vaddrState) newRequest(mods ...dhcpv4.Modifier) (*dhcpv4.DHCPv4, error) {
+	m := append(
+		s.addr.ClientOptions.modifiers(),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithHwAddr(s.addr.HWAddr),
+		dhcpv4.WithOption(dhcpv4.OptMaxMessageSize(nclient4.MaxMessageSize)),
+		withRequestedOptions)
+	req, err := dhcpv4.New(append(m, mods...)...)
+	if err != nil {
+		return nil, fmt.Errorf("dhcp: could not create request: %w", err)
 	}
-	return nil, fmt.Errorf(
-		"dhcp: could not get unexpired lease; last lease seen: %+v", s.curLease)
+	return req, nil
 }
 
-func (s *vaddrState) timeToRebind() <-chan time.Time {
-	if s.curLease == nil {
-		return nil
+// Asks for the options captured in a Lease.
+var withRequestedOptions = dhcpv4.WithRequestedOptions(
+	dhcpv4.OptionSubnetMask,
+	dhcpv4.OptionRouter,
+	dhcpv4.OptionDomainName,
+	dhcpv4.OptionDomainNameServer,
+	dhcpv4.OptionDNSDomainSearchList,
+	dhcpv4.OptionInterfaceMTU,
+	dhcpv4.OptionClasslessStaticRoute,
+	dhcpv4.OptionNTPServers,
+	dhcpv4.OptionHostName,
+)
+
+func isACKOrNAK(p *dhcpv4.DHCPv4) bool {
+	t := p.MessageType()
+	return t == dhcpv4.MessageTypeAck || t == dhcpv4.MessageTypeNak
+}
+
+// Binds the lease in an ACK, making sure no one else is using a new address
+// first. A NAK sends us back to INIT.
+func (s *vaddrState) handleReply(
+	ctx context.Context,
+	offer, reply *dhcpv4.DHCPv4,
+	sent time.Time,
+) (state, error) {
+	if reply.MessageType() == dhcpv4.MessageTypeNak {
+		s.logger().Warning("dhcp: lease was NAK'd")
+		return stateInit, nil
+	}
+
+	l, err := rawLease{Offer: offer, ACK: reply, CreationTime: sent}.ToLease()
+	if err != nil {
+		s.logger().Warningf("dhcp: bad ACK: %v", err)
+		return stateInit, nil
+	}
+
+	if !s.holds(l.LeasedIP) && s.inUse(ctx, l.LeasedIP) {
+		s.logger().With(log.LeaseIP(l.LeasedIP)).Warning(
+			"dhcp: leased address is in use; declining")
+		s.decline(l)
+		s.sleep(ctx, s.backoff)
+		return stateInit, nil
+	}
+
+	s.logger().With(log.LeaseIP(l.LeasedIP)).V(2).Infof("Got DHCP lease: %+v", l)
+
+	if err := s.maybeBind(l); err != nil {
+		return 0, fmt.Errorf("dhcp: error rebinding: %w", err)
+	}
+	return stateBound, nil
+}
+
+func (s *vaddrState) holds(ip net.IP) bool {
+	return s.activeVAddr != nil && s.curLease.LeasedIP.Equal(ip)
+}
+
+// Probing is best-effort: if it fails, the address is assumed to be free.
+func (s *vaddrState) inUse(ctx context.Context, ip net.IP) bool {
+	inUse, err := s.probe(ctx, ip)
+	if err != nil {
+		s.logger().Warningf("dhcp: could not probe for %v: %v", ip, err)
+		return false
+	}
+	return inUse
+}
+
+func (s *vaddrState) decline(l Lease) {
+	p, err := dhcpv4.New(append(
+		s.addr.ClientOptions.idModifiers(),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeDecline),
+		dhcpv4.WithHwAddr(s.addr.HWAddr),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(l.LeasedIP)),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(l.ServerIP)))...)
+	if err == nil {
+		_, err = s.conn.WriteTo(p.ToBytes(), nclient4.DefaultServers)
+	}
+	if err != nil {
+		s.logger().With(log.LeaseIP(l.LeasedIP)).Warningf("dhcp: could not decline: %v", err)
+	}
+}
+
+func (s *vaddrState) release(l Lease) {
+	p, err := dhcpv4.New(append(
+		s.addr.ClientOptions.idModifiers(),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease),
+		dhcpv4.WithHwAddr(s.addr.HWAddr),
+		dhcpv4.WithClientIP(l.LeasedIP),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(l.ServerIP)))...)
+	if err == nil {
+		server := &net.UDPAddr{IP: l.ServerIP, Port: nclient4.ServerPort}
+		_, err = s.conn.WriteTo(p.ToBytes(), server)
+	}
+	if err != nil {
+		s.logger().With(log.LeaseIP(l.LeasedIP)).Warningf("dhcp: could not release: %v", err)
 	}
-	rebindTime := s.curLease.StartTime.Add(s.curLease.RebindAfter)
-	return time.After(time.Until(rebindTime))
 }
 
+func (s *vaddrState) stop() {
+	if s.addr.ReleaseOnStop && s.curLease != nil {
+		s.logger().V(2).Info("Releasing DHCP lease")
+		s.release(*s.curLease)
+	}
+	if err := s.unbind(); err != nil {
+		s.logger().Warningf("dhcp: error unbinding: %v", err)
+	}
+}
+
+func (s *vaddrState) sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// Binds l unless it's netwise the same as what's already bound, in which case
+// the current lease is just updated. This keeps a renewal from flapping the
+// address.
 func (s *vaddrState) maybeBind(l Lease) error {
 	netwiseSame := s.curLease != nil &&
 		s.curLease.LeasedIP.Equal(l.LeasedIP) &&
 		s.curLease.SubnetMask == l.SubnetMask &&
-		s.curLease.GatewayIP.Equal(l.GatewayIP)
-
-	if netwiseSame &&
-		s.activeVAddr != nil &&
-		s.curLease.StartTime.After(l.StartTime) {
+		s.curLease.GatewayIP.Equal(l.GatewayIP) &&
+		s.curLease.MTU == l.MTU &&
+		routesEqual(s.curLease.Routes, l.Routes)
+
+	if netwiseSame && s.activeVAddr != nil {
+		if l.StartTime.After(s.curLease.StartTime) {
+			s.curLease = &l
+			s.notifyBound(l)
+		}
 		return nil
 	}
 
@@ -185,8 +603,18 @@ func (s *vaddrState) bind(l Lease) error {
 		return err
 	}
 	s.curLease = &l
-	s.activeVAddr = s.vaddrForLease(l)
-	return s.activeVAddr.Start()
+	s.activeVAddr = s.newVAddr(l)
+	if err := s.activeVAddr.Start(); err != nil {
+		return err
+	}
+	s.notifyBound(l)
+	return nil
+}
+
+func (s *vaddrState) notifyBound(l Lease) {
+	if s.addr.OnBound != nil {
+		s.addr.OnBound(l)
+	}
 }
 
 func (s *vaddrState) unbind() error {
@@ -199,88 +627,57 @@ func (s *vaddrState) unbind() error {
 	return err
 }
 
-func (s *vaddrState) requestLease(ctx context.Context) (l Lease, err error) {
-	c, err := nclient4.New(
-		s.addr.Link.Name(),
-		nclient4.WithHWAddr(s.addr.HWAddr),
-		nclient4.WithRetry(45),
-		nclient4.WithSummaryLogger())
-	if err != nil {
-		err = fmt.Errorf("dhcp: could not create dhcp client: %w", err)
-		return
-	}
-	defer c.Close()
-
-	// There would be a multitude of good places to issue a DHCP release if this
-	// fails, but releases are no more than an optimization and floating a
-	// virtual MAC address effectively makes releasing unnecessary. If we ever
-	// did rely on releasing on failure, we'd get screwed at some point since
-	// we can't atomically acquire a lease while logging that we've acquired the
-	// lease.
-	rl, err := newRawLease(c.Request(ctx))
-	if err != nil {
-		err = fmt.Errorf("dhcp: could not get lease from network: %w", err)
-		return
+// Puts l into ls. If ls supports it, l is only written if the stored lease isn't
+// newer, so a lingering writer can't overwrite a lease just obtained by another.
+func putLease(ctx context.Context, logger log.Logger, ls LeaseStore, l Lease) error {
+	cas, ok := ls.(CASLeaseStore)
+	if !ok {
+		return ls.Put(ctx, l)
+	}
+	for {
+		cur, err := cas.Get(ctx)
+		if err != nil {
+			return err
+		}
+		if cur.StartTime.After(l.StartTime) {
+			logger.With(log.LeaseIP(l.LeasedIP)).Warningf(
+				"dhcp: not storing lease since a newer lease is stored: %+v", cur)
+			return nil
+		}
+		err = cas.CompareAndSwap(ctx, cur, l)
+		if !errors.Is(err, ErrLeaseChanged) {
+			return err
+		}
+		logger.V(2).Info("dhcp: stored lease changed while storing; retrying")
 	}
-
-	return rl.ToLease()
 }
 
-// Holds the lease and potentially writes the lease to a LeaseStore. Returns nil
-// when the lease should be renewed.
-func (s *vaddrState) holdLease(ctx context.Context) error {
-	if s.curLease == nil {
-		panic("dhcp: can't have nil curLease here")
+func (s *vaddrState) vaddrForLease(l Lease) vaddr.Wrapper {
+	var w []vaddr.Wrapper
+	if l.MTU != 0 {
+		w = append(w, &vaddrutil.MTU{Link: s.addr.Link, MTU: l.MTU})
 	}
-	l := *s.curLease
+	w = append(w, &vaddrutil.IP{Link: s.addr.Link, Addr: leasedAddr(l)})
 
-	ctx, cancel := context.WithCancel(ctx)
-	eg, ctx := errgroup.WithContext(ctx)
-
-	if s.addr.LeaseStore != nil {
-		eg.Go(func() error {
-			err := s.addr.LeaseStore.Put(ctx, l)
-			if err != nil {
-				return fmt.Errorf(
-					"dhcp: error putting lease into lease store: %w", err)
-			}
-			return nil
+	// Per RFC 3442, the router option is ignored if there are classless static
+	// routes. The default route (if any) is one of them.
+	if len(l.Routes) == 0 {
+		w = append(w, &vaddrutil.DefaultRoute{Link: s.addr.Link, GW: gwAddr(l)})
+	}
+	for _, r := range l.Routes {
+		w = append(w, &vaddrutil.Route{
+			Link: s.addr.Link,
+			Dst:  r.Dest,
+			GW:   r.Router,
 		})
 	}
 
-	eg.Go(func() error {
-		select {
-		case <-time.After(l.RenewAfter - time.Now().Sub(l.StartTime)):
-			// No point in waiting on LeaseStore.Put() if the lease needs to be
-			// renewed now.
-			cancel()
-			return nil
-		case <-ctx.Done():
-			return ctx.Err()
-		}
+	w = append(w, &vaddrutil.GratuitousARP{
+		Link:   s.addr.Link,
+		HWAddr: s.addr.HWAddr,
+		IP:     l.LeasedIP,
 	})
-
-	return eg.Wait()
-}
-
-func (s *vaddrState) vaddrForLease(l Lease) vaddr.Wrapper {
-	return &vaddr.CombinedWrappers{
-		Wrappers: []vaddr.Wrapper{
-			&vaddrutil.IP{
-				Link: s.addr.Link,
-				Addr: leasedAddr(l),
-			},
-			&vaddrutil.DefaultRoute{
-				Link: s.addr.Link,
-				GW:   gwAddr(l),
-			},
-			&vaddrutil.GratuitousARP{
-				Link:   s.addr.Link,
-				HWAddr: s.addr.HWAddr,
-				IP:     l.LeasedIP,
-			},
-		},
-	}
+	return &vaddr.CombinedWrappers{Wrappers: w}
 }
 
 func leasedAddr(l Lease) fw.Addr {
@@ -298,3 +695,7 @@ func gwAddr(l Lease) fw.Addr {
 	}
 	return a
 }
+
+func (a *VAddr) String() string {
+	return fmt.Sprintf("DHCP on %s (%v)", a.Link.Name(), a.HWAddr)
+}
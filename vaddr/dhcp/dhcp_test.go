@@ -0,0 +1,704 @@
+package dhcp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/log"
+	"go.jonnrb.io/egress/vaddr"
+	"go.jonnrb.io/egress/vaddr/vaddrutil"
+)
+
+// A casStore that signals when a lease is stored.
+type notifyStore struct {
+	casStore
+	stored chan struct{}
+}
+
+func (s *notifyStore) CompareAndSwap(ctx context.Context, old, l Lease) error {
+	err := s.casStore.CompareAndSwap(ctx, old, l)
+	if err == nil {
+		select {
+		case s.stored <- struct{}{}:
+		default:
+		}
+	}
+	return err
+}
+
+type casStore struct {
+	l     Lease
+	swaps int
+}
+
+func (s *casStore) Get(ctx context.Context) (Lease, error) {
+	return s.l, nil
+}
+
+func (s *casStore) Put(ctx context.Context, l Lease) error {
+	panic("Put() shouldn't be used when CompareAndSwap() is available")
+}
+
+func (s *casStore) CompareAndSwap(ctx context.Context, old, l Lease) error {
+	if !s.l.Equal(old) {
+		return ErrLeaseChanged
+	}
+	s.l = l
+	s.swaps++
+	return nil
+}
+
+func TestPutLease_doesNotOverwriteNewer(t *testing.T) {
+	newer := Lease{StartTime: time.Now()}
+	s := &casStore{l: newer}
+
+	err := putLease(context.Background(), log.With(), s, Lease{StartTime: newer.StartTime.Add(-time.Hour)})
+
+	if err != nil {
+		t.Errorf("expected err == nil; got: %v", err)
+	}
+	if s.swaps != 0 || !s.l.Equal(newer) {
+		t.Errorf("newer lease was overwritten: %+v", s.l)
+	}
+}
+
+func TestPutLease_replacesOlder(t *testing.T) {
+	older := Lease{StartTime: time.Now()}
+	s := &casStore{l: older}
+	l := Lease{StartTime: older.StartTime.Add(time.Hour)}
+
+	err := putLease(context.Background(), log.With(), s, l)
+
+	if err != nil {
+		t.Errorf("expected err == nil; got: %v", err)
+	}
+	if s.swaps != 1 || !s.l.Equal(l) {
+		t.Errorf("lease was not replaced: %+v", s.l)
+	}
+}
+
+var (
+	testHWAddr    = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testServerIP  = net.IPv4(192, 0, 2, 1).To4()
+	testGatewayIP = net.IPv4(192, 0, 2, 254).To4()
+	testLeasedIP  = net.IPv4(192, 0, 2, 100).To4()
+)
+
+type packet struct {
+	msg  *dhcpv4.DHCPv4
+	dest *net.UDPAddr
+}
+
+// A DHCP server at the other end of the client's net.PacketConn.
+type fakeServer struct {
+	mu   sync.Mutex
+	sent []packet
+
+	// Requests are NAK'd if set.
+	nak bool
+	// Packets from the client are ignored if this returns true.
+	drop func(p packet) bool
+	// Extra options included in OFFERs and ACKs.
+	options []dhcpv4.Option
+
+	replies chan []byte
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{
+		replies: make(chan []byte, 16),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (f *fakeServer) WriteTo(b []byte, addr net.Addr) (int, error) {
+	m, err := dhcpv4.FromBytes(b)
+	if err != nil {
+		return 0, err
+	}
+	p := packet{m, addr.(*net.UDPAddr)}
+
+	f.mu.Lock()
+	f.sent = append(f.sent, p)
+	reply := f.reply(p)
+	f.mu.Unlock()
+
+	if reply != nil {
+		f.replies <- reply.ToBytes()
+	}
+	return len(b), nil
+}
+
+func (f *fakeServer) reply(p packet) *dhcpv4.DHCPv4 {
+	if f.drop != nil && f.drop(p) {
+		return nil
+	}
+
+	var t dhcpv4.MessageType
+	switch p.msg.MessageType() {
+	case dhcpv4.MessageTypeDiscover:
+		t = dhcpv4.MessageTypeOffer
+	case dhcpv4.MessageTypeRequest:
+		t = dhcpv4.MessageTypeAck
+		if f.nak {
+			t = dhcpv4.MessageTypeNak
+		}
+	default:
+		return nil
+	}
+
+	mods := []dhcpv4.Modifier{
+		dhcpv4.WithReply(p.msg),
+		dhcpv4.WithMessageType(t),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(testServerIP)),
+	}
+	if t != dhcpv4.MessageTypeNak {
+		mods = append(mods,
+			dhcpv4.WithYourIP(testLeasedIP),
+			dhcpv4.WithRouter(testGatewayIP),
+			dhcpv4.WithNetmask(net.CIDRMask(24, 32)),
+			dhcpv4.WithLeaseTime(3600))
+		for _, o := range f.options {
+			mods = append(mods, dhcpv4.WithOption(o))
+		}
+	}
+	r, err := dhcpv4.New(mods...)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func (f *fakeServer) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case r := <-f.replies:
+		return copy(b, r), &net.UDPAddr{IP: testServerIP, Port: 67}, nil
+	case <-f.closed:
+		return 0, nil, errors.New("closed")
+	}
+}
+
+func (f *fakeServer) Close() error {
+	f.once.Do(func() { close(f.closed) })
+	return nil
+}
+
+func (f *fakeServer) LocalAddr() net.Addr                { return &net.UDPAddr{Port: 68} }
+func (f *fakeServer) SetDeadline(t time.Time) error      { return nil }
+func (f *fakeServer) SetReadDeadline(t time.Time) error  { return nil }
+func (f *fakeServer) SetWriteDeadline(t time.Time) error { return nil }
+
+// Returns the packets of type t sent by the client.
+func (f *fakeServer) sentOfType(t dhcpv4.MessageType) []packet {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var ps []packet
+	for _, p := range f.sent {
+		if p.msg.MessageType() == t {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+type fakeVAddr struct {
+	l       Lease
+	started bool
+	stopped bool
+}
+
+func (v *fakeVAddr) Start() error {
+	v.started = true
+	return nil
+}
+
+func (v *fakeVAddr) Stop() error {
+	v.stopped = true
+	return nil
+}
+
+type testState struct {
+	*vaddrState
+	srv   *fakeServer
+	bound []*fakeVAddr
+	inUse bool
+}
+
+func newTestState(a *VAddr) *testState {
+	a.HWAddr = testHWAddr
+	a.Link = fw.LinkString("eth0")
+
+	ts := &testState{srv: newFakeServer()}
+	ts.vaddrState = &vaddrState{
+		addr:   a,
+		staged: a.takeStagedLease(),
+		dial: func() (net.PacketConn, error) {
+			return ts.srv, nil
+		},
+		newVAddr: func(l Lease) vaddr.Wrapper {
+			v := &fakeVAddr{l: l}
+			ts.bound = append(ts.bound, v)
+			return v
+		},
+		probe: func(ctx context.Context, ip net.IP) (bool, error) {
+			return ts.inUse, nil
+		},
+		timeout: 10 * time.Millisecond,
+		retries: 2,
+		backoff: time.Millisecond,
+	}
+	return ts
+}
+
+// Runs s until it enters the stop state.
+func (ts *testState) runUntil(t *testing.T, ctx context.Context, st, stop state) state {
+	t.Helper()
+	for i := 0; st != stop; i++ {
+		if i > 10 {
+			t.Fatalf("never entered %v; stuck in %v", stop, st)
+		}
+		var err error
+		st, err = ts.step(ctx, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return st
+}
+
+func (ts *testState) open(t *testing.T) {
+	if err := ts.vaddrState.open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ts.client.Close() })
+}
+
+func testLease(start time.Time) Lease {
+	return Lease{
+		LeasedIP:    testLeasedIP,
+		SubnetMask:  24,
+		GatewayIP:   testGatewayIP,
+		ServerIP:    testServerIP,
+		StartTime:   start,
+		Duration:    time.Hour,
+		RenewAfter:  30 * time.Minute,
+		RebindAfter: 50 * time.Minute,
+	}
+}
+
+func TestRun_discoverOfferRequestACK(t *testing.T) {
+	ts := newTestState(&VAddr{})
+	ts.open(t)
+
+	st := ts.runUntil(t, context.Background(), stateInit, stateBound)
+
+	if st != stateBound {
+		t.Fatalf("expected state %v; got %v", stateBound, st)
+	}
+	if len(ts.bound) != 1 || !ts.bound[0].started {
+		t.Fatalf("expected one lease to be bound; got %v", ts.bound)
+	}
+	if l := ts.bound[0].l; !l.LeasedIP.Equal(testLeasedIP) || !l.ServerIP.Equal(testServerIP) {
+		t.Errorf("unexpected lease: %+v", l)
+	}
+	reqs := ts.srv.sentOfType(dhcpv4.MessageTypeRequest)
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 request; got %d", len(reqs))
+	}
+	if !reqs[0].dest.IP.Equal(net.IPv4bcast) {
+		t.Errorf("expected request to be broadcast; sent to %v", reqs[0].dest)
+	}
+	if !reqs[0].msg.RequestedIPAddress().Equal(testLeasedIP) {
+		t.Errorf("expected request for %v; got %v", testLeasedIP, reqs[0].msg.RequestedIPAddress())
+	}
+}
+
+func TestRenewing_unicastsToServer(t *testing.T) {
+	ts := newTestState(&VAddr{})
+	ts.open(t)
+	old := testLease(time.Now().Add(-40 * time.Minute))
+	if err := ts.bind(old); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := ts.renewing(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != stateBound {
+		t.Errorf("expected state %v; got %v", stateBound, st)
+	}
+	reqs := ts.srv.sentOfType(dhcpv4.MessageTypeRequest)
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 request; got %d", len(reqs))
+	}
+	if !reqs[0].dest.IP.Equal(testServerIP) || reqs[0].dest.Port != nclient4.ServerPort {
+		t.Errorf("expected renewal to be unicast to the server; sent to %v", reqs[0].dest)
+	}
+	if !reqs[0].msg.ClientIPAddr.Equal(testLeasedIP) {
+		t.Errorf("expected ciaddr %v; got %v", testLeasedIP, reqs[0].msg.ClientIPAddr)
+	}
+	if ip := reqs[0].msg.RequestedIPAddress(); ip != nil {
+		t.Errorf("expected no requested IP option when renewing; got %v", ip)
+	}
+	if len(ts.bound) != 1 || ts.bound[0].stopped {
+		t.Error("expected renewal to not rebind the address")
+	}
+	if !ts.curLease.StartTime.After(old.StartTime) {
+		t.Errorf("expected lease to be extended; got %+v", ts.curLease)
+	}
+}
+
+func TestRenewing_rebindsWhenServerIsGone(t *testing.T) {
+	ts := newTestState(&VAddr{})
+	ts.srv.drop = func(p packet) bool { return !p.dest.IP.Equal(net.IPv4bcast) }
+	ts.open(t)
+	l := testLease(time.Now().Add(-50*time.Minute + 100*time.Millisecond))
+	if err := ts.bind(l); err != nil {
+		t.Fatal(err)
+	}
+
+	st := ts.runUntil(t, context.Background(), stateRenewing, stateBound)
+
+	if st != stateBound {
+		t.Fatalf("expected state %v; got %v", stateBound, st)
+	}
+	reqs := ts.srv.sentOfType(dhcpv4.MessageTypeRequest)
+	last := reqs[len(reqs)-1]
+	if !last.dest.IP.Equal(net.IPv4bcast) {
+		t.Errorf("expected rebinding request to be broadcast; sent to %v", last.dest)
+	}
+	if !last.msg.ClientIPAddr.Equal(testLeasedIP) {
+		t.Errorf("expected ciaddr %v; got %v", testLeasedIP, last.msg.ClientIPAddr)
+	}
+}
+
+func TestRebinding_expires(t *testing.T) {
+	ts := newTestState(&VAddr{})
+	ts.srv.drop = func(p packet) bool { return true }
+	ts.open(t)
+	l := testLease(time.Now().Add(-time.Hour + 100*time.Millisecond))
+	if err := ts.bind(l); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := ts.rebinding(context.Background())
+	if err == nil {
+		st, err = ts.step(context.Background(), st)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != stateSelecting {
+		t.Errorf("expected state %v; got %v", stateSelecting, st)
+	}
+	if ts.curLease != nil || !ts.bound[0].stopped {
+		t.Error("expected expired lease to be unbound")
+	}
+}
+
+func TestRequesting_NAK(t *testing.T) {
+	ts := newTestState(&VAddr{})
+	ts.srv.nak = true
+	ts.open(t)
+
+	st := ts.runUntil(t, context.Background(), stateInit, stateRequesting)
+	st, err := ts.step(context.Background(), st)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != stateInit {
+		t.Errorf("expected state %v; got %v", stateInit, st)
+	}
+	if len(ts.bound) != 0 {
+		t.Error("expected NAK'd lease to not be bound")
+	}
+}
+
+func TestRequesting_declinesAddressInUse(t *testing.T) {
+	ts := newTestState(&VAddr{})
+	ts.inUse = true
+	ts.open(t)
+
+	st := ts.runUntil(t, context.Background(), stateInit, stateRequesting)
+	st, err := ts.step(context.Background(), st)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != stateInit {
+		t.Errorf("expected state %v; got %v", stateInit, st)
+	}
+	if len(ts.bound) != 0 {
+		t.Error("expected conflicting lease to not be bound")
+	}
+	declines := ts.srv.sentOfType(dhcpv4.MessageTypeDecline)
+	if len(declines) != 1 {
+		t.Fatalf("expected 1 decline; got %d", len(declines))
+	}
+	d := declines[0].msg
+	if !d.RequestedIPAddress().Equal(testLeasedIP) || !d.ServerIdentifier().Equal(testServerIP) {
+		t.Errorf("unexpected decline: %v", d)
+	}
+}
+
+func TestRun_initRebootFromLeaseStore(t *testing.T) {
+	stored := testLease(time.Now().Add(-time.Minute))
+	ts := newTestState(&VAddr{LeaseStore: &casStore{l: stored}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts.open(t)
+
+	st, err := ts.start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != stateInitReboot {
+		t.Fatalf("expected state %v; got %v", stateInitReboot, st)
+	}
+	if len(ts.bound) != 1 || !ts.bound[0].l.Equal(stored) {
+		t.Fatal("expected stored lease to be bound immediately")
+	}
+
+	st, err = ts.step(ctx, st)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != stateBound {
+		t.Errorf("expected state %v; got %v", stateBound, st)
+	}
+	if n := len(ts.srv.sentOfType(dhcpv4.MessageTypeDiscover)); n != 0 {
+		t.Errorf("expected no discovers; got %d", n)
+	}
+	reqs := ts.srv.sentOfType(dhcpv4.MessageTypeRequest)
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 request; got %d", len(reqs))
+	}
+	if !reqs[0].msg.RequestedIPAddress().Equal(stored.LeasedIP) {
+		t.Errorf("expected request for %v; got %v", stored.LeasedIP, reqs[0].msg.RequestedIPAddress())
+	}
+	if !reqs[0].msg.ClientIPAddr.IsUnspecified() {
+		t.Errorf("expected ciaddr to be unset; got %v", reqs[0].msg.ClientIPAddr)
+	}
+}
+
+func TestRun_initRebootNAK(t *testing.T) {
+	stored := testLease(time.Now().Add(-time.Minute))
+	ts := newTestState(&VAddr{LeaseStore: &casStore{l: stored}})
+	ts.srv.nak = true
+	ts.open(t)
+
+	st, err := ts.start(context.Background())
+	if err == nil {
+		st, err = ts.step(context.Background(), st)
+	}
+	if err == nil {
+		st, err = ts.step(context.Background(), st)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != stateSelecting {
+		t.Errorf("expected state %v; got %v", stateSelecting, st)
+	}
+	if ts.curLease != nil || !ts.bound[0].stopped {
+		t.Error("expected NAK'd stored lease to be unbound")
+	}
+}
+
+func TestRun_releaseOnStop(t *testing.T) {
+	for _, release := range []bool{false, true} {
+		ls := &notifyStore{stored: make(chan struct{}, 1)}
+		ts := newTestState(&VAddr{LeaseStore: ls, ReleaseOnStop: release})
+		ctx, cancel := context.WithCancel(context.Background())
+
+		ec := make(chan error, 1)
+		go func() { ec <- ts.Run(ctx) }()
+		select {
+		case <-ls.stored:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for lease to be stored")
+		}
+		cancel()
+		if err := <-ec; err != context.Canceled {
+			t.Errorf("expected err == context.Canceled; got %v", err)
+		}
+
+		releases := ts.srv.sentOfType(dhcpv4.MessageTypeRelease)
+		switch {
+		case release && len(releases) != 1:
+			t.Errorf("expected 1 release; got %d", len(releases))
+		case release && !releases[0].dest.IP.Equal(testServerIP):
+			t.Errorf("expected release to be sent to the server; sent to %v", releases[0].dest)
+		case !release && len(releases) != 0:
+			t.Errorf("expected no release unless ReleaseOnStop; got %d", len(releases))
+		}
+		if !ts.bound[len(ts.bound)-1].stopped {
+			t.Error("expected lease to be unbound on stop")
+		}
+	}
+}
+
+func TestRun_capturesOptions(t *testing.T) {
+	ts := newTestState(&VAddr{})
+	_, dest, _ := net.ParseCIDR("198.51.100.0/24")
+	ts.srv.options = []dhcpv4.Option{
+		dhcpv4.OptDNS(net.IPv4(192, 0, 2, 53)),
+		dhcpv4.OptDomainName("example.com"),
+		dhcpv4.OptGeneric(dhcpv4.OptionInterfaceMTU, []byte{0x05, 0xd4}),
+		dhcpv4.OptClasslessStaticRoute(&dhcpv4.Route{Dest: dest, Router: testGatewayIP}),
+		dhcpv4.OptNTPServers(net.IPv4(192, 0, 2, 123)),
+		dhcpv4.OptHostName("gw"),
+	}
+	var notified []Lease
+	ts.addr.OnBound = func(l Lease) { notified = append(notified, l) }
+	ts.open(t)
+
+	ts.runUntil(t, context.Background(), stateInit, stateBound)
+
+	if len(ts.bound) != 1 {
+		t.Fatalf("expected one lease to be bound; got %v", ts.bound)
+	}
+	l := ts.bound[0].l
+	if len(l.DNS) != 1 || !l.DNS[0].Equal(net.IPv4(192, 0, 2, 53)) {
+		t.Errorf("unexpected DNS: %v", l.DNS)
+	}
+	if l.DomainName != "example.com" {
+		t.Errorf("unexpected domain name: %q", l.DomainName)
+	}
+	if l.MTU != 1492 {
+		t.Errorf("expected MTU 1492; got %d", l.MTU)
+	}
+	if len(l.Routes) != 1 || !l.Routes[0].Equal(Route{Dest: dest, Router: testGatewayIP}) {
+		t.Errorf("unexpected routes: %v", l.Routes)
+	}
+	if len(l.NTP) != 1 || !l.NTP[0].Equal(net.IPv4(192, 0, 2, 123)) {
+		t.Errorf("unexpected NTP servers: %v", l.NTP)
+	}
+	if l.Hostname != "gw" {
+		t.Errorf("unexpected hostname: %q", l.Hostname)
+	}
+	if len(notified) != 1 || !notified[0].Equal(l) {
+		t.Errorf("expected OnBound to be called with the lease; got %v", notified)
+	}
+
+	for _, mt := range []dhcpv4.MessageType{dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeRequest} {
+		prl := ts.srv.sentOfType(mt)[0].msg.ParameterRequestList()
+		for _, o := range []dhcpv4.OptionCode{
+			dhcpv4.OptionDomainNameServer,
+			dhcpv4.OptionInterfaceMTU,
+			dhcpv4.OptionClasslessStaticRoute,
+			dhcpv4.OptionNTPServers,
+		} {
+			if !prl.Has(o) {
+				t.Errorf("expected %v to request %v", mt, o)
+			}
+		}
+	}
+}
+
+func TestVAddrForLease_classlessRoutesReplaceRouter(t *testing.T) {
+	s := newVAddrState(&VAddr{Link: fw.LinkString("eth0")})
+	_, dflt, _ := net.ParseCIDR("0.0.0.0/0")
+	l := testLease(time.Now())
+	l.Routes = []Route{{Dest: dflt, Router: testGatewayIP}}
+	l.MTU = 1492
+
+	w := s.vaddrForLease(l).(*vaddr.CombinedWrappers).Wrappers
+
+	var hasMTU, hasRoute bool
+	for _, w := range w {
+		switch w := w.(type) {
+		case *vaddrutil.DefaultRoute:
+			t.Error("expected router option to be ignored")
+		case *vaddrutil.Route:
+			hasRoute = w.Dst.String() == "0.0.0.0/0" && w.GW.Equal(testGatewayIP)
+		case *vaddrutil.MTU:
+			hasMTU = w.MTU == 1492
+		}
+	}
+	if !hasRoute {
+		t.Error("expected default route from classless static routes")
+	}
+	if !hasMTU {
+		t.Error("expected MTU to be applied")
+	}
+}
+
+func TestClientOptions_onWire(t *testing.T) {
+	opts := ClientOptions{
+		ClientID:    []byte{1, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		Hostname:    "egress",
+		VendorClass: "picky-isp",
+	}
+	ts := newTestState(&VAddr{ClientOptions: opts, ReleaseOnStop: true})
+	ts.open(t)
+
+	ts.runUntil(t, context.Background(), stateInit, stateBound)
+	if _, err := ts.renewing(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ts.stop()
+
+	check := func(p packet, withAll bool) {
+		t.Helper()
+		m := p.msg
+		if got := m.GetOneOption(dhcpv4.OptionClientIdentifier); string(got) != string(opts.ClientID) {
+			t.Errorf("expected %v to have client id %x; got %x", m.MessageType(), opts.ClientID, got)
+		}
+		if !withAll {
+			return
+		}
+		if got := m.HostName(); got != opts.Hostname {
+			t.Errorf("expected %v to have hostname %q; got %q", m.MessageType(), opts.Hostname, got)
+		}
+		if got := m.ClassIdentifier(); got != opts.VendorClass {
+			t.Errorf("expected %v to have vendor class %q; got %q", m.MessageType(), opts.VendorClass, got)
+		}
+	}
+	discovers := ts.srv.sentOfType(dhcpv4.MessageTypeDiscover)
+	reqs := ts.srv.sentOfType(dhcpv4.MessageTypeRequest)
+	releases := ts.srv.sentOfType(dhcpv4.MessageTypeRelease)
+	if len(discovers) != 1 || len(reqs) != 2 || len(releases) != 1 {
+		t.Fatalf("expected 1 discover, 2 requests and 1 release; got %d, %d and %d",
+			len(discovers), len(reqs), len(releases))
+	}
+	check(discovers[0], true)
+	check(reqs[0], true)
+	check(reqs[1], true)
+	check(releases[0], false)
+}
+
+func TestClientOptions_omittedByDefault(t *testing.T) {
+	ts := newTestState(&VAddr{})
+	ts.open(t)
+
+	ts.runUntil(t, context.Background(), stateInit, stateBound)
+
+	for _, p := range ts.srv.sent {
+		for _, o := range []dhcpv4.OptionCode{
+			dhcpv4.OptionClientIdentifier,
+			dhcpv4.OptionHostName,
+			dhcpv4.OptionClassIdentifier,
+		} {
+			if p.msg.Options.Has(o) {
+				t.Errorf("expected %v to not have %v", p.msg.MessageType(), o)
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package dhcptesting
+
+import (
+	"context"
+	"sync"
+
+	"go.jonnrb.io/egress/vaddr/dhcp"
+)
+
+// An in-memory dhcp.CASLeaseStore. The zero value is an empty store.
+type LeaseStore struct {
+	mu sync.Mutex
+	l  dhcp.Lease
+
+	// Counts calls to Put and successful calls to CompareAndSwap.
+	Writes int
+}
+
+func (s *LeaseStore) Get(ctx context.Context) (dhcp.Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.l, nil
+}
+
+func (s *LeaseStore) Put(ctx context.Context, l dhcp.Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.l = l
+	s.Writes++
+	return nil
+}
+
+func (s *LeaseStore) CompareAndSwap(ctx context.Context, old, l dhcp.Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.l.Equal(old) {
+		return dhcp.ErrLeaseChanged
+	}
+	s.l = l
+	s.Writes++
+	return nil
+}
@@ -3,10 +3,13 @@ package dhcp
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
 	"time"
 
+	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
 	"go.jonnrb.io/egress/log"
 )
@@ -16,6 +19,20 @@ type LeaseStore interface {
 	Put(ctx context.Context, l Lease) error
 }
 
+// A LeaseStore that can conditionally replace the stored lease. This keeps two
+// writers (e.g. the old and new leader during a failover) from clobbering each
+// other.
+type CASLeaseStore interface {
+	LeaseStore
+
+	// Replaces the stored lease with l only if the stored lease is equal to
+	// old. If it isn't, ErrLeaseChanged is returned. If nothing is stored, the
+	// stored lease is considered to be the zero Lease.
+	CompareAndSwap(ctx context.Context, old, l Lease) error
+}
+
+var ErrLeaseChanged = errors.New("dhcp: stored lease changed")
+
 type Lease struct {
 	LeasedIP   net.IP
 	SubnetMask int
@@ -26,17 +43,85 @@ type Lease struct {
 	Duration    time.Duration
 	RenewAfter  time.Duration
 	RebindAfter time.Duration
+
+	// Optional configuration from the lease's options. These are zero if the
+	// server didn't provide them.
+	DNS          []net.IP
+	DomainName   string
+	DomainSearch []string
+	MTU          int
+	Routes       []Route
+	NTP          []net.IP
+	Hostname     string
 }
 
-type rawLease nclient4.Lease
+// A classless static route (option 121). A Router of 0.0.0.0 means Dest is
+// on-link.
+type Route struct {
+	Dest   *net.IPNet
+	Router net.IP
+}
 
-func newRawLease(r *nclient4.Lease, err error) (*rawLease, error) {
-	if err != nil {
-		return nil, fmt.Errorf("dhcp: error getting lease: %w", err)
+func (r Route) Equal(o Route) bool {
+	return r.Dest.String() == o.Dest.String() && r.Router.Equal(o.Router)
+}
+
+func (l Lease) Equal(o Lease) bool {
+	return l.LeasedIP.Equal(o.LeasedIP) &&
+		l.SubnetMask == o.SubnetMask &&
+		l.GatewayIP.Equal(o.GatewayIP) &&
+		l.ServerIP.Equal(o.ServerIP) &&
+		l.StartTime.Equal(o.StartTime) &&
+		l.Duration == o.Duration &&
+		l.RenewAfter == o.RenewAfter &&
+		l.RebindAfter == o.RebindAfter &&
+		ipsEqual(l.DNS, o.DNS) &&
+		l.DomainName == o.DomainName &&
+		stringsEqual(l.DomainSearch, o.DomainSearch) &&
+		l.MTU == o.MTU &&
+		routesEqual(l.Routes, o.Routes) &&
+		ipsEqual(l.NTP, o.NTP) &&
+		l.Hostname == o.Hostname
+}
+
+func ipsEqual(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
 	}
-	return (*rawLease)(r), nil
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
 }
 
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func routesEqual(a, b []Route) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+type rawLease nclient4.Lease
+
 const defaultLeaseTime = 24 * time.Hour
 
 func defaultRenewFactor(d time.Duration) time.Duration {
@@ -77,9 +162,36 @@ func (r rawLease) ToLease() (l Lease, err error) {
 	if err != nil {
 		return
 	}
+	r.options(&l)
 	return
 }
 
+// The smallest MTU allowed by option 26.
+const minMTU = 68
+
+// Options that aren't needed to use the lease are taken from the ACK alone
+// since it's authoritative.
+func (r rawLease) options(l *Lease) {
+	a := r.ACK
+	l.DNS = a.DNS()
+	l.DomainName = a.DomainName()
+	if s := a.DomainSearch(); s != nil {
+		l.DomainSearch = s.Labels
+	}
+	if b := a.GetOneOption(dhcpv4.OptionInterfaceMTU); len(b) == 2 {
+		if mtu := int(binary.BigEndian.Uint16(b)); mtu >= minMTU {
+			l.MTU = mtu
+		} else {
+			log.Warningf("dhcp: ignoring invalid MTU %d", mtu)
+		}
+	}
+	for _, rt := range a.ClasslessStaticRoute() {
+		l.Routes = append(l.Routes, Route{Dest: rt.Dest, Router: rt.Router})
+	}
+	l.NTP = a.NTPServers()
+	l.Hostname = a.HostName()
+}
+
 func (r rawLease) LeasedIP() (net.IP, error) {
 	o, a := r.Offer.YourIPAddr, r.ACK.YourIPAddr
 	switch {
@@ -131,7 +243,7 @@ func (r rawLease) SubnetMaskLen() (int, error) {
 }
 
 func (r rawLease) GatewayIP() (net.IP, error) {
-	o, a := r.Offer.GatewayIPAddr, r.ACK.GatewayIPAddr
+	o, a := gatewayIP(r.Offer), gatewayIP(r.ACK)
 	switch {
 	case (o == nil || o.IsUnspecified()) && (a == nil || a.IsUnspecified()):
 		return nil, fmt.Errorf("dhcp: no offered gateway IP")
@@ -150,7 +262,7 @@ func (r rawLease) GatewayIP() (net.IP, error) {
 }
 
 func (r rawLease) ServerIP() (net.IP, error) {
-	o, a := r.Offer.ServerIPAddr, r.ACK.ServerIPAddr
+	o, a := serverIP(r.Offer), serverIP(r.ACK)
 	switch {
 	case (o == nil || o.IsUnspecified()) && (a == nil || a.IsUnspecified()):
 		return nil, fmt.Errorf("dhcp: no offered server IP")
@@ -168,6 +280,24 @@ func (r rawLease) ServerIP() (net.IP, error) {
 	}
 }
 
+// Prefers the router option since giaddr is the relay agent's address, which
+// is only the gateway for simple setups.
+func gatewayIP(p *dhcpv4.DHCPv4) net.IP {
+	if rs := p.Router(); len(rs) != 0 {
+		return rs[0]
+	}
+	return p.GatewayIPAddr
+}
+
+// Prefers the server identifier option since siaddr is the next server to use
+// for bootstrapping, which isn't necessarily the DHCP server.
+func serverIP(p *dhcpv4.DHCPv4) net.IP {
+	if ip := p.ServerIdentifier(); ip != nil {
+		return ip
+	}
+	return p.ServerIPAddr
+}
+
 func (r rawLease) LeaseTime(def time.Duration) (time.Duration, error) {
 	o, a := r.Offer.IPAddressLeaseTime(def), r.ACK.IPAddressLeaseTime(def)
 	switch {
@@ -0,0 +1,126 @@
+// A dhcp.LeaseStore backed by a file on the local filesystem.
+package leasefile // import "go.jonnrb.io/egress/vaddr/dhcp/leasefile"
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"go.jonnrb.io/egress/vaddr/dhcp"
+	"golang.org/x/sys/unix"
+)
+
+type LeaseStore struct {
+	// The file to store the lease in. A lock file with a ".lock" suffix is
+	// created next to it.
+	Path string
+}
+
+func (s *LeaseStore) Get(ctx context.Context) (l dhcp.Lease, err error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	return s.read()
+}
+
+func (s *LeaseStore) Put(ctx context.Context, l dhcp.Lease) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.write(l)
+}
+
+func (s *LeaseStore) CompareAndSwap(ctx context.Context, old, l dhcp.Lease) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cur, err := s.read()
+	if err != nil {
+		return err
+	}
+	if !cur.Equal(old) {
+		return dhcp.ErrLeaseChanged
+	}
+	return s.write(l)
+}
+
+// Takes an exclusive lock shared with other processes using the same Path.
+func (s *LeaseStore) lock() (unlock func(), err error) {
+	f, err := os.OpenFile(s.Path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("leasefile: could not open lock file: %w", err)
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("leasefile: could not lock %q: %w", f.Name(), err)
+	}
+	return func() {
+		unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+func (s *LeaseStore) read() (l dhcp.Lease, err error) {
+	d, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		// Like an absent ConfigMap, this is an empty (and long expired) lease.
+		err = nil
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("leasefile: could not read lease: %w", err)
+		return
+	}
+	return dhcp.UnmarshalLease(d)
+}
+
+// Atomically replaces the file at s.Path with l. The data and the rename are
+// both synced to disk before returning.
+func (s *LeaseStore) write(l dhcp.Lease) error {
+	dir, base := filepath.Split(s.Path)
+	if dir == "" {
+		dir = "."
+	}
+
+	f, err := ioutil.TempFile(dir, base+".tmp")
+	if err != nil {
+		return fmt.Errorf("leasefile: could not create temp file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(dhcp.MarshalLease(l))
+	if err == nil {
+		err = f.Sync()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return fmt.Errorf("leasefile: could not write lease: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), s.Path); err != nil {
+		return fmt.Errorf("leasefile: could not replace lease: %w", err)
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("leasefile: could not open %q: %w", dir, err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("leasefile: could not sync %q: %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package leasefile_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.jonnrb.io/egress/vaddr/dhcp"
+	"go.jonnrb.io/egress/vaddr/dhcp/leasefile"
+)
+
+func testLease(day int) dhcp.Lease {
+	return dhcp.Lease{
+		LeasedIP:    net.IPv4(10, 11, 11, 17),
+		SubnetMask:  24,
+		GatewayIP:   net.IPv4(10, 11, 11, 1),
+		ServerIP:    net.IPv4(10, 11, 11, 32),
+		StartTime:   time.Date(2020, 10, day, 11, 11, 11, 0, time.UTC),
+		Duration:    7 * 24 * time.Hour,
+		RenewAfter:  24 * time.Hour,
+		RebindAfter: 24 * time.Hour,
+	}
+}
+
+func newStore(t *testing.T) (*leasefile.LeaseStore, func()) {
+	dir, err := ioutil.TempDir("", "leasefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &leasefile.LeaseStore{Path: filepath.Join(dir, "lease.json")},
+		func() { os.RemoveAll(dir) }
+}
+
+func TestGetEmpty(t *testing.T) {
+	s, cleanup := newStore(t)
+	defer cleanup()
+
+	l, err := s.Get(context.Background())
+
+	if err != nil {
+		t.Errorf("no lease should not error; got: %v", err)
+	} else if diff := cmp.Diff(l, dhcp.Lease{}); diff != "" {
+		t.Errorf("no lease should be an empty lease; diff: %v", diff)
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	s, cleanup := newStore(t)
+	defer cleanup()
+
+	for _, day := range []int{9, 10} {
+		if err := s.Put(context.Background(), testLease(day)); err != nil {
+			t.Fatalf("expected err == nil; got: %v", err)
+		}
+		l, err := s.Get(context.Background())
+		if err != nil {
+			t.Fatalf("expected err == nil; got: %v", err)
+		}
+		if diff := cmp.Diff(testLease(day), l); diff != "" {
+			t.Errorf("lease wasn't updated; diff: %v", diff)
+		}
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(s.Path), "*.tmp*"))
+	if len(matches) != 0 {
+		t.Errorf("temp files left behind: %v", matches)
+	}
+}
+
+func TestGetBadSchemaVersion(t *testing.T) {
+	s, cleanup := newStore(t)
+	defer cleanup()
+
+	err := ioutil.WriteFile(s.Path, []byte(`{"version": 1000}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get(context.Background()); err == nil {
+		t.Error("expected err != nil for unsupported schema version")
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	s, cleanup := newStore(t)
+	defer cleanup()
+
+	if err := s.CompareAndSwap(context.Background(), dhcp.Lease{}, testLease(9)); err != nil {
+		t.Fatalf("expected err == nil; got: %v", err)
+	}
+	if err := s.CompareAndSwap(context.Background(), dhcp.Lease{}, testLease(10)); err != dhcp.ErrLeaseChanged {
+		t.Errorf("expected err == dhcp.ErrLeaseChanged; got: %v", err)
+	}
+	if err := s.CompareAndSwap(context.Background(), testLease(9), testLease(10)); err != nil {
+		t.Errorf("expected err == nil; got: %v", err)
+	}
+
+	l, err := s.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(testLease(10), l); diff != "" {
+		t.Errorf("wrong lease stored; diff: %v", diff)
+	}
+}
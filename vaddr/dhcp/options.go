@@ -0,0 +1,40 @@
+package dhcp
+
+import (
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// Options identifying the client to the DHCP server. Most servers don't care,
+// but some ISPs won't give out a lease without them.
+type ClientOptions struct {
+	// Option 61. The first byte is the type, e.g. 1 for an Ethernet address
+	// followed by the address.
+	ClientID []byte
+
+	// Option 12.
+	Hostname string
+
+	// Option 60.
+	VendorClass string
+}
+
+// Modifiers for DISCOVERs and REQUESTs.
+func (o ClientOptions) modifiers() (m []dhcpv4.Modifier) {
+	m = append(m, o.idModifiers()...)
+	if o.Hostname != "" {
+		m = append(m, dhcpv4.WithOption(dhcpv4.OptHostName(o.Hostname)))
+	}
+	if o.VendorClass != "" {
+		m = append(m, dhcpv4.WithOption(dhcpv4.OptClassIdentifier(o.VendorClass)))
+	}
+	return
+}
+
+// Modifiers for DECLINEs and RELEASEs, which must identify the client the same
+// way it was identified when it got its lease (RFC 2131 section 4.4.4).
+func (o ClientOptions) idModifiers() (m []dhcpv4.Modifier) {
+	if len(o.ClientID) != 0 {
+		m = append(m, dhcpv4.WithOption(dhcpv4.OptClientIdentifier(o.ClientID)))
+	}
+	return
+}
@@ -0,0 +1,57 @@
+package dhcp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Formats the DNS configuration in l as a resolv.conf(5).
+func ResolvConf(l Lease) []byte {
+	var b bytes.Buffer
+	fmt.Fprintln(&b, "# Generated from the DHCP lease for", l.LeasedIP)
+	switch {
+	case len(l.DomainSearch) != 0:
+		fmt.Fprintln(&b, "search", strings.Join(l.DomainSearch, " "))
+	case l.DomainName != "":
+		fmt.Fprintln(&b, "search", l.DomainName)
+	}
+	for _, ip := range l.DNS {
+		fmt.Fprintln(&b, "nameserver", ip)
+	}
+	return b.Bytes()
+}
+
+// Atomically replaces the file at path with ResolvConf(l). Resolvers that watch
+// the file (e.g. dnsmasq's resolv-file) will never see it partially written.
+func WriteResolvConf(path string, l Lease) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	f, err := ioutil.TempFile(dir, base+".tmp")
+	if err != nil {
+		return fmt.Errorf("dhcp: could not create temp file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(ResolvConf(l))
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("dhcp: could not write resolv.conf: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("dhcp: could not replace %q: %w", path, err)
+	}
+	return nil
+}
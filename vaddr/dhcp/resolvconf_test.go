@@ -0,0 +1,23 @@
+package dhcp
+
+import (
+	"net"
+	"testing"
+)
+
+func TestResolvConf(t *testing.T) {
+	l := Lease{
+		LeasedIP:   net.IPv4(10, 11, 11, 17),
+		DNS:        []net.IP{net.IPv4(10, 11, 11, 53)},
+		DomainName: "example.com",
+	}
+
+	got := string(ResolvConf(l))
+
+	want := "# Generated from the DHCP lease for 10.11.11.17\n" +
+		"search example.com\n" +
+		"nameserver 10.11.11.53\n"
+	if got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+}
@@ -0,0 +1,168 @@
+package dhcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"go.jonnrb.io/egress/fw"
+)
+
+// The version of the serialized lease schema written by MarshalLease. Leases
+// written before the schema was versioned are treated as version 1.
+const leaseSchemaVersion = 1
+
+type serializableLease struct {
+	Version     int       `json:"version,omitempty"`
+	LeasedIP    string    `json:"leasedIP"`
+	GatewayIP   string    `json:"gatewayIP"`
+	ServerIP    string    `json:"serverIP"`
+	StartTime   time.Time `json:"startTime"`
+	Duration    int       `json:"duration"`
+	RenewAfter  int       `json:"renewAfter"`
+	RebindAfter int       `json:"rebindAfter"`
+
+	DNS          []string            `json:"dns,omitempty"`
+	DomainName   string              `json:"domainName,omitempty"`
+	DomainSearch []string            `json:"domainSearch,omitempty"`
+	MTU          int                 `json:"mtu,omitempty"`
+	Routes       []serializableRoute `json:"routes,omitempty"`
+	NTP          []string            `json:"ntp,omitempty"`
+	Hostname     string              `json:"hostname,omitempty"`
+}
+
+type serializableRoute struct {
+	Dest   string `json:"dest"`
+	Router string `json:"router"`
+}
+
+// Serializes l as JSON for a LeaseStore.
+func MarshalLease(l Lease) []byte {
+	b, err := json.Marshal(
+		serializableLease{
+			Version:     leaseSchemaVersion,
+			LeasedIP:    fmt.Sprintf("%s/%d", l.LeasedIP, l.SubnetMask),
+			GatewayIP:   l.GatewayIP.String(),
+			ServerIP:    l.ServerIP.String(),
+			StartTime:   l.StartTime,
+			Duration:    int(l.Duration / time.Millisecond),
+			RenewAfter:  int(l.RenewAfter / time.Millisecond),
+			RebindAfter: int(l.RebindAfter / time.Millisecond),
+
+			DNS:          ipStrings(l.DNS),
+			DomainName:   l.DomainName,
+			DomainSearch: l.DomainSearch,
+			MTU:          l.MTU,
+			Routes:       routeStrings(l.Routes),
+			NTP:          ipStrings(l.NTP),
+			Hostname:     l.Hostname,
+		})
+	if err != nil {
+		panic(fmt.Sprintf("dhcp: could not marshal lease: %+v", l))
+	}
+	return b
+}
+
+// Parses a lease serialized by MarshalLease.
+func UnmarshalLease(d []byte) (l Lease, err error) {
+	var s serializableLease
+	err = json.Unmarshal(d, &s)
+	if err != nil {
+		err = fmt.Errorf("dhcp: could not unmarshal lease %q: %w", d, err)
+		return
+	}
+	return parseLease(s)
+}
+
+func parseLease(s serializableLease) (l Lease, err error) {
+	if s.Version > leaseSchemaVersion {
+		err = fmt.Errorf(
+			"dhcp: lease schema version %d is newer than supported version %d",
+			s.Version, leaseSchemaVersion)
+		return
+	}
+	leasedAddr, err := fw.ParseAddr(s.LeasedIP)
+	if err != nil {
+		err = fmt.Errorf("dhcp: %q is not a valid IP: %w", s.LeasedIP, err)
+		return
+	}
+	l.LeasedIP = leasedAddr.IP
+	l.SubnetMask, _ = leasedAddr.Mask.Size()
+	l.GatewayIP = net.ParseIP(s.GatewayIP)
+	if l.GatewayIP == nil {
+		err = fmt.Errorf("dhcp: %q is not a valid IP", s.GatewayIP)
+		return
+	}
+	l.ServerIP = net.ParseIP(s.ServerIP)
+	if l.ServerIP == nil {
+		err = fmt.Errorf("dhcp: %q is not a valid IP", s.ServerIP)
+		return
+	}
+	l.StartTime = s.StartTime
+	l.Duration = time.Duration(s.Duration) * time.Millisecond
+	l.RenewAfter = time.Duration(s.RenewAfter) * time.Millisecond
+	l.RebindAfter = time.Duration(s.RebindAfter) * time.Millisecond
+
+	l.DNS, err = parseIPs(s.DNS)
+	if err != nil {
+		return
+	}
+	l.DomainName = s.DomainName
+	l.DomainSearch = s.DomainSearch
+	l.MTU = s.MTU
+	l.Routes, err = parseRoutes(s.Routes)
+	if err != nil {
+		return
+	}
+	l.NTP, err = parseIPs(s.NTP)
+	if err != nil {
+		return
+	}
+	l.Hostname = s.Hostname
+	return
+}
+
+func ipStrings(ips []net.IP) (s []string) {
+	for _, ip := range ips {
+		s = append(s, ip.String())
+	}
+	return
+}
+
+func parseIPs(s []string) (ips []net.IP, err error) {
+	for _, i := range s {
+		ip := net.ParseIP(i)
+		if ip == nil {
+			return nil, fmt.Errorf("dhcp: %q is not a valid IP", i)
+		}
+		ips = append(ips, ip)
+	}
+	return
+}
+
+func routeStrings(rs []Route) (s []serializableRoute) {
+	for _, r := range rs {
+		s = append(s, serializableRoute{
+			Dest:   r.Dest.String(),
+			Router: r.Router.String(),
+		})
+	}
+	return
+}
+
+func parseRoutes(s []serializableRoute) (rs []Route, err error) {
+	for _, sr := range s {
+		var r Route
+		_, r.Dest, err = net.ParseCIDR(sr.Dest)
+		if err != nil {
+			return nil, fmt.Errorf("dhcp: %q is not a valid route: %w", sr.Dest, err)
+		}
+		r.Router = net.ParseIP(sr.Router)
+		if r.Router == nil {
+			return nil, fmt.Errorf("dhcp: %q is not a valid IP", sr.Router)
+		}
+		rs = append(rs, r)
+	}
+	return
+}
@@ -0,0 +1,88 @@
+package dhcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMarshalLease_roundTrip(t *testing.T) {
+	l := Lease{
+		LeasedIP:    net.IPv4(10, 11, 11, 17),
+		SubnetMask:  24,
+		GatewayIP:   net.IPv4(10, 11, 11, 1),
+		ServerIP:    net.IPv4(10, 11, 11, 32),
+		StartTime:   time.Date(2020, 10, 10, 11, 11, 11, 0, time.UTC),
+		Duration:    7 * 24 * time.Hour,
+		RenewAfter:  24 * time.Hour,
+		RebindAfter: 24 * time.Hour,
+	}
+
+	got, err := UnmarshalLease(MarshalLease(l))
+
+	if err != nil {
+		t.Fatalf("expected err == nil; got: %v", err)
+	}
+	if diff := cmp.Diff(l, got); diff != "" {
+		t.Errorf("lease changed in round trip; diff: %v", diff)
+	}
+	if !l.Equal(got) {
+		t.Error("expected round tripped lease to be Equal()")
+	}
+}
+
+func TestMarshalLease_roundTripOptions(t *testing.T) {
+	_, dest, _ := net.ParseCIDR("10.20.0.0/16")
+	l := Lease{
+		LeasedIP:     net.IPv4(10, 11, 11, 17),
+		SubnetMask:   24,
+		GatewayIP:    net.IPv4(10, 11, 11, 1),
+		ServerIP:     net.IPv4(10, 11, 11, 32),
+		StartTime:    time.Date(2020, 10, 10, 11, 11, 11, 0, time.UTC),
+		Duration:     7 * 24 * time.Hour,
+		RenewAfter:   24 * time.Hour,
+		RebindAfter:  24 * time.Hour,
+		DNS:          []net.IP{net.IPv4(10, 11, 11, 53), net.IPv4(10, 11, 11, 54)},
+		DomainName:   "example.com",
+		DomainSearch: []string{"example.com", "corp.example.com"},
+		MTU:          1492,
+		Routes:       []Route{{Dest: dest, Router: net.IPv4(10, 11, 11, 2)}},
+		NTP:          []net.IP{net.IPv4(10, 11, 11, 123)},
+		Hostname:     "gw",
+	}
+
+	got, err := UnmarshalLease(MarshalLease(l))
+
+	if err != nil {
+		t.Fatalf("expected err == nil; got: %v", err)
+	}
+	if !l.Equal(got) {
+		t.Errorf("expected round tripped lease to be Equal(); got: %+v", got)
+	}
+}
+
+func TestUnmarshalLease_unversioned(t *testing.T) {
+	_, err := UnmarshalLease([]byte(`{
+	  "leasedIP":    "10.11.11.17/24",
+	  "gatewayIP":   "10.11.11.1",
+	  "serverIP":    "10.11.11.32",
+	  "startTime":   "2020-10-10T11:11:11Z",
+	  "duration":    604800,
+	  "renewAfter":  86400,
+	  "rebindAfter": 86400
+	}`))
+
+	if err != nil {
+		t.Errorf("expected err == nil; got: %v", err)
+	}
+}
+
+func TestUnmarshalLease_newerVersion(t *testing.T) {
+	_, err := UnmarshalLease([]byte(`{"version": 2}`))
+
+	if err == nil {
+		t.Error("expected err != nil")
+	}
+}
@@ -3,6 +3,7 @@ package vaddr
 import (
 	"context"
 
+	"go.jonnrb.io/egress/tracing"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -29,12 +30,24 @@ type CombinedWrappers struct {
 }
 
 func (s *CombinedWrappers) Start() error {
+	return s.StartContext(context.Background())
+}
+
+func (s *CombinedWrappers) Stop() error {
+	return s.StopContext(context.Background())
+}
+
+// Like Start(), but each Wrapper's Start() is traced as a child of any span in
+// ctx.
+func (s *CombinedWrappers) StartContext(ctx context.Context) error {
 	s.activeWrappers = make([]Wrapper, len(s.Wrappers))[:0]
 	for _, w := range s.Wrappers {
+		_, span := tracing.Start(ctx, "vaddr.Wrapper.Start", tracing.Type(w))
 		err := w.Start()
+		tracing.End(span, err)
 		if err != nil {
 			s.errs = append(s.errs, err)
-			s.Stop()
+			s.StopContext(ctx)
 			return s.err()
 		}
 		s.activeWrappers = append(s.activeWrappers, w)
@@ -42,9 +55,13 @@ func (s *CombinedWrappers) Start() error {
 	return nil
 }
 
-func (s *CombinedWrappers) Stop() error {
+// Like Stop(), but each Wrapper's Stop() is traced as a child of any span in
+// ctx.
+func (s *CombinedWrappers) StopContext(ctx context.Context) error {
 	for _, w := range reversedWrappers(s.activeWrappers) {
+		_, span := tracing.Start(ctx, "vaddr.Wrapper.Stop", tracing.Type(w))
 		err := w.Stop()
+		tracing.End(span, err)
 		if err != nil {
 			s.errs = append(s.errs, err)
 		}
@@ -62,7 +79,15 @@ func (s CombinedActives) Run(ctx context.Context) error {
 	for i := range s {
 		a := s[i]
 		eg.Go(func() error {
-			return a.Run(ctx)
+			ctx, span := tracing.Start(ctx, "vaddr.Active.Run", tracing.Type(a))
+			err := a.Run(ctx)
+			if err == context.Canceled {
+				// Being stopped isn't a failure.
+				tracing.End(span, nil)
+			} else {
+				tracing.End(span, err)
+			}
+			return err
 		})
 	}
 
@@ -0,0 +1,322 @@
+// Package srcroute routes individual LAN clients through alternate uplinks
+// using source-based policy routing.
+package srcroute
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/fw/rules"
+	"go.jonnrb.io/egress/log"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Chains holding the per-client rules. They are created by ChainRules().
+	filterChain = "fw-uplinks"
+	natChain    = "masq-uplinks"
+
+	defaultPriority = 1000
+
+	// Keeps selected clients from falling back to the main table (and so the
+	// default uplink) when their uplink's route is missing.
+	unreachableMetric = 4096
+)
+
+// An uplink clients can be routed through instead of the default one.
+type Uplink struct {
+	// What clients select the uplink by.
+	Name string
+
+	Link fw.Link
+
+	// The next hop. If nil, traffic is routed directly out Link (e.g. for
+	// point-to-point tunnels).
+	GW net.IP
+
+	// The routing table holding the uplink's default route.
+	Table int
+}
+
+// Maps LAN client IPs to the name of the uplink they should use. Clients not
+// in a Selection use the default uplink.
+type Selection map[string]string
+
+// Rules creating the chains Router fills in. These belong in the firewall
+// rendered for the fw.Config.
+func ChainRules() rules.RuleSet {
+	return rules.RuleSet{
+		rules.Rule("-t filter -N " + filterChain),
+		rules.Rule("-t filter -A fw-interfaces -j " + filterChain),
+		rules.Rule("-t nat -N " + natChain),
+		rules.Rule("-t nat -A POSTROUTING -j " + natChain),
+	}
+}
+
+// Installs a routing table per uplink while started and routes clients
+// through them according to the last Selection passed to Select(). Only IPv4
+// clients are supported.
+type Router struct {
+	LAN     fw.Link
+	Uplinks []Uplink
+
+	// Maps the names of links whose clients all use an uplink (e.g. a guest
+	// LAN) to the uplink's name. They are routed while started. Only the
+	// default route is overridden for them.
+	Links map[string]string
+
+	// The priority of the per-client policy routing rules. Defaults to 1000.
+	// The per-link rules come right after.
+	Priority int
+
+	mu       sync.Mutex
+	selected Selection
+}
+
+func (r *Router) Start() error {
+	for _, u := range r.Uplinks {
+		routes, err := u.routes()
+		if err != nil {
+			return err
+		}
+		for _, route := range routes {
+			if err := netlink.RouteReplace(route); err != nil {
+				return fmt.Errorf("srcroute: could not add route for uplink %q: %w", u.Name, err)
+			}
+		}
+	}
+	for link, name := range r.Links {
+		for _, rule := range r.linkRules(link, name) {
+			if err := netlink.RuleAdd(rule); err != nil && err != unix.EEXIST {
+				return fmt.Errorf("srcroute: could not add rule for link %q: %w", link, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Router) Stop() error {
+	err := r.Select(nil)
+	for link, name := range r.Links {
+		for _, rule := range r.linkRules(link, name) {
+			if rerr := netlink.RuleDel(rule); rerr != nil && rerr != unix.ENOENT && err == nil {
+				err = fmt.Errorf("srcroute: could not remove rule for link %q: %w", link, rerr)
+			}
+		}
+	}
+	for _, u := range r.Uplinks {
+		routes, rerr := u.routes()
+		if rerr != nil {
+			if err == nil {
+				err = rerr
+			}
+			continue
+		}
+		for _, route := range routes {
+			if rerr := netlink.RouteDel(route); rerr != nil && rerr != unix.ESRCH && err == nil {
+				err = fmt.Errorf("srcroute: could not remove route for uplink %q: %w", u.Name, rerr)
+			}
+		}
+	}
+	return err
+}
+
+func (u Uplink) routes() ([]*netlink.Route, error) {
+	l, err := netlink.LinkByName(u.Link.Name())
+	if err != nil {
+		return nil, fmt.Errorf("srcroute: could not get link %q: %w", u.Link.Name(), err)
+	}
+	return []*netlink.Route{
+		{
+			LinkIndex: l.Attrs().Index,
+			Dst:       defaultDst(),
+			Gw:        u.GW,
+			Table:     u.Table,
+		},
+		{
+			Dst:      defaultDst(),
+			Type:     unix.RTN_UNREACHABLE,
+			Priority: unreachableMetric,
+			Table:    u.Table,
+		},
+	}, nil
+}
+
+func defaultDst() *net.IPNet {
+	return &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}
+}
+
+// Routes the clients in sel through their uplinks and everyone else through
+// the default one. Clients selecting an unknown uplink are left on the default
+// one. Clients that could not be routed are retried by the next call.
+func (r *Router) Select(sel Selection) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var err error
+	r.selected, err = r.updateRules(r.valid(sel), netlink.RuleAdd, netlink.RuleDel)
+	filter, nat := r.chainRules(r.selected)
+	for _, c := range []struct {
+		chain fw.Chain
+		specs []string
+	}{
+		{fw.Chain{Table: "filter", Name: filterChain}, filter},
+		{fw.Chain{Table: "nat", Name: natChain}, nat},
+	} {
+		if cerr := fw.ReplaceChain(c.chain, c.specs); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Adds and removes policy routing rules to move from r.selected to sel,
+// returning the selection actually in place.
+func (r *Router) updateRules(sel Selection, add, del func(*netlink.Rule) error) (Selection, error) {
+	var firstErr error
+	record := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	installed := make(Selection)
+	for ip, name := range r.selected {
+		if sel[ip] == name {
+			installed[ip] = name
+			continue
+		}
+		if err := del(r.rule(ip, name)); err != nil && err != unix.ENOENT {
+			record(fmt.Errorf("srcroute: could not remove rule for %v: %w", ip, err))
+			installed[ip] = name
+			continue
+		}
+		log.V(2).Infof("srcroute: %v no longer routed through %q", ip, name)
+	}
+	for ip, name := range sel {
+		// Either already routed or still routed through its old uplink.
+		if _, ok := installed[ip]; ok {
+			continue
+		}
+		if err := add(r.rule(ip, name)); err != nil && err != unix.EEXIST {
+			record(fmt.Errorf("srcroute: could not add rule for %v: %w", ip, err))
+			continue
+		}
+		installed[ip] = name
+		log.V(2).Infof("srcroute: routing %v through %q", ip, name)
+	}
+	return installed, firstErr
+}
+
+// Drops clients with invalid IPs or unknown uplinks from sel.
+func (r *Router) valid(sel Selection) Selection {
+	valid := make(Selection)
+	for ip, name := range sel {
+		if parsed := net.ParseIP(ip); parsed == nil || parsed.To4() == nil {
+			log.Warningf("srcroute: ignoring selection for non-IPv4 client %q", ip)
+			continue
+		}
+		if _, ok := r.uplink(name); !ok {
+			log.Warningf("srcroute: %v selected unknown uplink %q", ip, name)
+			continue
+		}
+		valid[ip] = name
+	}
+	return valid
+}
+
+func (r *Router) uplink(name string) (Uplink, bool) {
+	for _, u := range r.Uplinks {
+		if u.Name == name {
+			return u, true
+		}
+	}
+	return Uplink{}, false
+}
+
+func (r *Router) rule(ip, name string) *netlink.Rule {
+	u, _ := r.uplink(name)
+	rule := netlink.NewRule()
+	rule.Src = &net.IPNet{IP: net.ParseIP(ip).To4(), Mask: net.CIDRMask(32, 32)}
+	rule.Table = u.Table
+	rule.Priority = r.priority()
+	return rule
+}
+
+// Routes traffic in link through the uplink's table unless the main table has
+// a more specific route than the default (e.g. to another LAN).
+func (r *Router) linkRules(link, name string) []*netlink.Rule {
+	u, _ := r.uplink(name)
+	local := netlink.NewRule()
+	local.IifName = link
+	local.Table = unix.RT_TABLE_MAIN
+	local.SuppressPrefixlen = 0
+	local.Priority = r.priority() + 1
+
+	uplink := netlink.NewRule()
+	uplink.IifName = link
+	uplink.Table = u.Table
+	uplink.Priority = r.priority() + 2
+	return []*netlink.Rule{local, uplink}
+}
+
+func (r *Router) priority() int {
+	if r.Priority == 0 {
+		return defaultPriority
+	}
+	return r.Priority
+}
+
+// The rules of the per-client filter and nat chains routing sel.
+func (r *Router) chainRules(sel Selection) (filter, nat []string) {
+	var ips []string
+	for ip := range sel {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	for _, ip := range ips {
+		u, _ := r.uplink(sel[ip])
+		filter = append(filter, fmt.Sprintf(
+			"-j ACCEPT -s %s/32 -i %s -o %s", ip, r.LAN.Name(), u.Link.Name()))
+		nat = append(nat, fmt.Sprintf(
+			"-j MASQUERADE -s %s/32 -o %s", ip, u.Link.Name()))
+	}
+	return filter, nat
+}
+
+// Watches for changes in which clients select which uplinks.
+type Source interface {
+	// Calls update with the full Selection whenever it changes until ctx is
+	// canceled.
+	Watch(ctx context.Context, update func(Selection)) error
+}
+
+// Feeds a Router the selections from a Source while active.
+type Selector struct {
+	Router *Router
+	Source Source
+}
+
+func (s *Selector) Run(ctx context.Context) error {
+	return s.Source.Watch(ctx, func(sel Selection) {
+		if err := s.Router.Select(sel); err != nil {
+			log.Errorf("srcroute: %v", err)
+		}
+	})
+}
+
+func (r *Router) String() string {
+	return fmt.Sprintf(
+		"source routing from %s through %d uplinks (%d LAN links routed wholesale)",
+		r.LAN.Name(), len(r.Uplinks), len(r.Links))
+}
+
+func (s *Selector) String() string {
+	return fmt.Sprintf("uplink selection for %s", s.Router.LAN.Name())
+}
@@ -0,0 +1,120 @@
+package srcroute
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"go.jonnrb.io/egress/fw"
+	"golang.org/x/sys/unix"
+)
+
+func testRouter() *Router {
+	return &Router{
+		LAN: fw.LinkString("eth1"),
+		Uplinks: []Uplink{
+			{Name: "vpn", Link: fw.LinkString("tun0"), Table: 100},
+			{Name: "isp2", Link: fw.LinkString("eth3"), Table: 101},
+		},
+	}
+}
+
+func TestValid(t *testing.T) {
+	r := testRouter()
+	got := r.valid(Selection{
+		"10.0.0.2": "vpn",
+		"10.0.0.3": "nope",
+		"fd00::3":  "vpn",
+		"garbage":  "isp2",
+	})
+	want := Selection{"10.0.0.2": "vpn"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("valid() = %v; want %v", got, want)
+	}
+}
+
+func TestChainRules(t *testing.T) {
+	r := testRouter()
+	filter, nat := r.chainRules(Selection{"10.0.0.3": "isp2", "10.0.0.2": "vpn"})
+	wantFilter := []string{
+		"-j ACCEPT -s 10.0.0.2/32 -i eth1 -o tun0",
+		"-j ACCEPT -s 10.0.0.3/32 -i eth1 -o eth3",
+	}
+	wantNAT := []string{
+		"-j MASQUERADE -s 10.0.0.2/32 -o tun0",
+		"-j MASQUERADE -s 10.0.0.3/32 -o eth3",
+	}
+	if !reflect.DeepEqual(filter, wantFilter) || !reflect.DeepEqual(nat, wantNAT) {
+		t.Errorf("chainRules() = %q, %q; want %q, %q", filter, nat, wantFilter, wantNAT)
+	}
+}
+
+func TestUpdateRules_retriesFailures(t *testing.T) {
+	r := testRouter()
+	r.selected = Selection{"10.0.0.2": "vpn", "10.0.0.4": "vpn"}
+
+	fail := map[string]bool{"10.0.0.3": true, "10.0.0.4": true}
+	var added []string
+	add := func(rule *netlink.Rule) error {
+		ip := rule.Src.IP.String()
+		if fail[ip] {
+			return unix.EPERM
+		}
+		added = append(added, ip)
+		return nil
+	}
+	del := func(rule *netlink.Rule) error {
+		if fail[rule.Src.IP.String()] {
+			return unix.EPERM
+		}
+		return nil
+	}
+
+	got, err := r.updateRules(Selection{"10.0.0.3": "isp2", "10.0.0.4": "isp2", "10.0.0.5": "isp2"}, add, del)
+	if err == nil {
+		t.Error("expected an error")
+	}
+	want := Selection{"10.0.0.4": "vpn", "10.0.0.5": "isp2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("updateRules() = %v; want %v", got, want)
+	}
+
+	// Once the failures clear, the next update installs what was missed.
+	r.selected = got
+	fail = nil
+	added = nil
+	got, err = r.updateRules(Selection{"10.0.0.3": "isp2", "10.0.0.4": "isp2", "10.0.0.5": "isp2"}, add, del)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(added)
+	if want := []string{"10.0.0.3", "10.0.0.4"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added rules for %v; want %v", added, want)
+	}
+	if want := (Selection{"10.0.0.3": "isp2", "10.0.0.4": "isp2", "10.0.0.5": "isp2"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("updateRules() = %v; want %v", got, want)
+	}
+}
+
+func TestRule(t *testing.T) {
+	r := testRouter()
+	rule := r.rule("10.0.0.3", "isp2")
+	if rule.Table != 101 || rule.Priority != defaultPriority || rule.Src.String() != "10.0.0.3/32" {
+		t.Errorf("rule() = %+v", rule)
+	}
+}
+
+func TestLinkRules(t *testing.T) {
+	r := testRouter()
+	rs := r.linkRules("eth4", "vpn")
+	if len(rs) != 2 {
+		t.Fatalf("expected 2 rules; got %d", len(rs))
+	}
+	if rs[0].IifName != "eth4" || rs[0].Table != unix.RT_TABLE_MAIN || rs[0].SuppressPrefixlen != 0 {
+		t.Errorf("expected eth4 to use the main table's specific routes first; got %+v", rs[0])
+	}
+	if rs[1].IifName != "eth4" || rs[1].Table != 100 || rs[1].Priority <= rs[0].Priority {
+		t.Errorf("expected eth4 to then use table 100; got %+v", rs[1])
+	}
+}
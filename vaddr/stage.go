@@ -0,0 +1,38 @@
+package vaddr
+
+import "context"
+
+// Implemented by Wrappers and Actives that can do some of their work ahead of
+// time (e.g. while following in an HA deployment) so that Start() or Run() is
+// quicker when it's time to take over.
+type Stager interface {
+	// Validates and caches whatever can be done without taking ownership of
+	// any addresses. May be called any number of times.
+	Stage(ctx context.Context) error
+}
+
+// Stages every Wrapper and Active in s that is a Stager. All are staged even
+// if some fail; the first error is returned.
+func Stage(ctx context.Context, s Suite) error {
+	var err error
+	try := func(v interface{}) {
+		st, ok := v.(Stager)
+		if !ok {
+			return
+		}
+		if e := st.Stage(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	for _, w := range s.Wrappers {
+		try(w)
+	}
+	for _, a := range s.Actives {
+		try(a)
+	}
+	return err
+}
+
+func (s *CombinedWrappers) Stage(ctx context.Context) error {
+	return Stage(ctx, Suite{Wrappers: s.Wrappers})
+}
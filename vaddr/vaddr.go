@@ -47,12 +47,12 @@ type Suite struct {
 func (s Suite) Run(ctx context.Context) (err error) {
 	w, a := Split(s)
 
-	err = w.Start()
+	err = w.StartContext(ctx)
 	if err != nil {
 		return
 	}
 	defer func() {
-		errStop := w.Stop()
+		errStop := w.StopContext(ctx)
 		if err == nil {
 			err = errStop
 		}
@@ -7,6 +7,10 @@ import (
 	"strings"
 	"sync"
 	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func successfulWrapper(name string) func(func(string)) Wrapper {
@@ -243,3 +247,75 @@ func TestSuite_multipleFailingAction(t *testing.T) {
 		t.Errorf("got bad action sequence: %v", a)
 	}
 }
+
+type stager struct {
+	Wrapper
+	err    error
+	staged *int
+}
+
+func (s stager) Stage(ctx context.Context) error {
+	*s.staged++
+	return s.err
+}
+
+func TestStage(t *testing.T) {
+	var n int
+	var r actionRecorder
+	s := newTestSuite(r.record, successfulWrapper("W1"))
+	s.Wrappers = append(s.Wrappers,
+		stager{Wrapper: s.Wrappers[0], err: errors.New("failS1"), staged: &n},
+		&CombinedWrappers{Wrappers: []Wrapper{
+			stager{Wrapper: s.Wrappers[0], staged: &n},
+		}})
+	s.Actives = append(s.Actives, struct {
+		Active
+		Stager
+	}{nil, stager{staged: &n, err: errors.New("failS2")}})
+
+	err := Stage(context.Background(), s)
+
+	if err == nil || err.Error() != "failS1" {
+		t.Errorf("expected err == errors.New(\"failS1\"); got err == %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 stagers to be staged; got %d", n)
+	}
+	if a := r.get(); a != "" {
+		t.Errorf("staging should not start anything; got: %v", a)
+	}
+}
+
+func TestCombinedWrappers_traced(t *testing.T) {
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	otel.SetTracerProvider(tp)
+
+	noop := func(string) {}
+	w := &CombinedWrappers{Wrappers: []Wrapper{
+		successfulWrapper("A")(noop),
+		failingInitWrapper("B")(noop),
+	}}
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	if err := w.StartContext(ctx); err == nil {
+		t.Fatal("expected start to fail")
+	}
+	parent.End()
+
+	var names []string
+	for _, s := range sr.Ended() {
+		if s.Name() == "parent" {
+			continue
+		}
+		if s.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("expected span %q to be a child of the parent", s.Name())
+		}
+		names = append(names, s.Name())
+	}
+	want := "vaddr.Wrapper.Start,vaddr.Wrapper.Start,vaddr.Wrapper.Stop"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("expected spans %q; got %q", want, got)
+	}
+}
@@ -4,24 +4,73 @@ import (
 	"context"
 	"time"
 
-	"go.jonnrb.io/egress/ha"
+	"go.jonnrb.io/egress/log"
+	"go.jonnrb.io/egress/tracing"
 	"go.jonnrb.io/egress/vaddr"
 )
 
+// How often a follower re-stages its virtual addresses. Staged state (like a
+// DHCP lease) goes stale, so this is periodically refreshed.
+const restageInterval = 30 * time.Second
+
 type Member struct {
-	VAddr vaddr.Active
+	VAddr vaddr.Suite
+
+	// If non-nil, called with how long it took to bring up the virtual
+	// addresses after becoming the leader.
+	OnActivated func(d time.Duration)
 }
 
-func New(va vaddr.Active) Member {
-	return Member{va}
+func New(va vaddr.Suite) Member {
+	return Member{VAddr: va}
 }
 
 func (m Member) Lead(
 	ctx context.Context,
-	isLeaseAcceptable func(expiredToleration time.Duration) error) error {
-	return m.VAddr.Run(ctx)
+	isLeaseAcceptable func(expiredToleration time.Duration) error) (err error) {
+	start := time.Now()
+
+	w, a := vaddr.Split(m.VAddr)
+	actx, span := tracing.Start(ctx, "vaddrha.Activate")
+	err = w.StartContext(actx)
+	tracing.End(span, err)
+	if err != nil {
+		return
+	}
+	defer func() {
+		errStop := w.StopContext(ctx)
+		if err == nil {
+			err = errStop
+		}
+	}()
+
+	d := time.Since(start)
+	log.V(2).Infof("vaddrha: virtual addresses up %v after becoming leader", d)
+	if m.OnActivated != nil {
+		m.OnActivated(d)
+	}
+
+	err = a.Run(ctx)
+	return
 }
 
+// Keeps the virtual addresses staged so that Lead() has as little to do as
+// possible. Staging errors are logged, but don't end followship since that
+// could needlessly cause a leadership change.
 func (m Member) Follow(ctx context.Context, leader string) error {
-	return ha.TrivialFollower{}.Follow(ctx, leader)
+	t := time.NewTicker(restageInterval)
+	defer t.Stop()
+
+	for {
+		if err := vaddr.Stage(ctx, m.VAddr); err != nil && ctx.Err() == nil {
+			log.With(log.Leader(leader)).Warningf(
+				"vaddrha: could not stage virtual addresses while following: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
 }
@@ -0,0 +1,82 @@
+package vaddrha
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.jonnrb.io/egress/vaddr"
+)
+
+type stagedWrapper struct {
+	staged  chan struct{}
+	started bool
+}
+
+func (w *stagedWrapper) Start() error {
+	w.started = true
+	return nil
+}
+
+func (w *stagedWrapper) Stop() error {
+	return nil
+}
+
+func (w *stagedWrapper) Stage(ctx context.Context) error {
+	select {
+	case w.staged <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func TestMember_FollowStages(t *testing.T) {
+	w := &stagedWrapper{staged: make(chan struct{}, 1)}
+	m := New(vaddr.Suite{Wrappers: []vaddr.Wrapper{w}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ec := make(chan error, 1)
+	go func() {
+		ec <- m.Follow(ctx, "other")
+	}()
+
+	select {
+	case <-w.staged:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected follower to stage the wrapper")
+	}
+	cancel()
+
+	if err := <-ec; err != context.Canceled {
+		t.Errorf("expected err == context.Canceled; got err == %v", err)
+	}
+	if w.started {
+		t.Error("follower should not start the wrapper")
+	}
+}
+
+func TestMember_LeadReportsActivation(t *testing.T) {
+	w := &stagedWrapper{}
+	var activations int
+	m := Member{
+		VAddr: vaddr.Suite{
+			Wrappers: []vaddr.Wrapper{w},
+			Actives:  []vaddr.Active{vaddr.ActiveWaiter{}},
+		},
+		OnActivated: func(time.Duration) { activations++ },
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := m.Lead(ctx, nil)
+
+	if err != nil {
+		t.Errorf("expected err == nil; got err == %v", err)
+	}
+	if !w.started {
+		t.Error("expected leader to start the wrapper")
+	}
+	if activations != 1 {
+		t.Errorf("expected 1 activation; got %d", activations)
+	}
+}
@@ -1,8 +1,11 @@
 package vaddrutil
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/mdlayher/arp"
 	"go.jonnrb.io/egress/fw"
@@ -46,3 +49,55 @@ var broadcastHWAddr = net.HardwareAddr{255, 255, 255, 255, 255, 255}
 func (a *GratuitousARP) Stop() error {
 	return nil
 }
+
+// How long ProbeARP waits for another host to claim an address.
+const probeWait = time.Second
+
+// Checks whether another host on link claims ip by sending an ARP probe (a
+// request with an unspecified sender IP, as in RFC 5227) and waiting briefly
+// for a reply.
+func ProbeARP(ctx context.Context, link fw.Link, hwAddr net.HardwareAddr, ip net.IP) (inUse bool, err error) {
+	i, err := net.InterfaceByName(link.Name())
+	if err != nil {
+		return false, fmt.Errorf(
+			"vaddrutil: could not get interface %q: %w", link.Name(), err)
+	}
+	c, err := arp.Dial(i)
+	if err != nil {
+		return false, fmt.Errorf("vaddrutil: could not get ARP conn: %w", err)
+	}
+	defer c.Close()
+
+	p, err := arp.NewPacket(
+		arp.OperationRequest, hwAddr, net.IPv4zero, broadcastHWAddr, ip)
+	if err != nil {
+		return false, fmt.Errorf(
+			"vaddrutil: could not construct ARP probe: %w", err)
+	}
+	if err := c.WriteTo(p, broadcastHWAddr); err != nil {
+		return false, fmt.Errorf("vaddrutil: could not write ARP probe: %w", err)
+	}
+
+	deadline := time.Now().Add(probeWait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.SetReadDeadline(deadline)
+
+	for {
+		p, _, err := c.Read()
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("vaddrutil: error reading ARP: %w", err)
+		}
+		if p.SenderIP.Equal(ip) && !bytes.Equal(p.SenderHardwareAddr, hwAddr) {
+			return true, nil
+		}
+	}
+}
+
+func (a *GratuitousARP) String() string {
+	return fmt.Sprintf("gratuitous ARP for %v at %v on %s", a.IP, a.HWAddr, a.Link.Name())
+}
@@ -56,3 +56,7 @@ func (ip *IP) Stop() error {
 	}
 	return nil
 }
+
+func (ip *IP) String() string {
+	return fmt.Sprintf("%v on %s", ip.Addr, ip.Link.Name())
+}
@@ -60,3 +60,7 @@ func (a *VirtualMAC) Stop() error {
 
 	return nil
 }
+
+func (a *VirtualMAC) String() string {
+	return fmt.Sprintf("MAC %v on %s", a.Addr, a.Link.Name())
+}
@@ -0,0 +1,52 @@
+package vaddrutil
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"go.jonnrb.io/egress/fw"
+)
+
+type MTU struct {
+	Link fw.Link
+	MTU  int
+
+	originalMTU int
+}
+
+// Sets the MTU of the link and saves the original MTU.
+func (m *MTU) Start() error {
+	l, err := netlink.LinkByName(m.Link.Name())
+	if err != nil {
+		return fmt.Errorf(
+			"vaddrutil: failed to get link %q: %w", m.Link.Name(), err)
+	}
+
+	m.originalMTU = l.Attrs().MTU
+	if err = netlink.LinkSetMTU(l, m.MTU); err != nil {
+		return fmt.Errorf(
+			"vaddrutil: failed to set link %q MTU %d: %w", m.Link.Name(), m.MTU, err)
+	}
+	return nil
+}
+
+func (m *MTU) Stop() error {
+	if m.originalMTU == 0 {
+		return nil
+	}
+	l, err := netlink.LinkByName(m.Link.Name())
+	if err != nil {
+		return fmt.Errorf(
+			"vaddrutil: failed to get link %q: %w", m.Link.Name(), err)
+	}
+	if err = netlink.LinkSetMTU(l, m.originalMTU); err != nil {
+		return fmt.Errorf(
+			"vaddrutil: failed to set link %q MTU %d: %w",
+			m.Link.Name(), m.originalMTU, err)
+	}
+	return nil
+}
+
+func (m *MTU) String() string {
+	return fmt.Sprintf("MTU %d on %s", m.MTU, m.Link.Name())
+}
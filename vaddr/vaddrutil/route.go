@@ -20,16 +20,7 @@ func (r *DefaultRoute) Start() error {
 	if err != nil {
 		return err
 	}
-	err = netlink.RouteAdd(route)
-	// EEXIST is ok.
-	if errno, ok := err.(syscall.Errno); ok && errno == unix.EEXIST {
-		err = netlink.RouteReplace(route)
-	}
-	if err != nil {
-		return fmt.Errorf(
-			"vaddrutil: could not add/replace route %+v: %w", route, err)
-	}
-	return nil
+	return addRoute(route)
 }
 
 func (r *DefaultRoute) Stop() error {
@@ -37,15 +28,7 @@ func (r *DefaultRoute) Stop() error {
 	if err != nil {
 		return err
 	}
-	err = netlink.RouteDel(route)
-	if errno, ok := err.(syscall.Errno); ok && errno == unix.ESRCH {
-		return nil
-	}
-	if err != nil {
-		return fmt.Errorf(
-			"vaddrutil: failed to delete route %+v: %w", route, err)
-	}
-	return nil
+	return delRoute(route)
 }
 
 func (r *DefaultRoute) route() (*netlink.Route, error) {
@@ -69,3 +52,80 @@ func (r *DefaultRoute) route() (*netlink.Route, error) {
 	}
 	return route, nil
 }
+
+// A route to Dst on Link. If GW is nil or unspecified, Dst is on-link.
+type Route struct {
+	Link fw.Link
+	Dst  *net.IPNet
+	GW   net.IP
+}
+
+func (r *Route) Start() error {
+	route, err := r.route()
+	if err != nil {
+		return err
+	}
+	return addRoute(route)
+}
+
+func (r *Route) Stop() error {
+	route, err := r.route()
+	if err != nil {
+		return err
+	}
+	return delRoute(route)
+}
+
+func (r *Route) route() (*netlink.Route, error) {
+	l, err := netlink.LinkByName(r.Link.Name())
+	if err != nil {
+		return nil, fmt.Errorf(
+			"vaddrutil: failed to get link %q: %w", r.Link.Name(), err)
+	}
+	route := &netlink.Route{
+		LinkIndex: l.Attrs().Index,
+		Dst:       r.Dst,
+	}
+	if r.GW == nil || r.GW.IsUnspecified() {
+		route.Scope = netlink.SCOPE_LINK
+	} else {
+		route.Gw = r.GW
+	}
+	return route, nil
+}
+
+func addRoute(route *netlink.Route) error {
+	err := netlink.RouteAdd(route)
+	// EEXIST is ok.
+	if errno, ok := err.(syscall.Errno); ok && errno == unix.EEXIST {
+		err = netlink.RouteReplace(route)
+	}
+	if err != nil {
+		return fmt.Errorf(
+			"vaddrutil: could not add/replace route %+v: %w", route, err)
+	}
+	return nil
+}
+
+func delRoute(route *netlink.Route) error {
+	err := netlink.RouteDel(route)
+	if errno, ok := err.(syscall.Errno); ok && errno == unix.ESRCH {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf(
+			"vaddrutil: failed to delete route %+v: %w", route, err)
+	}
+	return nil
+}
+
+func (r *DefaultRoute) String() string {
+	return fmt.Sprintf("default route via %v on %s", r.GW.IP, r.Link.Name())
+}
+
+func (r *Route) String() string {
+	if r.GW == nil {
+		return fmt.Sprintf("route to %v on %s", r.Dst, r.Link.Name())
+	}
+	return fmt.Sprintf("route to %v via %v on %s", r.Dst, r.GW, r.Link.Name())
+}
@@ -0,0 +1,49 @@
+package vaddrutil
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"go.jonnrb.io/egress/fw"
+)
+
+// The wrappers here only validate that their link exists when staged. This is
+// cheap and catches a missing interface before it would fail a failover.
+
+func stageLink(l fw.Link) error {
+	if _, err := netlink.LinkByName(l.Name()); err != nil {
+		return fmt.Errorf("vaddrutil: failed to get link %q: %w", l.Name(), err)
+	}
+	return nil
+}
+
+func (a *Up) Stage(ctx context.Context) error {
+	return stageLink(a.Link)
+}
+
+func (a *VirtualMAC) Stage(ctx context.Context) error {
+	return stageLink(a.Link)
+}
+
+func (ip *IP) Stage(ctx context.Context) error {
+	return stageLink(ip.Link)
+}
+
+func (r *DefaultRoute) Stage(ctx context.Context) error {
+	_, err := r.route()
+	return err
+}
+
+func (m *MTU) Stage(ctx context.Context) error {
+	return stageLink(m.Link)
+}
+
+func (r *Route) Stage(ctx context.Context) error {
+	_, err := r.route()
+	return err
+}
+
+func (a *GratuitousARP) Stage(ctx context.Context) error {
+	return stageLink(a.Link)
+}
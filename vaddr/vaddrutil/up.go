@@ -38,3 +38,7 @@ func (a *Up) Stop() error {
 	}
 	return nil
 }
+
+func (a *Up) String() string {
+	return fmt.Sprintf("%s up", a.Link.Name())
+}
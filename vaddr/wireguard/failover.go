@@ -0,0 +1,243 @@
+package wireguard
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.jonnrb.io/egress/log"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	defaultMaxHandshakeAge = 3 * time.Minute
+	defaultCheckInterval   = 10 * time.Second
+	defaultCooldown        = 30 * time.Minute
+)
+
+// Rotates Device through its Config.Candidates when the handshake with the
+// one in use goes stale. Meant to be run while Device is started.
+//
+// Candidates that haven't been tried are preferred (in order), then ones that
+// haven't gone stale within Cooldown (quickest to handshake first), then the
+// rest (least recently stale first).
+type Failover struct {
+	Device *Device
+
+	// How long to go without a handshake before rotating. A newly picked
+	// candidate gets this long to handshake. Defaults to 3 minutes.
+	MaxHandshakeAge time.Duration
+
+	// How often to check the handshake. Defaults to 10 seconds.
+	Interval time.Duration
+
+	// How long to avoid a candidate after it goes stale. Defaults to 30
+	// minutes.
+	Cooldown time.Duration
+
+	// For tests.
+	now func() time.Time
+
+	mu    sync.Mutex
+	since time.Time
+	stats []candidateStats
+}
+
+type candidateStats struct {
+	tried bool
+	// How long the candidate took to handshake after it was last picked. Zero
+	// if it didn't.
+	latency time.Duration
+	stale   time.Time
+}
+
+func (f *Failover) Run(ctx context.Context) error {
+	interval := f.Interval
+	if interval == 0 {
+		interval = defaultCheckInterval
+	}
+
+	f.reset()
+	defer func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.stats = nil
+	}()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			if err := f.check(); err != nil {
+				f.logger().Warningf("%v", err)
+			}
+		}
+	}
+}
+
+func (f *Failover) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.since = f.clock()
+	f.stats = make([]candidateStats, len(f.Device.Config.Candidates))
+	if len(f.stats) > 0 {
+		f.stats[f.Device.Candidate()].tried = true
+	}
+}
+
+func (f *Failover) clock() time.Time {
+	if f.now != nil {
+		return f.now()
+	}
+	return time.Now()
+}
+
+func (f *Failover) logger() log.Logger {
+	return log.With(log.Link(f.Device.Link.Name()))
+}
+
+// Rotates to another candidate if the one in use has gone stale.
+func (f *Failover) check() error {
+	if len(f.Device.Config.Candidates) < 2 {
+		return nil
+	}
+	cur := f.Device.Candidate()
+	hs, err := f.lastHandshake(f.Device.Config.Candidates[cur].PublicKey)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.clock()
+	st := &f.stats[cur]
+	if hs.After(f.since) && st.latency == 0 {
+		st.latency = hs.Sub(f.since)
+	}
+	last := hs
+	if last.Before(f.since) {
+		last = f.since
+	}
+	if now.Sub(last) <= f.maxHandshakeAge() {
+		return nil
+	}
+
+	st.stale = now
+	if !hs.After(f.since) {
+		st.latency = 0
+	}
+	next := f.next(cur, now)
+	f.logger().Warningf(
+		"wireguard: handshake with %v is stale; switching to %v",
+		f.Device.Config.Candidates[cur].Endpoint,
+		f.Device.Config.Candidates[next].Endpoint)
+	if err := f.Device.UseCandidate(next); err != nil {
+		return err
+	}
+	f.since = now
+	f.stats[next].tried = true
+	f.stats[next].latency = 0
+	return nil
+}
+
+func (f *Failover) lastHandshake(key wgtypes.Key) (time.Time, error) {
+	c, err := f.Device.client()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("wireguard: could not open wgctrl: %w", err)
+	}
+	defer c.Close()
+
+	dev, err := c.Device(f.Device.Link.Name())
+	if err != nil {
+		return time.Time{}, fmt.Errorf(
+			"wireguard: could not get %q: %w", f.Device.Link.Name(), err)
+	}
+	for _, p := range dev.Peers {
+		if p.PublicKey == key {
+			return p.LastHandshakeTime, nil
+		}
+	}
+	return time.Time{}, nil
+}
+
+func (f *Failover) maxHandshakeAge() time.Duration {
+	if f.MaxHandshakeAge == 0 {
+		return defaultMaxHandshakeAge
+	}
+	return f.MaxHandshakeAge
+}
+
+// Picks the candidate to rotate to from cur.
+func (f *Failover) next(cur int, now time.Time) int {
+	cooldown := f.Cooldown
+	if cooldown == 0 {
+		cooldown = defaultCooldown
+	}
+	rank := func(i int) int {
+		st := f.stats[i]
+		switch {
+		case !st.tried:
+			return 0
+		case st.stale.IsZero() || now.Sub(st.stale) > cooldown:
+			return 1
+		default:
+			return 2
+		}
+	}
+
+	var idx []int
+	for i := range f.stats {
+		if i != cur {
+			idx = append(idx, i)
+		}
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		i, j := idx[a], idx[b]
+		ri, rj := rank(i), rank(j)
+		if ri != rj {
+			return ri < rj
+		}
+		si, sj := f.stats[i], f.stats[j]
+		switch ri {
+		case 1:
+			if (si.latency == 0) != (sj.latency == 0) {
+				return si.latency != 0
+			}
+			return si.latency < sj.latency
+		case 2:
+			return si.stale.Before(sj.stale)
+		}
+		return false
+	})
+	return idx[0]
+}
+
+// Describes the candidate in use.
+func (f *Failover) State() string {
+	f.mu.Lock()
+	running := f.stats != nil
+	f.mu.Unlock()
+	if !running {
+		return "STOPPED"
+	}
+	n := len(f.Device.Config.Candidates)
+	if n == 0 {
+		return "no candidates"
+	}
+	i := f.Device.Candidate()
+	return fmt.Sprintf(
+		"using %v (candidate %d of %d)", f.Device.Config.Candidates[i].Endpoint, i+1, n)
+}
+
+func (f *Failover) String() string {
+	return fmt.Sprintf(
+		"WireGuard failover on %s across %d candidates",
+		f.Device.Link.Name(), len(f.Device.Config.Candidates))
+}
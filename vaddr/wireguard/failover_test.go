@@ -0,0 +1,134 @@
+package wireguard
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"go.jonnrb.io/egress/fw"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func newTestFailover(t *testing.T, n int) (*Failover, *fakeClient, *time.Time) {
+	c := &fakeClient{}
+	d := &Device{
+		Link:      fw.LinkString("wg0"),
+		NewClient: func() (Client, error) { return c, nil },
+	}
+	for i := 0; i < n; i++ {
+		k, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Config.Candidates = append(d.Config.Candidates, Peer{
+			PublicKey: k.PublicKey(),
+			Endpoint:  &net.UDPAddr{IP: net.IPv4(198, 51, 100, byte(i+1)), Port: 51820},
+		})
+	}
+	now := time.Unix(1600000000, 0)
+	f := &Failover{
+		Device:          d,
+		MaxHandshakeAge: time.Minute,
+		now:             func() time.Time { return now },
+	}
+	f.reset()
+	return f, c, &now
+}
+
+// Reports a handshake at t with candidate i.
+func handshake(f *Failover, c *fakeClient, i int, t time.Time) {
+	c.dev.Peers = []wgtypes.Peer{{
+		PublicKey:         f.Device.Config.Candidates[i].PublicKey,
+		LastHandshakeTime: t,
+	}}
+}
+
+func TestFailover(t *testing.T) {
+	f, c, now := newTestFailover(t, 3)
+	t0 := *now
+
+	handshake(f, c, 0, t0.Add(5*time.Second))
+	*now = t0.Add(30 * time.Second)
+	if err := f.check(); err != nil {
+		t.Fatal(err)
+	}
+	if i := f.Device.Candidate(); i != 0 {
+		t.Fatalf("expected to stay on a fresh candidate; switched to %d", i)
+	}
+
+	*now = t0.Add(2 * time.Minute)
+	if err := f.check(); err != nil {
+		t.Fatal(err)
+	}
+	if i := f.Device.Candidate(); i != 1 {
+		t.Fatalf("expected to switch to candidate 1 on a stale handshake; got %d", i)
+	}
+	got := c.configs[len(c.configs)-1]
+	if len(got.Peers) != 2 ||
+		!got.Peers[0].Remove || got.Peers[0].PublicKey != f.Device.Config.Candidates[0].PublicKey ||
+		got.Peers[1].PublicKey != f.Device.Config.Candidates[1].PublicKey ||
+		got.Peers[1].Endpoint.String() != "198.51.100.2:51820" {
+		t.Errorf("unexpected config on switch: %+v", got)
+	}
+
+	// Candidate 1 never handshakes, so it gets MaxHandshakeAge from when it
+	// was picked.
+	*now = t0.Add(2*time.Minute + 59*time.Second)
+	if err := f.check(); err != nil {
+		t.Fatal(err)
+	}
+	if i := f.Device.Candidate(); i != 1 {
+		t.Fatalf("expected a new candidate to get a grace period; switched to %d", i)
+	}
+	*now = t0.Add(3*time.Minute + time.Second)
+	if err := f.check(); err != nil {
+		t.Fatal(err)
+	}
+	if i := f.Device.Candidate(); i != 2 {
+		t.Fatalf("expected to switch to untried candidate 2; got %d", i)
+	}
+
+	// Everything has gone stale recently, so the least recently stale is next.
+	*now = t0.Add(4*time.Minute + 2*time.Second)
+	if err := f.check(); err != nil {
+		t.Fatal(err)
+	}
+	if i := f.Device.Candidate(); i != 0 {
+		t.Fatalf("expected to switch back to candidate 0; got %d", i)
+	}
+}
+
+func TestFailover_next(t *testing.T) {
+	f, _, now := newTestFailover(t, 4)
+	long := now.Add(-time.Hour)
+	f.stats = []candidateStats{
+		{tried: true},
+		{tried: true, stale: long},
+		{tried: true, stale: long, latency: 2 * time.Second},
+		{tried: true, stale: long, latency: time.Second},
+	}
+	if i := f.next(0, *now); i != 3 {
+		t.Errorf("expected quickest candidate out of cooldown; got %d", i)
+	}
+
+	f.stats[3].stale = now.Add(-time.Minute)
+	if i := f.next(0, *now); i != 2 {
+		t.Errorf("expected candidate in cooldown to be avoided; got %d", i)
+	}
+
+	f.stats[1].tried = false
+	if i := f.next(0, *now); i != 1 {
+		t.Errorf("expected untried candidate to be preferred; got %d", i)
+	}
+}
+
+func TestFailover_singleCandidate(t *testing.T) {
+	f, c, now := newTestFailover(t, 1)
+	*now = now.Add(time.Hour)
+	if err := f.check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.configs) != 0 {
+		t.Errorf("expected nothing to rotate to; got %+v", c.configs)
+	}
+}
@@ -0,0 +1,112 @@
+package wireguard
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"go.jonnrb.io/egress/fw"
+	"go.jonnrb.io/egress/vaddr"
+	"go.jonnrb.io/egress/vaddr/vaddrutil"
+	"golang.org/x/sys/unix"
+)
+
+// Returns wrappers that route the allowed IPs of cfg's peers and candidates
+// over link. A default route is split into two /1 routes (so it wins over the
+// existing default without replacing it) and each endpoint is pinned to the
+// route it has before the tunnel comes up, so the tunnel's own packets don't
+// loop through it. Every candidate's endpoint is pinned up front since, once
+// the tunnel is routing, the original route can't be looked up anymore.
+func Routes(link fw.Link, cfg Config) []vaddr.Wrapper {
+	peers := append(append([]Peer(nil), cfg.Peers...), cfg.Candidates...)
+
+	var w []vaddr.Wrapper
+	seen := make(map[string]bool)
+	for _, p := range peers {
+		if p.Endpoint != nil && !seen[p.Endpoint.IP.String()] {
+			seen[p.Endpoint.IP.String()] = true
+			w = append(w, &EndpointRoute{IP: p.Endpoint.IP})
+		}
+	}
+	for _, p := range peers {
+		for _, n := range p.AllowedIPs {
+			for _, dst := range splitDefault(n) {
+				if seen[dst.String()] {
+					continue
+				}
+				seen[dst.String()] = true
+				dst := dst
+				w = append(w, &vaddrutil.Route{Link: link, Dst: &dst})
+			}
+		}
+	}
+	return w
+}
+
+func splitDefault(n net.IPNet) []net.IPNet {
+	ones, bits := n.Mask.Size()
+	if ones != 0 {
+		return []net.IPNet{n}
+	}
+	lo := make(net.IP, bits/8)
+	hi := make(net.IP, bits/8)
+	hi[0] = 0x80
+	return []net.IPNet{
+		{IP: lo, Mask: net.CIDRMask(1, bits)},
+		{IP: hi, Mask: net.CIDRMask(1, bits)},
+	}
+}
+
+// Pins the route to IP to whatever route it has when started.
+type EndpointRoute struct {
+	IP net.IP
+
+	route *netlink.Route
+}
+
+func (r *EndpointRoute) Start() error {
+	rs, err := netlink.RouteGet(r.IP)
+	if err != nil || len(rs) == 0 {
+		return fmt.Errorf("wireguard: could not find route to endpoint %v: %v", r.IP, err)
+	}
+	bits := 8 * len(r.IP.To16())
+	if r.IP.To4() != nil {
+		bits = 32
+	}
+	r.route = &netlink.Route{
+		LinkIndex: rs[0].LinkIndex,
+		Gw:        rs[0].Gw,
+		Dst:       &net.IPNet{IP: r.IP, Mask: net.CIDRMask(bits, bits)},
+	}
+	if r.route.Gw == nil {
+		r.route.Scope = netlink.SCOPE_LINK
+	}
+	err = netlink.RouteAdd(r.route)
+	if errno, ok := err.(syscall.Errno); ok && errno == unix.EEXIST {
+		err = netlink.RouteReplace(r.route)
+	}
+	if err != nil {
+		return fmt.Errorf("wireguard: could not pin route to endpoint %v: %w", r.IP, err)
+	}
+	return nil
+}
+
+func (r *EndpointRoute) Stop() error {
+	if r.route == nil {
+		return nil
+	}
+	err := netlink.RouteDel(r.route)
+	r.route = nil
+	if errno, ok := err.(syscall.Errno); ok && errno == unix.ESRCH {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("wireguard: could not unpin route to endpoint: %w", err)
+	}
+	return nil
+}
+
+func (r *EndpointRoute) String() string {
+	return fmt.Sprintf("route pinning WireGuard endpoint %v", r.IP)
+}
@@ -0,0 +1,224 @@
+// Package wireguard configures a WireGuard uplink as a vaddr.Wrapper.
+package wireguard
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.jonnrb.io/egress/fw"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// The parts of *wgctrl.Client used here.
+type Client interface {
+	Device(name string) (*wgtypes.Device, error)
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+	Close() error
+}
+
+type Peer struct {
+	PublicKey    wgtypes.Key
+	PresharedKey *wgtypes.Key
+
+	// Where to reach the peer. May be nil if the peer connects to us.
+	Endpoint *net.UDPAddr
+
+	AllowedIPs []net.IPNet
+
+	// Zero disables keepalives.
+	PersistentKeepalive time.Duration
+}
+
+type Config struct {
+	PrivateKey wgtypes.Key
+
+	// Zero picks a random port.
+	ListenPort int
+
+	Peers []Peer
+
+	// Interchangeable peers (e.g. a VPN provider's servers), only one of which
+	// is configured at a time. The first is used until a Failover picks
+	// another.
+	Candidates []Peer
+
+	// How long a candidate can go without a handshake before a Failover
+	// rotates to another. Zero uses the Failover's default.
+	FailoverAfter time.Duration
+}
+
+// Applies Config to the WireGuard device Link on Start() and clears the
+// device's key and peers on Stop(). Clearing it matters in HA deployments:
+// followers share the leader's key, and a peer will only talk to the most
+// recent one to handshake.
+type Device struct {
+	Link   fw.Link
+	Config Config
+
+	// Opens a Client. Defaults to wgctrl.New().
+	NewClient func() (Client, error)
+
+	mu        sync.Mutex
+	candidate int
+}
+
+func (d *Device) client() (Client, error) {
+	if d.NewClient != nil {
+		return d.NewClient()
+	}
+	c, err := wgctrl.New()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (d *Device) Start() error {
+	c, err := d.client()
+	if err != nil {
+		return fmt.Errorf("wireguard: could not open wgctrl: %w", err)
+	}
+	defer c.Close()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := c.ConfigureDevice(d.Link.Name(), d.Config.wgConfig(d.candidate)); err != nil {
+		return fmt.Errorf(
+			"wireguard: could not configure %q: %w", d.Link.Name(), err)
+	}
+	return nil
+}
+
+func (d *Device) Stop() error {
+	c, err := d.client()
+	if err != nil {
+		return fmt.Errorf("wireguard: could not open wgctrl: %w", err)
+	}
+	defer c.Close()
+
+	var zero wgtypes.Key
+	err = c.ConfigureDevice(d.Link.Name(), wgtypes.Config{
+		PrivateKey:   &zero,
+		ReplacePeers: true,
+	})
+	if err != nil {
+		return fmt.Errorf("wireguard: could not clear %q: %w", d.Link.Name(), err)
+	}
+	return nil
+}
+
+// Returns the index in Config.Candidates of the candidate in use.
+func (d *Device) Candidate() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.candidate
+}
+
+// Switches the device to the candidate at index i in Config.Candidates.
+func (d *Device) UseCandidate(i int) error {
+	if i < 0 || i >= len(d.Config.Candidates) {
+		return fmt.Errorf("wireguard: no candidate %d", i)
+	}
+	c, err := d.client()
+	if err != nil {
+		return fmt.Errorf("wireguard: could not open wgctrl: %w", err)
+	}
+	defer c.Close()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	prev, next := d.Config.Candidates[d.candidate], d.Config.Candidates[i]
+	var peers []wgtypes.PeerConfig
+	if prev.PublicKey != next.PublicKey {
+		peers = append(peers, wgtypes.PeerConfig{
+			PublicKey: prev.PublicKey,
+			Remove:    true,
+		})
+	}
+	peers = append(peers, next.wgConfig())
+	err = c.ConfigureDevice(d.Link.Name(), wgtypes.Config{Peers: peers})
+	if err != nil {
+		return fmt.Errorf(
+			"wireguard: could not switch %q to %v: %w", d.Link.Name(), next.Endpoint, err)
+	}
+	d.candidate = i
+	return nil
+}
+
+func (cfg Config) wgConfig(candidate int) wgtypes.Config {
+	key := cfg.PrivateKey
+	port := cfg.ListenPort
+	c := wgtypes.Config{
+		PrivateKey:   &key,
+		ListenPort:   &port,
+		ReplacePeers: true,
+	}
+	for _, p := range cfg.Peers {
+		c.Peers = append(c.Peers, p.wgConfig())
+	}
+	if candidate < len(cfg.Candidates) {
+		c.Peers = append(c.Peers, cfg.Candidates[candidate].wgConfig())
+	}
+	return c
+}
+
+func (p Peer) wgConfig() wgtypes.PeerConfig {
+	keepalive := p.PersistentKeepalive
+	c := wgtypes.PeerConfig{
+		PublicKey:                   p.PublicKey,
+		Endpoint:                    p.Endpoint,
+		PersistentKeepaliveInterval: &keepalive,
+		ReplaceAllowedIPs:           true,
+		AllowedIPs:                  p.AllowedIPs,
+	}
+	if p.PresharedKey != nil {
+		psk := *p.PresharedKey
+		c.PresharedKey = &psk
+	} else {
+		c.PresharedKey = new(wgtypes.Key)
+	}
+	return c
+}
+
+// Returns an error unless a peer on the device has completed a handshake within
+// maxAge. WireGuard re-handshakes every 2 minutes on an active tunnel, so a
+// stale handshake means the tunnel is down.
+func (d *Device) CheckHandshake(maxAge time.Duration) error {
+	c, err := d.client()
+	if err != nil {
+		return fmt.Errorf("wireguard: could not open wgctrl: %w", err)
+	}
+	defer c.Close()
+
+	dev, err := c.Device(d.Link.Name())
+	if err != nil {
+		return fmt.Errorf("wireguard: could not get %q: %w", d.Link.Name(), err)
+	}
+	var latest time.Time
+	for _, p := range dev.Peers {
+		if p.LastHandshakeTime.After(latest) {
+			latest = p.LastHandshakeTime
+		}
+	}
+	if latest.IsZero() {
+		return fmt.Errorf("wireguard: no handshake on %q yet", d.Link.Name())
+	}
+	if age := time.Since(latest); age > maxAge {
+		return fmt.Errorf(
+			"wireguard: last handshake on %q was %v ago", d.Link.Name(), age.Round(time.Second))
+	}
+	return nil
+}
+
+// Describes d without its keys.
+func (d *Device) String() string {
+	return fmt.Sprintf(
+		"WireGuard device %s with %d peers and %d candidates",
+		d.Link.Name(), len(d.Config.Peers), len(d.Config.Candidates))
+}
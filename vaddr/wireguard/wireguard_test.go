@@ -0,0 +1,135 @@
+package wireguard
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.jonnrb.io/egress/fw"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+type fakeClient struct {
+	dev     wgtypes.Device
+	configs []wgtypes.Config
+	closed  int
+}
+
+func (c *fakeClient) Device(name string) (*wgtypes.Device, error) {
+	d := c.dev
+	d.Name = name
+	return &d, nil
+}
+
+func (c *fakeClient) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	c.configs = append(c.configs, cfg)
+	return nil
+}
+
+func (c *fakeClient) Close() error {
+	c.closed++
+	return nil
+}
+
+func newTestDevice(t *testing.T) (*Device, *fakeClient) {
+	priv, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, allowed, _ := net.ParseCIDR("0.0.0.0/0")
+	c := &fakeClient{}
+	return &Device{
+		Link: fw.LinkString("wg0"),
+		Config: Config{
+			PrivateKey: priv,
+			ListenPort: 51820,
+			Peers: []Peer{{
+				PublicKey:           priv.PublicKey(),
+				Endpoint:            &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 51820},
+				AllowedIPs:          []net.IPNet{*allowed},
+				PersistentKeepalive: 25 * time.Second,
+			}},
+		},
+		NewClient: func() (Client, error) { return c, nil },
+	}, c
+}
+
+func TestDevice(t *testing.T) {
+	d, c := newTestDevice(t)
+
+	if err := d.Start(); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	if len(c.configs) != 1 {
+		t.Fatalf("expected 1 config to be applied; got %d", len(c.configs))
+	}
+	got := c.configs[0]
+	if *got.PrivateKey != d.Config.PrivateKey || *got.ListenPort != 51820 || !got.ReplacePeers {
+		t.Errorf("unexpected device config: %+v", got)
+	}
+	if len(got.Peers) != 1 {
+		t.Fatalf("expected 1 peer; got %+v", got.Peers)
+	}
+	p := got.Peers[0]
+	if p.PublicKey != d.Config.Peers[0].PublicKey ||
+		p.Endpoint.String() != "198.51.100.1:51820" ||
+		*p.PersistentKeepaliveInterval != 25*time.Second ||
+		!p.ReplaceAllowedIPs || len(p.AllowedIPs) != 1 {
+		t.Errorf("unexpected peer config: %+v", p)
+	}
+
+	if err := d.Stop(); err != nil {
+		t.Fatalf("Stop() failed: %v", err)
+	}
+	got = c.configs[1]
+	if *got.PrivateKey != (wgtypes.Key{}) || !got.ReplacePeers || len(got.Peers) != 0 {
+		t.Errorf("expected device to be cleared; got %+v", got)
+	}
+	if c.closed != 2 {
+		t.Errorf("expected client to be closed after each use; closed %d times", c.closed)
+	}
+}
+
+func TestCheckHandshake(t *testing.T) {
+	d, c := newTestDevice(t)
+
+	c.dev.Peers = []wgtypes.Peer{{}}
+	if err := d.CheckHandshake(time.Minute); err == nil {
+		t.Error("expected an error before any handshake")
+	}
+
+	c.dev.Peers = []wgtypes.Peer{
+		{LastHandshakeTime: time.Now().Add(-10 * time.Minute)},
+		{LastHandshakeTime: time.Now().Add(-30 * time.Second)},
+	}
+	if err := d.CheckHandshake(time.Minute); err != nil {
+		t.Errorf("expected recent handshake to be healthy; got %v", err)
+	}
+	if err := d.CheckHandshake(10 * time.Second); err == nil {
+		t.Error("expected stale handshake to be unhealthy")
+	}
+}
+
+func TestSplitDefault(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want []string
+	}{
+		{"0.0.0.0/0", []string{"0.0.0.0/1", "128.0.0.0/1"}},
+		{"::/0", []string{"::/1", "8000::/1"}},
+		{"10.0.0.0/8", []string{"10.0.0.0/8"}},
+	} {
+		_, n, err := net.ParseCIDR(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, dst := range splitDefault(*n) {
+			got = append(got, dst.String())
+		}
+		if diff := cmp.Diff(c.want, got); diff != "" {
+			t.Errorf("splitDefault(%v) mismatch (-want +got):\n%s", c.in, diff)
+		}
+	}
+}